@@ -1,5 +1,11 @@
 package api
 
+import (
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+)
+
 type BalanceResponse struct {
 	Balance int64 `json:"balance"`
 }
@@ -16,12 +22,24 @@ type CreateInvoiceResponse struct {
 	Preimage    string `json:"preimage,omitempty"`
 }
 type CreateInvoiceRequest struct {
-	Memo                string `json:"memo"`
-	Amount              int64  `json:"amount"`
-	DescriptionHash     string `json:"description_hash"`
-	UnhashedDescription string `json:"unhashed_description"`
+	Memo                string  `json:"memo"`
+	Amount              int64   `json:"amount"`
+	DescriptionHash     string  `json:"description_hash"`
+	UnhashedDescription string  `json:"unhashed_description"`
+	Currency            string  `json:"currency,omitempty"`
+	FiatAmount          float64 `json:"fiat_amount,omitempty"`
+}
+
+type CreateFiatInvoiceResponse struct {
+	lnbits.Invoice
+	Amount      int64     `json:"amount"`
+	Currency    string    `json:"currency"`
+	FiatAmount  float64   `json:"fiat_amount"`
+	Rate        float64   `json:"rate"`
+	LockedUntil time.Time `json:"locked_until"`
 }
 
 type PayInvoiceRequest struct {
-	PayRequest string `json:"pay_req"`
+	PayRequest   string `json:"pay_req"`
+	Confirmation string `json:"confirmation,omitempty"`
 }
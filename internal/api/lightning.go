@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	decodepay "github.com/fiatjaf/ln-decodepay"
 	"github.com/gorilla/mux"
 	"github.com/massmux/SatsMobiBot/internal"
 	"github.com/massmux/SatsMobiBot/internal/lnbits"
@@ -52,6 +53,10 @@ func (s Service) CreateInvoice(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if createInvoiceRequest.FiatAmount > 0 {
+		s.createFiatInvoice(w, user, createInvoiceRequest)
+		return
+	}
 	invoice, err := user.Wallet.Invoice(
 		lnbits.InvoiceParams{
 			Amount:              createInvoiceRequest.Amount,
@@ -71,6 +76,36 @@ func (s Service) CreateInvoice(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(invoice)
 }
 
+// createFiatInvoice creates an invoice for a fiat amount with a locked rate
+func (s Service) createFiatInvoice(w http.ResponseWriter, user *lnbits.User, createInvoiceRequest CreateInvoiceRequest) {
+	quote, err := telegram.NewFiatQuote(createInvoiceRequest.FiatAmount, createInvoiceRequest.Currency)
+	if err != nil {
+		RespondError(w, "could not convert amount: "+err.Error())
+		return
+	}
+	invoiceEvent, err := s.Bot.CreateFiatInvoice(user,
+		lnbits.InvoiceParams{
+			DescriptionHash:     createInvoiceRequest.DescriptionHash,
+			UnhashedDescription: createInvoiceRequest.UnhashedDescription,
+			Memo:                createInvoiceRequest.Memo},
+		quote)
+	if err != nil {
+		RespondError(w, "could not create invoice")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(CreateFiatInvoiceResponse{
+		Invoice:     lnbits.Invoice{PaymentHash: invoiceEvent.PaymentHash, PaymentRequest: invoiceEvent.PaymentRequest},
+		Amount:      quote.Sats,
+		Currency:    quote.Currency,
+		FiatAmount:  quote.Amount,
+		Rate:        quote.Rate,
+		LockedUntil: quote.LockedUntil,
+	})
+}
+
 func (s Service) PayInvoice(w http.ResponseWriter, r *http.Request) {
 	user := telegram.LoadUser(r.Context())
 	var payInvoiceRequest PayInvoiceRequest
@@ -79,7 +114,12 @@ func (s Service) PayInvoice(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	invoice, err := user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: payInvoiceRequest.PayRequest}, s.Bot.Client)
+	_, err = decodepay.Decodepay(payInvoiceRequest.PayRequest)
+	if err != nil {
+		RespondError(w, "invalid invoice")
+		return
+	}
+	invoice, err := s.Bot.PayInvoice(user, payInvoiceRequest.PayRequest, "api", payInvoiceRequest.Confirmation)
 	if err != nil {
 		RespondError(w, "could not pay invoice: "+err.Error())
 		return
@@ -186,3 +226,36 @@ out:
 	close(c)
 	time.Sleep(time.Second * 5)
 }
+
+// ExportTransactions returns the transactions of the user as csv or json.
+// Query parameters: from, to (YYYY-MM-DD), format (csv|json) and currency.
+func (s Service) ExportTransactions(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	query := r.URL.Query()
+	from, to, err := telegram.ParseExportPeriod(query.Get("from"), query.Get("to"))
+	if err != nil {
+		RespondError(w, "invalid period")
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = telegram.ExportFormatCSV
+	}
+	if format != telegram.ExportFormatCSV && format != telegram.ExportFormatJSON {
+		RespondError(w, "invalid format")
+		return
+	}
+	rows, err := s.Bot.ExportTransactions(user, from, to, telegram.ExportCurrency(user, query.Get("currency")))
+	if err != nil {
+		RespondError(w, "export failed")
+		return
+	}
+	if format == telegram.ExportFormatJSON {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"transactions_%s.csv\"", to.Format("2006-01-02")))
+	}
+	w.WriteHeader(http.StatusOK)
+	telegram.WriteTransactionExport(w, rows, format)
+}
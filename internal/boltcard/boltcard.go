@@ -0,0 +1,169 @@
+// Package boltcard implements the card side cryptography of the Bolt Card
+// protocol: NTAG 424 DNA cards encrypt their UID and tap counter into the
+// p parameter of the LNURL-withdraw link and authenticate it with the c parameter.
+package boltcard
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// KeySize is the size of the AES-128 application keys K0 to K4
+	KeySize = 16
+	// UIDSize is the size of the card UID
+	UIDSize = 7
+	// piccDataTag marks the encrypted PICC data that contains UID and counter
+	piccDataTag = 0xc7
+)
+
+var (
+	ErrInvalidParameters = fmt.Errorf("invalid card parameters")
+	ErrInvalidCMAC       = fmt.Errorf("invalid card signature")
+)
+
+// sv2Prefix is the session vector used to derive the MAC session key
+var sv2Prefix = []byte{0x3c, 0xc3, 0x00, 0x01, 0x00, 0x80}
+
+// NewKey returns a random hex encoded card key
+func NewKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// Verify decrypts p with k1 and checks c with k2. It returns the UID of the
+// card and the tap counter.
+func Verify(k1, k2, p, c string) (uid []byte, counter uint32, err error) {
+	encKey, err := decodeHex(k1, KeySize)
+	if err != nil {
+		return nil, 0, err
+	}
+	macKey, err := decodeHex(k2, KeySize)
+	if err != nil {
+		return nil, 0, err
+	}
+	piccData, err := decodeHex(p, aes.BlockSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	mac, err := decodeHex(c, 8)
+	if err != nil {
+		return nil, 0, err
+	}
+	uid, ctr, err := decryptPICCData(encKey, piccData)
+	if err != nil {
+		return nil, 0, err
+	}
+	expected, err := sunMAC(macKey, uid, ctr)
+	if err != nil {
+		return nil, 0, err
+	}
+	if subtle.ConstantTimeCompare(expected, mac) != 1 {
+		return nil, 0, ErrInvalidCMAC
+	}
+	return uid, uint32(ctr[0]) | uint32(ctr[1])<<8 | uint32(ctr[2])<<16, nil
+}
+
+// decryptPICCData returns the UID and the little endian counter bytes of the card
+func decryptPICCData(key, p []byte) (uid []byte, counter []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	// a single block in CBC mode with a zero IV is ECB
+	plain := make([]byte, aes.BlockSize)
+	block.Decrypt(plain, p)
+	if plain[0] != piccDataTag {
+		return nil, nil, ErrInvalidParameters
+	}
+	return plain[1 : 1+UIDSize], plain[1+UIDSize : 1+UIDSize+3], nil
+}
+
+// sunMAC computes the truncated SUN MAC of the card for uid and counter
+func sunMAC(key, uid, counter []byte) ([]byte, error) {
+	sv2 := bytes.Join([][]byte{sv2Prefix, uid, counter}, nil)
+	sessionKey, err := cmac(key, sv2)
+	if err != nil {
+		return nil, err
+	}
+	full, err := cmac(sessionKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the card only sends the odd bytes of the MAC
+	mac := make([]byte, 0, aes.BlockSize/2)
+	for i := 1; i < len(full); i += 2 {
+		mac = append(mac, full[i])
+	}
+	return mac, nil
+}
+
+// cmac computes AES-CMAC (RFC 4493) of msg
+func cmac(key, msg []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	subkey1 := make([]byte, aes.BlockSize)
+	block.Encrypt(subkey1, subkey1)
+	subkey1 = shiftSubkey(subkey1)
+	subkey2 := shiftSubkey(subkey1)
+
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	complete := n > 0 && len(msg)%aes.BlockSize == 0
+	if n == 0 {
+		n = 1
+	}
+	last := make([]byte, aes.BlockSize)
+	copy(last, msg[(n-1)*aes.BlockSize:])
+	if complete {
+		xorBytes(last, subkey1)
+	} else {
+		last[len(msg)-(n-1)*aes.BlockSize] = 0x80
+		xorBytes(last, subkey2)
+	}
+
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		xorBytes(x, msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(x, x)
+	}
+	xorBytes(x, last)
+	block.Encrypt(x, x)
+	return x, nil
+}
+
+// shiftSubkey doubles k in GF(2^128)
+func shiftSubkey(k []byte) []byte {
+	shifted := make([]byte, len(k))
+	for i := 0; i < len(k)-1; i++ {
+		shifted[i] = k[i]<<1 | k[i+1]>>7
+	}
+	shifted[len(k)-1] = k[len(k)-1] << 1
+	if k[0]&0x80 != 0 {
+		shifted[len(k)-1] ^= 0x87
+	}
+	return shifted
+}
+
+func xorBytes(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+func decodeHex(s string, size int) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(b) != size {
+		return nil, ErrInvalidParameters
+	}
+	return b, nil
+}
@@ -0,0 +1,53 @@
+package boltcard
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestCMAC(t *testing.T) {
+	// test vectors from RFC 4493
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	for msg, want := range map[string]string{
+		"":                                 "bb1d6929e95937287fa37d129b756746",
+		"6bc1bee22e409f96e93d7e117393172a": "070a16b46b4d4144f79bdd9dd04a287c",
+		"6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411": "dfa66747de9ae63030ca32611497c827",
+	} {
+		m, _ := hex.DecodeString(msg)
+		mac, err := cmac(key, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(mac); got != want {
+			t.Errorf("cmac(%s) = %s, want %s", msg, got, want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	// test vectors from the Bolt Card specification
+	k1 := "0c3b25d92b38ae443229dd59ad34b85d"
+	k2 := "b45775776cb224c75bcde7ca3704e933"
+	for _, v := range []struct {
+		p, c    string
+		counter uint32
+	}{
+		{"4E2E289D945A66BB13377A728884E867", "E19CCB1FED8892CE", 3},
+		{"00F48C4F8E386DED06BCDC78FA92E2FE", "66B4826EA4C155B4", 5},
+		{"0DBF3C59B59B0638D60B5842A997D4D1", "CC61660C020B4D96", 7},
+	} {
+		uid, counter, err := Verify(k1, k2, v.p, v.c)
+		if err != nil {
+			t.Fatalf("Verify(%s) error: %v", v.p, err)
+		}
+		if got := hex.EncodeToString(uid); got != "04996c6a926980" {
+			t.Errorf("Verify(%s) uid = %s", v.p, got)
+		}
+		if counter != v.counter {
+			t.Errorf("Verify(%s) counter = %d, want %d", v.p, counter, v.counter)
+		}
+	}
+	if _, _, err := Verify(k1, k2, "4E2E289D945A66BB13377A728884E867", "E19CCB1FED8892CF"); err != ErrInvalidCMAC {
+		t.Errorf("Verify() with wrong c = %v, want %v", err, ErrInvalidCMAC)
+	}
+}
@@ -18,8 +18,26 @@ var Configuration = struct {
 	Nostr      NostrConfiguration      `yaml:"nostr"`
 	Pos        PosConfiguration        `yaml:"pos"`
 	Voucherbot VoucherbotConfiguration `yaml:"voucherbot"`
+	Price      PriceConfiguration      `yaml:"price"`
+	Boltz      BoltzConfiguration      `yaml:"boltz"`
 }{}
 
+type PriceConfiguration struct {
+	HistoryResolution int64              `yaml:"history_resolution"`
+	HistoryRetention  int64              `yaml:"history_retention"`
+	Providers         []string           `yaml:"providers"`
+	File              string             `yaml:"file"`
+	FixedRates        map[string]float64 `yaml:"fixed_rates"`
+	MaxAge            int64              `yaml:"max_age"`
+	RateLock          int64              `yaml:"rate_lock"`
+}
+
+type BoltzConfiguration struct {
+	Endpoint        string `yaml:"endpoint"`
+	Network         string `yaml:"network"`
+	MempoolEndpoint string `yaml:"mempool_endpoint"`
+}
+
 type PosConfiguration struct {
 	Currency    string `yaml:"currency"`
 	Max_balance int64  `yaml:"max_balance"`
@@ -29,8 +47,11 @@ type VoucherbotConfiguration struct {
 	Endpoint      string `yaml:"endpoint"`
 	ApiKey        string `yaml:"api_key"`
 	PurchaseType  string `yaml:"purchase_type"`
+	SellType      string `yaml:"sell_type"`
+	SellPath      string `yaml:"sell_path"`
 	DefaultAmount string `yaml:"default_amount"`
 	Currency      string `yaml:"currency"`
+	StatusPath    string `yaml:"status_path"`
 }
 
 type NostrConfiguration struct {
@@ -62,6 +83,7 @@ type BotConfiguration struct {
 	Name           string              `yaml:"name"`
 	Username       string              `yaml:"username"`
 	Botadmin       string              `yaml:"botadmin"`
+	Arbiters       []int64             `yaml:"arbiters"`
 }
 
 type TelegramConfiguration struct {
@@ -156,6 +156,30 @@ func (c Client) Payments(w Wallet) (wtx Payments, err error) {
 	return
 }
 
+// PaymentsPage returns up to limit wallet payments, skipping the newest offset payments
+func (c Client) PaymentsPage(w Wallet, limit, offset int) (wtx Payments, err error) {
+	// custom header with invoice key
+	invoiceHeader := req.Header{
+		"Content-Type": "application/json",
+		"Accept":       "application/json",
+		"X-Api-Key":    w.Inkey,
+	}
+	resp, err := req.Get(c.url+fmt.Sprintf("/api/v1/payments?limit=%d&offset=%d", limit, offset), invoiceHeader, nil)
+	if err != nil {
+		return
+	}
+
+	if resp.Response().StatusCode >= 300 {
+		var reqErr Error
+		resp.ToJSON(&reqErr)
+		err = reqErr
+		return
+	}
+
+	err = resp.ToJSON(&wtx)
+	return
+}
+
 // Payment state of a payment
 func (c Client) Payment(w Wallet, payment_hash string) (payment LNbitsPayment, err error) {
 	// custom header with invoice key
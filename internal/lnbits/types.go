@@ -39,17 +39,42 @@ type User struct {
 }
 
 type Settings struct {
-	ID      string          `json:"id" gorm:"primarykey"`
-	Display DisplaySettings `gorm:"embedded;embeddedPrefix:display_"`
-	Node    NodeSettings    `gorm:"embedded;embeddedPrefix:node_"`
-	Nostr   NostrSettings   `gorm:"embedded;embeddedPrefix:nostr_"`
+	ID        string           `json:"id" gorm:"primarykey"`
+	Display   DisplaySettings  `gorm:"embedded;embeddedPrefix:display_"`
+	Node      NodeSettings     `gorm:"embedded;embeddedPrefix:node_"`
+	Nostr     NostrSettings    `gorm:"embedded;embeddedPrefix:nostr_"`
+	Limits    LimitSettings    `gorm:"embedded;embeddedPrefix:limits_"`
+	Privacy   PrivacySettings  `gorm:"embedded;embeddedPrefix:privacy_"`
+	Reactions ReactionSettings `gorm:"embedded;embeddedPrefix:reactions_"`
 }
 
 type DisplaySettings struct {
 	DisplayCurrency string `json:"displaycurrency"`
 }
 type NostrSettings struct {
-	PubKey string `json:"pubkey"`
+	PubKey         string `json:"pubkey"`
+	DirectMessages bool   `json:"directmessages"`
+	// NIP-26 delegation of PubKey to the bot key for zap requests
+	Delegation           string `json:"delegation"`
+	DelegationConditions string `json:"delegationconditions"`
+}
+type ReactionSettings struct {
+	// Tips maps reaction emojis to amounts, e.g. "⚡:21,🔥:100"
+	Tips     string `json:"tips"`
+	DailyCap int64  `json:"dailycap"`
+}
+type PrivacySettings struct {
+	HideFromRankings bool `json:"hidefromrankings"`
+}
+type LimitSettings struct {
+	Daily          int64  `json:"daily"`
+	PerTransaction int64  `json:"pertransaction"`
+	ConfirmAbove   int64  `json:"confirmabove"`
+	PinHash        string `json:"pinhash"`
+	TotpSecret     string `json:"totpsecret"`
+	TotpEnabled    bool   `json:"totpenabled"`
+	// TotpLastCounter is the time step of the last accepted TOTP code
+	TotpLastCounter int64 `json:"totplastcounter"`
 }
 type NodeSettings struct {
 	NodeType     string                 `json:"nodetype"`
@@ -74,6 +99,7 @@ const (
 	UserStateShopItemSendItemFile
 	UserEnterShopsDescription
 	UserEnterDallePrompt
+	UserEnterSpendingConfirmation
 )
 
 type UserStateKey int
@@ -90,6 +116,7 @@ type InvoiceParams struct {
 	Webhook             string `json:"webhook,omitempty"`              // the webhook to fire back to when payment is received.
 	DescriptionHash     string `json:"description_hash,omitempty"`     // the invoice description hash.
 	UnhashedDescription string `json:"unhashed_description,omitempty"` // the unhashed invoice description.
+	Expiry              int64  `json:"expiry,omitempty"`               // seconds until the invoice expires.
 }
 
 type PaymentParams struct {
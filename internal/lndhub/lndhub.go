@@ -1,8 +1,13 @@
 package lndhub
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
 	"net/http"
+	"strings"
 
+	decodepay "github.com/fiatjaf/ln-decodepay"
 	"github.com/massmux/SatsMobiBot/internal"
 	"github.com/massmux/SatsMobiBot/internal/api"
 	"github.com/massmux/SatsMobiBot/internal/telegram"
@@ -11,11 +16,47 @@ import (
 
 type LndHub struct {
 	database *gorm.DB
+	bot      *telegram.TipBot
+}
+
+type payInvoiceRequest struct {
+	Invoice string `json:"invoice"`
+	Amount  int64  `json:"amount"`
 }
 
 func New(bot *telegram.TipBot) LndHub {
-	return LndHub{database: bot.DB.Users}
+	return LndHub{database: bot.DB.Users, bot: bot}
 }
 func (w LndHub) Handle(writer http.ResponseWriter, request *http.Request) {
+	if request.Method == http.MethodPost && strings.HasSuffix(request.URL.Path, "/payinvoice") {
+		err := w.checkSpendingLimits(request)
+		if err != nil {
+			api.RespondError(writer, err.Error())
+			return
+		}
+	}
 	api.Proxy(writer, request, internal.Configuration.Lnbits.Url)
 }
+
+// checkSpendingLimits reads the invoice of a payinvoice request and restores the body for the proxy.
+func (w LndHub) checkSpendingLimits(request *http.Request) error {
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		return err
+	}
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	var payInvoice payInvoiceRequest
+	err = json.Unmarshal(body, &payInvoice)
+	if err != nil {
+		return err
+	}
+	bolt11, err := decodepay.Decodepay(payInvoice.Invoice)
+	if err != nil {
+		return err
+	}
+	amount := bolt11.MSatoshi / 1000
+	if amount == 0 {
+		amount = payInvoice.Amount
+	}
+	return w.bot.CheckSpendingLimits(telegram.LoadUser(request.Context()), amount, "lndhub", "")
+}
@@ -0,0 +1,131 @@
+package lnurl
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/eko/gocache/store"
+	"github.com/fiatjaf/go-lnurl"
+	"github.com/gorilla/mux"
+	"github.com/massmux/SatsMobiBot/internal/api"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/telegram"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	BoltCardEndpoint = "boltcard"
+	// boltCardK1Expiry is the time a wallet has to send the invoice after a tap
+	boltCardK1Expiry = 5 * time.Minute
+)
+
+// BoltCardProgramResponse is read by the Bolt Card NFC programmer app
+type BoltCardProgramResponse struct {
+	ProtocolName    string `json:"protocol_name"`
+	ProtocolVersion int    `json:"protocol_version"`
+	CardName        string `json:"card_name"`
+	LNURLWBase      string `json:"lnurlw_base"`
+	telegram.BoltCardKeys
+}
+
+type boltCardWithdraw struct {
+	CardID     uint
+	ExternalID string
+}
+
+// HandleBoltCard serves the LNURL-withdraw request of a card tap
+func (w Lnurl) HandleBoltCard(writer http.ResponseWriter, request *http.Request) {
+	externalID := mux.Vars(request)["id"]
+	card, err := w.bot.TapBoltCard(externalID, request.FormValue("p"), request.FormValue("c"))
+	if err != nil {
+		log.Errorf("[BoltCard] %v", err)
+		w.writeBoltCardError(writer, err)
+		return
+	}
+	maxWithdrawable := card.MaxWithdrawable()
+	if maxWithdrawable <= 0 {
+		w.writeBoltCardError(writer, telegram.ErrBoltCardLimitExceeded)
+		return
+	}
+	k1Bytes := make([]byte, 32)
+	if _, err = rand.Read(k1Bytes); err != nil {
+		api.NotFoundHandler(writer, err)
+		return
+	}
+	k1 := hex.EncodeToString(k1Bytes)
+	runtime.IgnoreError(w.cache.Set(boltCardK1Key(k1), boltCardWithdraw{CardID: card.ID, ExternalID: externalID}, &store.Options{Expiration: boltCardK1Expiry}))
+	log.Infof("[BoltCard] Serving withdraw request for card %d", card.ID)
+	err = api.WriteResponse(writer, lnurl.LNURLWithdrawResponse{
+		LNURLResponse:      lnurl.LNURLResponse{Status: api.StatusOk},
+		Tag:                "withdrawRequest",
+		K1:                 k1,
+		Callback:           fmt.Sprintf("%s/%s/%s/callback", w.callbackHostname.String(), BoltCardEndpoint, externalID),
+		MinWithdrawable:    1000,
+		MaxWithdrawable:    maxWithdrawable * 1000,
+		DefaultDescription: fmt.Sprintf("Bolt Card %s", card.Name),
+	})
+	if err != nil {
+		api.NotFoundHandler(writer, err)
+	}
+}
+
+// HandleBoltCardCallback pays the invoice of the wallet that read the card
+func (w Lnurl) HandleBoltCardCallback(writer http.ResponseWriter, request *http.Request) {
+	k1 := request.FormValue("k1")
+	paymentRequest := request.FormValue("pr")
+	// concurrent callbacks with the same k1 must not both find it in the cache
+	mutex.Lock(boltCardK1Key(k1))
+	defer mutex.Unlock(boltCardK1Key(k1))
+	withdraw, err := w.cache.Get(boltCardK1Key(k1))
+	if err != nil || len(paymentRequest) == 0 || withdraw.(boltCardWithdraw).ExternalID != mux.Vars(request)["id"] {
+		w.writeBoltCardError(writer, fmt.Errorf("invalid k1"))
+		return
+	}
+	// every tap pays only once
+	runtime.IgnoreError(w.cache.Delete(boltCardK1Key(k1)))
+	err = w.bot.PayWithBoltCard(withdraw.(boltCardWithdraw).CardID, paymentRequest)
+	if err != nil {
+		w.writeBoltCardError(writer, err)
+		return
+	}
+	err = api.WriteResponse(writer, lnurl.OkResponse())
+	if err != nil {
+		api.NotFoundHandler(writer, err)
+	}
+}
+
+// HandleBoltCardProgram exports the keys of a new card to the programmer app
+func (w Lnurl) HandleBoltCardProgram(writer http.ResponseWriter, request *http.Request) {
+	card, err := w.bot.ProgramBoltCard(mux.Vars(request)["token"])
+	if err != nil {
+		api.NotFoundHandler(writer, err)
+		return
+	}
+	lnurlw := *w.callbackHostname
+	lnurlw.Scheme = "lnurlw"
+	err = api.WriteResponse(writer, BoltCardProgramResponse{
+		ProtocolName:    "create_bolt_card_response",
+		ProtocolVersion: 2,
+		CardName:        card.Name,
+		LNURLWBase:      fmt.Sprintf("%s/%s/%s", lnurlw.String(), BoltCardEndpoint, card.ExternalID),
+		BoltCardKeys:    card.Keys(),
+	})
+	if err != nil {
+		api.NotFoundHandler(writer, err)
+	}
+}
+
+func (w Lnurl) writeBoltCardError(writer http.ResponseWriter, err error) {
+	err = api.WriteResponse(writer, lnurl.ErrorResponse(err.Error()))
+	if err != nil {
+		api.NotFoundHandler(writer, err)
+	}
+}
+
+func boltCardK1Key(k1 string) string {
+	return fmt.Sprintf("boltcard_k1_%s", k1)
+}
@@ -0,0 +1,159 @@
+// Package nip44 implements version 2 of the NIP-44 payload encryption
+// used by NIP-17 private direct messages.
+package nip44
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	version         byte = 2
+	minPlaintextLen      = 1
+	maxPlaintextLen      = 65535
+)
+
+// ConversationKey derives the shared key between a private key and a public key (both hex encoded).
+func ConversationKey(pub string, sk string) ([]byte, error) {
+	privKeyBytes, err := hex.DecodeString(sk)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode private key: %w", err)
+	}
+	privKey, _ := btcec.PrivKeyFromBytes(privKeyBytes)
+	pubKeyBytes, err := hex.DecodeString("02" + pub)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode public key: %w", err)
+	}
+	pubKey, err := btcec.ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse public key: %w", err)
+	}
+	sharedX := btcec.GenerateSharedSecret(privKey, pubKey)
+	return hkdf.Extract(sha256.New, sharedX, []byte("nip44-v2")), nil
+}
+
+// Encrypt encrypts plaintext with the conversation key and a random nonce.
+func Encrypt(plaintext string, conversationKey []byte) (string, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return encrypt(plaintext, conversationKey, nonce)
+}
+
+func encrypt(plaintext string, conversationKey []byte, nonce []byte) (string, error) {
+	chachaKey, chachaNonce, hmacKey, err := messageKeys(conversationKey, nonce)
+	if err != nil {
+		return "", err
+	}
+	padded, err := pad(plaintext)
+	if err != nil {
+		return "", err
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(chachaKey, chachaNonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(padded))
+	cipher.XORKeyStream(ciphertext, padded)
+
+	payload := make([]byte, 0, 1+len(nonce)+len(ciphertext)+sha256.Size)
+	payload = append(payload, version)
+	payload = append(payload, nonce...)
+	payload = append(payload, ciphertext...)
+	payload = append(payload, mac(hmacKey, nonce, ciphertext)...)
+	return base64.StdEncoding.EncodeToString(payload), nil
+}
+
+// Decrypt decrypts a base64 payload with the conversation key.
+func Decrypt(payload string, conversationKey []byte) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64: %w", err)
+	}
+	if len(data) < 99 || len(data) > 65603 {
+		return "", fmt.Errorf("invalid payload length %d", len(data))
+	}
+	if data[0] != version {
+		return "", fmt.Errorf("unknown version %d", data[0])
+	}
+	nonce := data[1:33]
+	ciphertext := data[33 : len(data)-sha256.Size]
+	chachaKey, chachaNonce, hmacKey, err := messageKeys(conversationKey, nonce)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal(mac(hmacKey, nonce, ciphertext), data[len(data)-sha256.Size:]) {
+		return "", fmt.Errorf("invalid mac")
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(chachaKey, chachaNonce)
+	if err != nil {
+		return "", err
+	}
+	padded := make([]byte, len(ciphertext))
+	cipher.XORKeyStream(padded, ciphertext)
+	return unpad(padded)
+}
+
+func messageKeys(conversationKey []byte, nonce []byte) (chachaKey, chachaNonce, hmacKey []byte, err error) {
+	if len(conversationKey) != 32 {
+		return nil, nil, nil, fmt.Errorf("invalid conversation key length %d", len(conversationKey))
+	}
+	if len(nonce) != 32 {
+		return nil, nil, nil, fmt.Errorf("invalid nonce length %d", len(nonce))
+	}
+	keys := make([]byte, 76)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, conversationKey, nonce), keys); err != nil {
+		return nil, nil, nil, err
+	}
+	return keys[0:32], keys[32:44], keys[44:76], nil
+}
+
+func mac(hmacKey, nonce, ciphertext []byte) []byte {
+	h := hmac.New(sha256.New, hmacKey)
+	h.Write(nonce)
+	h.Write(ciphertext)
+	return h.Sum(nil)
+}
+
+func paddedLen(unpaddedLen int) int {
+	if unpaddedLen <= 32 {
+		return 32
+	}
+	nextPower := 1 << bits.Len(uint(unpaddedLen-1))
+	chunk := 32
+	if nextPower > 256 {
+		chunk = nextPower / 8
+	}
+	return chunk * ((unpaddedLen-1)/chunk + 1)
+}
+
+func pad(plaintext string) ([]byte, error) {
+	unpaddedLen := len(plaintext)
+	if unpaddedLen < minPlaintextLen || unpaddedLen > maxPlaintextLen {
+		return nil, fmt.Errorf("invalid plaintext length %d", unpaddedLen)
+	}
+	padded := make([]byte, 2+paddedLen(unpaddedLen))
+	binary.BigEndian.PutUint16(padded, uint16(unpaddedLen))
+	copy(padded[2:], plaintext)
+	return padded, nil
+}
+
+func unpad(padded []byte) (string, error) {
+	unpaddedLen := int(binary.BigEndian.Uint16(padded[0:2]))
+	if unpaddedLen < minPlaintextLen || len(padded) != 2+paddedLen(unpaddedLen) {
+		return "", fmt.Errorf("invalid padding")
+	}
+	return string(padded[2 : 2+unpaddedLen]), nil
+}
@@ -0,0 +1,47 @@
+package nip44
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestConversationKey(t *testing.T) {
+	// test vector from the NIP-44 specification
+	sec1 := strings.Repeat("0", 63) + "1"
+	pub2 := "c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5"
+	key, err := ConversationKey(pub2, sec1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "c41c775356fd92eadc63ff5a0dc1da211b268cbea22316767095b2871ea1412d"
+	if got := hex.EncodeToString(key); got != want {
+		t.Errorf("ConversationKey() = %s, want %s", got, want)
+	}
+	nonce, _ := hex.DecodeString(strings.Repeat("0", 63) + "1")
+	payload, err := encrypt("a", key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPayload := "AgAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAABee0G5VSK0/9YypIObAtDKfYEAjD35uVkHyB0F4DwrcNaCXlCWZKaArsGrY6M9wnuTMxWfp1RTN9Xga8no+kF5Vsb"
+	if payload != wantPayload {
+		t.Errorf("encrypt() = %s, want %s", payload, wantPayload)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, _ := hex.DecodeString(strings.Repeat("ab", 32))
+	for _, plaintext := range []string{"a", "hello nostr 💜", strings.Repeat("x", 300), strings.Repeat("y", 65535)} {
+		payload, err := Encrypt(plaintext, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := Decrypt(payload, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted != plaintext {
+			t.Errorf("Decrypt() returned %d bytes, want %d", len(decrypted), len(plaintext))
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package price
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultHistoryResolution is used if no price.history_resolution is configured
+	defaultHistoryResolution = 5 * time.Minute
+	// defaultHistoryRetention is used if no price.history_retention is configured
+	defaultHistoryRetention = 730 * 24 * time.Hour
+	historyPruneInterval    = 24 * time.Hour
+)
+
+// Sample is a stored price of one bitcoin in a currency
+type Sample struct {
+	ID       uint      `gorm:"primaryKey"`
+	Currency string    `gorm:"index:idx_price_samples_currency_time"`
+	Price    float64   `json:"price"`
+	Time     time.Time `gorm:"index:idx_price_samples_currency_time"`
+}
+
+// TableName sets the table of the samples
+func (Sample) TableName() string {
+	return "price_samples"
+}
+
+// Bucket is the average, lowest and highest price of a currency in a time interval
+type Bucket struct {
+	Time  time.Time
+	Price float64
+	Low   float64
+	High  float64
+}
+
+// History stores price samples in a database
+type History struct {
+	db         *gorm.DB
+	Resolution time.Duration
+	Retention  time.Duration
+	lastSample map[string]time.Time
+	lastPrune  time.Time
+	mu         sync.Mutex
+}
+
+var H *History
+
+// NewHistory creates the price history in the database
+func NewHistory(db *gorm.DB) (*History, error) {
+	err := db.AutoMigrate(&Sample{})
+	if err != nil {
+		return nil, err
+	}
+	resolution := time.Duration(internal.Configuration.Price.HistoryResolution) * time.Second
+	if resolution <= 0 {
+		resolution = defaultHistoryResolution
+	}
+	retention := time.Duration(internal.Configuration.Price.HistoryRetention) * 24 * time.Hour
+	if retention <= 0 {
+		retention = defaultHistoryRetention
+	}
+	H = &History{db: db, Resolution: resolution, Retention: retention, lastSample: make(map[string]time.Time)}
+	return H, nil
+}
+
+// Record stores the price if the last sample of the currency is older than the resolution
+func (h *History) Record(currency string, price float64, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if price <= 0 || t.Sub(h.lastSample[currency]) < h.Resolution {
+		return
+	}
+	tx := h.db.Create(&Sample{Currency: currency, Price: price, Time: t.UTC()})
+	if tx.Error != nil {
+		log.Errorf("[PriceHistory] could not store %s price: %v", currency, tx.Error)
+		return
+	}
+	h.lastSample[currency] = t
+	if t.Sub(h.lastPrune) >= historyPruneInterval {
+		h.prune(t.Add(-h.Retention))
+		h.lastPrune = t
+	}
+}
+
+// prune deletes all samples older than before
+func (h *History) prune(before time.Time) {
+	tx := h.db.Where("time < ?", before.UTC()).Delete(&Sample{})
+	if tx.Error != nil {
+		log.Errorf("[PriceHistory] could not delete old prices: %v", tx.Error)
+		return
+	}
+	if tx.RowsAffected > 0 {
+		log.Infof("[PriceHistory] Deleted %d prices older than %s", tx.RowsAffected, before.Format(time.RFC3339))
+	}
+}
+
+// Latest returns the most recent sample of every currency
+func (h *History) Latest() ([]Sample, error) {
+	var samples []Sample
+	tx := h.db.Where("id IN (?)", h.db.Model(&Sample{}).Select("max(id)").Group("currency")).Find(&samples)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, sample := range samples {
+		if sample.Time.After(h.lastSample[sample.Currency]) {
+			h.lastSample[sample.Currency] = sample.Time
+		}
+	}
+	return samples, nil
+}
+
+// At returns the price of the sample closest to t. Only samples within one
+// resolution window of t are used, older or newer prices would be misleading.
+func (h *History) At(currency string, t time.Time) (float64, error) {
+	var before, after Sample
+	tx := h.db.Where("currency = ? AND time <= ? AND time >= ?", currency, t.UTC(), t.Add(-h.Resolution).UTC()).Order("time desc").Limit(1).Find(&before)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	foundBefore := tx.RowsAffected > 0
+	tx = h.db.Where("currency = ? AND time > ? AND time <= ?", currency, t.UTC(), t.Add(h.Resolution).UTC()).Order("time asc").Limit(1).Find(&after)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	foundAfter := tx.RowsAffected > 0
+	switch {
+	case foundBefore && (!foundAfter || t.Sub(before.Time) <= after.Time.Sub(t)):
+		return before.Price, nil
+	case foundAfter:
+		return after.Price, nil
+	}
+	return 0, fmt.Errorf("no %s price at %s", currency, t.Format(time.RFC3339))
+}
+
+// Range returns the prices of a currency since the given time, oldest first. The samples
+// are aggregated by the database into buckets of the given width.
+func (h *History) Range(currency string, since time.Time, width time.Duration) ([]Bucket, error) {
+	seconds := int64(width / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	var rows []struct {
+		Start int64
+		Price float64
+		Low   float64
+		High  float64
+	}
+	unix := "CAST(strftime('%s', time) AS INTEGER)"
+	tx := h.db.Model(&Sample{}).
+		Select(fmt.Sprintf("(%s - %d) / %d AS bucket, min(%s) AS start, avg(price) AS price, min(price) AS low, max(price) AS high", unix, since.Unix(), seconds, unix)).
+		Where("currency = ? AND time >= ?", currency, since.UTC()).
+		Group("bucket").
+		Order("bucket").
+		Scan(&rows)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	buckets := make([]Bucket, 0, len(rows))
+	for _, row := range rows {
+		buckets = append(buckets, Bucket{Time: time.Unix(row.Start, 0), Price: row.Price, Low: row.Low, High: row.High})
+	}
+	return buckets, nil
+}
+
+// PriceAt returns the historical price of one bitcoin in the currency at time t
+func PriceAt(currency string, t time.Time) (float64, error) {
+	if H == nil {
+		return 0, fmt.Errorf("price history not available")
+	}
+	return H.At(currency, t)
+}
+
+// PriceRange returns the stored prices of the currency since the given time in buckets of the given width
+func PriceRange(currency string, since time.Time, width time.Duration) ([]Bucket, error) {
+	if H == nil {
+		return nil, fmt.Errorf("price history not available")
+	}
+	return H.Range(currency, since, width)
+}
@@ -0,0 +1,85 @@
+package price
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestHistory(t *testing.T) *History {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHistory(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Resolution = 5 * time.Minute
+	return h
+}
+
+func TestHistory_At(t *testing.T) {
+	h := newTestHistory(t)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	h.Record("EUR", 100, start)
+	h.Record("EUR", 200, start.Add(10*time.Minute))
+	for _, v := range []struct {
+		t     time.Time
+		price float64
+		ok    bool
+	}{
+		{start, 100, true},
+		{start.Add(2 * time.Minute), 100, true},
+		{start.Add(8 * time.Minute), 200, true},
+		{start.Add(-time.Minute), 100, true},
+		{start.Add(-time.Hour), 0, false},
+		{start.Add(time.Hour), 0, false},
+	} {
+		price, err := h.At("EUR", v.t)
+		if (err == nil) != v.ok || price != v.price {
+			t.Errorf("At(%s) = %v, %v, want %v", v.t.Format(time.Kitchen), price, err, v.price)
+		}
+	}
+}
+
+func TestHistory_Range(t *testing.T) {
+	h := newTestHistory(t)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, p := range []float64{100, 300, 200, 600} {
+		h.Record("EUR", p, start.Add(time.Duration(i)*10*time.Minute))
+	}
+	h.Record("USD", 1, start)
+	buckets, err := h.Range("EUR", start, 20*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Bucket{
+		{Time: start, Price: 200, Low: 100, High: 300},
+		{Time: start.Add(20 * time.Minute), Price: 400, Low: 200, High: 600},
+	}
+	if len(buckets) != len(want) {
+		t.Fatalf("Range() = %v, want %v", buckets, want)
+	}
+	for i := range want {
+		if !buckets[i].Time.Equal(want[i].Time) || buckets[i].Price != want[i].Price || buckets[i].Low != want[i].Low || buckets[i].High != want[i].High {
+			t.Errorf("Range()[%d] = %v, want %v", i, buckets[i], want[i])
+		}
+	}
+}
+
+func TestHistory_Retention(t *testing.T) {
+	h := newTestHistory(t)
+	h.Retention = 24 * time.Hour
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	h.Record("EUR", 100, start)
+	h.Record("EUR", 200, start.Add(48*time.Hour))
+	if _, err := h.At("EUR", start); err == nil {
+		t.Errorf("At() found a price older than the retention")
+	}
+	if p, err := h.At("EUR", start.Add(48*time.Hour)); err != nil || p != 200 {
+		t.Errorf("At() = %v, %v, want 200", p, err)
+	}
+}
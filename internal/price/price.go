@@ -2,29 +2,46 @@ package price
 
 import (
 	"fmt"
-	"io/ioutil"
+	"math"
 	"net/http"
-	"net/url"
-	"strconv"
-	"strings"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/massmux/SatsMobiBot/internal"
 	log "github.com/sirupsen/logrus"
-	"github.com/tidwall/gjson"
+)
+
+const (
+	// maxDeviation is the relative distance to the median above which a price is ignored
+	maxDeviation  = 0.05
+	defaultMaxAge = 5 * time.Minute
 )
 
 type PriceWatcher struct {
 	client         *http.Client
 	UpdateInterval time.Duration
+	MaxAge         time.Duration
 	Currencies     map[string]string
-	Exchanges      map[string]func(string) (float64, error)
+	Providers      []PriceProvider
 }
 
 var (
-	Price map[string]float64
-	P     *PriceWatcher
+	// latest and updated are written by the watcher, read them with Get
+	latest    map[string]float64
+	updated   map[string]time.Time
+	P         *PriceWatcher
+	mu        sync.RWMutex
+	listeners []func(currency string, price float64)
 )
 
+// OnUpdate registers a function that is called after every price update
+func OnUpdate(f func(currency string, price float64)) {
+	mu.Lock()
+	defer mu.Unlock()
+	listeners = append(listeners, f)
+}
+
 func NewPriceWatcher() *PriceWatcher {
 	pricewatcher := &PriceWatcher{
 		client: &http.Client{
@@ -42,91 +59,169 @@ func NewPriceWatcher() *PriceWatcher {
 			"TRY": "₺",
 			"INR": "₹",
 		},
-		Exchanges:      make(map[string]func(string) (float64, error), 0),
 		UpdateInterval: time.Second * time.Duration(30),
+		MaxAge:         time.Duration(internal.Configuration.Price.MaxAge) * time.Second,
+	}
+	if pricewatcher.MaxAge <= 0 {
+		pricewatcher.MaxAge = defaultMaxAge
 	}
-	pricewatcher.Exchanges["coinbase"] = pricewatcher.GetCoinbasePrice
-	pricewatcher.Exchanges["bitfinex"] = pricewatcher.GetBitfinexPrice
-	Price = make(map[string]float64, 0)
+	pricewatcher.Providers = pricewatcher.newProviders(internal.Configuration.Price)
+	latest = make(map[string]float64, 0)
+	updated = make(map[string]time.Time, 0)
 	log.Infof("[PriceWatcher] Watcher started")
 	P = pricewatcher
 	return pricewatcher
 }
 
+// newProviders creates the configured providers. All exchanges are used if none are configured.
+func (p *PriceWatcher) newProviders(config internal.PriceConfiguration) []PriceProvider {
+	names := config.Providers
+	if len(names) == 0 {
+		names = []string{"kraken", "bitstamp", "coinbase", "bitfinex"}
+	}
+	var providers []PriceProvider
+	for _, name := range names {
+		switch name {
+		case "kraken":
+			providers = append(providers, KrakenProvider{client: p.client})
+		case "bitstamp":
+			providers = append(providers, BitstampProvider{client: p.client})
+		case "coinbase":
+			providers = append(providers, CoinbaseProvider{client: p.client})
+		case "bitfinex":
+			providers = append(providers, BitfinexProvider{client: p.client})
+		case "file":
+			providers = append(providers, FileProvider{Path: config.File})
+		case "fixed":
+			providers = append(providers, FixedProvider{Prices: config.FixedRates})
+		default:
+			log.Warnf("[PriceWatcher] Unknown price provider %s", name)
+		}
+	}
+	return providers
+}
+
 func (p *PriceWatcher) Start() {
+	p.restore()
 	go p.Watch()
 }
 
+// restore fills the prices from the history until the exchanges answer
+func (p *PriceWatcher) restore() {
+	if H == nil {
+		return
+	}
+	samples, err := H.Latest()
+	if err != nil {
+		log.Errorf("[PriceWatcher] could not restore prices: %v", err)
+		return
+	}
+	mu.Lock()
+	for _, sample := range samples {
+		if _, ok := p.Currencies[sample.Currency]; ok {
+			latest[sample.Currency] = sample.Price
+			updated[sample.Currency] = sample.Time
+		}
+	}
+	mu.Unlock()
+	log.Infof("[PriceWatcher] Restored %d prices from history", len(samples))
+}
+
 func (p *PriceWatcher) Watch() error {
 	for {
-		for currency, _ := range p.Currencies {
-			avg_price := 0.0
-			n_responses := 0
-			for _, getPrice := range p.Exchanges {
-				fprice, err := getPrice(currency)
-				if err != nil {
-					// log.Debug(err)
-					// if one exchanges is down, use the next
-					continue
-				}
-				n_responses++
-				avg_price += fprice
-				// log.Debugf("[PriceWatcher] %s %s price: %f", exchange, currency, fprice)
-				time.Sleep(time.Second * time.Duration(2))
-			}
-			Price[currency] = avg_price / float64(n_responses)
-			// log.Debugf("[PriceWatcher] Average %s price: %f", currency, Price[currency])
+		var wg sync.WaitGroup
+		for currency := range p.Currencies {
+			wg.Add(1)
+			go func(currency string) {
+				defer wg.Done()
+				p.update(currency)
+			}(currency)
 		}
+		wg.Wait()
 		time.Sleep(p.UpdateInterval)
 	}
 }
 
-func (p *PriceWatcher) GetCoinbasePrice(currency string) (float64, error) {
-	coinbaseEndpoint, err := url.Parse(fmt.Sprintf("https://api.coinbase.com/v2/prices/spot?currency=%s", currency))
-	response, err := p.client.Get(coinbaseEndpoint.String())
-	if err != nil {
-		return 0, err
+// update queries all providers concurrently and stores the median of the plausible prices
+func (p *PriceWatcher) update(currency string) {
+	prices := make([]float64, len(p.Providers))
+	var wg sync.WaitGroup
+	for i, provider := range p.Providers {
+		wg.Add(1)
+		go func(i int, provider PriceProvider) {
+			defer wg.Done()
+			fprice, err := provider.Price(currency)
+			if err != nil {
+				// if one provider is down, use the others
+				return
+			}
+			prices[i] = fprice
+		}(i, provider)
 	}
-	bodyBytes, err := ioutil.ReadAll(response.Body)
+	wg.Wait()
+	fprice, err := aggregate(prices)
 	if err != nil {
-		log.Debug(err)
-		return 0, err
-	}
-	price := gjson.Get(string(bodyBytes), "data.amount")
-	if len(price.String()) > 0 {
-		fprice, err := strconv.ParseFloat(strings.TrimSpace(price.String()), 64)
-		if err != nil {
-			log.Debug(err)
-			return 0, err
+		// keep the last known price
+		return
+	}
+	now := time.Now()
+	mu.Lock()
+	latest[currency] = fprice
+	updated[currency] = now
+	notify := append([]func(string, float64){}, listeners...)
+	mu.Unlock()
+	if H != nil {
+		H.Record(currency, fprice, now)
+	}
+	for _, f := range notify {
+		f(currency, fprice)
+	}
+}
+
+// aggregate returns the median of the prices after removing prices too far from the median
+func aggregate(prices []float64) (float64, error) {
+	var valid []float64
+	for _, fprice := range prices {
+		if fprice > 0 && !math.IsInf(fprice, 0) {
+			valid = append(valid, fprice)
 		}
-		return fprice, nil
-	} else {
+	}
+	if len(valid) == 0 {
 		return 0, fmt.Errorf("no price")
 	}
+	m := median(valid)
+	var plausible []float64
+	for _, fprice := range valid {
+		if math.Abs(fprice-m)/m <= maxDeviation {
+			plausible = append(plausible, fprice)
+		}
+	}
+	if len(plausible) == 0 {
+		return 0, fmt.Errorf("no plausible price")
+	}
+	return median(plausible), nil
 }
 
-func (p *PriceWatcher) GetBitfinexPrice(currency string) (float64, error) {
-	var bitfinexCurrencyToPair = map[string]string{"USD": "btcusd", "EUR": "btceur", "GBP": "btcusd", "JPY": "btcjpy"}
-	pair := bitfinexCurrencyToPair[currency]
-	bitfinexEndpoint, err := url.Parse(fmt.Sprintf("https://api.bitfinex.com/v1/pubticker/%s", pair))
-	response, err := p.client.Get(bitfinexEndpoint.String())
-	if err != nil {
-		return 0, err
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
 	}
-	bodyBytes, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Debug(err)
-		return 0, err
-	}
-	price := gjson.Get(string(bodyBytes), "last_price")
-	if len(price.String()) > 0 {
-		fprice, err := strconv.ParseFloat(strings.TrimSpace(price.String()), 64)
-		if err != nil {
-			log.Debug(err)
-			return 0, err
-		}
-		return fprice, nil
-	} else {
-		return 0, fmt.Errorf("no price")
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// Get returns the price of one bitcoin in the currency if it is not older than MaxAge
+func Get(currency string) (float64, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	fprice := latest[currency]
+	if !(fprice > 0) {
+		return 0, fmt.Errorf("no %s price", currency)
+	}
+	if time.Since(updated[currency]) > P.MaxAge {
+		return 0, fmt.Errorf("%s price is stale since %s", currency, updated[currency].Format(time.RFC3339))
 	}
+	return fprice, nil
 }
@@ -0,0 +1,87 @@
+package price
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// stubProvider returns a fixed price and error for every currency
+type stubProvider struct {
+	price float64
+	err   error
+}
+
+func (p stubProvider) Name() string {
+	return "stub"
+}
+
+func (p stubProvider) Price(currency string) (float64, error) {
+	return p.price, p.err
+}
+
+func TestMedian(t *testing.T) {
+	for _, v := range []struct {
+		values []float64
+		want   float64
+	}{
+		{[]float64{5}, 5},
+		{[]float64{3, 1, 2}, 2},
+		{[]float64{4, 1, 3, 2}, 2.5},
+		{[]float64{100, 102}, 101},
+	} {
+		if got := median(v.values); got != v.want {
+			t.Errorf("median(%v) = %v, want %v", v.values, got, v.want)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	for _, v := range []struct {
+		name   string
+		prices []float64
+		want   float64
+		ok     bool
+	}{
+		{"single provider", []float64{50000}, 50000, true},
+		{"even count", []float64{50000, 50100, 50200, 50300}, 50150, true},
+		{"failed provider", []float64{50000, 0, 50200}, 50100, true},
+		{"outlier", []float64{50000, 50100, 50200, 1}, 50100, true},
+		{"all failed", []float64{0, 0}, 0, false},
+		{"no providers", nil, 0, false},
+	} {
+		got, err := aggregate(v.prices)
+		if (err == nil) != v.ok || got != v.want {
+			t.Errorf("aggregate(%s) = %v, %v, want %v", v.name, got, err, v.want)
+		}
+	}
+}
+
+func TestPriceWatcher_update(t *testing.T) {
+	latest = make(map[string]float64)
+	updated = make(map[string]time.Time)
+	p := &PriceWatcher{MaxAge: time.Minute, Providers: []PriceProvider{
+		FixedProvider{Prices: map[string]float64{"EUR": 40000}},
+		stubProvider{price: 0},
+		stubProvider{err: fmt.Errorf("down")},
+		stubProvider{price: 40400},
+	}}
+	P = p
+	p.update("EUR")
+	if got, err := Get("EUR"); err != nil || got != 40200 {
+		t.Errorf("Get(EUR) = %v, %v, want 40200", got, err)
+	}
+	// without any price the last one is kept
+	p.Providers = []PriceProvider{stubProvider{err: fmt.Errorf("down")}}
+	p.update("EUR")
+	if got, err := Get("EUR"); err != nil || got != 40200 {
+		t.Errorf("Get(EUR) after failure = %v, %v, want 40200", got, err)
+	}
+	if _, err := Get("USD"); err == nil {
+		t.Errorf("Get(USD) without price succeeded")
+	}
+	updated["EUR"] = time.Now().Add(-time.Hour)
+	if _, err := Get("EUR"); err == nil {
+		t.Errorf("Get(EUR) with stale price succeeded")
+	}
+}
@@ -0,0 +1,143 @@
+package price
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// PriceProvider returns the price of one bitcoin in a currency
+type PriceProvider interface {
+	Name() string
+	Price(currency string) (float64, error)
+}
+
+// getJSON fetches the endpoint and returns the value at the gjson path as float
+func getJSON(client *http.Client, endpoint string, path string) (float64, error) {
+	response, err := client.Get(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return 0, fmt.Errorf("status %d", response.StatusCode)
+	}
+	bodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return 0, err
+	}
+	price := gjson.GetBytes(bodyBytes, path)
+	if len(price.String()) == 0 {
+		return 0, fmt.Errorf("no price")
+	}
+	return strconv.ParseFloat(strings.TrimSpace(price.String()), 64)
+}
+
+type CoinbaseProvider struct {
+	client *http.Client
+}
+
+func (p CoinbaseProvider) Name() string {
+	return "coinbase"
+}
+
+func (p CoinbaseProvider) Price(currency string) (float64, error) {
+	return getJSON(p.client, fmt.Sprintf("https://api.coinbase.com/v2/prices/spot?currency=%s", currency), "data.amount")
+}
+
+type BitfinexProvider struct {
+	client *http.Client
+}
+
+var bitfinexCurrencyToPair = map[string]string{"USD": "btcusd", "EUR": "btceur", "GBP": "btcgbp", "JPY": "btcjpy"}
+
+func (p BitfinexProvider) Name() string {
+	return "bitfinex"
+}
+
+func (p BitfinexProvider) Price(currency string) (float64, error) {
+	pair, ok := bitfinexCurrencyToPair[currency]
+	if !ok {
+		return 0, fmt.Errorf("%s not supported", currency)
+	}
+	return getJSON(p.client, fmt.Sprintf("https://api.bitfinex.com/v1/pubticker/%s", pair), "last_price")
+}
+
+type KrakenProvider struct {
+	client *http.Client
+}
+
+var krakenCurrencies = map[string]bool{"USD": true, "EUR": true, "GBP": true, "JPY": true}
+
+func (p KrakenProvider) Name() string {
+	return "kraken"
+}
+
+func (p KrakenProvider) Price(currency string) (float64, error) {
+	if !krakenCurrencies[currency] {
+		return 0, fmt.Errorf("%s not supported", currency)
+	}
+	// the result is keyed by kraken's own pair name, e.g. XXBTZUSD
+	return getJSON(p.client, fmt.Sprintf("https://api.kraken.com/0/public/Ticker?pair=XBT%s", currency), "result.@values.0.c.0")
+}
+
+type BitstampProvider struct {
+	client *http.Client
+}
+
+var bitstampCurrencies = map[string]bool{"USD": true, "EUR": true, "GBP": true}
+
+func (p BitstampProvider) Name() string {
+	return "bitstamp"
+}
+
+func (p BitstampProvider) Price(currency string) (float64, error) {
+	if !bitstampCurrencies[currency] {
+		return 0, fmt.Errorf("%s not supported", currency)
+	}
+	return getJSON(p.client, fmt.Sprintf("https://www.bitstamp.net/api/v2/ticker/btc%s/", strings.ToLower(currency)), "last")
+}
+
+// FileProvider reads prices from a json file like {"USD": 50000, "EUR": 46000}
+type FileProvider struct {
+	Path string
+}
+
+func (p FileProvider) Name() string {
+	return "file"
+}
+
+func (p FileProvider) Price(currency string) (float64, error) {
+	data, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return 0, err
+	}
+	prices := make(map[string]float64)
+	err = json.Unmarshal(data, &prices)
+	if err != nil {
+		return 0, err
+	}
+	return FixedProvider{Prices: prices}.Price(currency)
+}
+
+// FixedProvider returns fixed prices
+type FixedProvider struct {
+	Prices map[string]float64
+}
+
+func (p FixedProvider) Name() string {
+	return "fixed"
+}
+
+func (p FixedProvider) Price(currency string) (float64, error) {
+	price, ok := p.Prices[currency]
+	if !ok || !(price > 0) {
+		return 0, fmt.Errorf("%s not supported", currency)
+	}
+	return price, nil
+}
@@ -0,0 +1,143 @@
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed five field cron expression (minute hour day-of-month month day-of-week).
+type Cron struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+	// day restrictions are OR'ed if both day fields are restricted
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+type cronBounds struct {
+	min, max int
+}
+
+var (
+	cronMinuteBounds     = cronBounds{0, 59}
+	cronHourBounds       = cronBounds{0, 23}
+	cronDayOfMonthBounds = cronBounds{1, 31}
+	cronMonthBounds      = cronBounds{1, 12}
+	cronDayOfWeekBounds  = cronBounds{0, 7}
+)
+
+// ParseCron parses expressions like "0 9 * * 1" or "*/15 8-18 * * 1-5".
+func ParseCron(expression string) (*Cron, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression needs 5 fields, got %d", len(fields))
+	}
+	var err error
+	c := &Cron{
+		anyDayOfMonth: fields[2] == "*",
+		anyDayOfWeek:  fields[4] == "*",
+	}
+	if c.minute, err = parseCronField(fields[0], cronMinuteBounds); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseCronField(fields[1], cronHourBounds); err != nil {
+		return nil, err
+	}
+	if c.dayOfMonth, err = parseCronField(fields[2], cronDayOfMonthBounds); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseCronField(fields[3], cronMonthBounds); err != nil {
+		return nil, err
+	}
+	if c.dayOfWeek, err = parseCronField(fields[4], cronDayOfWeekBounds); err != nil {
+		return nil, err
+	}
+	// sunday can be 0 or 7
+	if c.dayOfWeek&(1<<7) != 0 {
+		c.dayOfWeek |= 1
+	}
+	return c, nil
+}
+
+func parseCronField(field string, bounds cronBounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %s", part)
+			}
+			part = part[:i]
+		}
+		start, end := bounds.min, bounds.max
+		if part != "*" {
+			var err error
+			if i := strings.Index(part, "-"); i >= 0 {
+				if start, err = strconv.Atoi(part[:i]); err != nil {
+					return 0, fmt.Errorf("invalid range %s", part)
+				}
+				if end, err = strconv.Atoi(part[i+1:]); err != nil {
+					return 0, fmt.Errorf("invalid range %s", part)
+				}
+			} else {
+				if start, err = strconv.Atoi(part); err != nil {
+					return 0, fmt.Errorf("invalid value %s", part)
+				}
+				end = start
+				if step > 1 {
+					end = bounds.max
+				}
+			}
+		}
+		if start < bounds.min || end > bounds.max || start > end {
+			return 0, fmt.Errorf("value out of range in %s", field)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c *Cron) matchesDay(t time.Time) bool {
+	domMatch := c.dayOfMonth&(1<<uint(t.Day())) != 0
+	dowMatch := c.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first time after t matching the expression.
+// A zero time is returned if there is no match within the next five years.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,53 @@
+package runtime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expression := range []string{"* * * * *", "0 9 * * 1", "*/15 8-18 * * 1-5", "0 0 1,15 * *", "30 12 * * 7"} {
+		if _, err := ParseCron(expression); err != nil {
+			t.Errorf("ParseCron(%q) error: %v", expression, err)
+		}
+	}
+	for _, expression := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expression); err == nil {
+			t.Errorf("ParseCron(%q) expected error", expression)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Wednesday
+	from := time.Date(2024, 1, 31, 10, 7, 30, 0, time.UTC)
+	for _, v := range []struct {
+		expression string
+		want       time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 31, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 15, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1", time.Date(2024, 2, 5, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 1 *", time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)},
+		// both day fields restricted match either of them
+		{"0 0 15 * 5", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+	} {
+		c, err := ParseCron(v.expression)
+		if err != nil {
+			t.Fatalf("ParseCron(%q) error: %v", v.expression, err)
+		}
+		if got := c.Next(from); !got.Equal(v.want) {
+			t.Errorf("Next(%q) = %v, want %v", v.expression, got, v.want)
+		}
+	}
+	// february 30th never exists
+	c, _ := ParseCron("0 0 30 2 *")
+	if got := c.Next(from); !got.IsZero() {
+		t.Errorf("Next(0 0 30 2 *) = %v, want zero time", got)
+	}
+}
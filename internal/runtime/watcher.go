@@ -0,0 +1,15 @@
+package runtime
+
+import "time"
+
+// Watch loads all items every interval and calls update for each of them.
+// It blocks forever and is meant to be started in its own goroutine.
+func Watch[T any](interval time.Duration, load func() []T, update func(T)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, item := range load() {
+			update(item)
+		}
+	}
+}
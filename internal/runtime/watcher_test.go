@@ -0,0 +1,27 @@
+package runtime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	updated := make(chan int, 10)
+	loads := 0
+	go Watch(time.Millisecond, func() []int {
+		loads++
+		return []int{loads, loads}
+	}, func(i int) {
+		updated <- i
+	})
+	for _, want := range []int{1, 1, 2, 2} {
+		select {
+		case got := <-updated:
+			if got != want {
+				t.Fatalf("update(%d), want %d", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("watcher did not tick")
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/price"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	alertHelpMessage      = "🔔 *Price alerts*\n\n`/alert <currency> <above|below> <price>` Get notified when BTC crosses a price.\n`/alert list` List your alerts.\n`/alert remove <id>` Remove an alert.\n\nAn alert fires again after the price moved back by %.0f%%."
+	alertSetMessage       = "🔔 Alert `%d` set: BTC %s %s (now %s)."
+	alertListMessage      = "🔔 *Your price alerts*\n\n%s"
+	alertEntryMessage     = "`%d` BTC %s %s%s\n"
+	alertWaitingMessage   = " _(waiting for reset)_"
+	alertEmptyMessage     = "🔔 You have no price alerts. Set one with `/alert <currency> <above|below> <price>`."
+	alertRemovedMessage   = "✅ Alert `%d` removed."
+	alertNotFoundMessage  = "🚫 Alert `%s` not found."
+	alertLimitMessage     = "🚫 You can set up to %d alerts."
+	alertCurrencyMessage  = "🚫 There is no current `%s` price."
+	alertTriggeredMessage = "🔔 BTC is %s %s: %s."
+)
+
+const (
+	alertsMaxPerUser = 10
+	// alertHysteresis is the relative distance the price must move back over the level before an alert fires again
+	alertHysteresis = 0.01
+)
+
+// PriceAlert notifies a user when the price crosses a level. An alert is disarmed
+// when it fires and armed again when the price moved back by alertHysteresis.
+type PriceAlert struct {
+	ID           uint      `gorm:"primarykey"`
+	TelegramID   int64     `gorm:"index"`
+	LanguageCode string    `json:"language_code"`
+	Currency     string    `gorm:"index"`
+	Above        bool      `json:"above"`
+	Price        float64   `json:"price"`
+	Armed        bool      `json:"armed"`
+	TriggeredAt  time.Time `json:"triggered_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (a PriceAlert) direction() string {
+	if a.Above {
+		return "above"
+	}
+	return "below"
+}
+
+// crossed returns true if the price is beyond the level of the alert
+func (a PriceAlert) crossed(fprice float64) bool {
+	if a.Above {
+		return fprice >= a.Price
+	}
+	return fprice <= a.Price
+}
+
+// reset returns true if the price moved back far enough to arm the alert again
+func (a PriceAlert) reset(fprice float64) bool {
+	if a.Above {
+		return fprice <= a.Price*(1-alertHysteresis)
+	}
+	return fprice >= a.Price*(1+alertHysteresis)
+}
+
+// parseAlertPrice parses prices like 50000, 50k or 49,500.50
+func parseAlertPrice(input string) (float64, error) {
+	input = strings.ToLower(strings.Replace(input, ",", "", -1))
+	multiplier := 1.0
+	if strings.HasSuffix(input, "k") {
+		multiplier = 1000
+		input = strings.TrimSuffix(input, "k")
+	}
+	fprice, err := strconv.ParseFloat(input, 64)
+	if err != nil || !(fprice > 0) {
+		return 0, fmt.Errorf("invalid price")
+	}
+	return fprice * multiplier, nil
+}
+
+// alertHandler handles /alert
+func (bot *TipBot) alertHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	arguments := strings.Fields(m.Text)
+	if len(arguments) < 2 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(alertHelpMessage, alertHysteresis*100))
+		return ctx, nil
+	}
+	switch strings.ToLower(arguments[1]) {
+	case "list":
+		return bot.listAlertsHandler(ctx)
+	case "remove", "delete":
+		if len(arguments) < 3 {
+			break
+		}
+		return bot.removeAlertHandler(ctx, arguments[2])
+	default:
+		if len(arguments) < 4 {
+			break
+		}
+		return bot.addAlertHandler(ctx, arguments[1], arguments[2], arguments[3])
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(alertHelpMessage, alertHysteresis*100))
+	return ctx, errors.Create(errors.InvalidSyntaxError)
+}
+
+func (bot *TipBot) addAlertHandler(ctx intercept.Context, currency, direction, level string) (intercept.Context, error) {
+	m := ctx.Message()
+	currency = strings.ToUpper(currency)
+	direction = strings.ToLower(direction)
+	fprice, err := parseAlertPrice(level)
+	if err != nil || (direction != "above" && direction != "below") {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(alertHelpMessage, alertHysteresis*100))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	current, err := price.Get(currency)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(alertCurrencyMessage, currency))
+		return ctx, err
+	}
+	var count int64
+	bot.DB.Users.Model(&PriceAlert{}).Where("telegram_id = ?", m.Sender.ID).Count(&count)
+	if count >= alertsMaxPerUser {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(alertLimitMessage, alertsMaxPerUser))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	alert := &PriceAlert{
+		TelegramID:   m.Sender.ID,
+		LanguageCode: m.Sender.LanguageCode,
+		Currency:     currency,
+		Above:        direction == "above",
+		Price:        fprice,
+	}
+	// an alert that is already crossed fires after the price moved back
+	alert.Armed = !alert.crossed(current)
+	tx := bot.DB.Users.Create(alert)
+	if tx.Error != nil {
+		log.Errorf("[alert] could not save alert of %s: %v", GetUserStr(m.Sender), tx.Error)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, tx.Error
+	}
+	log.Infof("[alert] %s set alert %d: BTC %s %.2f %s", GetUserStr(m.Sender), alert.ID, direction, fprice, currency)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(alertSetMessage, alert.ID, direction,
+		FormatFiat(fprice, currency, alert.LanguageCode), FormatFiat(current, currency, alert.LanguageCode)))
+	return ctx, nil
+}
+
+func (bot *TipBot) listAlertsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	var alerts []PriceAlert
+	tx := bot.DB.Users.Where("telegram_id = ?", m.Sender.ID).Order("currency, price").Find(&alerts)
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	if len(alerts) == 0 {
+		bot.trySendMessage(m.Sender, alertEmptyMessage)
+		return ctx, nil
+	}
+	list := ""
+	for _, alert := range alerts {
+		waiting := ""
+		if !alert.Armed {
+			waiting = alertWaitingMessage
+		}
+		list += fmt.Sprintf(alertEntryMessage, alert.ID, alert.direction(), FormatFiat(alert.Price, alert.Currency, alert.LanguageCode), waiting)
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(alertListMessage, list))
+	return ctx, nil
+}
+
+func (bot *TipBot) removeAlertHandler(ctx intercept.Context, id string) (intercept.Context, error) {
+	m := ctx.Message()
+	alertID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(alertNotFoundMessage, id))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	tx := bot.DB.Users.Where("id = ? AND telegram_id = ?", alertID, m.Sender.ID).Delete(&PriceAlert{})
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(alertNotFoundMessage, id))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(alertRemovedMessage, alertID))
+	return ctx, nil
+}
+
+// checkPriceAlerts is called by the price watcher after every update of a currency
+func (bot *TipBot) checkPriceAlerts(currency string, fprice float64) {
+	var alerts []PriceAlert
+	tx := bot.DB.Users.Where("currency = ?", currency).Find(&alerts)
+	if tx.Error != nil {
+		log.Errorf("[alert] could not load %s alerts: %v", currency, tx.Error)
+		return
+	}
+	for _, alert := range alerts {
+		switch {
+		case alert.Armed && alert.crossed(fprice):
+			alert.Armed = false
+			alert.TriggeredAt = time.Now()
+			if tx := bot.DB.Users.Save(&alert); tx.Error != nil {
+				log.Errorf("[alert] could not update alert %d: %v", alert.ID, tx.Error)
+				continue
+			}
+			log.Infof("[alert] alert %d triggered: BTC %s %.2f %s", alert.ID, alert.direction(), alert.Price, currency)
+			bot.trySendMessage(&tb.User{ID: alert.TelegramID}, fmt.Sprintf(alertTriggeredMessage, alert.direction(),
+				FormatFiat(alert.Price, currency, alert.LanguageCode), FormatFiat(fprice, currency, alert.LanguageCode)))
+		case !alert.Armed && alert.reset(fprice):
+			alert.Armed = true
+			if tx := bot.DB.Users.Save(&alert); tx.Error != nil {
+				log.Errorf("[alert] could not update alert %d: %v", alert.ID, tx.Error)
+			}
+		}
+	}
+}
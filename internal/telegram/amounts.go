@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -18,6 +19,10 @@ import (
 	"github.com/massmux/SatsMobiBot/internal/price"
 	"github.com/massmux/SatsMobiBot/internal/runtime"
 	log "github.com/sirupsen/logrus"
+	xcurrency "golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
 
@@ -48,8 +53,8 @@ func decodeAmountFromCommand(input string) (amount int64, err error) {
 // GetAmount parses an amount from a string like 1.2k or 3.50€
 // and returns the value in satoshis
 func GetAmount(input string) (amount int64, err error) {
-	// replace occurances of comma with dot
-	input = strings.Replace(input, ",", ".", -1)
+	// read 1,000 and 1.000,50 like ParseFiatAmount does
+	input = normalizeDecimalSeparator(input)
 
 	// replace strings in amountsMap with their integer values
 	for k, v := range amountsMap {
@@ -67,43 +72,145 @@ func GetAmount(input string) (amount int64, err error) {
 	}
 
 	// convert fiat currencies to satoshis
-	for currency, symbol := range price.P.Currencies {
+	fmount, currency, ok, err := parseFiatAmount(input)
+	if ok {
+		if err != nil {
+			return 0, err
+		}
+		// refuse to convert with a stale price
+		fprice, err := price.Get(currency)
+		if err != nil {
+			return 0, err
+		}
+		amount = int64(fmount / fprice * float64(100_000_000))
+		return amount, nil
+	}
+
+	// use plain integer as satoshis
+	amount, err = strconv.ParseInt(input, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if amount <= 0 {
+		return 0, fmt.Errorf("amount must be greater than 0")
+	}
+	return amount, err
+}
+
+// ParseFiatAmount parses an amount like 3.50€, 3,50€, 1.000,50€ or USD10.
+// ok is false if the input has no currency.
+func ParseFiatAmount(input string) (fiat float64, currency string, ok bool, err error) {
+	return parseFiatAmount(normalizeDecimalSeparator(input))
+}
+
+// normalizeDecimalSeparator rewrites the number in input to use . as decimal separator
+// and no thousands separators. If both , and . are used, the last one is the decimal
+// separator. A single , is only a decimal separator if it is followed by 1 or 2 digits,
+// 1,000 is one thousand. Inputs with invalid thousands groups are returned unchanged.
+func normalizeDecimalSeparator(input string) string {
+	lastComma, lastDot := strings.LastIndex(input, ","), strings.LastIndex(input, ".")
+	if lastComma < 0 {
+		if strings.Count(input, ".") < 2 {
+			return input
+		}
+		// 1.000.000
+		return removeThousandsSeparator(input, ".", len(input))
+	}
+	if lastDot > lastComma {
+		// 1,000.50
+		return removeThousandsSeparator(input, ",", lastDot)
+	}
+	if lastDot >= 0 {
+		// 1.000,50
+		return strings.Replace(removeThousandsSeparator(input, ".", lastComma), ",", ".", 1)
+	}
+	if strings.Count(input, ",") == 1 {
+		decimals := 0
+		for _, r := range input[lastComma+1:] {
+			if r < '0' || r > '9' {
+				break
+			}
+			decimals++
+		}
+		if decimals == 1 || decimals == 2 {
+			// 0,5 and 3,50
+			return strings.Replace(input, ",", ".", 1)
+		}
+	}
+	// 1,000 and 1,000,000
+	return removeThousandsSeparator(input, ",", len(input))
+}
+
+// removeThousandsSeparator removes the separator from input[:end] if every group
+// after the first one has 3 digits. Only the last group can be followed by a currency.
+func removeThousandsSeparator(input string, separator string, end int) string {
+	groups := strings.Split(input[:end], separator)
+	isDigit := func(r rune) bool { return r >= '0' && r <= '9' }
+	for i, group := range groups[1:] {
+		if i == len(groups)-2 && end == len(input) {
+			group = strings.TrimRightFunc(group, func(r rune) bool { return !isDigit(r) })
+		}
+		if len(group) != 3 || strings.IndexFunc(group, func(r rune) bool { return !isDigit(r) }) >= 0 {
+			return input
+		}
+	}
+	return strings.Join(groups, "") + input[end:]
+}
+
+// currenciesBySymbol returns the currencies with the longest symbols first
+// so that R$ is matched before $
+func currenciesBySymbol() []string {
+	currencies := make([]string, 0, len(price.P.Currencies))
+	for currency := range price.P.Currencies {
+		currencies = append(currencies, currency)
+	}
+	sort.Slice(currencies, func(i, j int) bool {
+		a, b := price.P.Currencies[currencies[i]], price.P.Currencies[currencies[j]]
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return currencies[i] < currencies[j]
+	})
+	return currencies
+}
+
+func parseFiatAmount(input string) (fiat float64, currency string, ok bool, err error) {
+	for _, currency := range currenciesBySymbol() {
+		symbol := price.P.Currencies[currency]
 		if strings.HasPrefix(input, symbol) || strings.HasSuffix(input, symbol) || // for 1$ and $1
 			strings.HasPrefix(strings.ToLower(input), strings.ToLower(currency)) || // for USD1
 			strings.HasSuffix(strings.ToLower(input), strings.ToLower(currency)) { // for 1USD
 			numeric_string := ""
 			numeric_string = strings.Replace(input, symbol, "", 1)                                              // for symbol like $
 			numeric_string = strings.Replace(strings.ToLower(numeric_string), strings.ToLower(currency), "", 1) // for 1USD
-			fmount, err := strconv.ParseFloat(numeric_string, 64)
+			fiat, err = strconv.ParseFloat(numeric_string, 64)
 			if err != nil {
 				log.Errorln(err)
-				return 0, err
-			}
-			if !(price.Price[currency] > 0) {
-				return 0, fmt.Errorf("price is zero")
 			}
-			amount = int64(fmount / price.Price[currency] * float64(100_000_000))
-			return amount, nil
+			return fiat, currency, true, err
 		}
 	}
+	return 0, "", false, nil
+}
 
-	// use plain integer as satoshis
-	amount, err = strconv.ParseInt(input, 10, 64)
+func SatoshisToFiat(amount int64, currency string) (fiat float64, err error) {
+	fprice, err := price.Get(currency)
 	if err != nil {
 		return 0, err
 	}
-	if amount <= 0 {
-		return 0, fmt.Errorf("amount must be greater than 0")
-	}
-	return amount, err
+	fiat = float64(amount) / 100_000_000 * fprice
+	return fiat, nil
 }
 
-func SatoshisToFiat(amount int64, currency string) (fiat float64, err error) {
-	if !(price.Price[currency] > 0) {
-		return 0, fmt.Errorf("price is zero")
+// FormatFiat formats a fiat amount with the number format of the language
+// and the decimals of the currency, e.g. 1.234,56 EUR
+func FormatFiat(amount float64, currency string, languageCode string) string {
+	scale := 2
+	if unit, err := xcurrency.ParseISO(currency); err == nil {
+		scale, _ = xcurrency.Standard.Rounding(unit)
 	}
-	fiat = float64(amount) / 100_000_000 * price.Price[currency]
-	return fiat, nil
+	p := message.NewPrinter(language.Make(languageCode))
+	return fmt.Sprintf("%s %s", p.Sprint(number.Decimal(amount, number.Scale(scale))), currency)
 }
 
 type EnterAmountStateData struct {
@@ -0,0 +1,74 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/massmux/SatsMobiBot/internal/price"
+)
+
+func TestParseFiatAmount(t *testing.T) {
+	price.P = &price.PriceWatcher{Currencies: map[string]string{"EUR": "€", "USD": "$", "BRL": "R$"}}
+	for _, v := range []struct {
+		input    string
+		fiat     float64
+		currency string
+		ok       bool
+	}{
+		{"1,000", 0, "", false},
+		{"1,000€", 1000, "EUR", true},
+		{"1.000,50€", 1000.5, "EUR", true},
+		{"0,5€", 0.5, "EUR", true},
+		{"3,50€", 3.5, "EUR", true},
+		{"$1,000.50", 1000.5, "USD", true},
+		{"1,000,000USD", 1000000, "USD", true},
+		{"1.000.000€", 1000000, "EUR", true},
+		{"3.50€", 3.5, "EUR", true},
+		{"R$10", 10, "BRL", true},
+	} {
+		fiat, currency, ok, err := ParseFiatAmount(v.input)
+		if err != nil || ok != v.ok || fiat != v.fiat || currency != v.currency {
+			t.Errorf("ParseFiatAmount(%q) = %v, %q, %v, %v, want %v, %q, %v", v.input, fiat, currency, ok, err, v.fiat, v.currency, v.ok)
+		}
+	}
+	for _, input := range []string{"1,0000€", "1.00,5€", "1,00,000€"} {
+		if _, _, _, err := ParseFiatAmount(input); err == nil {
+			t.Errorf("ParseFiatAmount(%q) succeeded", input)
+		}
+	}
+}
+
+func TestNormalizeDecimalSeparator(t *testing.T) {
+	for input, want := range map[string]string{
+		"1,000":     "1000",
+		"1.000,50":  "1000.50",
+		"0,5":       "0.5",
+		"1,000.50":  "1000.50",
+		"1.5":       "1.5",
+		"1,2345":    "1,2345",
+		"12,345,67": "12,345,67",
+	} {
+		if got := normalizeDecimalSeparator(input); got != want {
+			t.Errorf("normalizeDecimalSeparator(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestGetAmount(t *testing.T) {
+	for input, want := range map[string]int64{
+		"1000":      1000,
+		"1,000":     1000,
+		"1.000.000": 1000000,
+		"1,5k":      1500,
+		"1.5k":      1500,
+		"🥜":         69,
+	} {
+		if got, err := GetAmount(input); err != nil || got != want {
+			t.Errorf("GetAmount(%q) = %d, %v, want %d", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"0,5", "1,0000", "0", "-1"} {
+		if _, err := GetAmount(input); err == nil {
+			t.Errorf("GetAmount(%q) succeeded", input)
+		}
+	}
+}
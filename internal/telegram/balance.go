@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/massmux/SatsMobiBot/internal"
 	"strconv"
+	"time"
 
 	"github.com/massmux/SatsMobiBot/internal/errors"
 	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
@@ -44,7 +45,7 @@ func (bot *TipBot) balanceHandler(ctx intercept.Context) (intercept.Context, err
 	}
 
 	log.Infof("[/balance] %s's balance: %d sat\n", usrStr, balance)
-	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "balanceMessage"), balance))
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "balanceMessage"), balance)+bot.fiatValueText(user, balance, time.Now()))
 
 	// check user balance. if more than Maximum allowed (in config) then send a warning message
 	if balance >= internal.Configuration.Pos.Max_balance {
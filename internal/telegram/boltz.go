@@ -1,43 +1,107 @@
 package telegram
 
-// lightning to chain
+// swaps between lightning and chain with Boltz
 
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
+	"time"
+
 	"github.com/BoltzExchange/boltz-client/v2/pkg/boltz"
 	"github.com/btcsuite/btcd/btcec/v2"
-	"os"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	log "github.com/sirupsen/logrus"
 )
 
-const endpoint = "wss://api.boltz.exchange/v2/ws"
-const invoiceAmount = 10000
-const destinationAddress = "<address to which the swap should be claimed>"
+const (
+	defaultBoltzEndpoint = "https://api.boltz.exchange"
+	// boltzReconnectDelay is the time to wait before the websocket is opened again
+	boltzReconnectDelay = time.Minute
+)
 
-// Swap from Lightning to BTC mainchain
-// var toCurrency = boltz.CurrencyBtc
-var toCurrency = boltz.CurrencyLiquid
+func boltzApi() *boltz.Api {
+	endpoint := internal.Configuration.Boltz.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = defaultBoltzEndpoint
+	}
+	return &boltz.Api{URL: endpoint}
+}
 
-var boltz_network = boltz.MainNet
+func boltzNetwork() *boltz.Network {
+	switch internal.Configuration.Boltz.Network {
+	case "testnet":
+		return boltz.TestNet
+	case "regtest":
+		return boltz.Regtest
+	}
+	return boltz.MainNet
+}
 
-func printJson(v interface{}) {
-	b, err := json.MarshalIndent(v, "", "  ")
+// restoreSwapTree restores the persisted script tree and our key of a swap
+func restoreSwapTree(serializedTree json.RawMessage, privateKeyHex string, boltzPublicKeyHex string) (*boltz.SwapTree, *btcec.PrivateKey, error) {
+	privateKey, err := hex.DecodeString(privateKeyHex)
+	if err != nil {
+		return nil, nil, err
+	}
+	ourKeys, _ := btcec.PrivKeyFromBytes(privateKey)
+	boltzPublicKey, err := hex.DecodeString(boltzPublicKeyHex)
+	if err != nil {
+		return nil, nil, err
+	}
+	boltzPubKey, err := btcec.ParsePubKey(boltzPublicKey)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, nil, err
+	}
+	var serialized boltz.SerializedTree
+	if err := json.Unmarshal(serializedTree, &serialized); err != nil {
+		return nil, nil, err
+	}
+	tree := serialized.Deserialize()
+	if err := tree.Init(boltz.CurrencyBtc, false, ourKeys, boltzPubKey); err != nil {
+		return nil, nil, err
 	}
+	return tree, ourKeys, nil
+}
 
-	fmt.Println(string(b))
+// getSwapOutQuote returns the fees and limits of a reverse swap of amount sat
+func getSwapOutQuote(amount int64) (*SwapOutQuote, error) {
+	pairs, err := boltzApi().GetReversePairs()
+	if err != nil {
+		return nil, err
+	}
+	pair, ok := pairs[boltz.CurrencyBtc][boltz.CurrencyBtc]
+	if !ok {
+		return nil, fmt.Errorf("no BTC/BTC reverse pair")
+	}
+	feeRate, err := mempoolFeeRate()
+	if err != nil {
+		return nil, err
+	}
+	return &SwapOutQuote{
+		Amount:     amount,
+		Percentage: pair.Fees.Percentage,
+		BoltzFee:   int64(math.Ceil(float64(amount) * pair.Fees.Percentage / 100)),
+		LockupFee:  int64(pair.Fees.MinerFees.Lockup),
+		ClaimFee:   swapClaimFee(feeRate),
+		FeeRate:    feeRate,
+		PairHash:   pair.Hash,
+		Minimal:    int64(pair.Limits.Minimal),
+		Maximal:    int64(pair.Limits.Maximal),
+	}, nil
 }
 
-func reverseSwap() error {
+// createReverseSwap creates the swap at Boltz and stores the keys and the preimage in the swap
+func createReverseSwap(swap *SwapOut) error {
 	ourKeys, err := btcec.NewPrivateKey()
 	if err != nil {
 		return err
 	}
-
 	preimage := make([]byte, 32)
 	_, err = rand.Read(preimage)
 	if err != nil {
@@ -45,117 +109,366 @@ func reverseSwap() error {
 	}
 	preimageHash := sha256.Sum256(preimage)
 
-	boltzApi := &boltz.Api{URL: endpoint}
-
-	swap, err := boltzApi.CreateReverseSwap(boltz.CreateReverseSwapRequest{
+	response, err := boltzApi().CreateReverseSwap(boltz.CreateReverseSwapRequest{
 		From:           boltz.CurrencyBtc,
-		To:             toCurrency,
+		To:             boltz.CurrencyBtc,
 		ClaimPublicKey: ourKeys.PubKey().SerializeCompressed(),
 		PreimageHash:   preimageHash[:],
-		InvoiceAmount:  invoiceAmount,
+		InvoiceAmount:  uint64(swap.Amount),
+		PairHash:       swap.Quote.PairHash,
 	})
 	if err != nil {
-		return fmt.Errorf("Could not create swap: %s", err)
+		return fmt.Errorf("could not create swap: %w", err)
 	}
-
-	boltzPubKey, err := btcec.ParsePubKey(swap.RefundPublicKey)
+	boltzPubKey, err := btcec.ParsePubKey(response.RefundPublicKey)
 	if err != nil {
 		return err
 	}
-
-	tree := swap.SwapTree.Deserialize()
-	if err := tree.Init(toCurrency, false, ourKeys, boltzPubKey); err != nil {
+	// verify the script before we pay the invoice
+	tree := response.SwapTree.Deserialize()
+	if err := tree.Init(boltz.CurrencyBtc, false, ourKeys, boltzPubKey); err != nil {
 		return err
 	}
-
-	if err := tree.Check(boltz.ReverseSwap, swap.TimeoutBlockHeight, preimageHash[:]); err != nil {
+	if err := tree.Check(boltz.ReverseSwap, response.TimeoutBlockHeight, preimageHash[:]); err != nil {
+		return err
+	}
+	if err := tree.CheckAddress(response.LockupAddress, boltzNetwork(), nil); err != nil {
+		return err
+	}
+	// the invoice must only be paid when the preimage unlocks the funds of the swap
+	invoice, err := decodepay.Decodepay(response.Invoice)
+	if err != nil {
 		return err
 	}
+	if invoice.PaymentHash != hex.EncodeToString(preimageHash[:]) {
+		return fmt.Errorf("invoice of swap %s has a different payment hash", response.Id)
+	}
+	if invoice.MSatoshi != swap.Amount*1000 {
+		return fmt.Errorf("invoice of swap %s is for %d msat instead of %d sat", response.Id, invoice.MSatoshi, swap.Amount)
+	}
+	if promised := swap.Amount - swap.Quote.BoltzFee - swap.Quote.LockupFee; int64(response.OnchainAmount) < promised {
+		return fmt.Errorf("swap %s locks %d sat instead of %d sat", response.Id, response.OnchainAmount, promised)
+	}
+	serializedTree, err := json.Marshal(response.SwapTree)
+	if err != nil {
+		return err
+	}
+	swap.SwapID = response.Id
+	swap.Invoice = response.Invoice
+	swap.LockupAddress = response.LockupAddress
+	swap.OnchainAmount = int64(response.OnchainAmount)
+	swap.TimeoutBlockHeight = response.TimeoutBlockHeight
+	swap.Preimage = hex.EncodeToString(preimage)
+	swap.PrivateKey = hex.EncodeToString(ourKeys.Serialize())
+	swap.RefundPublicKey = hex.EncodeToString(response.RefundPublicKey)
+	swap.SwapTree = serializedTree
+	return nil
+}
 
-	fmt.Println("Swap created")
-	printJson(swap)
+// watchBoltzSwap follows the status updates of a swap at Boltz until handle returns true.
+// The websocket is opened again whenever Boltz closes it.
+func watchBoltzSwap(tag string, swapID string, handle func(status string, transactionHex string) bool) {
+	for !watchBoltzWebsocket(tag, swapID, handle) {
+		log.Warnf("[%s] Boltz websocket of swap %s closed, subscribing again", tag, swapID)
+		time.Sleep(boltzReconnectDelay)
+	}
+}
 
-	boltzWs := boltzApi.NewWebsocket()
+// watchBoltzWebsocket returns true if handle is done with the swap and false if the websocket closed before
+func watchBoltzWebsocket(tag string, swapID string, handle func(status string, transactionHex string) bool) bool {
+	boltzWs := boltzApi().NewWebsocket()
 	if err := boltzWs.Connect(); err != nil {
-		return fmt.Errorf("Could not connect to Boltz websocket: %w", err)
+		log.Errorf("[%s] Could not connect to Boltz websocket: %v", tag, err)
+		return false
 	}
-
-	if err := boltzWs.Subscribe([]string{swap.Id}); err != nil {
-		return err
+	defer boltzWs.Close()
+	if err := boltzWs.Subscribe([]string{swapID}); err != nil {
+		log.Errorf("[%s] Could not subscribe to swap %s: %v", tag, swapID, err)
+		return false
 	}
-
 	for update := range boltzWs.Updates {
-		parsedStatus := boltz.ParseEvent(update.Status)
-
-		printJson(update)
-
-		switch parsedStatus {
-		case boltz.SwapCreated:
-			fmt.Println("Waiting for invoice to be paid")
-			break
+		if update.Id != swapID {
+			continue
+		}
+		log.Debugf("[%s] Swap %s: %s", tag, swapID, update.Status)
+		if handle(update.Status, update.Transaction.Hex) {
+			return true
+		}
+	}
+	return false
+}
 
-		case boltz.TransactionMempool:
-			lockupTransaction, err := boltz.NewTxFromHex(toCurrency, update.Transaction.Hex, nil)
+// watchSwapOut follows the status of the swap at Boltz and claims the funds when they are locked.
+// The swap stays active until Boltz reports a final state.
+func (bot *TipBot) watchSwapOut(id string) {
+	swap := &SwapOut{Base: storage.New(storage.ID(id))}
+	if err := bot.Bunt.Get(swap); err != nil {
+		log.Errorf("[/swapout] Could not load swap %s: %v", id, err)
+		return
+	}
+	watchBoltzSwap("/swapout", swap.SwapID, func(status string, transactionHex string) bool {
+		switch boltz.ParseEvent(status) {
+		case boltz.TransactionMempool, boltz.TransactionConfirmed:
+			err := bot.claimSwapOut(id, transactionHex)
 			if err != nil {
-				return err
+				log.Errorf("[/swapout] Could not claim swap %s: %v", swap.SwapID, err)
 			}
-
-			vout, _, err := lockupTransaction.FindVout(boltz_network, swap.LockupAddress)
-			if err != nil {
-				return err
+		case boltz.InvoiceSettled:
+			swap, err := bot.updateSwapOut(id, func(swap *SwapOut) {
+				swap.Status = SwapStatusCompleted
+				swap.Active = false
+			})
+			if err == nil {
+				log.Infof("[/swapout] Swap %s completed", swap.SwapID)
+				bot.trySendMessage(swap.User.Telegram, fmt.Sprintf(swapOutCompletedMessage, swap.SwapID))
 			}
+			return true
+		case boltz.SwapExpired, boltz.InvoiceExpired, boltz.TransactionFailed, boltz.TransactionRefunded:
+			bot.failSwapOut(id, status)
+			return true
+		}
+		return false
+	})
+}
 
-			satPerVbyte := float64(2)
-			claimTransaction, _, err := boltz.ConstructTransaction(
-				boltz_network,
-				boltz.CurrencyBtc,
-				[]boltz.OutputDetails{
-					{
-						SwapId:            swap.Id,
-						SwapType:          boltz.ReverseSwap,
-						Address:           destinationAddress,
-						LockupTransaction: lockupTransaction,
-						Vout:              vout,
-						Preimage:          preimage,
-						PrivateKey:        ourKeys,
-						SwapTree:          tree,
-						Cooperative:       true,
-					},
-				},
-				satPerVbyte,
-				boltzApi,
-			)
-			if err != nil {
-				return fmt.Errorf("could not create claim transaction: %w", err)
-			}
+// claimSwapOut sends the locked funds to the address of the user
+func (bot *TipBot) claimSwapOut(id string, lockupTransactionHex string) error {
+	swap := &SwapOut{Base: storage.New(storage.ID(id))}
+	if err := bot.Bunt.Get(swap); err != nil {
+		return err
+	}
+	if len(swap.ClaimTxID) > 0 {
+		// already claimed
+		return nil
+	}
+	tree, ourKeys, err := restoreSwapTree(swap.SwapTree, swap.PrivateKey, swap.RefundPublicKey)
+	if err != nil {
+		return err
+	}
+	preimage, err := hex.DecodeString(swap.Preimage)
+	if err != nil {
+		return err
+	}
 
-			txHex, err := claimTransaction.Serialize()
-			if err != nil {
-				return fmt.Errorf("could not serialize claim transaction: %w", err)
-			}
+	lockupTransaction, err := boltz.NewTxFromHex(boltz.CurrencyBtc, lockupTransactionHex, nil)
+	if err != nil {
+		return err
+	}
+	vout, _, err := lockupTransaction.FindVout(boltzNetwork(), swap.LockupAddress)
+	if err != nil {
+		return err
+	}
+	// use the current fee rate, the one of the quote if it is not available
+	feeRate, err := mempoolFeeRate()
+	if err != nil {
+		feeRate = swap.Quote.FeeRate
+	}
+	api := boltzApi()
+	claimTransaction, fee, err := boltz.ConstructTransaction(
+		boltzNetwork(),
+		boltz.CurrencyBtc,
+		[]boltz.OutputDetails{
+			{
+				SwapId:            swap.SwapID,
+				SwapType:          boltz.ReverseSwap,
+				Address:           swap.Address,
+				LockupTransaction: lockupTransaction,
+				Vout:              vout,
+				Preimage:          preimage,
+				PrivateKey:        ourKeys,
+				SwapTree:          tree,
+				Cooperative:       true,
+			},
+		},
+		feeRate,
+		api,
+	)
+	if err != nil {
+		return fmt.Errorf("could not create claim transaction: %w", err)
+	}
+	txHex, err := claimTransaction.Serialize()
+	if err != nil {
+		return fmt.Errorf("could not serialize claim transaction: %w", err)
+	}
+	txId, err := api.BroadcastTransaction(boltz.CurrencyBtc, txHex)
+	if err != nil {
+		return fmt.Errorf("could not broadcast transaction: %w", err)
+	}
+	swap, err = bot.updateSwapOut(id, func(swap *SwapOut) {
+		swap.ClaimTxID = txId
+		swap.Status = SwapStatusClaimed
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("[/swapout] Swap %s claimed: %s", swap.SwapID, txId)
+	bot.trySendMessage(swap.User.Telegram, fmt.Sprintf(swapOutClaimedMessage, swap.SwapID, swap.OnchainAmount-int64(fee), swap.Address, mempoolTransactionLink(txId)))
+	return nil
+}
 
-			txId, err := boltzApi.BroadcastTransaction(toCurrency, txHex)
-			if err != nil {
-				return fmt.Errorf("could not broadcast transaction: %w", err)
-			}
+// getSwapInQuote returns the fees and limits of a submarine swap of amount sat
+func getSwapInQuote(amount int64) (*SwapInQuote, error) {
+	pairs, err := boltzApi().GetSubmarinePairs()
+	if err != nil {
+		return nil, err
+	}
+	pair, ok := pairs[boltz.CurrencyBtc][boltz.CurrencyBtc]
+	if !ok {
+		return nil, fmt.Errorf("no BTC/BTC submarine pair")
+	}
+	return &SwapInQuote{
+		Amount:     amount,
+		Percentage: pair.Fees.Percentage,
+		BoltzFee:   int64(math.Ceil(float64(amount) * pair.Fees.Percentage / 100)),
+		MinerFee:   int64(pair.Fees.MinerFees),
+		PairHash:   pair.Hash,
+		Minimal:    int64(pair.Limits.Minimal),
+		Maximal:    int64(pair.Limits.Maximal),
+	}, nil
+}
 
-			fmt.Printf("Broadcast claim transaction: %s\n", txId)
-			break
+// createSubmarineSwap creates the swap for the invoice of the swap and stores our refund key in the swap
+func createSubmarineSwap(swap *SwapIn) error {
+	ourKeys, err := btcec.NewPrivateKey()
+	if err != nil {
+		return err
+	}
+	response, err := boltzApi().CreateSwap(boltz.CreateSwapRequest{
+		From:            boltz.CurrencyBtc,
+		To:              boltz.CurrencyBtc,
+		PairHash:        swap.Quote.PairHash,
+		RefundPublicKey: ourKeys.PubKey().SerializeCompressed(),
+		Invoice:         swap.Invoice,
+	})
+	if err != nil {
+		return fmt.Errorf("could not create swap: %w", err)
+	}
+	boltzPubKey, err := btcec.ParsePubKey(response.ClaimPublicKey)
+	if err != nil {
+		return err
+	}
+	paymentHash, err := hex.DecodeString(swap.PaymentHash)
+	if err != nil {
+		return err
+	}
+	// verify that we can refund before the user sends funds to the address
+	tree := response.SwapTree.Deserialize()
+	if err := tree.Init(boltz.CurrencyBtc, false, ourKeys, boltzPubKey); err != nil {
+		return err
+	}
+	if err := tree.Check(boltz.NormalSwap, response.TimeoutBlockHeight, paymentHash); err != nil {
+		return err
+	}
+	// only funds sent to an address of the verified tree can be refunded with our key
+	if err := tree.CheckAddress(response.Address, boltzNetwork(), nil); err != nil {
+		return err
+	}
+	if expected := int64(response.ExpectedAmount); expected < swap.Amount || expected > swap.Quote.Send() {
+		return fmt.Errorf("swap %s expects %d sat, the quote was %d sat", response.Id, expected, swap.Quote.Send())
+	}
+	serializedTree, err := json.Marshal(response.SwapTree)
+	if err != nil {
+		return err
+	}
+	swap.SwapID = response.Id
+	swap.LockupAddress = response.Address
+	swap.Bip21 = response.Bip21
+	swap.ExpectedAmount = int64(response.ExpectedAmount)
+	swap.TimeoutBlockHeight = response.TimeoutBlockHeight
+	swap.PrivateKey = hex.EncodeToString(ourKeys.Serialize())
+	swap.ClaimPublicKey = hex.EncodeToString(response.ClaimPublicKey)
+	swap.SwapTree = serializedTree
+	return nil
+}
 
-		case boltz.InvoiceSettled:
-			fmt.Println("Swap succeeded", swap.Id)
-			if err := boltzWs.Close(); err != nil {
-				return err
-			}
-			break
+// watchSwapIn follows the status of the swap at Boltz until it is completed or failed
+func (bot *TipBot) watchSwapIn(id string) {
+	swap := &SwapIn{Base: storage.New(storage.ID(id))}
+	if err := bot.Bunt.Get(swap); err != nil {
+		log.Errorf("[/swapin] Could not load swap %s: %v", id, err)
+		return
+	}
+	watchBoltzSwap("/swapin", swap.SwapID, func(status string, transactionHex string) bool {
+		switch boltz.ParseEvent(status) {
+		case boltz.TransactionMempool, boltz.TransactionConfirmed:
+			bot.swapInLocked(id, transactionHex)
+		case boltz.TransactionClaimed:
+			// Boltz claims with the preimage if we do not sign the claim cooperatively
+			bot.swapInCompleted(id)
+			return true
+		case boltz.TransactionLockupFailed, boltz.InvoiceFailedToPay:
+			bot.swapInFailed(id, status, true)
+			return true
+		case boltz.SwapExpired:
+			bot.swapInFailed(id, status, false)
+			return true
 		}
+		return false
+	})
+}
+
+// swapInLockupTransaction returns the lockup transaction that Boltz saw for the swap
+func swapInLockupTransaction(swapID string) (string, error) {
+	response, err := boltzApi().GetSwapTransaction(swapID)
+	if err != nil {
+		return "", err
 	}
-	return nil
+	return response.Hex, nil
 }
 
-func main() {
-	if err := reverseSwap(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// refundSubmarineSwap sends the deposit back to the refund address and returns the transaction id
+func refundSubmarineSwap(swap *SwapIn) (string, error) {
+	if len(swap.RefundAddress) == 0 {
+		return "", fmt.Errorf("no refund address")
+	}
+	tree, ourKeys, err := restoreSwapTree(swap.SwapTree, swap.PrivateKey, swap.ClaimPublicKey)
+	if err != nil {
+		return "", err
+	}
+	api := boltzApi()
+	lockupTransactionHex := swap.LockupTransaction
+	if len(lockupTransactionHex) == 0 {
+		lockupTransactionHex, err = swapInLockupTransaction(swap.SwapID)
+		if err != nil {
+			return "", err
+		}
+	}
+	lockupTransaction, err := boltz.NewTxFromHex(boltz.CurrencyBtc, lockupTransactionHex, nil)
+	if err != nil {
+		return "", err
+	}
+	vout, _, err := lockupTransaction.FindVout(boltzNetwork(), swap.LockupAddress)
+	if err != nil {
+		return "", err
+	}
+	feeRate, err := mempoolFeeRate()
+	if err != nil {
+		return "", err
+	}
+	output := boltz.OutputDetails{
+		SwapId:             swap.SwapID,
+		SwapType:           boltz.NormalSwap,
+		Address:            swap.RefundAddress,
+		LockupTransaction:  lockupTransaction,
+		Vout:               vout,
+		PrivateKey:         ourKeys,
+		SwapTree:           tree,
+		TimeoutBlockHeight: swap.TimeoutBlockHeight,
+		Cooperative:        true,
+	}
+	refundTransaction, _, err := boltz.ConstructTransaction(boltzNetwork(), boltz.CurrencyBtc, []boltz.OutputDetails{output}, feeRate, api)
+	if err != nil {
+		// without Boltz the refund is valid after the timeout
+		log.Warnf("[/swapin] Cooperative refund of swap %s failed: %v", swap.SwapID, err)
+		output.Cooperative = false
+		refundTransaction, _, err = boltz.ConstructTransaction(boltzNetwork(), boltz.CurrencyBtc, []boltz.OutputDetails{output}, feeRate, api)
+		if err != nil {
+			return "", fmt.Errorf("could not create refund transaction: %w", err)
+		}
+	}
+	txHex, err := refundTransaction.Serialize()
+	if err != nil {
+		return "", fmt.Errorf("could not serialize refund transaction: %w", err)
 	}
+	return api.BroadcastTransaction(boltz.CurrencyBtc, txHex)
 }
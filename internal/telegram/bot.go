@@ -16,6 +16,7 @@ import (
 
 	"github.com/massmux/SatsMobiBot/internal"
 	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/price"
 	"github.com/massmux/SatsMobiBot/internal/storage"
 	gocache "github.com/patrickmn/go-cache"
 	log "github.com/sirupsen/logrus"
@@ -61,7 +62,7 @@ func NewBot() TipBot {
 func newTelegramBot() *tb.Bot {
 	tgb, err := tb.NewBot(tb.Settings{
 		Token:     internal.Configuration.Telegram.ApiKey,
-		Poller:    &tb.LongPoller{Timeout: 60 * time.Second},
+		Poller:    &ReactionPoller{Timeout: 60 * time.Second},
 		ParseMode: tb.ModeMarkdown,
 		Verbose:   false,
 	})
@@ -119,6 +120,12 @@ func (bot *TipBot) Start() {
 	// register telegram handlers
 	bot.registerTelegramHandlers()
 
+	// tip with message reactions
+	if poller, ok := bot.Telegram.Poller.(*ReactionPoller); ok {
+		poller.OnMessage = bot.rememberMessageAuthor
+		poller.OnReaction = bot.messageReactionHandler
+	}
+
 	// download bot avatar once
 	bot.downloadMyProfilePicture()
 
@@ -136,6 +143,28 @@ func (bot *TipBot) Start() {
 	go bot.Telegram.Start()
 
 	go bot.restartPersistedTickets()
+
+	// execute scheduled payments
+	go bot.startScheduler()
+
+	// refund expired escrows
+	go bot.startEscrowWatcher()
+	go bot.startPaymentRequestWatcher()
+	// close expired faucets
+	go bot.startFaucetWatcher()
+	// settle crowdfunding tipjars
+	go bot.startCrowdfundWatcher()
+	// draw raffles
+	go bot.startRaffleWatcher()
+	// post weekly group rankings
+	go bot.startTopDigestScheduler()
+	// track voucher orders at the provider
+	go bot.startVoucherOrderWatcher()
+	// resume on-chain withdrawals and deposits
+	go bot.restartSwapOuts()
+	go bot.restartSwapIns()
+	// evaluate price alerts on every price update
+	price.OnUpdate(bot.checkPriceAlerts)
 	// gracefully shutdown
 	exit := make(chan os.Signal, 1) // we need to reserve to buffer size 1, so the notifier are not blocked
 	// we need to catch SIGTERM and SIGSTOP
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"github.com/massmux/SatsMobiBot/internal"
 	"github.com/massmux/SatsMobiBot/internal/errors"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/massmux/SatsMobiBot/internal/str"
 	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
 	log "github.com/sirupsen/logrus"
 
@@ -13,10 +16,34 @@ import (
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
 
+// parseVoucherAmount parses a fiat amount like 50, 50.5 or 50€ in the currency of the voucher provider
+func parseVoucherAmount(input string) (string, bool) {
+	currency := internal.Configuration.Voucherbot.Currency
+	input = strings.TrimSpace(input)
+	input = strings.TrimSuffix(strings.ToUpper(input), strings.ToUpper(currency))
+	input = strings.Trim(input, "€")
+	input = strings.Replace(input, ",", ".", 1)
+	amount, err := strconv.ParseFloat(input, 64)
+	if err != nil || !(amount > 0) {
+		return "", false
+	}
+	return strconv.FormatFloat(amount, 'f', -1, 64), true
+}
+
 func (bot *TipBot) buyHandler(ctx intercept.Context) (intercept.Context, error) {
-	// commands: /buy IBAN
+	// commands: /buy [amount] IBAN
 	m := ctx.Message()
+	// default amount to purchase in fiat
+	purchaseAmount := internal.Configuration.Voucherbot.DefaultAmount
 	giveniban, err := getArgumentFromCommand(ctx.Message().Text, 1)
+	if amount, ok := parseVoucherAmount(giveniban); ok {
+		purchaseAmount = amount
+		giveniban, err = getArgumentFromCommand(ctx.Message().Text, 2)
+	} else if amountArgument, amountErr := getArgumentFromCommand(ctx.Message().Text, 2); amountErr == nil {
+		if amount, ok := parseVoucherAmount(amountArgument); ok {
+			purchaseAmount = amount
+		}
+	}
 	if m.Chat.Type != tb.ChatPrivate {
 		return ctx, errors.Create(errors.NoPrivateChatError)
 	}
@@ -57,9 +84,6 @@ func (bot *TipBot) buyHandler(ctx intercept.Context) (intercept.Context, error)
 	fromUser := LoadUser(ctx)
 	lnaddr, _ := bot.UserGetLightningAddress(fromUser)
 
-	// default amount to purchase in fiat
-	purchaseAmount := internal.Configuration.Voucherbot.DefaultAmount
-
 	// send user confirmation message
 	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "buyCmdInvoked"), userStr, iban.Code, lnaddr, purchaseAmount))
 
@@ -67,7 +91,7 @@ func (bot *TipBot) buyHandler(ctx intercept.Context) (intercept.Context, error)
 	log.Infof("[buyHandler] buy details: %s %s %s %s", userStr, iban.Code, lnaddr, purchaseAmount)
 
 	// generate the order
-	voucherbotManager := &VoucherBot{APIKey: internal.Configuration.Voucherbot.ApiKey}
+	voucherbotManager := NewVoucherBot(voucherHTTPClient)
 
 	voucherbotManager.setLightningRecipient(lnaddr, purchaseAmount, iban.Code)
 	orderResult := voucherbotManager.createLightningOrder()
@@ -86,6 +110,8 @@ func (bot *TipBot) buyHandler(ctx intercept.Context) (intercept.Context, error)
 		orderConfirmation := fmt.Sprintf(Translate(ctx, "buyOrderConfirmation"), now.Format("2006-01-02"), purchaseAmount, currency, creditorName, creditorAddress, creditorBankName, creditorBankIban, creditorBankBic, purchaseAmount, currency, orderResult["payment_description"].(string), iban.Code, orderResult["orderid"].(string), orderResult["orderid"].(string), orderResult["orderid"].(string))
 
 		log.Infof("[buyHandler] Order accepted: %s from IBAN: %s Amount: %s", orderResult["orderid"].(string), iban.Code, purchaseAmount)
+		// store the order to track its status
+		bot.newVoucherOrder(user, m.Sender.LanguageCode, orderResult, iban.Code, purchaseAmount, lnaddr)
 		bot.trySendMessage(m.Sender, fmt.Sprintf("%s", orderConfirmation))
 	} else {
 		// order is not accepted by the provider
@@ -98,7 +124,14 @@ func (bot *TipBot) buyHandler(ctx intercept.Context) (intercept.Context, error)
 	return ctx, err
 }
 
-// todo: confirm and cancel error messages
+// sendVoucherError tells the user why the provider refused a request
+func (bot *TipBot) sendVoucherError(ctx intercept.Context, err error) {
+	if voucherErr, ok := err.(VoucherError); ok && len(voucherErr.Message) > 0 {
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(voucherProviderErrorMessage, str.MarkdownEscape(voucherErr.Message)))
+		return
+	}
+	bot.trySendMessage(ctx.Sender(), Translate(ctx, "errorTryLaterMessage"))
+}
 
 func (bot *TipBot) cancelHandler(ctx intercept.Context) (intercept.Context, error) {
 	// commands: /cancel orderid
@@ -115,8 +148,19 @@ func (bot *TipBot) cancelHandler(ctx intercept.Context) (intercept.Context, erro
 		log.Errorln(errmsg)
 		return ctx, errors.New(errors.InvalidSyntaxError, err)
 	}
-	voucherbotManager := &VoucherBot{APIKey: internal.Configuration.Voucherbot.ApiKey}
-	voucherbotManager.cancelOrder(orderid)
+	user := LoadUser(ctx)
+	if bot.isForeignVoucherOrder(user, orderid) {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(voucherOrderNotFoundMessage, orderid))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	voucherbotManager := NewVoucherBot(voucherHTTPClient)
+	err = voucherbotManager.cancelOrder(orderid)
+	if err != nil {
+		log.Errorf("[/cancel] Error: %v", err)
+		bot.sendVoucherError(ctx, err)
+		return ctx, err
+	}
+	bot.setVoucherOrderStatus(user, orderid, VoucherOrderStatusCanceled)
 
 	bot.trySendMessage(m.Sender, fmt.Sprintf("✔️*ORDER CANCEL*\n\nOrderid: %s\nResult: Cancelled", orderid))
 	return ctx, err
@@ -138,8 +182,19 @@ func (bot *TipBot) confirmHandler(ctx intercept.Context) (intercept.Context, err
 		log.Errorln(errmsg)
 		return ctx, errors.New(errors.InvalidSyntaxError, err)
 	}
-	voucherbotManager := &VoucherBot{APIKey: internal.Configuration.Voucherbot.ApiKey}
-	voucherbotManager.notifyPayment(orderid)
+	user := LoadUser(ctx)
+	if bot.isForeignVoucherOrder(user, orderid) {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(voucherOrderNotFoundMessage, orderid))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	voucherbotManager := NewVoucherBot(voucherHTTPClient)
+	err = voucherbotManager.notifyPayment(orderid)
+	if err != nil {
+		log.Errorf("[/confirm] Error: %v", err)
+		bot.sendVoucherError(ctx, err)
+		return ctx, err
+	}
+	bot.setVoucherOrderStatus(user, orderid, VoucherOrderStatusPaymentNotified)
 
 	bot.trySendMessage(m.Sender, fmt.Sprintf("✔️*ORDER CONFIRM*\n\nOrderid: %s\nResult: marked paid", orderid))
 	return ctx, err
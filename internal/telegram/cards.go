@@ -1,15 +1,155 @@
 package telegram
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
 	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/boltcard"
 	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
 	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
 	log "github.com/sirupsen/logrus"
-	"strconv"
+	"github.com/skip2/go-qrcode"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	ErrBoltCardNotFound      = fmt.Errorf("card not found")
+	ErrBoltCardDisabled      = fmt.Errorf("card disabled")
+	ErrBoltCardReplay        = fmt.Errorf("card counter already used")
+	ErrBoltCardLimitExceeded = fmt.Errorf("card limit exceeded")
+)
+
+var (
+	boltCardHelpMessage          = "💳 *Bolt Cards*\n\n`/card add [name]` Create a card and get the link for the Bolt Card NFC programmer app.\n`/card list` List your cards.\n`/card limit <id> <tx> <daily>` Set the limits of a card in sat.\n`/card enable <id>` Enable a card.\n`/card disable <id>` Disable a card.\n`/card wipe <id>` Disable a card and get the keys to wipe it.\n`/card reprogram <id>` Create new keys for a wiped card.\n`/card remove <id>` Delete a card."
+	boltCardProgramMessage       = "💳 Card `%d` (%s)\n\nOpen the Bolt Card NFC programmer app, scan this QR code and hold your card to the phone. The link is valid for %d minutes.\n\nLimits: %d sat per payment, %d sat per day. Change them with `/card limit %d <tx> <daily>`."
+	boltCardListMessage          = "💳 *Your Bolt Cards*\n\n%s"
+	boltCardEntryMessage         = "`%d` %s %s: %d sat per payment, %d sat per day, %d sat spent today\n"
+	boltCardEmptyMessage         = "💳 You have no cards. Create one with `/card add`."
+	boltCardNotFoundMessage      = "🚫 Card `%s` not found."
+	boltCardMaxMessage           = "🚫 You can add up to %d cards."
+	boltCardInvalidLimitsMessage = "🚫 The limits must be positive and the per payment limit must not exceed the daily limit."
+	boltCardLimitsMessage        = "✅ Card `%d`: %d sat per payment, %d sat per day."
+	boltCardEnabledMessage       = "✅ Card `%d` enabled."
+	boltCardDisabledMessage      = "⏸ Card `%d` disabled."
+	boltCardWipeMessage          = "🧹 Card `%d` is disabled.\n\nTo wipe it, choose *Reset Keys* in the Bolt Card NFC programmer app, scan this QR code and hold your card to the phone. Afterwards use `/card reprogram %d` or `/card remove %d`."
+	boltCardNotWipedMessage      = "🚫 Wipe card `%d` with `/card wipe %d` first."
+	boltCardWipedMessage         = "🚫 Card `%d` was wiped. Program it again with `/card reprogram %d`."
+	boltCardRemovedMessage       = "✅ Card `%d` removed."
+	boltCardPaidMessage          = "💳 Card `%s` paid %d sat."
 )
 
+const (
+	boltCardsMaxPerUser       = 5
+	boltCardDefaultTxLimit    = 20_000
+	boltCardDefaultDailyLimit = 100_000
+	// boltCardProgrammingExpiry is the validity of the link for the programmer app
+	boltCardProgrammingExpiry = 10 * time.Minute
+)
+
+// BoltCard is an NFC card that pays from the wallet of its owner. The card
+// authenticates every tap with K1 and K2, K0 is the master key of the card.
+type BoltCard struct {
+	ID                   uint      `gorm:"primarykey"`
+	TelegramID           int64     `gorm:"index"`
+	ExternalID           string    `gorm:"uniqueIndex"`
+	Name                 string    `json:"name"`
+	UID                  string    `json:"uid"`
+	K0                   string    `json:"-"`
+	K1                   string    `json:"-"`
+	K2                   string    `json:"-"`
+	K3                   string    `json:"-"`
+	K4                   string    `json:"-"`
+	Counter              uint32    `json:"counter"`
+	Enabled              bool      `json:"enabled"`
+	Programmed           bool      `json:"programmed"`
+	Wiped                bool      `json:"wiped"`
+	TxLimit              int64     `json:"tx_limit"`
+	DailyLimit           int64     `json:"daily_limit"`
+	SpentDay             string    `json:"spent_day"`
+	SpentToday           int64     `json:"spent_today"`
+	ProgrammingToken     string    `gorm:"index" json:"-"`
+	ProgrammingExpiresAt time.Time `json:"-"`
+	CreatedAt            time.Time `json:"created_at"`
+}
+
+// BoltCardKeys is the key export for the Bolt Card NFC programmer app
+type BoltCardKeys struct {
+	K0 string `json:"k0"`
+	K1 string `json:"k1"`
+	K2 string `json:"k2"`
+	K3 string `json:"k3"`
+	K4 string `json:"k4"`
+}
+
+func (card BoltCard) Keys() BoltCardKeys {
+	return BoltCardKeys{K0: card.K0, K1: card.K1, K2: card.K2, K3: card.K3, K4: card.K4}
+}
+
+// spent returns the amount paid with the card today
+func (card BoltCard) spent() int64 {
+	if card.SpentDay != time.Now().Format("2006-01-02") {
+		return 0
+	}
+	return card.SpentToday
+}
+
+// MaxWithdrawable returns the highest amount in sat the card can pay now
+func (card BoltCard) MaxWithdrawable() int64 {
+	left := card.DailyLimit - card.spent()
+	if card.TxLimit < left {
+		return card.TxLimit
+	}
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+func (card BoltCard) status() string {
+	switch {
+	case card.Wiped:
+		return "🧹"
+	case !card.Enabled:
+		return "⏸"
+	case !card.Programmed:
+		return "🆕"
+	}
+	return "✅"
+}
+
+// newBoltCardKeys sets new keys and a new programming link for card
+func newBoltCardKeys(card *BoltCard) error {
+	keys := make([]string, 5)
+	for i := range keys {
+		key, err := boltcard.NewKey()
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+	card.K0, card.K1, card.K2, card.K3, card.K4 = keys[0], keys[1], keys[2], keys[3], keys[4]
+	token, err := boltcard.NewKey()
+	if err != nil {
+		return err
+	}
+	card.ProgrammingToken = token
+	card.ProgrammingExpiresAt = time.Now().Add(boltCardProgrammingExpiry)
+	card.Counter = 0
+	card.Programmed = false
+	card.Wiped = false
+	return nil
+}
+
 func helpActivatecardUsage(ctx context.Context, errormsg string) string {
 	if len(errormsg) > 0 {
 		return fmt.Sprintf(Translate(ctx, "activatecardHelpText"), fmt.Sprintf("%s", errormsg))
@@ -18,9 +158,16 @@ func helpActivatecardUsage(ctx context.Context, errormsg string) string {
 	}
 }
 
-// activate NFC card function
+// activatecardHandler creates a Bolt Card for the card with the given UID
 func (bot *TipBot) activatecardHandler(ctx intercept.Context) (intercept.Context, error) {
 	cardID, err := getArgumentFromCommand(ctx.Message().Text, 1)
+	if err == nil {
+		var uid []byte
+		uid, err = hex.DecodeString(cardID)
+		if err == nil && len(uid) != boltcard.UIDSize {
+			err = fmt.Errorf("invalid card id %s", cardID)
+		}
+	}
 	if err != nil {
 		NewMessage(ctx.Message(), WithDuration(0, bot))
 		bot.trySendMessage(ctx.Sender(), helpActivatecardUsage(ctx, ""))
@@ -28,38 +175,320 @@ func (bot *TipBot) activatecardHandler(ctx intercept.Context) (intercept.Context
 		log.Errorln(errmsg)
 		return ctx, errors.New(errors.InvalidSyntaxError, err)
 	}
+	return bot.addCardHandler(ctx, strings.ToUpper(cardID), strings.ToLower(cardID))
+}
 
-	// send confirmation to final user
-	cardIdMsg := fmt.Sprintf(Translate(ctx, "activatecardSendText"), cardID)
-	bot.trySendMessage(ctx.Sender(), cardIdMsg)
+// cardHandler handles /card
+func (bot *TipBot) cardHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	arguments := strings.Fields(m.Text)
+	if len(arguments) < 2 {
+		bot.trySendMessage(m.Sender, boltCardHelpMessage)
+		return ctx, nil
+	}
+	switch strings.ToLower(arguments[1]) {
+	case "add", "new":
+		return bot.addCardHandler(ctx, strings.Join(arguments[2:], " "), "")
+	case "list":
+		return bot.listCardsHandler(ctx)
+	case "limit", "limits":
+		if len(arguments) < 5 {
+			break
+		}
+		return bot.cardLimitsHandler(ctx, arguments[2], arguments[3], arguments[4])
+	case "enable", "disable", "wipe", "reprogram", "remove", "delete":
+		if len(arguments) < 3 {
+			break
+		}
+		card, err := bot.loadCard(ctx, arguments[2])
+		if err != nil {
+			return ctx, err
+		}
+		switch strings.ToLower(arguments[1]) {
+		case "enable":
+			return bot.enableCardHandler(ctx, card, true)
+		case "disable":
+			return bot.enableCardHandler(ctx, card, false)
+		case "wipe":
+			return bot.wipeCardHandler(ctx, card)
+		case "reprogram":
+			return bot.reprogramCardHandler(ctx, card)
+		default:
+			return bot.removeCardHandler(ctx, card)
+		}
+	}
+	bot.trySendMessage(m.Sender, boltCardHelpMessage)
+	return ctx, errors.Create(errors.InvalidSyntaxError)
+}
 
-	// send activation request to bot admin
-	userID := strconv.FormatInt(ctx.Sender().ID, 10)
-	cardIdAdminMsg := fmt.Sprintf(Translate(ctx, "activatecardAdminSendText"), cardID, userID, ctx.Sender().Username, cardID)
-	toUserDb, err := GetUserByTelegramUsername(internal.Configuration.Bot.Botadmin, *bot)
-	bot.trySendMessage(toUserDb.Telegram, cardIdAdminMsg)
+// loadCard returns the card with id of the sender
+func (bot *TipBot) loadCard(ctx intercept.Context, id string) (*BoltCard, error) {
+	card := &BoltCard{}
+	cardID, err := strconv.ParseUint(id, 10, 64)
+	if err == nil {
+		err = bot.DB.Users.Where("id = ? AND telegram_id = ?", cardID, ctx.Sender().ID).First(card).Error
+	}
+	if err != nil {
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(boltCardNotFoundMessage, id))
+		return nil, errors.New(errors.InvalidSyntaxError, err)
+	}
+	return card, nil
+}
 
+func (bot *TipBot) addCardHandler(ctx intercept.Context, name string, uid string) (intercept.Context, error) {
+	m := ctx.Message()
+	var count int64
+	bot.DB.Users.Model(&BoltCard{}).Where("telegram_id = ?", m.Sender.ID).Count(&count)
+	if count >= boltCardsMaxPerUser {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(boltCardMaxMessage, boltCardsMaxPerUser))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if len(name) == 0 {
+		name = fmt.Sprintf("Card %d", count+1)
+	}
+	externalID := make([]byte, 16)
+	if _, err := rand.Read(externalID); err != nil {
+		return ctx, err
+	}
+	card := &BoltCard{
+		TelegramID: m.Sender.ID,
+		ExternalID: hex.EncodeToString(externalID),
+		Name:       name,
+		UID:        uid,
+		Enabled:    true,
+		TxLimit:    boltCardDefaultTxLimit,
+		DailyLimit: boltCardDefaultDailyLimit,
+	}
+	if err := newBoltCardKeys(card); err != nil {
+		return ctx, err
+	}
+	tx := bot.DB.Users.Create(card)
+	if tx.Error != nil {
+		log.Errorf("[card] could not save card of %s: %v", GetUserStr(m.Sender), tx.Error)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, tx.Error
+	}
+	log.Infof("[card] %s added card %d", GetUserStr(m.Sender), card.ID)
+	bot.sendCardProgrammingLink(m.Sender, card)
 	return ctx, nil
 }
 
-func (bot *TipBot) confirmcardHandler(ctx intercept.Context) (intercept.Context, error) {
-	if ctx.Sender().Username != internal.Configuration.Bot.Botadmin {
-		// Not admin. do nothing exit
+// sendCardProgrammingLink sends the QR code for the programmer app
+func (bot *TipBot) sendCardProgrammingLink(to *tb.User, card *BoltCard) {
+	link := fmt.Sprintf("%s/boltcard/new/%s", internal.Configuration.Bot.LNURLHostUrl.String(), card.ProgrammingToken)
+	caption := fmt.Sprintf(boltCardProgramMessage, card.ID, card.Name, int(boltCardProgrammingExpiry.Minutes()), card.TxLimit, card.DailyLimit, card.ID)
+	qr, err := qrcode.Encode(link, qrcode.Medium, 256)
+	if err != nil {
+		log.Errorf("[card] Failed to create QR code: %v", err)
+		bot.trySendMessage(to, fmt.Sprintf("%s\n\n`%s`", caption, link))
+		return
+	}
+	bot.trySendMessage(to, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: caption})
+}
+
+func (bot *TipBot) listCardsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	var cards []BoltCard
+	tx := bot.DB.Users.Where("telegram_id = ?", m.Sender.ID).Order("id").Find(&cards)
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	if len(cards) == 0 {
+		bot.trySendMessage(m.Sender, boltCardEmptyMessage)
 		return ctx, nil
 	}
-	userID, err := getArgumentFromCommand(ctx.Message().Text, 1)
-	cardID, err := getArgumentFromCommand(ctx.Message().Text, 2)
-	toUserDb, err := GetUserByTelegramUsername(userID, *bot)
-	adminUserID, err := GetUserByTelegramUsername(internal.Configuration.Bot.Botadmin, *bot)
+	list := ""
+	for _, card := range cards {
+		list += fmt.Sprintf(boltCardEntryMessage, card.ID, card.status(), card.Name, card.TxLimit, card.DailyLimit, card.spent())
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(boltCardListMessage, list))
+	return ctx, nil
+}
+
+func (bot *TipBot) cardLimitsHandler(ctx intercept.Context, id, txLimit, dailyLimit string) (intercept.Context, error) {
+	card, err := bot.loadCard(ctx, id)
 	if err != nil {
-		NewMessage(ctx.Message(), WithDuration(0, bot))
-		bot.trySendMessage(ctx.Sender(), helpActivatecardUsage(ctx, ""))
-		errmsg := fmt.Sprintf("[/confirmcard] Error: Could not getArgumentFromCommand: %s", err.Error())
-		log.Errorln(errmsg)
-		return ctx, errors.New(errors.InvalidSyntaxError, err)
+		return ctx, err
+	}
+	perTransaction, err := GetAmount(txLimit)
+	if err != nil {
+		bot.trySendMessage(ctx.Sender(), boltCardInvalidLimitsMessage)
+		return ctx, errors.New(errors.InvalidAmountError, err)
+	}
+	daily, err := GetAmount(dailyLimit)
+	if err != nil || perTransaction <= 0 || perTransaction > daily {
+		bot.trySendMessage(ctx.Sender(), boltCardInvalidLimitsMessage)
+		return ctx, errors.Create(errors.InvalidAmountError)
 	}
-	cardIdConfirmMsg := fmt.Sprintf(Translate(ctx, "confirmCardAdminSendText"), cardID)
-	bot.trySendMessage(toUserDb.Telegram, cardIdConfirmMsg)
-	bot.trySendMessage(adminUserID.Telegram, "Message sent!")
+	tx := bot.DB.Users.Model(card).Updates(map[string]interface{}{"tx_limit": perTransaction, "daily_limit": daily})
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(boltCardLimitsMessage, card.ID, perTransaction, daily))
 	return ctx, nil
 }
+
+func (bot *TipBot) enableCardHandler(ctx intercept.Context, card *BoltCard, enabled bool) (intercept.Context, error) {
+	if card.Wiped && enabled {
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(boltCardWipedMessage, card.ID, card.ID))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	tx := bot.DB.Users.Model(card).Update("enabled", enabled)
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	log.Infof("[card] %s set card %d enabled: %t", GetUserStr(ctx.Sender()), card.ID, enabled)
+	if enabled {
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(boltCardEnabledMessage, card.ID))
+	} else {
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(boltCardDisabledMessage, card.ID))
+	}
+	return ctx, nil
+}
+
+// wipeCardHandler disables the card and sends the keys in the wipe format of the programmer app
+func (bot *TipBot) wipeCardHandler(ctx intercept.Context, card *BoltCard) (intercept.Context, error) {
+	tx := bot.DB.Users.Model(card).Updates(map[string]interface{}{"enabled": false, "wiped": true, "programming_token": ""})
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	wipe, err := json.Marshal(struct {
+		Version int    `json:"version"`
+		Action  string `json:"action"`
+		BoltCardKeys
+	}{Version: 1, Action: "wipe", BoltCardKeys: card.Keys()})
+	if err != nil {
+		return ctx, err
+	}
+	log.Infof("[card] %s wiped card %d", GetUserStr(ctx.Sender()), card.ID)
+	caption := fmt.Sprintf(boltCardWipeMessage, card.ID, card.ID, card.ID)
+	qr, err := qrcode.Encode(string(wipe), qrcode.Medium, 256)
+	if err != nil {
+		log.Errorf("[card] Failed to create QR code: %v", err)
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf("%s\n\n`%s`", caption, wipe))
+		return ctx, nil
+	}
+	bot.trySendMessage(ctx.Sender(), &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: caption})
+	return ctx, nil
+}
+
+// reprogramCardHandler creates new keys for a wiped card or a card that was not programmed yet
+func (bot *TipBot) reprogramCardHandler(ctx intercept.Context, card *BoltCard) (intercept.Context, error) {
+	if card.Programmed && !card.Wiped {
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(boltCardNotWipedMessage, card.ID, card.ID))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if err := newBoltCardKeys(card); err != nil {
+		return ctx, err
+	}
+	card.Enabled = true
+	tx := bot.DB.Users.Save(card)
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	log.Infof("[card] %s reprogrammed card %d", GetUserStr(ctx.Sender()), card.ID)
+	bot.sendCardProgrammingLink(ctx.Sender(), card)
+	return ctx, nil
+}
+
+func (bot *TipBot) removeCardHandler(ctx intercept.Context, card *BoltCard) (intercept.Context, error) {
+	tx := bot.DB.Users.Delete(card)
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	log.Infof("[card] %s removed card %d", GetUserStr(ctx.Sender()), card.ID)
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(boltCardRemovedMessage, card.ID))
+	return ctx, nil
+}
+
+// ProgramBoltCard returns the card of a programming link. The link works only once.
+func (bot *TipBot) ProgramBoltCard(token string) (*BoltCard, error) {
+	card := &BoltCard{}
+	tx := bot.DB.Users.Where("programming_token = ? AND programming_expires_at > ?", token, time.Now()).First(card)
+	if len(token) == 0 || tx.Error != nil {
+		return nil, ErrBoltCardNotFound
+	}
+	tx = bot.DB.Users.Model(card).Updates(map[string]interface{}{"programming_token": "", "programmed": true})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	log.Infof("[card] card %d was programmed", card.ID)
+	return card, nil
+}
+
+// TapBoltCard verifies the p and c parameters of a card tap and returns the card.
+// Every counter value is accepted only once.
+func (bot *TipBot) TapBoltCard(externalID, p, c string) (*BoltCard, error) {
+	card := &BoltCard{}
+	tx := bot.DB.Users.Where("external_id = ?", externalID).First(card)
+	if tx.Error != nil {
+		return nil, ErrBoltCardNotFound
+	}
+	uid, counter, err := boltcard.Verify(card.K1, card.K2, p, c)
+	if err != nil {
+		log.Warnf("[card] invalid tap of card %d: %v", card.ID, err)
+		return nil, err
+	}
+	if len(card.UID) > 0 && card.UID != hex.EncodeToString(uid) {
+		log.Warnf("[card] card %d was tapped with uid %x", card.ID, uid)
+		return nil, boltcard.ErrInvalidParameters
+	}
+	if !card.Enabled {
+		return nil, ErrBoltCardDisabled
+	}
+	// only move the counter forward
+	tx = bot.DB.Users.Model(&BoltCard{}).Where("id = ? AND counter < ?", card.ID, counter).
+		Updates(map[string]interface{}{"counter": counter, "uid": hex.EncodeToString(uid)})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		log.Warnf("[card] replayed counter %d of card %d", counter, card.ID)
+		return nil, ErrBoltCardReplay
+	}
+	card.Counter = counter
+	return card, nil
+}
+
+// PayWithBoltCard pays the invoice from the wallet of the card owner within the card limits
+func (bot *TipBot) PayWithBoltCard(cardID uint, paymentRequest string) error {
+	lockID := fmt.Sprintf("boltcard-%d", cardID)
+	mutex.Lock(lockID)
+	defer mutex.Unlock(lockID)
+	card := &BoltCard{}
+	if tx := bot.DB.Users.First(card, cardID); tx.Error != nil {
+		return ErrBoltCardNotFound
+	}
+	if !card.Enabled {
+		return ErrBoltCardDisabled
+	}
+	bolt11, err := decodepay.Decodepay(strings.ToLower(paymentRequest))
+	if err != nil {
+		return err
+	}
+	sats := bolt11.MSatoshi / 1000
+	if sats <= 0 || sats > card.MaxWithdrawable() {
+		log.Warnf("[card] card %d tried to pay %d sat, max is %d sat", card.ID, sats, card.MaxWithdrawable())
+		return ErrBoltCardLimitExceeded
+	}
+	user, err := GetLnbitsUser(&tb.User{ID: card.TelegramID}, *bot)
+	if err != nil {
+		return err
+	}
+	if user.Wallet == nil {
+		return ErrBoltCardNotFound
+	}
+	_, err = bot.PayInvoice(user, paymentRequest, "boltcard", "")
+	if err != nil {
+		log.Errorf("[card] card %d could not pay %d sat: %v", card.ID, sats, err)
+		return err
+	}
+	spent := card.spent() + sats
+	tx := bot.DB.Users.Model(card).Updates(map[string]interface{}{"spent_day": time.Now().Format("2006-01-02"), "spent_today": spent})
+	if tx.Error != nil {
+		log.Errorf("[card] could not update spending of card %d: %v", card.ID, tx.Error)
+	}
+	log.Infof("[card] card %d of %s paid %d sat", card.ID, GetUserStr(user.Telegram), sats)
+	bot.trySendMessage(user.Telegram, fmt.Sprintf(boltCardPaidMessage, card.Name, sats))
+	return nil
+}
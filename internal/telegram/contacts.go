@@ -0,0 +1,229 @@
+package telegram
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	"github.com/massmux/SatsMobiBot/pkg/lightning"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	contactsHelpMessage          = "📒 *Contacts*\n\n`/contacts` List your contacts.\n`/contacts add <name> <lightning address|@user|lnurl>` Save a contact.\n`/contacts remove <name>` Remove a contact.\n\nUse the name of a contact with `/send`, `/pay` and `/tip`, e.g. `/send 1000 <name>`."
+	contactsEmptyMessage         = "📒 You have no contacts yet. Add one with `/contacts add <name> <lightning address|@user|lnurl>`."
+	contactsListMessage          = "📒 *Your contacts*\n\n%s"
+	contactsSavedMessage         = "✅ Contact `%s` saved."
+	contactsRemovedMessage       = "✅ Contact `%s` removed."
+	contactsNotFoundMessage      = "🚫 Contact `%s` not found."
+	contactsInvalidNameMessage   = "🚫 Contact names must start with a letter and may contain letters, digits, `-` and `_`."
+	contactsInvalidTargetMessage = "🚫 Please use a Lightning address, @user or LNURL."
+	contactsLimitMessage         = "🚫 You can save up to %d contacts."
+)
+
+const (
+	contactsMaxPerUser        = 100
+	inlineContactSuggestions  = 5
+	contactsMaxAddressLength  = 1000
+	contactsMaxUsernameLength = 100
+)
+
+var contactNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_\-]{0,31}$`)
+
+// Contact is a saved recipient of a user. Address is a Lightning address, an LNURL or an @username.
+type Contact struct {
+	ID         uint      `gorm:"primarykey"`
+	UserID     string    `gorm:"index"`
+	Name       string    `json:"name"`
+	Address    string    `json:"address"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (c Contact) isTelegramUser() bool {
+	return strings.HasPrefix(c.Address, "@")
+}
+
+func (bot *TipBot) loadContacts(user *lnbits.User) ([]Contact, error) {
+	var contacts []Contact
+	tx := bot.DB.Users.Where("user_id = ?", user.ID).Order("last_used_at desc, name").Find(&contacts)
+	return contacts, tx.Error
+}
+
+func (bot *TipBot) loadContact(user *lnbits.User, name string) (*Contact, error) {
+	contact := &Contact{}
+	tx := bot.DB.Users.Where("user_id = ? AND LOWER(name) = LOWER(?)", user.ID, name).First(contact)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return contact, nil
+}
+
+// touchContact marks the contact of user as used after a successful payment to it.
+func (bot *TipBot) touchContact(user *lnbits.User, name string) {
+	if len(name) == 0 {
+		return
+	}
+	tx := bot.DB.Users.Model(&Contact{}).Where("user_id = ? AND LOWER(name) = LOWER(?)", user.ID, name).Update("last_used_at", time.Now())
+	if tx.Error != nil {
+		log.Errorf("[touchContact] %s", tx.Error.Error())
+	}
+}
+
+// recentContactUsers returns the users behind the most recently used @user contacts.
+func (bot *TipBot) recentContactUsers(user *lnbits.User, limit int) []*lnbits.User {
+	var users []*lnbits.User
+	tx := bot.DB.Users.Model(&lnbits.User{}).Select("users.*").
+		Joins("JOIN contacts ON LOWER(contacts.address) = '@' || LOWER(users.telegram_username)").
+		Where("contacts.user_id = ? AND users.wallet_id IS NOT NULL", user.ID).
+		Order("contacts.last_used_at desc").Limit(limit).Find(&users)
+	if tx.Error != nil {
+		log.Errorf("[recentContactUsers] %s", tx.Error.Error())
+		return nil
+	}
+	return users
+}
+
+// resolveContactArgument replaces the argument at position which of the message text
+// with the address of the saved contact of that name. The contact is marked as used
+// by touchContact once the payment succeeded.
+func (bot *TipBot) resolveContactArgument(ctx intercept.Context, which int) (*Contact, bool) {
+	m := ctx.Message()
+	arguments := strings.Fields(m.Text)
+	if len(arguments) <= which || !contactNamePattern.MatchString(arguments[which]) {
+		return nil, false
+	}
+	user := LoadUser(ctx)
+	contact, err := bot.loadContact(user, arguments[which])
+	if err != nil {
+		return nil, false
+	}
+	arguments[which] = contact.Address
+	m.Text = strings.Join(arguments, " ")
+	// entities of the original text don't match anymore
+	m.Entities = nil
+	log.Infof("[contacts] %s uses contact %s (%s)", GetUserStr(m.Sender), contact.Name, contact.Address)
+	return contact, true
+}
+
+// sendToContactHandler rewrites /pay and /tip commands of the form
+// <command> <name> [amount] [memo] or <command> [amount] <name> [memo] into /send.
+func (bot *TipBot) sendToContactHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	arguments := strings.Fields(m.Text)
+	if len(arguments) > 2 {
+		if _, err := GetAmount(arguments[2]); err == nil {
+			arguments[1], arguments[2] = arguments[2], arguments[1]
+		}
+	}
+	arguments[0] = "/send"
+	m.Text = strings.Join(arguments, " ")
+	return bot.sendHandler(ctx)
+}
+
+// isContactName returns true if the argument at position which is a saved contact of the user.
+func (bot *TipBot) isContactName(ctx intercept.Context, which int) bool {
+	arguments := strings.Fields(ctx.Message().Text)
+	if len(arguments) <= which || !contactNamePattern.MatchString(arguments[which]) {
+		return false
+	}
+	_, err := bot.loadContact(LoadUser(ctx), arguments[which])
+	return err == nil
+}
+
+func (bot *TipBot) contactsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	arguments := strings.Fields(m.Text)
+	if len(arguments) < 2 || strings.ToLower(arguments[1]) == "list" {
+		return bot.listContactsHandler(ctx)
+	}
+	switch strings.ToLower(arguments[1]) {
+	case "add":
+		if len(arguments) < 4 {
+			break
+		}
+		return bot.addContactHandler(ctx, arguments[2], arguments[3])
+	case "remove", "delete":
+		if len(arguments) < 3 {
+			break
+		}
+		return bot.removeContactHandler(ctx, arguments[2])
+	}
+	bot.trySendMessage(m.Sender, contactsHelpMessage)
+	return ctx, nil
+}
+
+func (bot *TipBot) listContactsHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	contacts, err := bot.loadContacts(user)
+	if err != nil {
+		return ctx, err
+	}
+	if len(contacts) == 0 {
+		bot.trySendMessage(ctx.Sender(), contactsEmptyMessage)
+		return ctx, nil
+	}
+	list := ""
+	for _, contact := range contacts {
+		list += fmt.Sprintf("`%s` → %s\n", contact.Name, str.MarkdownEscape(contact.Address))
+	}
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(contactsListMessage, list))
+	return ctx, nil
+}
+
+func (bot *TipBot) addContactHandler(ctx intercept.Context, name string, address string) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	if !contactNamePattern.MatchString(name) {
+		bot.trySendMessage(ctx.Sender(), contactsInvalidNameMessage)
+		return ctx, fmt.Errorf("invalid contact name")
+	}
+	address = strings.TrimPrefix(address, "lightning:")
+	switch {
+	case strings.HasPrefix(address, "@") && len(address) > 1 && len(address) <= contactsMaxUsernameLength:
+	case lightning.IsLnurl(address) && len(address) <= contactsMaxAddressLength:
+		address = strings.ToLower(address)
+	case lightning.IsLightningAddress(address) && len(address) <= contactsMaxAddressLength:
+	default:
+		bot.trySendMessage(ctx.Sender(), contactsInvalidTargetMessage)
+		return ctx, fmt.Errorf("invalid contact address")
+	}
+
+	contact, err := bot.loadContact(user, name)
+	if err != nil {
+		var count int64
+		bot.DB.Users.Model(&Contact{}).Where("user_id = ?", user.ID).Count(&count)
+		if count >= contactsMaxPerUser {
+			bot.trySendMessage(ctx.Sender(), fmt.Sprintf(contactsLimitMessage, contactsMaxPerUser))
+			return ctx, fmt.Errorf("too many contacts")
+		}
+		contact = &Contact{UserID: user.ID}
+	}
+	contact.Name = name
+	contact.Address = address
+	tx := bot.DB.Users.Save(contact)
+	if tx.Error != nil {
+		log.Errorf("[addContactHandler] could not save contact of %s: %s", GetUserStr(ctx.Sender()), tx.Error.Error())
+		return ctx, tx.Error
+	}
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(contactsSavedMessage, name))
+	return ctx, nil
+}
+
+func (bot *TipBot) removeContactHandler(ctx intercept.Context, name string) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	contact, err := bot.loadContact(user, name)
+	if err != nil {
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(contactsNotFoundMessage, str.MarkdownEscape(name)))
+		return ctx, err
+	}
+	tx := bot.DB.Users.Delete(contact)
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(contactsRemovedMessage, contact.Name))
+	return ctx, nil
+}
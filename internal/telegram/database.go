@@ -24,6 +24,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/price"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -39,6 +40,24 @@ const (
 	JoinTicketIndex             = "join-ticket:*"
 	MessageOrderedByReplyToFrom = "message.reply_to_message.from.id"
 	TipTooltipKeyPattern        = "tip-tool-tip:*"
+	ScheduledPaymentIndex       = "schedule"
+	ScheduledPaymentKeyPattern  = "schedule:*"
+	EscrowIndex                 = "escrow"
+	EscrowKeyPattern            = "escrow:*"
+	PaymentRequestIndex         = "request"
+	PaymentRequestKeyPattern    = "request:*"
+	FaucetIndex                 = "faucet"
+	FaucetKeyPattern            = "faucet:*"
+	TipjarIndex                 = "tipjar"
+	TipjarKeyPattern            = "tipjar:*"
+	RaffleIndex                 = "raffle"
+	RaffleKeyPattern            = "raffle:*"
+	VoucherOrderIndex           = "voucher-order"
+	VoucherOrderKeyPattern      = "voucher-order:*"
+	SwapOutIndex                = "swap-out"
+	SwapOutKeyPattern           = "swap-out:*"
+	SwapInIndex                 = "swap-in"
+	SwapInKeyPattern            = "swap-in:*"
 )
 
 func createBunt(file string) *storage.DB {
@@ -58,6 +77,51 @@ func createBunt(file string) *storage.DB {
 	if err != nil {
 		panic(err)
 	}
+	err = bunt.CreateIndex(ScheduledPaymentIndex, ScheduledPaymentKeyPattern, buntdb.IndexString)
+	log.Infof("[blunt] index 3 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex(EscrowIndex, EscrowKeyPattern, buntdb.IndexString)
+	log.Infof("[blunt] index 4 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex(PaymentRequestIndex, PaymentRequestKeyPattern, buntdb.IndexString)
+	log.Infof("[blunt] index 5 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex(FaucetIndex, FaucetKeyPattern, buntdb.IndexString)
+	log.Infof("[blunt] index 6 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex(TipjarIndex, TipjarKeyPattern, buntdb.IndexString)
+	log.Infof("[blunt] index 7 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex(RaffleIndex, RaffleKeyPattern, buntdb.IndexString)
+	log.Infof("[blunt] index 8 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex(VoucherOrderIndex, VoucherOrderKeyPattern, buntdb.IndexString)
+	log.Infof("[blunt] index 9 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex(SwapOutIndex, SwapOutKeyPattern, buntdb.IndexString)
+	log.Infof("[blunt] index 10 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex(SwapInIndex, SwapInKeyPattern, buntdb.IndexString)
+	log.Infof("[blunt] index 11 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
 	log.Infof("[blunt] total time: %s", time.Since(t1))
 	return bunt
 }
@@ -113,7 +177,7 @@ func AutoMigration() *Databases {
 	if err != nil {
 		panic(err)
 	}
-	err = orm.AutoMigrate(&lnbits.User{})
+	err = orm.AutoMigrate(&lnbits.User{}, &Contact{}, &PriceAlert{}, &BoltCard{})
 	if err != nil {
 		panic(err)
 	}
@@ -126,12 +190,17 @@ func AutoMigration() *Databases {
 	if err != nil {
 		panic(err)
 	}
+	// historical prices are stored next to the transactions
+	_, err = price.NewHistory(txLogger)
+	if err != nil {
+		panic(err)
+	}
 
 	groupsDb, err := gorm.Open(sqlite.Open(internal.Configuration.Database.GroupsDbPath), &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true, FullSaveAssociations: true})
 	if err != nil {
 		panic("Initialize orm failed.")
 	}
-	err = groupsDb.AutoMigrate(&Group{})
+	err = groupsDb.AutoMigrate(&Group{}, &Treasury{}, &TreasuryApprover{}, &TreasurySpend{}, &TreasuryApproval{}, &TopDigest{})
 	if err != nil {
 		panic(err)
 	}
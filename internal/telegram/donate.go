@@ -18,7 +18,6 @@ import (
 
 	"github.com/massmux/SatsMobiBot/internal/str"
 
-	"github.com/massmux/SatsMobiBot/internal/lnbits"
 	log "github.com/sirupsen/logrus"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
@@ -100,7 +99,7 @@ func (bot TipBot) donationHandler(ctx intercept.Context) (intercept.Context, err
 	// send donation invoice
 	// user := LoadUser(ctx)
 	// bot.trySendMessage(user.Telegram, string(body))
-	_, err = user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: string(pv.PR)}, bot.Client)
+	_, err = bot.PayInvoice(user, string(pv.PR), "donate", "")
 	if err != nil {
 		userStr := GetUserStr(user.Telegram)
 		errmsg := fmt.Sprintf("[/donate] Donation failed for user %s: %s", userStr, err)
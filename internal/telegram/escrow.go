@@ -0,0 +1,474 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	escrowHelpMessage        = "🤝 *Escrow*\n\n`/escrow <amount> <@seller> [<terms>]` 🔒 Lock your funds until you release them to the seller. Unreleased funds are refunded after %d days.\n`/escrow list` 📖 List your open escrows.\n`/escrow resolve <id> <release|refund>` ⚖️ Resolve a dispute (arbiters only)."
+	escrowMessage            = "🤝 *Escrow* `%s`\n\nAmount: %d sat\nBuyer: %s\nSeller: %s%s\nState: *%s*\nRefund after: `%s`"
+	escrowTermsMessage       = "\nTerms: %s"
+	escrowBuyerMessage       = "\n\nRelease the funds to the seller once you have received what you paid for."
+	escrowSellerMessage      = "\n\nThe funds are locked. They are released to you once the buyer confirms."
+	escrowCreatedMessage     = "🔒 %d sat are locked in escrow `%s`."
+	escrowDisputedMessage    = "⚠️ Escrow `%s` was disputed by %s. An arbiter will resolve it."
+	escrowArbiterMessage     = "⚖️ *Escrow dispute*\n\n%s\n\nResolve with `/escrow resolve %s release` or `/escrow resolve %s refund`."
+	escrowSettledMessage     = "🤝 Escrow `%s` was %s."
+	escrowNotFoundMessage    = "🚫 Escrow not found."
+	escrowNotArbiterMessage  = "🚫 Only arbiters can resolve disputes."
+	escrowNotDisputedMessage = "🚫 Escrow `%s` is not disputed."
+	escrowListMessage        = "🤝 *Your open escrows:*\n"
+	escrowListEntryMessage   = "\n`%s`: %d sat from %s to %s (%s)"
+	escrowListEmptyMessage   = "🤝 You have no open escrows."
+	escrowNoArbiterMessage   = "🚫 There is no arbiter configured."
+	escrowUnavailableMessage = "🚫 Escrow is not available right now."
+	escrowTooManyMessage     = "🚫 You can have at most %d open escrows."
+)
+
+const (
+	EscrowStateFunded   = "funded"
+	EscrowStateReleased = "released"
+	EscrowStateRefunded = "refunded"
+	EscrowStateDisputed = "disputed"
+)
+
+const (
+	escrowTimeoutDays    = 7
+	escrowTimeout        = escrowTimeoutDays * 24 * time.Hour
+	escrowTickerDuration = time.Minute
+	escrowTimeLayout     = "2006-01-02 15:04 UTC"
+	escrowTimeoutActor   = "timeout"
+	escrowMaxTermsLength = 200
+	escrowMaxOpenPerUser = 20
+)
+
+var (
+	escrowMenu       = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnEscrowRelease = escrowMenu.Data("✅ Release", "escrow_release")
+	btnEscrowRefund  = escrowMenu.Data("↩️ Refund", "escrow_refund")
+	btnEscrowDispute = escrowMenu.Data("⚠️ Dispute", "escrow_dispute")
+)
+
+// Escrow holds the funds of a buyer in the escrow wallet until they are released or refunded
+type Escrow struct {
+	*storage.Base
+	Buyer         *lnbits.User       `json:"buyer"`
+	Seller        *lnbits.User       `json:"seller"`
+	Amount        int64              `json:"amount"`
+	Terms         string             `json:"terms"`
+	State         string             `json:"state"`
+	Deadline      time.Time          `json:"deadline"`
+	BuyerMessage  *tb.Message        `json:"buyer_message"`
+	SellerMessage *tb.Message        `json:"seller_message"`
+	History       []EscrowTransition `json:"history"`
+	LanguageCode  string             `json:"languagecode"`
+}
+
+// EscrowTransition is a stored state change of an escrow
+type EscrowTransition struct {
+	State string    `json:"state"`
+	By    string    `json:"by"`
+	Time  time.Time `json:"time"`
+}
+
+// ShortID is the ID shown to the user
+func (e *Escrow) ShortID() string {
+	return strings.TrimPrefix(e.ID, "escrow:")
+}
+
+func (e *Escrow) transition(state string, by string) {
+	e.State = state
+	e.History = append(e.History, EscrowTransition{State: state, By: by, Time: time.Now()})
+	log.Infof("[escrow] %s is %s by %s", e.ID, state, by)
+}
+
+func (e *Escrow) messageText() string {
+	terms := ""
+	if len(e.Terms) > 0 {
+		terms = fmt.Sprintf(escrowTermsMessage, str.MarkdownEscape(e.Terms))
+	}
+	return fmt.Sprintf(escrowMessage, e.ShortID(), e.Amount, GetUserStrMd(e.Buyer.Telegram), GetUserStrMd(e.Seller.Telegram),
+		terms, e.State, e.Deadline.UTC().Format(escrowTimeLayout))
+}
+
+// keyboards returns the buttons for buyer and seller in the current state
+func (e *Escrow) keyboards() (buyer *tb.ReplyMarkup, seller *tb.ReplyMarkup) {
+	buyer, seller = &tb.ReplyMarkup{}, &tb.ReplyMarkup{}
+	release := buyer.Data(btnEscrowRelease.Text, btnEscrowRelease.Unique, e.ID)
+	refund := seller.Data(btnEscrowRefund.Text, btnEscrowRefund.Unique, e.ID)
+	switch e.State {
+	case EscrowStateFunded:
+		buyer.Inline(buyer.Row(release, buyer.Data(btnEscrowDispute.Text, btnEscrowDispute.Unique, e.ID)))
+		seller.Inline(seller.Row(refund, seller.Data(btnEscrowDispute.Text, btnEscrowDispute.Unique, e.ID)))
+	case EscrowStateDisputed:
+		// both parties can still give in
+		buyer.Inline(buyer.Row(release))
+		seller.Inline(seller.Row(refund))
+	}
+	return buyer, seller
+}
+
+// updateMessages edits the escrow messages of buyer and seller
+func (bot *TipBot) updateEscrowMessages(e *Escrow) {
+	buyerKeyboard, sellerKeyboard := e.keyboards()
+	if e.BuyerMessage != nil {
+		bot.tryEditMessage(e.BuyerMessage, e.messageText()+escrowBuyerMessage, buyerKeyboard)
+	}
+	if e.SellerMessage != nil {
+		bot.tryEditMessage(e.SellerMessage, e.messageText()+escrowSellerMessage, sellerKeyboard)
+	}
+}
+
+// escrowArbiters returns the Telegram user IDs of the arbiters. Without configured
+// arbiters, disputes go to the bot admin.
+func (bot *TipBot) escrowArbiters() []int64 {
+	if len(internal.Configuration.Bot.Arbiters) > 0 {
+		return internal.Configuration.Bot.Arbiters
+	}
+	botadmin := strings.TrimPrefix(internal.Configuration.Bot.Botadmin, "@")
+	if len(botadmin) == 0 {
+		return nil
+	}
+	admin, err := GetUserByTelegramUsername(botadmin, *bot)
+	if err != nil {
+		log.Errorf("[escrow] Could not load bot admin @%s: %v", botadmin, err)
+		return nil
+	}
+	return []int64{admin.Telegram.ID}
+}
+
+// isEscrowArbiter compares the Telegram user ID because usernames can be changed and taken over
+func (bot *TipBot) isEscrowArbiter(user *tb.User) bool {
+	for _, arbiter := range bot.escrowArbiters() {
+		if arbiter == user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// loadEscrowWallet returns the wallet that holds the funds of all escrows
+func (bot *TipBot) loadEscrowWallet() (*lnbits.User, error) {
+	return bot.loadHoldingWallet(escrowWalletUserID)
+}
+
+// escrowHandler handles the /escrow command
+func (bot *TipBot) escrowHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	command, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(escrowHelpMessage, escrowTimeoutDays))
+		return ctx, nil
+	}
+	switch strings.ToLower(command) {
+	case "list":
+		return bot.escrowListHandler(ctx)
+	case "resolve":
+		return bot.escrowResolveHandler(ctx)
+	case "help":
+		bot.trySendMessage(m.Sender, fmt.Sprintf(escrowHelpMessage, escrowTimeoutDays))
+		return ctx, nil
+	}
+	return bot.escrowCreateHandler(ctx)
+}
+
+func (bot *TipBot) escrowCreateHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	buyer := LoadUser(ctx)
+	if buyer.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	splits := strings.Fields(m.Text)
+	if len(splits) < 3 || !strings.HasPrefix(splits[2], "@") {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(escrowHelpMessage, escrowTimeoutDays))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(splits[1])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "lnurlInvalidAmountMessage"))
+		return ctx, errors.New(errors.InvalidAmountError, err)
+	}
+	seller, err := GetUserByTelegramUsername(strings.TrimPrefix(splits[2], "@"), *bot)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape(splits[2])))
+		return ctx, err
+	}
+	if seller.Telegram.ID == buyer.Telegram.ID {
+		bot.trySendMessage(m.Sender, Translate(ctx, "sendYourselfMessage"))
+		return ctx, errors.Create(errors.SelfPaymentError)
+	}
+	if len(bot.getEscrows(buyer)) >= escrowMaxOpenPerUser {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(escrowTooManyMessage, escrowMaxOpenPerUser))
+		return ctx, fmt.Errorf("too many open escrows")
+	}
+	terms := GetMemoFromCommand(m.Text, 3)
+	if len(terms) > escrowMaxTermsLength {
+		terms = terms[:escrowMaxTermsLength]
+	}
+	escrowWallet, err := bot.loadEscrowWallet()
+	if err != nil {
+		log.Errorf("[escrow] could not load escrow wallet: %v", err)
+		bot.trySendMessage(m.Sender, escrowUnavailableMessage)
+		return ctx, err
+	}
+
+	escrow := &Escrow{
+		Base:         storage.New(storage.ID(fmt.Sprintf("escrow:%s", RandStringRunes(8)))),
+		Buyer:        buyer,
+		Seller:       seller,
+		Amount:       amount,
+		Terms:        terms,
+		Deadline:     time.Now().Add(escrowTimeout),
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	t := NewTransaction(bot, buyer, escrowWallet, amount, TransactionType("escrow"), TransactionChat(m.Chat))
+	t.Memo = fmt.Sprintf("🤝 Escrow %s from %s to %s.", escrow.ShortID(), GetUserStr(buyer.Telegram), GetUserStr(seller.Telegram))
+	success, err := t.Send()
+	if !success {
+		if err == nil {
+			err = fmt.Errorf("transaction failed")
+		}
+		bot.trySendMessage(m.Sender, fmt.Sprintf("%s %s", Translate(ctx, "sendErrorMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	escrow.transition(EscrowStateFunded, GetUserStr(buyer.Telegram))
+
+	buyerKeyboard, sellerKeyboard := escrow.keyboards()
+	bot.trySendMessage(buyer.Telegram, fmt.Sprintf(escrowCreatedMessage, amount, escrow.ShortID()))
+	escrow.BuyerMessage = bot.trySendMessageEditable(buyer.Telegram, escrow.messageText()+escrowBuyerMessage, buyerKeyboard)
+	escrow.SellerMessage = bot.trySendMessageEditable(seller.Telegram, escrow.messageText()+escrowSellerMessage, sellerKeyboard)
+	runtime.IgnoreError(escrow.Set(escrow, bot.Bunt))
+	return ctx, nil
+}
+
+func (bot *TipBot) escrowListHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	escrows := bot.getEscrows(user)
+	if len(escrows) == 0 {
+		bot.trySendMessage(m.Sender, escrowListEmptyMessage)
+		return ctx, nil
+	}
+	message := escrowListMessage
+	for _, e := range escrows {
+		message += fmt.Sprintf(escrowListEntryMessage, e.ShortID(), e.Amount, GetUserStrMd(e.Buyer.Telegram), GetUserStrMd(e.Seller.Telegram), e.State)
+	}
+	bot.trySendMessage(m.Sender, message)
+	return ctx, nil
+}
+
+// escrowResolveHandler lets an arbiter settle a disputed escrow
+func (bot *TipBot) escrowResolveHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if !bot.isEscrowArbiter(m.Sender) {
+		bot.trySendMessage(m.Sender, escrowNotArbiterMessage)
+		return ctx, fmt.Errorf("not an arbiter")
+	}
+	splits := strings.Fields(m.Text)
+	if len(splits) != 4 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(escrowHelpMessage, escrowTimeoutDays))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	var state string
+	switch strings.ToLower(splits[3]) {
+	case "release":
+		state = EscrowStateReleased
+	case "refund":
+		state = EscrowStateRefunded
+	default:
+		bot.trySendMessage(m.Sender, fmt.Sprintf(escrowHelpMessage, escrowTimeoutDays))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	tx := &Escrow{Base: storage.New(storage.ID(fmt.Sprintf("escrow:%s", splits[2])))}
+	mutex.LockWithContext(ctx, tx.ID)
+	defer mutex.UnlockWithContext(ctx, tx.ID)
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		bot.trySendMessage(m.Sender, escrowNotFoundMessage)
+		return ctx, err
+	}
+	escrow := sn.(*Escrow)
+	if !escrow.Active || escrow.State != EscrowStateDisputed {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(escrowNotDisputedMessage, escrow.ShortID()))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	err = bot.settleEscrow(escrow, state, GetUserStr(m.Sender))
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(escrowSettledMessage, escrow.ShortID(), state))
+	return ctx, nil
+}
+
+// releaseEscrowHandler is invoked when the buyer releases the funds to the seller
+func (bot *TipBot) releaseEscrowHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.escrowButtonHandler(ctx, EscrowStateReleased)
+}
+
+// refundEscrowHandler is invoked when the seller refunds the buyer
+func (bot *TipBot) refundEscrowHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.escrowButtonHandler(ctx, EscrowStateRefunded)
+}
+
+// disputeEscrowHandler is invoked when buyer or seller dispute the escrow
+func (bot *TipBot) disputeEscrowHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.escrowButtonHandler(ctx, EscrowStateDisputed)
+}
+
+func (bot *TipBot) escrowButtonHandler(ctx intercept.Context, state string) (intercept.Context, error) {
+	c := ctx.Callback()
+	tx := &Escrow{Base: storage.New(storage.ID(c.Data))}
+	mutex.LockWithContext(ctx, tx.ID)
+	defer mutex.UnlockWithContext(ctx, tx.ID)
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		log.Errorf("[escrowButtonHandler] %s", err.Error())
+		return ctx, err
+	}
+	escrow := sn.(*Escrow)
+	if !escrow.Active {
+		bot.tryEditMessage(c, escrow.messageText(), &tb.ReplyMarkup{})
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	isBuyer := c.Sender.ID == escrow.Buyer.Telegram.ID
+	isSeller := c.Sender.ID == escrow.Seller.Telegram.ID
+	switch state {
+	case EscrowStateReleased:
+		// only the buyer can release the funds to the seller
+		if !isBuyer {
+			return ctx, errors.Create(errors.UnknownError)
+		}
+	case EscrowStateRefunded:
+		// only the seller can refund the buyer
+		if !isSeller {
+			return ctx, errors.Create(errors.UnknownError)
+		}
+	case EscrowStateDisputed:
+		if !isBuyer && !isSeller || escrow.State != EscrowStateFunded {
+			return ctx, errors.Create(errors.UnknownError)
+		}
+		return ctx, bot.disputeEscrow(escrow, c.Sender)
+	}
+	return ctx, bot.settleEscrow(escrow, state, GetUserStr(c.Sender))
+}
+
+// disputeEscrow stops the timeout of the escrow and asks the arbiters to resolve it
+func (bot *TipBot) disputeEscrow(escrow *Escrow, by *tb.User) error {
+	arbiters := bot.escrowArbiters()
+	if len(arbiters) == 0 {
+		bot.trySendMessage(by, escrowNoArbiterMessage)
+		return fmt.Errorf("no arbiter configured")
+	}
+	escrow.transition(EscrowStateDisputed, GetUserStr(by))
+	runtime.IgnoreError(escrow.Set(escrow, bot.Bunt))
+	bot.updateEscrowMessages(escrow)
+
+	message := fmt.Sprintf(escrowDisputedMessage, escrow.ShortID(), GetUserStrMd(by))
+	bot.trySendMessage(escrow.Buyer.Telegram, message)
+	bot.trySendMessage(escrow.Seller.Telegram, message)
+	for _, arbiter := range arbiters {
+		bot.trySendMessage(&tb.User{ID: arbiter}, fmt.Sprintf(escrowArbiterMessage, escrow.messageText(), escrow.ShortID(), escrow.ShortID()))
+	}
+	return nil
+}
+
+// settleEscrow pays the escrow out to the seller (released) or back to the buyer (refunded)
+func (bot *TipBot) settleEscrow(escrow *Escrow, state string, by string) error {
+	escrowWallet, err := bot.loadEscrowWallet()
+	if err != nil {
+		return err
+	}
+	to := escrow.Seller
+	if state == EscrowStateRefunded {
+		to = escrow.Buyer
+	}
+	t := NewTransaction(bot, escrowWallet, to, escrow.Amount, TransactionType("escrow"))
+	t.Memo = fmt.Sprintf("🤝 Escrow %s %s.", escrow.ShortID(), state)
+	success, err := t.Send()
+	if !success {
+		if err == nil {
+			err = fmt.Errorf("transaction failed")
+		}
+		log.Errorf("[escrow] could not settle %s: %v", escrow.ID, err)
+		return err
+	}
+	escrow.transition(state, by)
+	runtime.IgnoreError(escrow.Inactivate(escrow, bot.Bunt))
+	bot.updateEscrowMessages(escrow)
+
+	message := fmt.Sprintf(escrowSettledMessage, escrow.ShortID(), state)
+	bot.trySendMessage(escrow.Buyer.Telegram, message)
+	bot.trySendMessage(escrow.Seller.Telegram, message)
+	return nil
+}
+
+// getEscrows returns all open escrows of a user as buyer or seller
+func (bot *TipBot) getEscrows(user *lnbits.User) []*Escrow {
+	var escrows []*Escrow
+	for _, e := range bot.loadEscrows() {
+		if e.Buyer.Telegram.ID == user.Telegram.ID || e.Seller.Telegram.ID == user.Telegram.ID {
+			escrows = append(escrows, e)
+		}
+	}
+	return escrows
+}
+
+// loadEscrows returns all open escrows
+func (bot *TipBot) loadEscrows() []*Escrow {
+	var escrows []*Escrow
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend(EscrowIndex, func(key, value string) bool {
+			e := &Escrow{}
+			err := json.Unmarshal([]byte(value), e)
+			if err != nil || e.Base == nil || !e.Active || e.Buyer == nil || e.Seller == nil {
+				return true
+			}
+			escrows = append(escrows, e)
+			return true // continue iteration
+		})
+	})
+	return escrows
+}
+
+// startEscrowWatcher refunds funded escrows after their deadline.
+// Disputed escrows wait for an arbiter.
+func (bot *TipBot) startEscrowWatcher() {
+	runtime.Watch(escrowTickerDuration, bot.loadEscrows, func(e *Escrow) {
+		if e.State == EscrowStateFunded && !e.Deadline.After(time.Now()) {
+			bot.refundExpiredEscrow(e.ID)
+		}
+	})
+}
+
+func (bot *TipBot) refundExpiredEscrow(id string) {
+	tx := &Escrow{Base: storage.New(storage.ID(id))}
+	mutex.Lock(tx.ID)
+	defer mutex.Unlock(tx.ID)
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		log.Errorf("[escrow] could not load %s: %v", id, err)
+		return
+	}
+	escrow := sn.(*Escrow)
+	// check again, the escrow could have been settled in the meantime
+	if !escrow.Active || escrow.State != EscrowStateFunded {
+		return
+	}
+	runtime.IgnoreError(bot.settleEscrow(escrow, EscrowStateRefunded, escrowTimeoutActor))
+}
@@ -0,0 +1,295 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/price"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	exportHelpMessage     = "📄 *Export transactions*\n\n`/export [from] [to] [csv|json] [currency]`\n\nDates are `YYYY-MM-DD` (UTC). Without dates all transactions are exported. The fiat value is the price at the time of each transaction, it is empty if no price is known for that time."
+	exportCreatingMessage = "📄 Creating your export..."
+	exportEmptyMessage    = "📄 No transactions in this period."
+	exportCaptionMessage  = "📄 %d transactions from %s to %s."
+)
+
+const (
+	ExportFormatCSV  = "csv"
+	ExportFormatJSON = "json"
+
+	exportDateLayout = "2006-01-02"
+	exportPageSize   = 100
+)
+
+// TransactionExport is one row of a transaction export
+type TransactionExport struct {
+	Time         time.Time `json:"time"`
+	Status       string    `json:"status"`
+	Direction    string    `json:"direction"`
+	Amount       int64     `json:"amount_sat"`
+	Fee          int64     `json:"fee_sat"`
+	Memo         string    `json:"memo"`
+	PaymentHash  string    `json:"payment_hash"`
+	Type         string    `json:"type"`
+	Counterparty string    `json:"counterparty"`
+	Chat         string    `json:"chat"`
+	Currency     string    `json:"currency"`
+	FiatPrice    *float64  `json:"fiat_price"`
+	FiatValue    *float64  `json:"fiat_value"`
+}
+
+var exportCSVHeader = []string{"time", "status", "direction", "amount_sat", "fee_sat", "memo", "payment_hash", "type", "counterparty", "chat", "currency", "fiat_price", "fiat_value"}
+
+// ExportCurrency returns the currency of the fiat values of an export.
+// The display currency of the user is used if no valid currency is given.
+func ExportCurrency(user *lnbits.User, currency string) string {
+	currency = strings.ToUpper(currency)
+	if _, ok := price.P.Currencies[currency]; ok {
+		return currency
+	}
+	if user.Settings != nil {
+		currency = strings.ToUpper(user.Settings.Display.DisplayCurrency)
+		if _, ok := price.P.Currencies[currency]; ok {
+			return currency
+		}
+	}
+	return "USD"
+}
+
+// ParseExportPeriod parses the dates of an export. The end date is inclusive.
+func ParseExportPeriod(fromStr, toStr string) (from time.Time, to time.Time, err error) {
+	to = time.Now().UTC()
+	if len(fromStr) > 0 {
+		from, err = time.Parse(exportDateLayout, fromStr)
+		if err != nil {
+			return
+		}
+	}
+	if len(toStr) > 0 {
+		to, err = time.Parse(exportDateLayout, toStr)
+		if err != nil {
+			return
+		}
+		to = to.Add(24*time.Hour - time.Second)
+	}
+	if to.Before(from) {
+		err = fmt.Errorf("end date before start date")
+	}
+	return
+}
+
+// loadPaymentsBetween pages through the LNbits payments of a wallet, newest first,
+// until it reaches the start of the period or the oldest payment
+func (bot *TipBot) loadPaymentsBetween(wallet lnbits.Wallet, from, to time.Time) (lnbits.Payments, error) {
+	var payments lnbits.Payments
+	for page := 0; ; page++ {
+		p, err := bot.Client.PaymentsPage(wallet, exportPageSize, page*exportPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, payment := range p {
+			t := time.Unix(int64(payment.Time), 0)
+			if t.Before(from) || t.After(to) {
+				continue
+			}
+			payments = append(payments, payment)
+		}
+		if len(p) < exportPageSize || time.Unix(int64(p[len(p)-1].Time), 0).Before(from) {
+			break
+		}
+	}
+	return payments, nil
+}
+
+// ExportTransactions joins the LNbits payments of a user with the transaction log
+// and adds the fiat value at the time of each payment
+func (bot *TipBot) ExportTransactions(user *lnbits.User, from, to time.Time, currency string) ([]TransactionExport, error) {
+	payments, err := bot.loadPaymentsBetween(*user.Wallet, from, to)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(payments))
+	for _, payment := range payments {
+		hashes = append(hashes, payment.PaymentHash)
+	}
+	var transactions []Transaction
+	if len(hashes) > 0 {
+		tx := bot.DB.Transactions.
+			Where("invoice_payment_hash IN ? AND (from_id = ? OR to_id = ?)", hashes, user.Telegram.ID, user.Telegram.ID).
+			Find(&transactions)
+		if tx.Error != nil {
+			return nil, tx.Error
+		}
+	}
+	byHash := make(map[string]Transaction, len(transactions))
+	for _, t := range transactions {
+		byHash[t.Invoice.PaymentHash] = t
+	}
+
+	rows := make([]TransactionExport, 0, len(payments))
+	for _, payment := range payments {
+		row := TransactionExport{
+			Time:        time.Unix(int64(payment.Time), 0).UTC(),
+			Status:      "settled",
+			Direction:   "in",
+			Amount:      payment.Amount / 1000,
+			Fee:         payment.Fee / 1000,
+			Memo:        payment.Memo,
+			PaymentHash: payment.PaymentHash,
+			Currency:    currency,
+		}
+		if payment.Pending {
+			row.Status = "pending"
+		}
+		if payment.Amount < 0 {
+			row.Direction = "out"
+		}
+		if row.Fee < 0 {
+			row.Fee = -row.Fee
+		}
+		if t, ok := byHash[payment.PaymentHash]; ok {
+			row.Type = t.Type
+			row.Chat = t.ChatName
+			row.Counterparty = t.FromUser
+			if t.FromId == user.Telegram.ID {
+				row.Counterparty = t.ToUser
+			}
+		}
+		fiatPrice, err := price.PriceAt(currency, row.Time)
+		if err == nil {
+			fiatValue := math.Round(float64(row.Amount)/1e8*fiatPrice*100) / 100
+			row.FiatPrice, row.FiatValue = &fiatPrice, &fiatValue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// WriteTransactionExport writes the export rows as csv or json
+func WriteTransactionExport(w io.Writer, rows []TransactionExport, format string) error {
+	if format == ExportFormatJSON {
+		return json.NewEncoder(w).Encode(rows)
+	}
+	c := csv.NewWriter(w)
+	err := c.Write(exportCSVHeader)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err = c.Write([]string{
+			row.Time.Format(time.RFC3339),
+			row.Status,
+			row.Direction,
+			strconv.FormatInt(row.Amount, 10),
+			strconv.FormatInt(row.Fee, 10),
+			row.Memo,
+			row.PaymentHash,
+			row.Type,
+			row.Counterparty,
+			row.Chat,
+			row.Currency,
+			formatExportFiat(row.FiatPrice),
+			formatExportFiat(row.FiatValue),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// formatExportFiat leaves the column empty if no price was known at the time
+func formatExportFiat(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', 2, 64)
+}
+
+// exportHandler handles /export [from] [to] [csv|json] [currency]
+func (bot *TipBot) exportHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	var dates []string
+	format, currency := ExportFormatCSV, ""
+	for _, argument := range strings.Fields(m.Text)[1:] {
+		switch a := strings.ToLower(argument); {
+		case a == ExportFormatCSV || a == ExportFormatJSON:
+			format = a
+		case a == "help":
+			bot.trySendMessage(m.Sender, exportHelpMessage)
+			return ctx, nil
+		case len(a) == 3:
+			currency = a
+		default:
+			dates = append(dates, a)
+		}
+	}
+	if len(dates) > 2 {
+		bot.trySendMessage(m.Sender, exportHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	dates = append(dates, "", "")
+	from, to, err := ParseExportPeriod(dates[0], dates[1])
+	if err != nil {
+		bot.trySendMessage(m.Sender, exportHelpMessage)
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	if user.Settings == nil {
+		user, err = GetLnbitsUserWithSettings(m.Sender, *bot)
+		if err != nil {
+			return ctx, err
+		}
+	}
+	currency = ExportCurrency(user, currency)
+
+	creatingMsg := bot.trySendMessageEditable(m.Sender, exportCreatingMessage)
+	rows, err := bot.ExportTransactions(user, from, to, currency)
+	if err != nil {
+		log.Errorf("[export] could not export transactions of %s: %v", GetUserStr(user.Telegram), err)
+		bot.tryEditMessage(creatingMsg, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	if len(rows) == 0 {
+		bot.tryEditMessage(creatingMsg, exportEmptyMessage)
+		return ctx, nil
+	}
+	var buf bytes.Buffer
+	err = WriteTransactionExport(&buf, rows, format)
+	if err != nil {
+		log.Errorf("[export] could not write export of %s: %v", GetUserStr(user.Telegram), err)
+		bot.tryEditMessage(creatingMsg, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	bot.tryDeleteMessage(creatingMsg)
+	mime := "text/csv"
+	if format == ExportFormatJSON {
+		mime = "application/json"
+	}
+	fromStr := from.Format(exportDateLayout)
+	if from.IsZero() {
+		fromStr = rows[len(rows)-1].Time.Format(exportDateLayout)
+	}
+	bot.trySendMessage(m.Sender, &tb.Document{
+		File:     tb.FromReader(&buf),
+		FileName: fmt.Sprintf("transactions_%s_%s.%s", fromStr, to.Format(exportDateLayout), format),
+		MIME:     mime,
+		Caption:  fmt.Sprintf(exportCaptionMessage, len(rows), fromStr, to.Format(exportDateLayout)),
+	})
+	log.Infof("[export] %s exported %d transactions", GetUserStr(user.Telegram), len(rows))
+	return ctx, nil
+}
@@ -25,24 +25,27 @@ import (
 )
 
 var (
-	inlineFaucetMenu      = &tb.ReplyMarkup{ResizeKeyboard: true}
-	btnCancelInlineFaucet = inlineFaucetMenu.Data("🚫 Cancel", "cancel_faucet_inline")
-	btnAcceptInlineFaucet = inlineFaucetMenu.Data("✅ Collect", "confirm_faucet_inline")
+	inlineFaucetMenu       = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnCancelInlineFaucet  = inlineFaucetMenu.Data("🚫 Cancel", "cancel_faucet_inline")
+	btnAcceptInlineFaucet  = inlineFaucetMenu.Data("✅ Collect", "confirm_faucet_inline")
+	btnCaptchaInlineFaucet = inlineFaucetMenu.Data("❓", "captcha_faucet_inline")
 )
 
 type InlineFaucet struct {
 	*storage.Base
-	Message         string         `json:"inline_faucet_message"`
-	Amount          int64          `json:"inline_faucet_amount"`
-	RemainingAmount int64          `json:"inline_faucet_remainingamount"`
-	PerUserAmount   int64          `json:"inline_faucet_peruseramount"`
-	From            *lnbits.User   `json:"inline_faucet_from"`
-	To              []*lnbits.User `json:"inline_faucet_to"`
-	Memo            string         `json:"inline_faucet_memo"`
-	NTotal          int            `json:"inline_faucet_ntotal"`
-	NTaken          int            `json:"inline_faucet_ntaken"`
-	UserNeedsWallet bool           `json:"inline_faucet_userneedswallet"`
-	LanguageCode    string         `json:"languagecode"`
+	Message         string           `json:"inline_faucet_message"`
+	Amount          int64            `json:"inline_faucet_amount"`
+	RemainingAmount int64            `json:"inline_faucet_remainingamount"`
+	PerUserAmount   int64            `json:"inline_faucet_peruseramount"`
+	From            *lnbits.User     `json:"inline_faucet_from"`
+	To              []*lnbits.User   `json:"inline_faucet_to"`
+	Memo            string           `json:"inline_faucet_memo"`
+	NTotal          int              `json:"inline_faucet_ntotal"`
+	NTaken          int              `json:"inline_faucet_ntaken"`
+	UserNeedsWallet bool             `json:"inline_faucet_userneedswallet"`
+	LanguageCode    string           `json:"languagecode"`
+	Editable        tb.StoredMessage `json:"inline_faucet_editable"`
+	FaucetRules
 }
 
 func (bot TipBot) mapFaucetLanguage(ctx context.Context, command string) context.Context {
@@ -82,6 +85,10 @@ func (bot TipBot) createFaucet(ctx context.Context, text string, sender *tb.User
 	if balance < amount {
 		return nil, errors.New(errors.BalanceToLowError, fmt.Errorf("[faucet] Balance of user %s too low", fromUserStr))
 	}
+	text, rules, err := bot.parseFaucetRules(text)
+	if err != nil {
+		return nil, errors.New(errors.InvalidSyntaxError, err)
+	}
 	// // check for memo in command
 	memo := GetMemoFromCommand(text, 3)
 
@@ -89,6 +96,7 @@ func (bot TipBot) createFaucet(ctx context.Context, text string, sender *tb.User
 	if len(memo) > 0 {
 		inlineMessage = inlineMessage + fmt.Sprintf(Translate(ctx, "inlineFaucetAppendMemo"), memo)
 	}
+	inlineMessage += rules.message()
 	id := fmt.Sprintf("faucet:%s:%d", RandStringRunes(10), amount)
 
 	return &InlineFaucet{
@@ -103,6 +111,7 @@ func (bot TipBot) createFaucet(ctx context.Context, text string, sender *tb.User
 		RemainingAmount: amount,
 		UserNeedsWallet: false,
 		LanguageCode:    ctx.Value("publicLanguageCode").(string),
+		FaucetRules:     rules,
 	}, nil
 
 }
@@ -135,6 +144,10 @@ func (bot TipBot) makeFaucet(ctx context.Context, m *tb.Message, query bool) (*I
 			bot.trySendMessage(m.Sender, Translate(ctx, "inlineSendBalanceLowMessage"))
 			bot.tryDeleteMessage(m)
 			return nil, err
+		case errors.InvalidSyntaxError:
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "inlineFaucetHelpText"), err.(errors.TipBotError).Message+"\n"+faucetOptionsHelpMessage))
+			bot.tryDeleteMessage(m)
+			return nil, err
 		}
 	}
 	return faucet, err
@@ -163,15 +176,37 @@ func (bot TipBot) makeQueryFaucet(ctx intercept.Context) (*InlineFaucet, error)
 			log.Errorf(err.Error())
 			bot.inlineQueryReplyWithError(ctx, TranslateUser(ctx, "inlineSendBalanceLowMessage"), fmt.Sprintf(TranslateUser(ctx, "inlineQueryFaucetDescription"), bot.Telegram.Me.Username))
 			return nil, err
+		case errors.InvalidSyntaxError:
+			bot.inlineQueryReplyWithError(ctx, err.(errors.TipBotError).Message, faucetOptionsHelpMessage)
+			return nil, err
 		}
+		return nil, err
 	}
-	return faucet, err
+	// inline queries don't tell in which chat the faucet is posted
+	if faucet.RequireMembership && faucet.MemberChatID == 0 {
+		bot.inlineQueryReplyWithError(ctx, faucetInlineMemberChatMessage, faucetOptionsHelpMessage)
+		return nil, errors.Create(errors.InvalidSyntaxError)
+	}
+	return faucet, nil
 }
 
-func (bot TipBot) makeFaucetKeyboard(ctx context.Context, id string) *tb.ReplyMarkup {
+func (bot TipBot) makeFaucetKeyboard(ctx context.Context, inlineFaucet *InlineFaucet) *tb.ReplyMarkup {
+	id := inlineFaucet.ID
 	inlineFaucetMenu := &tb.ReplyMarkup{ResizeKeyboard: true}
-	acceptInlineFaucetButton := inlineFaucetMenu.Data(Translate(ctx, "collectButtonMessage"), "confirm_faucet_inline", id)
 	cancelInlineFaucetButton := inlineFaucetMenu.Data(Translate(ctx, "cancelButtonMessage"), "cancel_faucet_inline", id)
+	// with a captcha, collectors press one of the emojis instead of the collect button
+	if len(inlineFaucet.Captcha) > 0 {
+		var captchaButtons []tb.Btn
+		for i, emoji := range inlineFaucet.Captcha {
+			captchaButtons = append(captchaButtons, inlineFaucetMenu.Data(emoji, "captcha_faucet_inline", fmt.Sprintf("%s|%d", id, i)))
+		}
+		inlineFaucetMenu.Inline(
+			inlineFaucetMenu.Row(captchaButtons...),
+			inlineFaucetMenu.Row(cancelInlineFaucetButton),
+		)
+		return inlineFaucetMenu
+	}
+	acceptInlineFaucetButton := inlineFaucetMenu.Data(Translate(ctx, "collectButtonMessage"), "confirm_faucet_inline", id)
 	inlineFaucetMenu.Inline(
 		inlineFaucetMenu.Row(
 			acceptInlineFaucetButton,
@@ -192,8 +227,14 @@ func (bot TipBot) faucetHandler(ctx intercept.Context) (intercept.Context, error
 		log.Warnf("[faucet] %s", err.Error())
 		return ctx, err
 	}
+	if inlineFaucet.RequireMembership && inlineFaucet.MemberChatID == 0 {
+		inlineFaucet.MemberChatID = ctx.Message().Chat.ID
+	}
 	fromUserStr := GetUserStr(ctx.Message().Sender)
-	mFaucet := bot.trySendMessage(ctx.Message().Chat, inlineFaucet.Message, bot.makeFaucetKeyboard(ctx, inlineFaucet.ID))
+	mFaucet := bot.trySendMessage(ctx.Message().Chat, inlineFaucet.Message, bot.makeFaucetKeyboard(ctx, inlineFaucet))
+	if mFaucet != nil {
+		inlineFaucet.Editable = tb.StoredMessage{MessageID: strconv.Itoa(mFaucet.ID), ChatID: mFaucet.Chat.ID}
+	}
 	log.Infof("[faucet] %s created faucet %s: %d sat (%d per user)", fromUserStr, inlineFaucet.ID, inlineFaucet.Amount, inlineFaucet.PerUserAmount)
 
 	// log faucet link if possible
@@ -222,7 +263,7 @@ func (bot TipBot) handleInlineFaucetQuery(ctx intercept.Context) (intercept.Cont
 			// required for photos
 			ThumbURL: url,
 		}
-		result.ReplyMarkup = &tb.ReplyMarkup{InlineKeyboard: bot.makeFaucetKeyboard(ctx, inlineFaucet.ID).InlineKeyboard}
+		result.ReplyMarkup = &tb.ReplyMarkup{InlineKeyboard: bot.makeFaucetKeyboard(ctx, inlineFaucet).InlineKeyboard}
 		results[i] = result
 		// needed to set a unique string ID for each result
 		results[i].SetResultID(inlineFaucet.ID)
@@ -282,6 +323,15 @@ func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.C
 			return ctx, errors.Create(errors.UnknownError)
 		}
 	}
+	if reason := bot.faucetRuleViolation(inlineFaucet, to); len(reason) > 0 {
+		log.Debugf("[faucet] %s:%d does not meet the rules of faucet %s: %s", GetUserStr(to.Telegram), to.Telegram.ID, inlineFaucet.ID, reason)
+		ctx.Context = context.WithValue(ctx, "callback_response", reason)
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	// remember the message for edits from the faucet watcher
+	if len(inlineFaucet.Editable.MessageID) == 0 {
+		inlineFaucet.Editable.MessageID, inlineFaucet.Editable.ChatID = c.MessageSig()
+	}
 
 	defer inlineFaucet.Set(inlineFaucet, bot.Bunt)
 
@@ -332,6 +382,7 @@ func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.C
 			to_message := fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "inlineFaucetReceivedMessage"), fromUserStrMd, inlineFaucet.PerUserAmount)
 			ctx.Context = context.WithValue(ctx, "callback_response", to_message)
 			bot.trySendMessage(to.Telegram, to_message)
+			bot.sendNostrDirectMessage(to.Telegram, to_message)
 			bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "inlineFaucetSentMessage"), inlineFaucet.PerUserAmount, toUserStrMd))
 		}()
 
@@ -341,6 +392,7 @@ func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.C
 		if len(memo) > 0 {
 			inlineFaucet.Message = inlineFaucet.Message + fmt.Sprintf(i18n.Translate(inlineFaucet.LanguageCode, "inlineFaucetAppendMemo"), memo)
 		}
+		inlineFaucet.Message += inlineFaucet.FaucetRules.message()
 		if inlineFaucet.UserNeedsWallet {
 			inlineFaucet.Message += "\n\n" + fmt.Sprintf(i18n.Translate(inlineFaucet.LanguageCode, "inlineFaucetCreateWalletMessage"), GetUserStr(bot.Telegram.Me))
 		}
@@ -349,7 +401,7 @@ func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.C
 
 		// update the message if the faucet still has some sats left after this tx
 		if inlineFaucet.RemainingAmount >= inlineFaucet.PerUserAmount {
-			bot.tryEditStack(c, inlineFaucet.ID, inlineFaucet.Message, bot.makeFaucetKeyboard(ctx, inlineFaucet.ID))
+			bot.tryEditStack(c, inlineFaucet.ID, inlineFaucet.Message, bot.makeFaucetKeyboard(ctx, inlineFaucet))
 		}
 	}
 	if inlineFaucet.RemainingAmount < inlineFaucet.PerUserAmount {
@@ -0,0 +1,350 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/runtime/once"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	faucetOptionsHelpMessage       = "Options: `expire=<2h|1d>` `age=<30d>` `walletage=<7d>` `member[=@group]` `captcha`"
+	faucetExpiresMessage           = "\n⌛️ Expires: `%s`"
+	faucetAccountAgeMessage        = "\n🔒 Telegram account older than about %s (estimated)"
+	faucetWalletAgeMessage         = "\n🔒 Wallet older than %s"
+	faucetMembershipMessage        = "\n🔒 Members of the group only"
+	faucetCaptchaMessage           = "\n🔒 Press the result of `%s` to collect"
+	faucetExpiredMessage           = "⌛️ Faucet expired.\n\n🏅 %d sat given to %d users."
+	faucetExpiredCreatorMessage    = "⌛️ Your faucet expired. The remaining %d sat stay in your wallet."
+	faucetAccountTooNewMessage     = "🚫 Your Telegram account is too new for this faucet."
+	faucetWalletTooNewMessage      = "🚫 Your wallet is too new for this faucet."
+	faucetNotMemberMessage         = "🚫 This faucet is for members of the group only."
+	faucetCaptchaWrongMessage      = "🚫 Wrong answer."
+	faucetCaptchaRequiredMessage   = "🚫 Please press the right button."
+	faucetInvalidOptionMessage     = "🚫 Invalid option `%s`."
+	faucetInvalidMemberChatMessage = "🚫 I can't find the group %s."
+	faucetInlineMemberChatMessage  = "🚫 Inline faucets need the group: `member=@group`."
+)
+
+const (
+	faucetMaxExpiry      = 30 * 24 * time.Hour
+	faucetTickerDuration = time.Minute
+)
+
+// faucetCaptchaChoices is the number of answer buttons of a captcha
+const faucetCaptchaChoices = 4
+
+// FaucetRules restrict who can collect from a faucet and for how long
+type FaucetRules struct {
+	Expires           time.Time     `json:"inline_faucet_expires"`
+	MinAccountAge     time.Duration `json:"inline_faucet_minaccountage"`
+	MinWalletAge      time.Duration `json:"inline_faucet_minwalletage"`
+	RequireMembership bool          `json:"inline_faucet_requiremembership"`
+	MemberChatID      int64         `json:"inline_faucet_memberchatid"`
+	CaptchaQuestion   string        `json:"inline_faucet_captchaquestion"`
+	Captcha           []string      `json:"inline_faucet_captcha"`
+	CaptchaAnswer     int           `json:"inline_faucet_captchaanswer"`
+	CaptchaSolved     []int64       `json:"inline_faucet_captchasolved"`
+	CaptchaFailed     []int64       `json:"inline_faucet_captchafailed"`
+}
+
+// parseFaucetDuration parses durations like 30m, 2h or 7d
+func parseFaucetDuration(input string) (time.Duration, error) {
+	if strings.HasSuffix(input, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(input, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(input)
+}
+
+func formatFaucetDuration(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// parseFaucetRules removes the options from the faucet command and returns the rules they set
+func (bot TipBot) parseFaucetRules(text string) (string, FaucetRules, error) {
+	var rules FaucetRules
+	arguments := strings.Split(text, " ")
+	kept := arguments[:0]
+	for i, argument := range arguments {
+		// the first three arguments are the command, capacity and per user amount
+		if i < 3 {
+			kept = append(kept, argument)
+			continue
+		}
+		key, value, hasValue := strings.Cut(argument, "=")
+		switch strings.ToLower(key) {
+		case "expire", "expires":
+			d, err := parseFaucetDuration(value)
+			if err != nil || d <= 0 || d > faucetMaxExpiry {
+				return text, rules, fmt.Errorf(faucetInvalidOptionMessage, argument)
+			}
+			rules.Expires = time.Now().Add(d)
+		case "age":
+			d, err := parseFaucetDuration(value)
+			if err != nil || d <= 0 {
+				return text, rules, fmt.Errorf(faucetInvalidOptionMessage, argument)
+			}
+			rules.MinAccountAge = d
+		case "walletage":
+			d, err := parseFaucetDuration(value)
+			if err != nil || d <= 0 {
+				return text, rules, fmt.Errorf(faucetInvalidOptionMessage, argument)
+			}
+			rules.MinWalletAge = d
+		case "member", "members":
+			rules.RequireMembership = true
+			if hasValue {
+				chat, err := bot.Telegram.ChatByUsername("@" + strings.TrimPrefix(value, "@"))
+				if err != nil {
+					return text, rules, fmt.Errorf(faucetInvalidMemberChatMessage, value)
+				}
+				rules.MemberChatID = chat.ID
+			}
+		case "captcha":
+			rules.CaptchaQuestion, rules.Captcha, rules.CaptchaAnswer = newFaucetCaptcha()
+		default:
+			kept = append(kept, argument)
+		}
+	}
+	return strings.Join(kept, " "), rules, nil
+}
+
+// newFaucetCaptcha returns a small addition, the answer buttons and the index of the right answer
+func newFaucetCaptcha() (question string, choices []string, answer int) {
+	a, b := rand.Intn(9)+1, rand.Intn(9)+1
+	// the wrong answers are other sums of two digits
+	seen := map[int]bool{a + b: true}
+	for len(choices) < faucetCaptchaChoices-1 {
+		n := rand.Intn(17) + 2
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		choices = append(choices, strconv.Itoa(n))
+	}
+	answer = rand.Intn(faucetCaptchaChoices)
+	choices = append(choices[:answer], append([]string{strconv.Itoa(a + b)}, choices[answer:]...)...)
+	return fmt.Sprintf("%d + %d", a, b), choices, answer
+}
+
+// message describes the rules to the collectors
+func (rules FaucetRules) message() string {
+	message := ""
+	if !rules.Expires.IsZero() {
+		message += fmt.Sprintf(faucetExpiresMessage, rules.Expires.UTC().Format(escrowTimeLayout))
+	}
+	if rules.MinAccountAge > 0 {
+		message += fmt.Sprintf(faucetAccountAgeMessage, formatFaucetDuration(rules.MinAccountAge))
+	}
+	if rules.MinWalletAge > 0 {
+		message += fmt.Sprintf(faucetWalletAgeMessage, formatFaucetDuration(rules.MinWalletAge))
+	}
+	if rules.RequireMembership {
+		message += faucetMembershipMessage
+	}
+	if len(rules.Captcha) > 0 {
+		message += fmt.Sprintf(faucetCaptchaMessage, rules.CaptchaQuestion)
+	}
+	if len(message) > 0 {
+		message = "\n" + message
+	}
+	return message
+}
+
+func containsUserID(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// telegramAccountAnchors are rough (user ID, registration date) pairs of Telegram accounts.
+// Telegram doesn't expose the registration date, it can only be estimated from the ID.
+// IDs are not handed out strictly in order, so the age rule is an approximation
+// that keeps out fresh accounts but can be off by months for single users.
+var telegramAccountAnchors = []struct {
+	id   int64
+	time int64
+}{
+	{2768409, 1383264000},
+	{7679610, 1388448000},
+	{11538514, 1391212000},
+	{63263518, 1414454000},
+	{101260938, 1425600000},
+	{222021233, 1465344000},
+	{400169472, 1501459000},
+	{805158066, 1563208000},
+	{1974255900, 1634000000},
+	{5000000000, 1646092800},
+	{7000000000, 1704067200},
+}
+
+// estimateTelegramAccountCreation interpolates the registration date of a Telegram user ID
+func estimateTelegramAccountCreation(id int64) time.Time {
+	anchors := telegramAccountAnchors
+	if id <= anchors[0].id {
+		return time.Unix(anchors[0].time, 0)
+	}
+	for i := 1; i < len(anchors); i++ {
+		if id <= anchors[i].id || i == len(anchors)-1 {
+			a, b := anchors[i-1], anchors[i]
+			t := a.time + (id-a.id)*(b.time-a.time)/(b.id-a.id)
+			return time.Unix(t, 0)
+		}
+	}
+	return time.Now()
+}
+
+// faucetRuleViolation returns why the user can't collect from the faucet or an empty string
+func (bot *TipBot) faucetRuleViolation(inlineFaucet *InlineFaucet, to *lnbits.User) string {
+	rules := inlineFaucet.FaucetRules
+	if rules.MinAccountAge > 0 && time.Since(estimateTelegramAccountCreation(to.Telegram.ID)) < rules.MinAccountAge {
+		return faucetAccountTooNewMessage
+	}
+	if rules.MinWalletAge > 0 && (to.Wallet == nil || to.CreatedAt.IsZero() || time.Since(to.CreatedAt) < rules.MinWalletAge) {
+		return faucetWalletTooNewMessage
+	}
+	if rules.RequireMembership {
+		// the chat is stored when the faucet is created, inline callbacks don't carry it
+		if rules.MemberChatID == 0 {
+			return faucetNotMemberMessage
+		}
+		member, err := bot.Telegram.ChatMemberOf(&tb.Chat{ID: rules.MemberChatID}, to.Telegram)
+		if err != nil || member.Role == tb.Left || member.Role == tb.Kicked {
+			return faucetNotMemberMessage
+		}
+	}
+	if len(rules.Captcha) > 0 && !containsUserID(rules.CaptchaSolved, to.Telegram.ID) {
+		return faucetCaptchaRequiredMessage
+	}
+	return ""
+}
+
+// captchaInlineFaucetHandler is invoked when a collector answers the captcha of a faucet
+func (bot *TipBot) captchaInlineFaucetHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	data := strings.Split(c.Data, "|")
+	if len(data) != 2 {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	answer, err := strconv.Atoi(data[1])
+	if err != nil {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	solved, err := bot.answerFaucetCaptcha(ctx, data[0], c.Sender.ID, answer)
+	if err != nil {
+		return ctx, err
+	}
+	if !solved {
+		ctx.Context = context.WithValue(ctx, "callback_response", faucetCaptchaWrongMessage)
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	// collect like with the collect button
+	c.Data = data[0]
+	err = once.Once(c.Data, strconv.FormatInt(c.Sender.ID, 10))
+	if err != nil {
+		return ctx, err
+	}
+	return bot.acceptInlineFaucetHandler(ctx)
+}
+
+// answerFaucetCaptcha records the answer of a user. Every user has one try.
+func (bot *TipBot) answerFaucetCaptcha(ctx context.Context, id string, userID int64, answer int) (bool, error) {
+	tx := &InlineFaucet{Base: storage.New(storage.ID(id))}
+	mutex.LockWithContext(ctx, tx.ID)
+	defer mutex.UnlockWithContext(ctx, tx.ID)
+	fn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return false, err
+	}
+	inlineFaucet := fn.(*InlineFaucet)
+	if !inlineFaucet.Active || containsUserID(inlineFaucet.CaptchaFailed, userID) {
+		return false, nil
+	}
+	if containsUserID(inlineFaucet.CaptchaSolved, userID) {
+		return true, nil
+	}
+	if answer != inlineFaucet.CaptchaAnswer {
+		log.Infof("[faucet] user %d failed the captcha of faucet %s", userID, inlineFaucet.ID)
+		inlineFaucet.CaptchaFailed = append(inlineFaucet.CaptchaFailed, userID)
+		return false, inlineFaucet.Set(inlineFaucet, bot.Bunt)
+	}
+	inlineFaucet.CaptchaSolved = append(inlineFaucet.CaptchaSolved, userID)
+	return true, inlineFaucet.Set(inlineFaucet, bot.Bunt)
+}
+
+// loadExpiringFaucets returns all active faucets with an expiry time
+func (bot *TipBot) loadExpiringFaucets() []*InlineFaucet {
+	var faucets []*InlineFaucet
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend(FaucetIndex, func(key, value string) bool {
+			f := &InlineFaucet{}
+			err := json.Unmarshal([]byte(value), f)
+			if err != nil || f.Base == nil || !f.Active || f.Expires.IsZero() || f.From == nil {
+				return true
+			}
+			faucets = append(faucets, f)
+			return true // continue iteration
+		})
+	})
+	return faucets
+}
+
+// startFaucetWatcher closes faucets after their expiry time
+func (bot *TipBot) startFaucetWatcher() {
+	runtime.Watch(faucetTickerDuration, bot.loadExpiringFaucets, func(f *InlineFaucet) {
+		if !f.Expires.After(time.Now()) {
+			bot.expireFaucet(f.ID)
+		}
+	})
+}
+
+// expireFaucet closes the faucet. Faucets pay out of the creator's wallet,
+// so the remaining amount is refunded by not paying it out anymore.
+func (bot *TipBot) expireFaucet(id string) {
+	tx := &InlineFaucet{Base: storage.New(storage.ID(id))}
+	mutex.Lock(tx.ID)
+	defer mutex.Unlock(tx.ID)
+	fn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		log.Errorf("[faucet] could not load %s: %v", id, err)
+		return
+	}
+	inlineFaucet := fn.(*InlineFaucet)
+	if !inlineFaucet.Active {
+		return
+	}
+	inlineFaucet.Active = false
+	runtime.IgnoreError(inlineFaucet.Set(inlineFaucet, bot.Bunt))
+	once.Remove(inlineFaucet.ID)
+	log.Infof("[faucet] Faucet %s expired. Remaining: %d sat", inlineFaucet.ID, inlineFaucet.RemainingAmount)
+
+	if len(inlineFaucet.Editable.MessageID) > 0 {
+		bot.tryEditStack(inlineFaucet.Editable, inlineFaucet.ID, fmt.Sprintf(faucetExpiredMessage, inlineFaucet.Amount-inlineFaucet.RemainingAmount, inlineFaucet.NTaken), &tb.ReplyMarkup{})
+	}
+	bot.trySendMessage(inlineFaucet.From.Telegram, fmt.Sprintf(faucetExpiredCreatorMessage, inlineFaucet.RemainingAmount))
+	bot.trySendMessage(inlineFaucet.From.Telegram, listFaucetTakers(inlineFaucet))
+}
@@ -0,0 +1,82 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/price"
+)
+
+var (
+	fiatInvoiceCaptionMessage = "`%s`\n\n💱 %s = %d sat\n🔒 Rate %s/BTC locked until %s UTC."
+	fiatInvoicePriceMessage   = "🚫 There is no current %s price. Please try again later or use sat."
+)
+
+const defaultFiatRateLock = 10 * time.Minute
+
+// FiatQuote is a fiat amount converted to satoshis at a rate that is locked until the invoice expires
+type FiatQuote struct {
+	Amount      float64   `json:"amount"`
+	Currency    string    `json:"currency"`
+	Rate        float64   `json:"rate"`
+	Sats        int64     `json:"sats"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// fiatRateLock returns how long the rate of fiat invoices is locked
+func fiatRateLock() time.Duration {
+	if internal.Configuration.Price.RateLock > 0 {
+		return time.Duration(internal.Configuration.Price.RateLock) * time.Second
+	}
+	return defaultFiatRateLock
+}
+
+// NewFiatQuote converts a fiat amount at the current rate. It fails if the price is stale.
+func NewFiatQuote(amount float64, currency string) (*FiatQuote, error) {
+	currency = strings.ToUpper(currency)
+	if !(amount > 0) {
+		return nil, fmt.Errorf("amount must be greater than 0")
+	}
+	rate, err := price.Get(currency)
+	if err != nil {
+		return nil, err
+	}
+	sats := int64(amount / rate * 100_000_000)
+	if sats < 1 {
+		return nil, fmt.Errorf("amount too small")
+	}
+	return &FiatQuote{
+		Amount:      amount,
+		Currency:    currency,
+		Rate:        rate,
+		Sats:        sats,
+		LockedUntil: time.Now().Add(fiatRateLock()),
+	}, nil
+}
+
+// Expiry returns the seconds until the rate lock ends, which is used as the invoice expiry
+func (q *FiatQuote) Expiry() int64 {
+	return int64(time.Until(q.LockedUntil).Seconds())
+}
+
+func (q *FiatQuote) caption(paymentRequest string, languageCode string) string {
+	return fmt.Sprintf(fiatInvoiceCaptionMessage, paymentRequest, FormatFiat(q.Amount, q.Currency, languageCode), q.Sats,
+		FormatFiat(q.Rate, q.Currency, languageCode), q.LockedUntil.UTC().Format("15:04"))
+}
+
+// fiatQuoteFromCommand returns a quote if the amount of the command is in fiat, like /invoice 5€
+func fiatQuoteFromCommand(text string) (*FiatQuote, string, error) {
+	argument, err := getArgumentFromCommand(text, 1)
+	if err != nil {
+		return nil, "", nil
+	}
+	fiat, currency, ok, err := ParseFiatAmount(argument)
+	if !ok || err != nil {
+		// the amount is asked for again
+		return nil, "", nil
+	}
+	quote, err := NewFiatQuote(fiat, currency)
+	return quote, currency, err
+}
@@ -142,6 +142,9 @@ func (bot TipBot) groupHandler(ctx intercept.Context) (intercept.Context, error)
 		if splits[1] == "ticket" {
 			return bot.handleJoinTicketPayWall(ctx)
 		}
+		if splits[1] == "treasury" {
+			return bot.treasuryHandler(ctx)
+		}
 		if splits[1] == "remove" {
 			// todo -- implement this
 			// return bot.addGroupHandler(ctx, m)
@@ -299,7 +302,7 @@ func (bot *TipBot) groupConfirmPayButtonHandler(ctx intercept.Context) (intercep
 
 	log.Infof("[/pay] Attempting %s's invoice %s (%d sat)", GetUserStr(user.Telegram), ticketEvent.ID, ticketEvent.Group.Ticket.Price)
 	// // pay invoice
-	_, err = user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: ticketEvent.Invoice.PaymentRequest}, bot.Client)
+	_, err = bot.PayInvoice(user, ticketEvent.Invoice.PaymentRequest, "group ticket", "")
 	if err != nil {
 		errmsg := fmt.Sprintf("[/pay] Could not pay invoice of %s: %s", GetUserStr(user.Telegram), err)
 		err = fmt.Errorf(i18n.Translate(ticketEvent.LanguageCode, "invoiceUndefinedErrorMessage"))
@@ -116,7 +116,7 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 		// 	},
 		// },
 		{
-			Endpoints: []interface{}{"/tip", "/t", "/honk", "/zap"},
+			Endpoints: []interface{}{"/tip", "/t", "/honk"},
 			Handler:   bot.tipHandler,
 			Interceptor: &Interceptor{
 				Before: []intercept.Func{
@@ -131,6 +131,22 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/zap"},
+			Handler:   bot.zapHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.loadReplyToInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/pay"},
 			Handler:   bot.payHandler,
@@ -197,8 +213,8 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{"/confirmcard"},
-			Handler:   bot.confirmcardHandler,
+			Endpoints: []interface{}{"/card"},
+			Handler:   bot.cardHandler,
 			Interceptor: &Interceptor{
 				Before: []intercept.Func{
 					bot.requirePrivateChatInterceptor,
@@ -277,6 +293,78 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/sell"},
+			Handler:   bot.sellHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/swapout"},
+			Handler:   bot.swapOutHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/swapin"},
+			Handler:   bot.swapInHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/orders"},
+			Handler:   bot.ordersHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/order"},
+			Handler:   bot.orderHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/invoice", &btnInvoiceMainMenu},
 			Handler:   bot.invoiceHandler,
@@ -294,6 +382,22 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/contacts"},
+			Handler:   bot.contactsHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/set"},
 			Handler:   bot.settingHandler,
@@ -310,6 +414,22 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/schedule"},
+			Handler:   bot.scheduleHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/nostr"},
 			Handler:   bot.nostrHandler,
@@ -486,6 +606,17 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 					bot.requireUserInterceptor,
 				}},
 		},
+		{
+			Endpoints: []interface{}{"/export"},
+			Handler:   bot.exportHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+				}},
+		},
 		{
 			Endpoints: []interface{}{&btnLeftTransactionsButton},
 			Handler:   bot.transactionsScrollLeftHandler,
@@ -691,6 +822,160 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/split"},
+			Handler:   bot.splitHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/escrow"},
+			Handler:   bot.escrowHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/top"},
+			Handler:   bot.topHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.loadUserInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/price"},
+			Handler:   bot.priceHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.tryLoadUserInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/alert", "/alerts"},
+			Handler:   bot.alertHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/raffle"},
+			Handler:   bot.raffleHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnBuyRaffleTicket},
+			Handler:   bot.buyRaffleTicketHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelRaffle},
+			Handler:   bot.cancelRaffleHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/request"},
+			Handler:   bot.paymentRequestHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnPayPaymentRequest},
+			Handler:   bot.confirmPaymentRequestHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnDeclinePaymentRequest},
+			Handler:   bot.declinePaymentRequestHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/join"},
 			Handler:   bot.groupRequestJoinHandler,
@@ -724,6 +1009,79 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{&btnTreasuryApprove},
+			Handler:   bot.approveTreasurySpendHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnTreasuryReject},
+			Handler:   bot.rejectTreasurySpendHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnEscrowRelease},
+			Handler:   bot.releaseEscrowHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnEscrowRefund},
+			Handler:   bot.refundEscrowHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnEscrowDispute},
+			Handler:   bot.disputeEscrowHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{tb.OnPhoto},
 			Handler:   bot.photoHandler,
@@ -759,8 +1117,8 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				Before: []intercept.Func{
 					bot.requirePrivateChatInterceptor, // Respond to any text only in private chat
 					bot.localizerInterceptor,
-					bot.logMessageInterceptor,
 					bot.loadUserInterceptor, // need to use loadUserInterceptor instead of requireUserInterceptor, because user might not be registered yet
+					bot.logMessageInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -850,6 +1208,66 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{&btnSell},
+			Handler:   bot.confirmSellHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelSell},
+			Handler:   bot.cancelSellHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnSwapOut},
+			Handler:   bot.confirmSwapOutHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelSwapOut},
+			Handler:   bot.cancelSwapOutHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{&btnAcceptInlineSend},
 			Handler:   bot.acceptInlineSendHandler,
@@ -898,6 +1316,22 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{&btnCancelSplit},
+			Handler:   bot.cancelSplitHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{&btnCancelInlineReceive},
 			Handler:   bot.cancelInlineReceiveHandler,
@@ -932,6 +1366,23 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{&btnCaptchaInlineFaucet},
+			Handler:   bot.captchaInlineFaucetHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{&btnCancelInlineFaucet},
 			Handler:   bot.cancelInlineFaucetHandler,
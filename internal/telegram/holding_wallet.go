@@ -0,0 +1,47 @@
+package telegram
+
+import (
+	"fmt"
+
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// Holding wallets keep funds until they are paid out. Every feature has its own wallet
+// so that a payout of one feature can never spend the funds held by another.
+const (
+	escrowWalletUserID    int64 = -1
+	raffleWalletUserID    int64 = -2
+	crowdfundWalletUserID int64 = -3
+)
+
+var holdingWalletNames = map[int64]string{
+	escrowWalletUserID:    "Escrow",
+	raffleWalletUserID:    "Raffle",
+	crowdfundWalletUserID: "Crowdfunding",
+}
+
+// loadHoldingWallet returns the holding wallet with the given user ID and creates it if necessary
+func (bot *TipBot) loadHoldingWallet(id int64) (*lnbits.User, error) {
+	name, ok := holdingWalletNames[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown holding wallet %d", id)
+	}
+	lockKey := fmt.Sprintf("holding-wallet-%d", id)
+	mutex.Lock(lockKey)
+	defer mutex.Unlock(lockKey)
+	tbUser := &tb.User{ID: id, FirstName: name}
+	if user, exists := bot.UserExists(tbUser); exists && user.Wallet != nil {
+		return user, nil
+	}
+	return bot.CreateWalletForTelegramUser(tbUser)
+}
+
+// holdingWalletUserID returns id or the escrow wallet that held the funds of all features before
+func holdingWalletUserID(id int64) int64 {
+	if id == 0 {
+		return escrowWalletUserID
+	}
+	return id
+}
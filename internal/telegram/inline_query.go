@@ -110,6 +110,13 @@ func (bot TipBot) anyChosenInlineHandler(ctx intercept.Context) (intercept.Conte
 		log.Errorf("[anyChosenInlineHandler] could not find inline object in cache. %v", err.Error())
 		return ctx, err
 	}
+	// remember the inline message of faucets and tipjars for edits from the watchers
+	switch inlineObject := inlineObject.(type) {
+	case *InlineFaucet:
+		inlineObject.Editable = tb.StoredMessage{MessageID: ctx.InlineResult().MessageID}
+	case *InlineTipjar:
+		inlineObject.Editable = tb.StoredMessage{MessageID: ctx.InlineResult().MessageID}
+	}
 	switch inlineObject.(type) {
 	case storage.Storable:
 		// persist inline object in bunt
@@ -40,6 +40,7 @@ type InlineReceive struct {
 	From_SpecificUser bool         `json:"from_specific_user"`
 	Memo              string       `json:"inline_receive_memo"`
 	LanguageCode      string       `json:"languagecode"`
+	SplitID           string       `json:"split_id,omitempty"`
 }
 
 func (bot TipBot) makeReceiveKeyboard(ctx context.Context, id string) *tb.ReplyMarkup {
@@ -198,7 +199,10 @@ func (bot *TipBot) acceptInlineReceiveHandler(ctx intercept.Context) (intercept.
 
 	if from.Wallet == nil || balance < inlineReceive.Amount {
 		// if user has no wallet, show invoice
-		bot.tryEditMessage(inlineReceive.Message, inlineReceive.MessageText, &tb.ReplyMarkup{})
+		// the message of a split stays open for the other participants
+		if len(inlineReceive.SplitID) == 0 {
+			bot.tryEditMessage(inlineReceive.Message, inlineReceive.MessageText, &tb.ReplyMarkup{})
+		}
 		// runtime.IgnoreError(inlineReceive.Set(inlineReceive, bot.Bunt))
 		bot.inlineReceiveInvoice(ctx, inlineReceive)
 		return ctx, errors.Create(errors.BalanceToLowError)
@@ -293,7 +297,9 @@ func (bot *TipBot) inlineReceiveInvoice(ctx intercept.Context, inlineReceive *In
 
 	// send the invoice data to user
 	msg := bot.trySendMessage(ctx.Callback().Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: fmt.Sprintf("`%s`", invoice.PaymentRequest)})
-	bot.tryEditMessage(inlineReceive.Message, fmt.Sprintf("%s\n\nPay this invoice:\n```%s```", inlineReceive.MessageText, invoice.PaymentRequest))
+	if len(inlineReceive.SplitID) == 0 {
+		bot.tryEditMessage(inlineReceive.Message, fmt.Sprintf("%s\n\nPay this invoice:\n```%s```", inlineReceive.MessageText, invoice.PaymentRequest))
+	}
 	invoice.InvoiceMessage = msg
 	runtime.IgnoreError(bot.Bunt.Set(invoice))
 	log.Printf("[/invoice] Invoice created. User: %s, amount: %d sat.", GetUserStr(inlineReceive.To.Telegram), inlineReceive.Amount)
@@ -335,10 +341,16 @@ func (bot *TipBot) finishInlineReceiveHandler(ctx context.Context, c *tb.Callbac
 		inlineReceive.MessageText += "\n\n" + fmt.Sprintf(i18n.Translate(inlineReceive.LanguageCode, "inlineSendCreateWalletMessage"), GetUserStrMd(bot.Telegram.Me))
 	}
 
-	bot.tryEditMessage(inlineReceive.Message, inlineReceive.MessageText, &tb.ReplyMarkup{})
+	if len(inlineReceive.SplitID) > 0 {
+		bot.splitSharePaid(inlineReceive)
+	} else {
+		bot.tryEditMessage(inlineReceive.Message, inlineReceive.MessageText, &tb.ReplyMarkup{})
+	}
 	// notify users
-	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), fromUserStrMd, inlineReceive.Amount))
-	bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "sendSentMessage"), inlineReceive.Amount, toUserStrMd))
+	sendReceivedMessage := fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), fromUserStrMd, inlineReceive.Amount) + bot.fiatValueText(to, inlineReceive.Amount, time.Now())
+	bot.trySendMessage(to.Telegram, sendReceivedMessage)
+	bot.sendNostrDirectMessage(to.Telegram, sendReceivedMessage)
+	bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "sendSentMessage"), inlineReceive.Amount, toUserStrMd)+bot.fiatValueText(from, inlineReceive.Amount, time.Now()))
 	if err != nil {
 		errmsg := fmt.Errorf("[acceptInlineReceiveHandler] Error: Receive message to %s: %s", toUserStr, err)
 		log.Warnln(errmsg)
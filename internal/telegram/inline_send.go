@@ -37,6 +37,7 @@ type InlineSend struct {
 	To_SpecificUser bool         `json:"to_specific_user"`
 	Memo            string       `json:"inline_send_memo"`
 	LanguageCode    string       `json:"languagecode"`
+	Contact         string       `json:"inline_send_contact,omitempty"`
 }
 
 func (bot TipBot) makeSendKeyboard(ctx context.Context, id string) *tb.ReplyMarkup {
@@ -89,8 +90,14 @@ func (bot TipBot) handleInlineSendQuery(ctx intercept.Context) (intercept.Contex
 	memo_argn := 2 // argument index at which the memo starts, will be 3 if there is a to_username in command
 	toUserDb := &lnbits.User{}
 	to_SpecificUser := false
+	contactName := ""
 	if len(strings.Split(q.Text, " ")) > 2 {
 		to_username := strings.Split(q.Text, " ")[2]
+		// a saved contact can be used instead of the username
+		if contact, err := bot.loadContact(fromUser, to_username); err == nil && contact.isTelegramUser() {
+			to_username = contact.Address
+			contactName = contact.Name
+		}
 		if strings.HasPrefix(to_username, "@") {
 			toUserDb, err = GetUserByTelegramUsername(to_username[1:], bot) // must be without the @
 			if err != nil {
@@ -109,16 +116,21 @@ func (bot TipBot) handleInlineSendQuery(ctx intercept.Context) (intercept.Contex
 
 	// check for memo in command
 	memo := GetMemoFromCommand(q.Text, memo_argn)
-	urls := []string{
-		queryImage,
+	recipients := []*lnbits.User{toUserDb}
+	// suggest recently paid contacts if no user was given
+	if !to_SpecificUser {
+		recipients = append(recipients, bot.recentContactUsers(fromUser, inlineContactSuggestions)...)
 	}
-	results := make(tb.Results, len(urls)) // []tb.Result
-	for i, url := range urls {
+	results := make(tb.Results, len(recipients)) // []tb.Result
+	for i, toUserDb := range recipients {
+		to_SpecificUser := to_SpecificUser || i > 0
 		inlineMessage := fmt.Sprintf(Translate(ctx, "inlineSendMessage"), fromUserStr, amount)
+		title := fmt.Sprintf(TranslateUser(ctx, "inlineResultSendTitle"), amount)
 
 		// modify message if payment is to specific user
 		if to_SpecificUser {
 			inlineMessage = fmt.Sprintf("@%s: %s", toUserDb.Telegram.Username, inlineMessage)
+			title = fmt.Sprintf("%s → @%s", title, toUserDb.Telegram.Username)
 		}
 
 		if len(memo) > 0 {
@@ -127,10 +139,10 @@ func (bot TipBot) handleInlineSendQuery(ctx intercept.Context) (intercept.Contex
 		result := &tb.ArticleResult{
 			// URL:         url,
 			Text:        inlineMessage,
-			Title:       fmt.Sprintf(TranslateUser(ctx, "inlineResultSendTitle"), amount),
+			Title:       title,
 			Description: fmt.Sprintf(TranslateUser(ctx, "inlineResultSendDescription"), amount),
 			// required for photos
-			ThumbURL: url,
+			ThumbURL: queryImage,
 		}
 		id := fmt.Sprintf("inl-send-%d-%d-%s", q.Sender.ID, amount, RandStringRunes(5))
 		result.ReplyMarkup = &tb.ReplyMarkup{InlineKeyboard: bot.makeSendKeyboard(ctx, id).InlineKeyboard}
@@ -148,6 +160,7 @@ func (bot TipBot) handleInlineSendQuery(ctx intercept.Context) (intercept.Contex
 			Memo:            memo,
 			Amount:          amount,
 			LanguageCode:    ctx.Value("publicLanguageCode").(string),
+			Contact:         contactName,
 		}
 
 		// add result to persistent struct
@@ -206,6 +219,11 @@ func (bot *TipBot) acceptInlineSendHandler(ctx intercept.Context) (intercept.Con
 		return ctx, errors.Create(errors.UnknownError)
 	}
 
+	err = bot.precheckSpendingLimits(fromUser, amount, "inline send")
+	if err != nil {
+		return ctx, err
+	}
+
 	toUserStrMd := GetUserStrMd(to.Telegram)
 	fromUserStrMd := GetUserStrMd(fromUser.Telegram)
 	toUserStr := GetUserStr(to.Telegram)
@@ -238,6 +256,7 @@ func (bot *TipBot) acceptInlineSendHandler(ctx intercept.Context) (intercept.Con
 	}
 
 	log.Infof("[💸 sendInline] Send from %s to %s (%d sat).", fromUserStr, toUserStr, amount)
+	bot.touchContact(fromUser, inlineSend.Contact)
 
 	inlineSend.Message = fmt.Sprintf("%s", fmt.Sprintf(i18n.Translate(inlineSend.LanguageCode, "inlineSendUpdateMessageAccept"), amount, fromUserStrMd, toUserStrMd))
 	memo := inlineSend.Memo
@@ -249,8 +268,10 @@ func (bot *TipBot) acceptInlineSendHandler(ctx intercept.Context) (intercept.Con
 	}
 	bot.tryEditMessage(c, inlineSend.Message, &tb.ReplyMarkup{})
 	// notify users
-	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), fromUserStrMd, amount))
-	bot.trySendMessage(fromUser.Telegram, fmt.Sprintf(i18n.Translate(fromUser.Telegram.LanguageCode, "sendSentMessage"), amount, toUserStrMd))
+	sendReceivedMessage := fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), fromUserStrMd, amount) + bot.fiatValueText(to, amount, time.Now())
+	bot.trySendMessage(to.Telegram, sendReceivedMessage)
+	bot.sendNostrDirectMessage(to.Telegram, sendReceivedMessage)
+	bot.trySendMessage(fromUser.Telegram, fmt.Sprintf(i18n.Translate(fromUser.Telegram.LanguageCode, "sendSentMessage"), amount, toUserStrMd)+bot.fiatValueText(fromUser, amount, time.Now()))
 	if err != nil {
 		errmsg := fmt.Errorf("[sendInline] Error: Send message to %s: %s", toUserStr, err)
 		log.Warnln(errmsg)
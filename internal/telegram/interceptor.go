@@ -198,12 +198,18 @@ func (bot TipBot) requirePrivateChatInterceptor(ctx intercept.Context) (intercep
 }
 
 const photoTag = "<Photo>"
+const redactedTag = "<Redacted>"
 
 func (bot TipBot) logMessageInterceptor(ctx intercept.Context) (intercept.Context, error) {
 	if ctx.Message() != nil {
 
 		if ctx.Message().Text != "" {
-			log_string := fmt.Sprintf("[%s:%d %s:%d] %s", ctx.Message().Chat.Title, ctx.Message().Chat.ID, GetUserStr(ctx.Message().Sender), ctx.Message().Sender.ID, ctx.Message().Text)
+			text := ctx.Message().Text
+			// don't log PINs and TOTP codes
+			if user := LoadUser(ctx); isSpendingSettingCommand(text) || (user != nil && user.StateKey == lnbits.UserEnterSpendingConfirmation) {
+				text = redactedTag
+			}
+			log_string := fmt.Sprintf("[%s:%d %s:%d] %s", ctx.Message().Chat.Title, ctx.Message().Chat.ID, GetUserStr(ctx.Message().Sender), ctx.Message().Sender.ID, text)
 			if ctx.Message().IsReply() {
 				log_string = fmt.Sprintf("%s -> %s", log_string, GetUserStr(ctx.Message().ReplyTo.Sender))
 			}
@@ -91,6 +91,7 @@ type InvoiceEvent struct {
 	Chat           *tb.Chat     `json:"chat,omitempty"`            // if invoice is supposed to be sent to a particular chat
 	Payer          *lnbits.User `json:"payer,omitempty"`           // if a particular user is supposed to pay this
 	UserCurrency   string       `json:"usercurrency,omitempty"`    // the currency a user selected
+	Fiat           *FiatQuote   `json:"fiat,omitempty"`            // the fiat amount and locked rate of a fiat invoice
 }
 
 func (invoiceEvent InvoiceEvent) Type() EventType {
@@ -139,13 +140,25 @@ func (bot *TipBot) invoiceHandler(ctx intercept.Context) (intercept.Context, err
 		return ctx, errors.Create(errors.NoPrivateChatError)
 	}
 
-	// if no amount is in the command, ask for it
-	amount, err := decodeAmountFromCommand(m.Text)
-	if (err != nil || amount < 1) && m.Chat.Type == tb.ChatPrivate {
-		// // no amount was entered, set user state and ask fo""r amount
-		_, err = bot.askForAmount(ctx, "", "CreateInvoiceState", 0, 0, m.Text)
+	// fiat amounts are converted at a locked rate
+	quote, currency, err := fiatQuoteFromCommand(m.Text)
+	if err != nil {
+		log.Warnf("[/invoice] Could not convert %s amount: %v", currency, err)
+		bot.trySendMessage(m.Sender, fmt.Sprintf(fiatInvoicePriceMessage, currency))
 		return ctx, err
 	}
+	var amount int64
+	if quote != nil {
+		amount = quote.Sats
+	} else {
+		// if no amount is in the command, ask for it
+		amount, err = decodeAmountFromCommand(m.Text)
+		if (err != nil || amount < 1) && m.Chat.Type == tb.ChatPrivate {
+			// // no amount was entered, set user state and ask fo""r amount
+			_, err = bot.askForAmount(ctx, "", "CreateInvoiceState", 0, 0, m.Text)
+			return ctx, err
+		}
+	}
 
 	// check for memo in command
 	memo := fmt.Sprintf("Powered by %s %s", internal.Configuration.Bot.Name, internal.Configuration.Bot.Username)
@@ -162,12 +175,16 @@ func (bot *TipBot) invoiceHandler(ctx intercept.Context) (intercept.Context, err
 	creatingMsg := bot.trySendMessageEditable(m.Sender, Translate(ctx, "lnurlGettingUserMessage"))
 	log.Debugf("[/invoice] Creating invoice for %s of %d sat.", userStr, amount)
 
-	currency := user.Settings.Display.DisplayCurrency
+	currency = user.Settings.Display.DisplayCurrency
 	if currency == "" {
 		currency = "BTC"
 	}
 
-	invoice, err := bot.createInvoiceWithEvent(ctx, user, amount, memo, currency, InvoiceCallbackGeneric, "")
+	params := lnbits.InvoiceParams{Amount: amount, Memo: memo}
+	if quote != nil {
+		params.Expiry = quote.Expiry()
+	}
+	invoice, err := bot.createInvoiceEvent(user, params, ctx.Value("publicLanguageCode").(string), currency, InvoiceCallbackGeneric, "", quote)
 	if err != nil {
 		errmsg := fmt.Sprintf("[/invoice] Could not create an invoice: %s", err.Error())
 		bot.tryEditMessage(creatingMsg, Translate(ctx, "errorTryLaterMessage"))
@@ -188,19 +205,31 @@ func (bot *TipBot) invoiceHandler(ctx intercept.Context) (intercept.Context, err
 	//bot.tryDeleteMessage(creatingMsg)
 
 	// send the invoice data to user
-	bot.trySendMessage(m.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: fmt.Sprintf("`%s`", invoice.PaymentRequest)})
+	caption := fmt.Sprintf("`%s`", invoice.PaymentRequest) + bot.fiatValueText(user, amount, time.Now())
+	if quote != nil {
+		caption = quote.caption(invoice.PaymentRequest, m.Sender.LanguageCode)
+	}
+	bot.trySendMessage(m.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: caption})
 	log.Printf("[/invoice] Invoice created. User: %s, amount: %d sat.", userStr, amount)
 	return ctx, nil
 }
 
 func (bot *TipBot) createInvoiceWithEvent(ctx context.Context, user *lnbits.User, amount int64, memo string, currency string, callback int, callbackData string) (InvoiceEvent, error) {
-	invoice, err := user.Wallet.Invoice(
-		lnbits.InvoiceParams{
-			Out:     false,
-			Amount:  int64(amount),
-			Memo:    memo,
-			Webhook: internal.Configuration.Lnbits.WebhookCall},
-		bot.Client)
+	return bot.createInvoiceEvent(user, lnbits.InvoiceParams{Amount: amount, Memo: memo}, ctx.Value("publicLanguageCode").(string), currency, callback, callbackData, nil)
+}
+
+// CreateFiatInvoice creates an invoice for the quote that expires when the rate lock ends
+func (bot *TipBot) CreateFiatInvoice(user *lnbits.User, params lnbits.InvoiceParams, quote *FiatQuote) (InvoiceEvent, error) {
+	params.Amount = quote.Sats
+	params.Expiry = quote.Expiry()
+	return bot.createInvoiceEvent(user, params, user.Telegram.LanguageCode, quote.Currency, InvoiceCallbackGeneric, "", quote)
+}
+
+// createInvoiceEvent creates the invoice and stores the event that is triggered when it is paid
+func (bot *TipBot) createInvoiceEvent(user *lnbits.User, params lnbits.InvoiceParams, languageCode string, currency string, callback int, callbackData string, quote *FiatQuote) (InvoiceEvent, error) {
+	params.Out = false
+	params.Webhook = internal.Configuration.Lnbits.WebhookCall
+	invoice, err := user.Wallet.Invoice(params, bot.Client)
 	if err != nil {
 		errmsg := fmt.Sprintf("[/invoice] Could not create an invoice: %s", err.Error())
 		log.Errorln(errmsg)
@@ -209,13 +238,14 @@ func (bot *TipBot) createInvoiceWithEvent(ctx context.Context, user *lnbits.User
 	invoiceEvent := InvoiceEvent{
 		Invoice: &Invoice{PaymentHash: invoice.PaymentHash,
 			PaymentRequest: invoice.PaymentRequest,
-			Amount:         amount,
-			Memo:           memo},
+			Amount:         params.Amount,
+			Memo:           params.Memo},
 		User:         user,
 		Callback:     callback,
 		CallbackData: callbackData,
-		LanguageCode: ctx.Value("publicLanguageCode").(string),
+		LanguageCode: languageCode,
 		UserCurrency: currency,
+		Fiat:         quote,
 	}
 	// save invoice struct for later use
 	runtime.IgnoreError(bot.Bunt.Set(invoiceEvent))
@@ -231,18 +261,21 @@ func (bot *TipBot) notifyInvoiceReceivedEvent(event Event) {
 		log.Errorln(errmsg)
 	}
 
-	if invoiceEvent.UserCurrency == "" || strings.ToLower(invoiceEvent.UserCurrency) == "btc" {
-		bot.trySendMessage(invoiceEvent.User.Telegram, fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedMessage"), invoiceEvent.Amount))
-	} else {
+	message := fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedMessage"), invoiceEvent.Amount)
+	if invoiceEvent.Fiat != nil {
+		// fiat invoices show the amount at the locked rate
+		message = fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedCurrencyMessage"), invoiceEvent.Amount, invoiceEvent.Fiat.Amount, invoiceEvent.Fiat.Currency)
+	} else if invoiceEvent.UserCurrency != "" && strings.ToLower(invoiceEvent.UserCurrency) != "btc" {
 		fiatAmount, err := SatoshisToFiat(invoiceEvent.Amount, strings.ToUpper(invoiceEvent.UserCurrency))
 		if err != nil {
-			log.Errorln(err)
 			// fallback to satoshis
-			bot.trySendMessage(invoiceEvent.User.Telegram, fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedMessage"), invoiceEvent.Amount))
-			return
+			log.Errorln(err)
+		} else {
+			message = fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedCurrencyMessage"), invoiceEvent.Amount, fiatAmount, strings.ToUpper(invoiceEvent.UserCurrency))
 		}
-		bot.trySendMessage(invoiceEvent.User.Telegram, fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedCurrencyMessage"), invoiceEvent.Amount, fiatAmount, strings.ToUpper(invoiceEvent.UserCurrency)))
 	}
+	bot.trySendMessage(invoiceEvent.User.Telegram, message)
+	bot.sendNostrDirectMessage(invoiceEvent.User.Telegram, message)
 }
 
 type LNURLInvoice struct {
@@ -299,6 +332,7 @@ func (bot *TipBot) lnurlReceiveEvent(event Event) {
 		if len(tx.Nip57Receipt.Sig) > 0 {
 			// zapEventSerialized, _ := json.Marshal(tx.Nip57Receipt)
 			bot.trySendMessage(tx.User.Telegram, "💜 This was a zap on nostr.")
+			bot.sendNostrDirectMessage(tx.User.Telegram, "💜 This was a zap on nostr.")
 			go bot.publishNostrEvent(tx.Nip57Receipt, tx.Nip57ReceiptRelays)
 		}
 	}
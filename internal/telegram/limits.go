@@ -0,0 +1,433 @@
+package telegram
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eko/gocache/store"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	"github.com/massmux/SatsMobiBot/internal/totp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	ErrSpendingLimitExceeded        = fmt.Errorf("spending limit exceeded")
+	ErrSpendingConfirmationRequired = fmt.Errorf("spending confirmation required")
+	ErrSpendingConfirmationInvalid  = fmt.Errorf("invalid spending confirmation")
+	ErrSpendingConfirmationLocked   = fmt.Errorf("too many invalid spending confirmations")
+)
+
+var (
+	spendingLimitExceededMessage    = "🚫 This payment exceeds your spending limits. See `/set limits`."
+	spendingConfirmationMessage     = "🔐 Payments above %d sat need confirmation. Please enter your PIN or TOTP code."
+	spendingConfirmedMessage        = "✅ Confirmed. Please press the button again within two minutes."
+	spendingConfirmationInvalidMsg  = "🚫 Invalid PIN or TOTP code."
+	spendingConfirmationLockedMsg   = "🚫 Too many invalid codes. Please try again in %s."
+	spendingCodeRequiredMessage     = "🔐 Please append your current PIN or TOTP code to the command."
+	spendingConfirmNeedsCodeMessage = "🚫 Set a PIN or TOTP first: `/set pin <digits>` or `/set totp`."
+	spendingInvalidPinMessage       = "🚫 The PIN must have 4 to 12 digits."
+	spendingTotpSetupMessage        = "🔐 Add this secret to your authenticator app:\n\n`%s`\n\n%s\n\nThen activate it with `/set totp <code>`."
+	spendingTotpActiveMessage       = "🔐 TOTP is active. Disable it with `/set totp off <code>`."
+	spendingLimitsUpdatedMessage    = "✅ Your spending limits have been updated."
+	spendingLimitsMessage           = "🔐 *Spending limits*\n\nPer transaction: %s\nDaily: %s\nConfirm above: %s\nPIN: %s\nTOTP: %s"
+	spendingAutomaticConfirmMessage = "🚫 Payments above %d sat need your PIN or TOTP code, which can't be entered for automatic payments. Lower the amount or change `/set confirm`."
+	spendingLimitsHelpMessage       = "📖 Spending limits\n\n`/set limits` Show your limits.\n`/set limit <daily|tx> <amount|off>` Set a daily or per transaction limit.\n`/set confirm <amount|off>` Ask for your PIN or TOTP code above this amount.\n`/set pin <digits|off>` Set your PIN.\n`/set totp [code|off]` Set up TOTP.\n\nIf a PIN or TOTP is set, append your current code to every change."
+)
+
+const (
+	spendingConfirmationExpiry = 2 * time.Minute
+	spendingLimitsDailyWindow  = 24 * time.Hour
+	// after spendingMaxFailedAttempts invalid codes, no code is checked for spendingLockDuration
+	spendingMaxFailedAttempts = 5
+	spendingLockDuration      = 15 * time.Minute
+)
+
+var (
+	spendingPinPattern            = regexp.MustCompile(`^[0-9]{4,12}$`)
+	spendingSettingCommandPattern = regexp.MustCompile(`(?i)^/set(@\S+)? +(limits|limit|confirm|pin|totp)\b`)
+)
+
+// CheckSpendingLimits checks an outgoing payment of user against their per-transaction
+// and daily limits. Above the confirmation threshold, the payment needs either a valid
+// PIN or TOTP code, or a confirmation the user has given in Telegram before, which is
+// used up by the payment. Every payment runs through it in Transaction.Send and PayInvoice.
+func (bot *TipBot) CheckSpendingLimits(user *lnbits.User, amount int64, source string, confirmation string) error {
+	return bot.checkSpendingLimits(user, amount, source, confirmation, true)
+}
+
+// checkSpendingLimits implements CheckSpendingLimits. A confirmation given in Telegram
+// is only used up if consume is set.
+func (bot *TipBot) checkSpendingLimits(user *lnbits.User, amount int64, source string, confirmation string, consume bool) error {
+	u, err := GetLnbitsUserWithSettings(user.Telegram, *bot)
+	if err != nil {
+		return err
+	}
+	limits := u.Settings.Limits
+	if limits.PerTransaction > 0 && amount > limits.PerTransaction {
+		log.Warnf("[CheckSpendingLimits] %s: %s tried to spend %d sat, per transaction limit is %d sat", source, GetUserStr(user.Telegram), amount, limits.PerTransaction)
+		return ErrSpendingLimitExceeded
+	}
+	if limits.Daily > 0 {
+		spent, err := bot.spentWithin(u, spendingLimitsDailyWindow)
+		if err != nil {
+			return err
+		}
+		if spent+amount > limits.Daily {
+			log.Warnf("[CheckSpendingLimits] %s: %s tried to spend %d sat, already spent %d of %d sat today", source, GetUserStr(user.Telegram), amount, spent, limits.Daily)
+			return ErrSpendingLimitExceeded
+		}
+	}
+	if limits.ConfirmAbove == 0 || amount <= limits.ConfirmAbove || !hasSpendingConfirmation(limits) {
+		return nil
+	}
+	if len(confirmation) > 0 {
+		err = bot.verifySpendingConfirmation(u, confirmation)
+		if err != nil {
+			log.Warnf("[CheckSpendingLimits] %s: invalid confirmation by %s for %d sat", source, GetUserStr(user.Telegram), amount)
+		}
+		return err
+	}
+	if bot.spendingConfirmed(u, amount, consume) {
+		return nil
+	}
+	return ErrSpendingConfirmationRequired
+}
+
+// spentWithin sums up the outgoing payments of user within the last window. All pages
+// of the window are loaded, incoming payments must not push older spending out of it.
+// Pending payments count as well, they are returned by LNbits until they failed.
+func (bot *TipBot) spentWithin(user *lnbits.User, window time.Duration) (int64, error) {
+	if user.Wallet == nil {
+		return 0, fmt.Errorf("user %s has no wallet", GetUserStr(user.Telegram))
+	}
+	now := time.Now()
+	payments, err := bot.loadPaymentsBetween(*user.Wallet, now.Add(-window), now)
+	if err != nil {
+		return 0, err
+	}
+	var spent int64
+	for _, payment := range payments {
+		if payment.Amount < 0 {
+			spent += -payment.Amount / 1000
+		}
+	}
+	return spent, nil
+}
+
+// refuseAutomaticPayment tells the user at to and returns ErrSpendingConfirmationRequired
+// if a payment of amount by user needs a PIN or TOTP code. Payments that the bot makes
+// later without the user, like scheduled payments, can't be confirmed.
+func (bot *TipBot) refuseAutomaticPayment(user *lnbits.User, to tb.Recipient, amount int64) error {
+	u, err := GetLnbitsUserWithSettings(user.Telegram, *bot)
+	if err != nil {
+		return err
+	}
+	limits := u.Settings.Limits
+	if limits.ConfirmAbove > 0 && amount > limits.ConfirmAbove && hasSpendingConfirmation(limits) {
+		bot.trySendMessage(to, fmt.Sprintf(spendingAutomaticConfirmMessage, limits.ConfirmAbove))
+		return ErrSpendingConfirmationRequired
+	}
+	return nil
+}
+
+func hasSpendingConfirmation(limits lnbits.LimitSettings) bool {
+	return len(limits.PinHash) > 0 || limits.TotpEnabled
+}
+
+func spendingAttemptsKey(user *lnbits.User) string {
+	return fmt.Sprintf("%s_spending_attempts", user.Name)
+}
+
+// spendingLocked returns true if user has entered too many invalid codes recently
+func (bot *TipBot) spendingLocked(user *lnbits.User) bool {
+	attempts, err := bot.Cache.Get(spendingAttemptsKey(user))
+	return err == nil && attempts.(int) >= spendingMaxFailedAttempts
+}
+
+// spendingAttemptFailed counts an invalid code of user
+func (bot *TipBot) spendingAttemptFailed(user *lnbits.User) {
+	attempts := 0
+	if a, err := bot.Cache.Get(spendingAttemptsKey(user)); err == nil {
+		attempts = a.(int)
+	}
+	bot.Cache.Set(spendingAttemptsKey(user), attempts+1, &store.Options{Expiration: spendingLockDuration})
+}
+
+// verifySpendingConfirmation checks the PIN or TOTP code of user. The user must be
+// loaded with settings. A TOTP code is accepted only once, its time step is stored.
+func (bot *TipBot) verifySpendingConfirmation(user *lnbits.User, code string) error {
+	if bot.spendingLocked(user) {
+		return ErrSpendingConfirmationLocked
+	}
+	limits := &user.Settings.Limits
+	code = strings.TrimSpace(code)
+	if len(limits.PinHash) > 0 && bcrypt.CompareHashAndPassword([]byte(limits.PinHash), []byte(code)) == nil {
+		bot.Cache.Delete(spendingAttemptsKey(user))
+		return nil
+	}
+	if limits.TotpEnabled {
+		counter, ok := totp.ValidateCounter(code, limits.TotpSecret, time.Now())
+		if ok && counter > limits.TotpLastCounter {
+			limits.TotpLastCounter = counter
+			if err := UpdateUserRecord(user, *bot); err != nil {
+				return err
+			}
+			bot.Cache.Delete(spendingAttemptsKey(user))
+			return nil
+		}
+	}
+	bot.spendingAttemptFailed(user)
+	return ErrSpendingConfirmationInvalid
+}
+
+// spendingConfirmed returns true if user has confirmed a payment of amount in Telegram.
+// The confirmation is used up if consume is set.
+func (bot *TipBot) spendingConfirmed(user *lnbits.User, amount int64, consume bool) bool {
+	key := fmt.Sprintf("%s_spending_confirmation", user.Name)
+	confirmed, err := bot.Cache.Get(key)
+	if err != nil || confirmed.(int64) != amount {
+		return false
+	}
+	if consume {
+		bot.Cache.Delete(key)
+	}
+	return true
+}
+
+// precheckSpendingLimits checks a payment started in Telegram before it is made and tells
+// the user why it was refused. If a confirmation is needed, the user is asked for their code.
+// The confirmation is used up by the payment itself.
+func (bot *TipBot) precheckSpendingLimits(user *lnbits.User, amount int64, source string) error {
+	err := bot.checkSpendingLimits(user, amount, source, "", false)
+	switch err {
+	case nil:
+		return nil
+	case ErrSpendingLimitExceeded:
+		bot.trySendMessage(user.Telegram, spendingLimitExceededMessage)
+	case ErrSpendingConfirmationRequired:
+		// reload the user, it might come from a stored payment
+		u, err := GetLnbitsUser(user.Telegram, *bot)
+		if err != nil {
+			return err
+		}
+		SetUserState(u, bot, lnbits.UserEnterSpendingConfirmation, strconv.FormatInt(amount, 10))
+		bot.trySendMessage(user.Telegram, fmt.Sprintf(spendingConfirmationMessage, amount))
+	default:
+		log.Errorf("[precheckSpendingLimits] %s: %s", source, err.Error())
+		bot.trySendMessage(user.Telegram, i18n.Translate(user.Telegram.LanguageCode, "errorTryLaterMessage"))
+	}
+	return err
+}
+
+// sendSpendingConfirmationError tells the user why their code was not accepted
+func (bot *TipBot) sendSpendingConfirmationError(to *tb.User, err error) {
+	if err == ErrSpendingConfirmationLocked {
+		bot.trySendMessage(to, fmt.Sprintf(spendingConfirmationLockedMsg, spendingLockDuration))
+		return
+	}
+	bot.trySendMessage(to, spendingConfirmationInvalidMsg)
+}
+
+// enterSpendingConfirmationHandler is invoked when the user has entered their PIN or TOTP code.
+func (bot *TipBot) enterSpendingConfirmationHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	// never leave the code in the chat
+	bot.tryDeleteMessage(m)
+	if user.StateKey != lnbits.UserEnterSpendingConfirmation {
+		ResetUserState(user, bot)
+		return ctx, fmt.Errorf("wrong state key")
+	}
+	amount, err := strconv.ParseInt(user.StateData, 10, 64)
+	ResetUserState(user, bot)
+	if err != nil {
+		return ctx, err
+	}
+	u, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	err = bot.verifySpendingConfirmation(u, m.Text)
+	if err != nil {
+		log.Warnf("[enterSpendingConfirmationHandler] invalid confirmation by %s for %d sat", GetUserStr(m.Sender), amount)
+		bot.sendSpendingConfirmationError(m.Sender, err)
+		return ctx, err
+	}
+	bot.Cache.Set(fmt.Sprintf("%s_spending_confirmation", user.Name), amount, &store.Options{Expiration: spendingConfirmationExpiry})
+	bot.trySendMessage(m.Sender, spendingConfirmedMessage)
+	return ctx, nil
+}
+
+// isSpendingSettingCommand returns true for /set commands that may contain a PIN or TOTP code.
+func isSpendingSettingCommand(text string) bool {
+	return spendingSettingCommandPattern.MatchString(text)
+}
+
+func formatSpendingLimit(amount int64) string {
+	if amount == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d sat", amount)
+}
+
+func formatSpendingEnabled(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
+
+func parseSpendingLimit(input string) (int64, error) {
+	if strings.ToLower(input) == "off" {
+		return 0, nil
+	}
+	return GetAmount(input)
+}
+
+// spendingLimitSettingHandler handles /set limits, limit, confirm, pin and totp.
+func (bot *TipBot) spendingLimitSettingHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	limits := &user.Settings.Limits
+	args := strings.Fields(m.Text)[1:]
+	command := strings.ToLower(args[0])
+	args = args[1:]
+	if command == "limits" {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(spendingLimitsMessage,
+			formatSpendingLimit(limits.PerTransaction),
+			formatSpendingLimit(limits.Daily),
+			formatSpendingLimit(limits.ConfirmAbove),
+			formatSpendingEnabled(len(limits.PinHash) > 0),
+			formatSpendingEnabled(limits.TotpEnabled)))
+		return ctx, nil
+	}
+
+	// every change needs the current code once a PIN or TOTP is set
+	if hasSpendingConfirmation(*limits) {
+		bot.tryDeleteMessage(m)
+		if len(args) == 0 {
+			bot.trySendMessage(m.Sender, spendingCodeRequiredMessage)
+			return ctx, ErrSpendingConfirmationInvalid
+		}
+		if err = bot.verifySpendingConfirmation(user, args[len(args)-1]); err != nil {
+			log.Warnf("[spendingLimitSettingHandler] %s tried to change spending limits without a valid code", GetUserStr(m.Sender))
+			bot.sendSpendingConfirmationError(m.Sender, err)
+			return ctx, err
+		}
+		args = args[:len(args)-1]
+	}
+
+	switch command {
+	case "limit":
+		if len(args) < 2 {
+			bot.trySendMessage(m.Sender, spendingLimitsHelpMessage)
+			return ctx, nil
+		}
+		value, err := parseSpendingLimit(args[1])
+		if err != nil {
+			bot.trySendMessage(m.Sender, Translate(ctx, "lnurlInvalidAmountMessage"))
+			return ctx, err
+		}
+		switch strings.ToLower(args[0]) {
+		case "daily":
+			limits.Daily = value
+		case "tx":
+			limits.PerTransaction = value
+		default:
+			bot.trySendMessage(m.Sender, spendingLimitsHelpMessage)
+			return ctx, nil
+		}
+	case "confirm":
+		if len(args) < 1 {
+			bot.trySendMessage(m.Sender, spendingLimitsHelpMessage)
+			return ctx, nil
+		}
+		value, err := parseSpendingLimit(args[0])
+		if err != nil {
+			bot.trySendMessage(m.Sender, Translate(ctx, "lnurlInvalidAmountMessage"))
+			return ctx, err
+		}
+		if value > 0 && !hasSpendingConfirmation(*limits) {
+			bot.trySendMessage(m.Sender, spendingConfirmNeedsCodeMessage)
+			return ctx, nil
+		}
+		limits.ConfirmAbove = value
+	case "pin":
+		bot.tryDeleteMessage(m)
+		if len(args) < 1 {
+			bot.trySendMessage(m.Sender, spendingLimitsHelpMessage)
+			return ctx, nil
+		}
+		if strings.ToLower(args[0]) == "off" {
+			limits.PinHash = ""
+			break
+		}
+		if !spendingPinPattern.MatchString(args[0]) {
+			bot.trySendMessage(m.Sender, spendingInvalidPinMessage)
+			return ctx, nil
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(args[0]), bcrypt.DefaultCost)
+		if err != nil {
+			return ctx, err
+		}
+		limits.PinHash = string(hash)
+	case "totp":
+		if len(args) == 0 {
+			if limits.TotpEnabled {
+				bot.trySendMessage(m.Sender, spendingTotpActiveMessage)
+				return ctx, nil
+			}
+			secret, err := totp.NewSecret()
+			if err != nil {
+				return ctx, err
+			}
+			limits.TotpSecret = secret
+			err = UpdateUserRecord(user, *bot)
+			if err != nil {
+				return ctx, err
+			}
+			bot.trySendMessage(m.Sender, fmt.Sprintf(spendingTotpSetupMessage, secret, totp.URI(secret, GetUserStr(m.Sender), bot.Telegram.Me.Username)))
+			return ctx, nil
+		}
+		bot.tryDeleteMessage(m)
+		if strings.ToLower(args[0]) == "off" {
+			limits.TotpEnabled = false
+			limits.TotpSecret = ""
+			limits.TotpLastCounter = 0
+			break
+		}
+		if bot.spendingLocked(user) {
+			bot.sendSpendingConfirmationError(m.Sender, ErrSpendingConfirmationLocked)
+			return ctx, ErrSpendingConfirmationLocked
+		}
+		counter, ok := totp.ValidateCounter(args[0], limits.TotpSecret, time.Now())
+		if len(limits.TotpSecret) == 0 || !ok {
+			bot.spendingAttemptFailed(user)
+			bot.trySendMessage(m.Sender, spendingConfirmationInvalidMsg)
+			return ctx, ErrSpendingConfirmationInvalid
+		}
+		limits.TotpEnabled = true
+		limits.TotpLastCounter = counter
+	}
+	// without a PIN or TOTP there is nothing to confirm with
+	if !hasSpendingConfirmation(*limits) {
+		limits.ConfirmAbove = 0
+	}
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[spendingLimitSettingHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, spendingLimitsUpdatedMessage)
+	return ctx, nil
+}
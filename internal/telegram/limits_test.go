@@ -0,0 +1,46 @@
+package telegram
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestTipBot_spentWithin(t *testing.T) {
+	now := int(time.Now().Unix())
+	// newest first: many small incoming payments, then spending within and before the window
+	var payments lnbits.Payments
+	for i := 0; i < 150; i++ {
+		payments = append(payments, lnbits.Payment{Amount: 1000, Time: now - i})
+	}
+	payments = append(payments,
+		lnbits.Payment{Amount: -5000000, Time: now - 3600, Pending: true},
+		lnbits.Payment{Amount: -2000000, Time: now - 7200},
+		lnbits.Payment{Amount: -9000000, Time: now - 2*24*3600},
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		page := lnbits.Payments{}
+		for i := offset; i < offset+limit && i < len(payments); i++ {
+			page = append(page, payments[i])
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer server.Close()
+	bot := &TipBot{Client: lnbits.NewClient("key", server.URL)}
+	user := &lnbits.User{Telegram: &tb.User{ID: 1}, Wallet: &lnbits.Wallet{Inkey: "inkey"}}
+	spent, err := bot.spentWithin(user, spendingLimitsDailyWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spent != 7000 {
+		t.Errorf("spentWithin() = %d, want 7000", spent)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/massmux/SatsMobiBot/internal/network"
 	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
@@ -20,6 +21,7 @@ import (
 	"github.com/massmux/SatsMobiBot/internal/runtime"
 
 	lnurl "github.com/fiatjaf/go-lnurl"
+	decodepay "github.com/fiatjaf/ln-decodepay"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -32,6 +34,8 @@ type LnurlPayState struct {
 	Amount          int64                `json:"amount"`
 	Comment         string               `json:"comment"`
 	DescriptionHash string               `json:"descriptionHash,omitempty"`
+	ZapRequest      string               `json:"zapRequest,omitempty"`
+	Contact         string               `json:"contact,omitempty"`
 	LanguageCode    string               `json:"languagecode"`
 }
 
@@ -48,6 +52,14 @@ func (bot *TipBot) lnurlPayHandler(ctx intercept.Context, payParams *LnurlPaySta
 	payParams.Base = storage.New(storage.ID(id))
 	payParams.From = user
 	payParams.LanguageCode = ctx.Value("publicLanguageCode").(string)
+	// NIP-57 zap request set by the zapHandler
+	if zapRequest, ok := ctx.Value("ZapRequest").(string); ok {
+		payParams.ZapRequest = zapRequest
+	}
+	// saved contact the user pays to
+	if contact, ok := ctx.Value("Contact").(string); ok {
+		payParams.Contact = contact
+	}
 
 	// first we check whether an amount is present in the command
 	amount, amount_err := decodeAmountFromCommand(m.Text)
@@ -154,6 +166,21 @@ func (bot *TipBot) lnurlPayHandlerSend(ctx intercept.Context) (intercept.Context
 
 	// LnurlPayState loaded
 
+	// refuse early if the limits are exceeded, the confirmation is asked for when paying the invoice
+	err = bot.checkSpendingLimits(user, lnurlPayState.Amount/1000, "lnurl-pay", "", false)
+	if err == ErrSpendingConfirmationRequired {
+		err = nil
+	}
+	if err == ErrSpendingLimitExceeded {
+		bot.tryEditMessage(statusMsg, spendingLimitExceededMessage)
+		ResetUserState(user, bot)
+		return ctx, err
+	} else if err != nil {
+		log.Errorf("[lnurlPayHandlerSend] Error: %s", err.Error())
+		bot.tryEditMessage(statusMsg, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+
 	callbackUrl, err := url.Parse(lnurlPayState.LNURLPayParams.Callback)
 	if err != nil {
 		log.Errorf("[lnurlPayHandlerSend] Error: %s", err.Error())
@@ -173,6 +200,10 @@ func (bot *TipBot) lnurlPayHandlerSend(ctx intercept.Context) (intercept.Context
 	if len(lnurlPayState.Comment) > 0 {
 		qs.Set("comment", lnurlPayState.Comment)
 	}
+	// add nostr zap request to query string
+	if len(lnurlPayState.ZapRequest) > 0 {
+		qs.Set("nostr", lnurlPayState.ZapRequest)
+	}
 
 	callbackUrl.RawQuery = qs.Encode()
 
@@ -209,6 +240,12 @@ func (bot *TipBot) lnurlPayHandlerSend(ctx intercept.Context) (intercept.Context
 
 	// store success action in context for printing after the payHandler
 	ctx.Context = context.WithValue(ctx, "SuccessAction", lnurlPayState.LNURLPayValues.SuccessAction)
+	if len(lnurlPayState.ZapRequest) > 0 {
+		ctx.Context = context.WithValue(ctx, "ZapRequest", lnurlPayState.ZapRequest)
+	}
+	if len(lnurlPayState.Contact) > 0 {
+		ctx.Context = context.WithValue(ctx, "Contact", lnurlPayState.Contact)
+	}
 
 	m.Text = fmt.Sprintf("/pay %s", response2.PR)
 	return bot.payHandler(ctx)
@@ -252,3 +289,89 @@ func (bot *TipBot) sendToLightningAddress(ctx intercept.Context, address string,
 	}
 	return bot.lnurlHandler(ctx)
 }
+
+// payLnurl pays amount (in sat) to a lightning address or LNURL-p without asking for confirmation.
+// It is used for payments that the user has already authorized, like scheduled payments.
+func (bot *TipBot) payLnurl(user *lnbits.User, address string, amount int64, comment string) (lnbits.Invoice, error) {
+	_, params, err := bot.HandleLNURL(address)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	payParams, ok := params.(lnurl.LNURLPayParams)
+	if !ok {
+		return lnbits.Invoice{}, fmt.Errorf("%s is not a LNURL-p", address)
+	}
+	if payParams.MaxSendable != 0 && payParams.MinSendable != 0 &&
+		(amount*1000 > payParams.MaxSendable || amount*1000 < payParams.MinSendable) {
+		return lnbits.Invoice{}, fmt.Errorf("amount not in range %d - %d sat", payParams.MinSendable/1000, payParams.MaxSendable/1000)
+	}
+	callbackUrl, err := url.Parse(payParams.Callback)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	client, err := network.GetClientForScheme(callbackUrl)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	qs := callbackUrl.Query()
+	qs.Set("amount", strconv.FormatInt(amount*1000, 10)) // msat
+	if len(comment) > 0 && payParams.CommentAllowed > 0 {
+		if len(comment) > int(payParams.CommentAllowed) {
+			comment = comment[:payParams.CommentAllowed]
+		}
+		qs.Set("comment", comment)
+	}
+	callbackUrl.RawQuery = qs.Encode()
+	res, err := client.Get(callbackUrl.String())
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	var payValues lnurl.LNURLPayValues
+	json.Unmarshal(body, &payValues)
+	if payValues.Status == "ERROR" || len(payValues.PR) < 1 {
+		reason := "could not receive invoice"
+		if len(payValues.Reason) > 0 {
+			reason = payValues.Reason
+		}
+		return lnbits.Invoice{}, fmt.Errorf("error in LNURLPayValues: %s", reason)
+	}
+	bolt11, err := decodepay.Decodepay(payValues.PR)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	if bolt11.MSatoshi != amount*1000 {
+		return lnbits.Invoice{}, fmt.Errorf("invoice amount %d msat does not match %d sat", bolt11.MSatoshi, amount)
+	}
+	return bot.PayInvoice(user, payValues.PR, "lnurl-pay", "")
+}
+
+// payLnurlAndLog pays to a lightning address or LNURL-p with payLnurl and logs the payment in the transactions database
+func (bot *TipBot) payLnurlAndLog(from *lnbits.User, address string, amount int64, memo string, opts ...TransactionOption) error {
+	t := &Transaction{
+		Bot:          bot,
+		From:         from,
+		FromId:       from.Telegram.ID,
+		FromUser:     GetUserStr(from.Telegram),
+		ToUser:       address,
+		Amount:       amount,
+		Memo:         memo,
+		Time:         time.Now(),
+		FromWallet:   from.Wallet.ID,
+		FromLNbitsID: from.ID,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	invoice, err := bot.payLnurl(from, address, amount, memo)
+	t.Success = err == nil
+	t.Invoice = invoice
+	if tx := bot.DB.Transactions.Save(t); tx.Error != nil {
+		log.Errorf("[payLnurlAndLog] could not log transaction: %v", tx.Error)
+	}
+	return err
+}
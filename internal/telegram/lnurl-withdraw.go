@@ -242,6 +242,11 @@ func (bot *TipBot) confirmWithdrawHandler(ctx intercept.Context) (intercept.Cont
 		return ctx, errors.Create(errors.UserNoWalletError)
 	}
 
+	err = bot.precheckSpendingLimits(user, lnurlWithdrawState.Amount/1000, "lnurl-withdraw")
+	if err != nil {
+		return ctx, err
+	}
+
 	// reset state immediately
 	ResetUserState(user, bot)
 
@@ -2,10 +2,14 @@ package telegram
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/massmux/SatsMobiBot/internal"
 	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
 	"github.com/nbd-wtf/go-nostr"
@@ -17,12 +21,23 @@ var (
 	nosterRegisterMessage       = "📖 Add your nostr pubkey for zap receipts"
 	nostrInfoMessage            = "💜 *Your nostr information*\n\nYour pubkey: `%s`"
 	nostrInfoLNAddrMessage      = "Your Lightning address: `%s`"
-	nostrHelpMessage            = "⚙️ *Nostr commands:*\n`/nostr add <pubkey>` ✅ Add your nostr pubkey.\n`/nostr help` 📖 Show help."
+	nostrInfoDMMessage          = "Nostr DM notifications: `%s`"
+	nostrHelpMessage            = "⚙️ *Nostr commands:*\n`/nostr add <pubkey>` ✅ Add your nostr pubkey.\n`/nostr dm <on|off>` 🔔 Payment notifications as nostr DMs.\n`/nostr delegate [<conditions> <signature>|off]` 🔑 Send zaps in your name (NIP-26).\n`/nostr help` 📖 Show help.\n`/zap <npub|note> <amount> [<comment>]` ⚡️ Zap on nostr."
 	nostrAddedMessage           = "✅ *Nostr pubkey added.*"
 	nostrPrivateKeyErrorMessage = "🚫 This is not your public key but your private key! Very dangerous! Try again with your npub..."
 	nostrPublicKeyErrorMessage  = "🚫 There was an error decoding your public key."
+	nostrDMEnabledMessage       = "🔔 *Nostr DM notifications enabled.*"
+	nostrDMDisabledMessage      = "🔕 *Nostr DM notifications disabled.*"
+	nostrDMNoPubkeyMessage      = "🚫 Add your nostr pubkey first with `/nostr add <pubkey>`."
+	nostrDelegateInfoMessage    = "🔑 *Zap in your name*\n\nSign this NIP-26 delegation token with your nostr key:\n`%s`\n\nThen send `/nostr delegate %s <signature>`."
+	nostrDelegatedMessage       = "✅ *Delegation saved.* Your zaps are sent in your name."
+	nostrDelegationOffMessage   = "✅ *Delegation removed.* Your zaps are sent by the bot."
+	nostrDelegationInvalidMsg   = "🚫 Invalid delegation. The conditions must allow kind 9734 now and the signature must match your pubkey."
 )
 
+// nostrRelays are the relays the bot publishes to and queries from
+var nostrRelays = []string{"wss://nostr.massmux.com", "wss://relay.nostr.ch", "wss://eden.nostr.land", "wss://nostr.btcmp.com", "wss://nostr.relayer.se", "wss://relay.current.fyi", "wss://nos.lol", "wss://nostr.mom", "wss://relay.nostr.info", "wss://nostr.zebedee.cloud", "wss://nostr-pub.wellorder.net", "wss://relay.snort.social/", "wss://relay.damus.io/", "wss://nostr.oxtr.dev/", "wss://nostr.fmt.wiz.biz/", "wss://brb.io"}
+
 func uniqueSlice(slice []string) []string {
 	keys := make(map[string]bool)
 	list := []string{}
@@ -62,10 +77,15 @@ func (bot *TipBot) publishNostrEvent(ev nostr.Event, relays []string) {
 
 	// calling Sign sets the event ID field and the event Sig field
 	ev.Sign(pk)
+	bot.broadcastNostrEvent(ev, relays)
+}
+
+// broadcastNostrEvent publishes an already signed event to relays
+func (bot *TipBot) broadcastNostrEvent(ev nostr.Event, relays []string) {
 	log.Debugf("[NOSTR] 🟣 publishing nostr event %s", ev.ID)
 
 	// more relays
-	relays = append(relays, "wss://nostr.massmux.com","wss://relay.nostr.ch", "wss://eden.nostr.land", "wss://nostr.btcmp.com", "wss://nostr.relayer.se", "wss://relay.current.fyi", "wss://nos.lol", "wss://nostr.mom", "wss://relay.nostr.info", "wss://nostr.zebedee.cloud", "wss://nostr-pub.wellorder.net", "wss://relay.snort.social/", "wss://relay.damus.io/", "wss://nostr.oxtr.dev/", "wss://nostr.fmt.wiz.biz/", "wss://brb.io")
+	relays = append(relays, nostrRelays...)
 
 	// remove trailing /
 	relays = cleanUrls(relays)
@@ -78,8 +98,11 @@ func (bot *TipBot) publishNostrEvent(ev nostr.Event, relays []string) {
 	if len(relays) > max_relays {
 		relays = relays[:max_relays]
 	}
+	bot.publishToNostrRelays(ev, relays)
+}
 
-	// publish the event to relays
+// publishToNostrRelays publishes a signed event to exactly the given relays
+func (bot *TipBot) publishToNostrRelays(ev nostr.Event, relays []string) {
 	for _, url := range relays {
 		go func(url string) {
 			// remove trailing /
@@ -109,6 +132,10 @@ func (bot *TipBot) nostrHandler(ctx intercept.Context) (intercept.Context, error
 		switch strings.ToLower(splits[1]) {
 		case "add":
 			return bot.addNostrPubkeyHandler(ctx)
+		case "dm":
+			return bot.nostrDirectMessagesHandler(ctx)
+		case "delegate":
+			return bot.nostrDelegateHandler(ctx)
 		case "help":
 			return bot.nostrHelpHandler(ctx)
 		}
@@ -159,6 +186,145 @@ func (bot *TipBot) addNostrPubkeyHandler(ctx intercept.Context) (intercept.Conte
 	return ctx, nil
 }
 
+func (bot *TipBot) nostrDirectMessagesHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	splits := strings.Split(m.Text, " ")
+	if len(splits) < 3 {
+		bot.trySendMessage(m.Sender, nostrHelpMessage)
+		return ctx, fmt.Errorf("not enough arguments")
+	}
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	switch strings.ToLower(splits[2]) {
+	case "on":
+		if len(user.Settings.Nostr.PubKey) == 0 {
+			bot.trySendMessage(m.Sender, nostrDMNoPubkeyMessage)
+			return ctx, fmt.Errorf("no nostr pubkey registered")
+		}
+		user.Settings.Nostr.DirectMessages = true
+	case "off":
+		user.Settings.Nostr.DirectMessages = false
+	default:
+		bot.trySendMessage(m.Sender, nostrHelpMessage)
+		return ctx, fmt.Errorf("invalid argument")
+	}
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[nostrDirectMessagesHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	if user.Settings.Nostr.DirectMessages {
+		bot.trySendMessage(m.Sender, nostrDMEnabledMessage)
+	} else {
+		bot.trySendMessage(m.Sender, nostrDMDisabledMessage)
+	}
+	return ctx, nil
+}
+
+// nostrDelegateHandler saves a NIP-26 delegation of the user's pubkey to the bot key
+func (bot *TipBot) nostrDelegateHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	splits := strings.Fields(m.Text)
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	if len(user.Settings.Nostr.PubKey) == 0 {
+		bot.trySendMessage(m.Sender, nostrDMNoPubkeyMessage)
+		return ctx, fmt.Errorf("no nostr pubkey registered")
+	}
+	botPubkey, err := nostr.GetPublicKey(internal.Configuration.Nostr.PrivateKey)
+	if err != nil {
+		bot.trySendMessage(m.Sender, zapNotConfiguredMessage)
+		return ctx, err
+	}
+	switch {
+	case len(splits) == 3 && strings.ToLower(splits[2]) == "off":
+		user.Settings.Nostr.Delegation = ""
+		user.Settings.Nostr.DelegationConditions = ""
+	case len(splits) == 4:
+		conditions, signature := splits[2], strings.ToLower(splits[3])
+		if !nostrDelegationAllows(conditions, nostrKindZapRequest, time.Now()) ||
+			!verifyNostrDelegation(user.Settings.Nostr.PubKey, botPubkey, conditions, signature) {
+			bot.trySendMessage(m.Sender, nostrDelegationInvalidMsg)
+			return ctx, fmt.Errorf("invalid delegation")
+		}
+		user.Settings.Nostr.Delegation = signature
+		user.Settings.Nostr.DelegationConditions = conditions
+	default:
+		conditions := fmt.Sprintf("kind=%d&created_at<%d", nostrKindZapRequest, time.Now().AddDate(1, 0, 0).Unix())
+		bot.trySendMessage(m.Sender, fmt.Sprintf(nostrDelegateInfoMessage, nostrDelegationToken(botPubkey, conditions), conditions))
+		return ctx, nil
+	}
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[nostrDelegateHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	if len(user.Settings.Nostr.Delegation) > 0 {
+		bot.trySendMessage(m.Sender, nostrDelegatedMessage)
+	} else {
+		bot.trySendMessage(m.Sender, nostrDelegationOffMessage)
+	}
+	return ctx, nil
+}
+
+// nostrDelegationToken returns the NIP-26 token that the delegator signs
+func nostrDelegationToken(delegatee, conditions string) string {
+	return fmt.Sprintf("nostr:delegation:%s:%s", delegatee, conditions)
+}
+
+// verifyNostrDelegation checks the schnorr signature of delegator over the delegation token
+func verifyNostrDelegation(delegator, delegatee, conditions, signature string) bool {
+	pubkeyBytes, err := hex.DecodeString(delegator)
+	if err != nil {
+		return false
+	}
+	pubkey, err := schnorr.ParsePubKey(pubkeyBytes)
+	if err != nil {
+		return false
+	}
+	sigBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	sig, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return false
+	}
+	hash := sha256.Sum256([]byte(nostrDelegationToken(delegatee, conditions)))
+	return sig.Verify(hash[:], pubkey)
+}
+
+// nostrDelegationAllows checks NIP-26 conditions like kind=9734&created_at<1700000000 for an event
+func nostrDelegationAllows(conditions string, kind int, createdAt time.Time) bool {
+	kindGiven := false
+	for _, condition := range strings.Split(conditions, "&") {
+		switch {
+		case strings.HasPrefix(condition, "kind="):
+			if condition != fmt.Sprintf("kind=%d", kind) {
+				return false
+			}
+			kindGiven = true
+		case strings.HasPrefix(condition, "created_at<"), strings.HasPrefix(condition, "created_at>"):
+			limit, err := strconv.ParseInt(condition[len("created_at<"):], 10, 64)
+			if err != nil {
+				return false
+			}
+			if condition[len("created_at")] == '<' && createdAt.Unix() >= limit ||
+				condition[len("created_at")] == '>' && createdAt.Unix() <= limit {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	// the delegation must be restricted to zap requests
+	return kindGiven
+}
+
 func (bot *TipBot) nostrHelpHandler(ctx intercept.Context) (intercept.Context, error) {
 	bot.trySendMessage(ctx.Message().Sender, nosterRegisterMessage+"\n\n"+nostrHelpMessage)
 	return ctx, nil
@@ -184,6 +350,11 @@ func (bot *TipBot) getNostrHandler(ctx intercept.Context) (intercept.Context, er
 			return ctx, err
 		}
 		dynamicHelpMessage += "\n\n" + fmt.Sprintf(nostrInfoMessage, pubkeyBech32)
+		dmStatus := "off"
+		if user.Settings.Nostr.DirectMessages {
+			dmStatus = "on"
+		}
+		dynamicHelpMessage += "\n" + fmt.Sprintf(nostrInfoDMMessage, dmStatus)
 		if lnaddr, _ := bot.UserGetLightningAddress(user); len(lnaddr) > 0 {
 			dynamicHelpMessage += "\n\n" + fmt.Sprintf(nostrInfoLNAddrMessage, lnaddr)
 		}
@@ -0,0 +1,196 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/eko/gocache/store"
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/nostr/nip44"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	nostrKindSeal                 = 13
+	nostrKindPrivateDirectMessage = 14
+	nostrKindGiftWrap             = 1059
+	nostrKindDMRelayList          = 10050
+	// nostrDMRelaysCacheDuration is how long the DM relay list of a user is cached
+	nostrDMRelaysCacheDuration = 30 * time.Minute
+)
+
+// nostrMarkdownReplacer removes the Telegram markdown from notifications
+var nostrMarkdownReplacer = strings.NewReplacer("\\_", "_", "\\*", "*", "\\`", "`", "\\[", "[", "*", "", "`", "")
+
+// sendNostrDirectMessage sends a notification as nostr direct message if the user has
+// registered a nostr pubkey and enabled nostr DMs. Users who publish a NIP-17 DM relay
+// list get a NIP-17 message on these relays, all others a NIP-04 message.
+func (bot *TipBot) sendNostrDirectMessage(to *tb.User, message string) {
+	pk := internal.Configuration.Nostr.PrivateKey
+	if len(pk) == 0 || to == nil {
+		return
+	}
+	user, err := GetLnbitsUserWithSettings(to, *bot)
+	if err != nil || !user.Settings.Nostr.DirectMessages || len(user.Settings.Nostr.PubKey) == 0 {
+		return
+	}
+	message = nostrMarkdownReplacer.Replace(message)
+	receiver := user.Settings.Nostr.PubKey
+	go func() {
+		if relays := bot.getNostrDMRelays(receiver); len(relays) > 0 {
+			ev, err := makeNip17GiftWrap(pk, receiver, message)
+			if err != nil {
+				log.Errorf("[sendNostrDirectMessage] could not create NIP-17 message for %s: %v", GetUserStr(to), err)
+				return
+			}
+			bot.publishToNostrRelays(ev, relays)
+			return
+		}
+		ev, err := makeNip04DirectMessage(pk, receiver, message)
+		if err != nil {
+			log.Errorf("[sendNostrDirectMessage] could not create NIP-04 message for %s: %v", GetUserStr(to), err)
+			return
+		}
+		bot.broadcastNostrEvent(ev, nil)
+	}()
+}
+
+// getNostrDMRelays returns the relays of the latest kind 10050 relay list of pubkey
+func (bot *TipBot) getNostrDMRelays(pubkey string) []string {
+	key := fmt.Sprintf("nostr_dm_relays_%s", pubkey)
+	if relays, err := bot.Cache.Get(key); err == nil {
+		return relays.([]string)
+	}
+	events := bot.queryNostrRelays(context.Background(), nil, nostr.Filter{Kinds: []int{nostrKindDMRelayList}, Authors: []string{pubkey}, Limit: 1})
+	var latest *nostr.Event
+	for _, ev := range events {
+		if latest == nil || ev.CreatedAt.After(latest.CreatedAt) {
+			latest = ev
+		}
+	}
+	relays := []string{}
+	if latest != nil {
+		for _, tag := range latest.Tags {
+			if len(tag) > 1 && tag[0] == "relay" {
+				relays = append(relays, tag[1])
+			}
+		}
+	}
+	relays = uniqueSlice(cleanUrls(relays))
+	runtime.IgnoreError(bot.Cache.Set(key, relays, &store.Options{Expiration: nostrDMRelaysCacheDuration}))
+	return relays
+}
+
+// makeNip04DirectMessage creates a signed kind 4 encrypted direct message
+func makeNip04DirectMessage(sk string, receiver string, message string) (nostr.Event, error) {
+	pub, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	sharedSecret, err := nip04.ComputeSharedSecret(receiver, sk)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	content, err := nip04.Encrypt(message, sharedSecret)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	ev := nostr.Event{
+		PubKey:    pub,
+		CreatedAt: time.Now(),
+		Kind:      nostr.KindEncryptedDirectMessage,
+		Tags:      nostr.Tags{nostr.Tag{"p", receiver}},
+		Content:   content,
+	}
+	err = ev.Sign(sk)
+	return ev, err
+}
+
+// makeNip17GiftWrap wraps an unsigned kind 14 message into a seal signed by the bot
+// and a gift wrap signed by a one-time key
+func makeNip17GiftWrap(sk string, receiver string, message string) (nostr.Event, error) {
+	pub, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	rumor := nostr.Event{
+		PubKey:    pub,
+		CreatedAt: time.Now(),
+		Kind:      nostrKindPrivateDirectMessage,
+		Tags:      nostr.Tags{nostr.Tag{"p", receiver}},
+		Content:   message,
+	}
+	rumor.ID = rumor.GetID()
+	// the rumor must not be signed
+	rumorSerialized, err := json.Marshal(map[string]interface{}{
+		"id":         rumor.ID,
+		"pubkey":     rumor.PubKey,
+		"created_at": rumor.CreatedAt.Unix(),
+		"kind":       rumor.Kind,
+		"tags":       rumor.Tags,
+		"content":    rumor.Content,
+	})
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	conversationKey, err := nip44.ConversationKey(receiver, sk)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	sealContent, err := nip44.Encrypt(string(rumorSerialized), conversationKey)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	seal := nostr.Event{
+		PubKey:    pub,
+		CreatedAt: randomNostrTimestamp(),
+		Kind:      nostrKindSeal,
+		Tags:      nostr.Tags{},
+		Content:   sealContent,
+	}
+	err = seal.Sign(sk)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	sealSerialized, err := json.Marshal(seal)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	wrapKey := nostr.GeneratePrivateKey()
+	wrapPub, err := nostr.GetPublicKey(wrapKey)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	conversationKey, err = nip44.ConversationKey(receiver, wrapKey)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	wrapContent, err := nip44.Encrypt(string(sealSerialized), conversationKey)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	wrap := nostr.Event{
+		PubKey:    wrapPub,
+		CreatedAt: randomNostrTimestamp(),
+		Kind:      nostrKindGiftWrap,
+		Tags:      nostr.Tags{nostr.Tag{"p", receiver}},
+		Content:   wrapContent,
+	}
+	err = wrap.Sign(wrapKey)
+	return wrap, err
+}
+
+// randomNostrTimestamp returns a time up to two days in the past to hide the real send time
+func randomNostrTimestamp() time.Time {
+	return time.Now().Add(-time.Duration(rand.Int63n(int64(48 * time.Hour))))
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/massmux/SatsMobiBot/internal/runtime"
 
 	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/pkg/lightning"
 	lnurl "github.com/fiatjaf/go-lnurl"
 	decodepay "github.com/fiatjaf/ln-decodepay"
 	log "github.com/sirupsen/logrus"
@@ -48,6 +49,8 @@ type PayData struct {
 	Amount          int64                `json:"amount"`
 	LanguageCode    string               `json:"languagecode"`
 	SuccessAction   *lnurl.SuccessAction `json:"successAction"`
+	ZapRequest      string               `json:"zapRequest,omitempty"`
+	Contact         string               `json:"contact,omitempty"`
 	TelegramMessage *tb.Message          `json:"telegrammessage"`
 }
 
@@ -77,6 +80,11 @@ func (bot *TipBot) payHandler(ctx intercept.Context) (intercept.Context, error)
 	// get rid of the URI prefix
 	paymentRequest = strings.TrimPrefix(paymentRequest, "lightning:")
 
+	// pay a saved contact
+	if !lightning.IsInvoice(paymentRequest) && (bot.isContactName(ctx, 1) || bot.isContactName(ctx, 2)) {
+		return bot.sendToContactHandler(ctx)
+	}
+
 	// decode invoice
 	bolt11, err := decodepay.Decodepay(paymentRequest)
 	if err != nil {
@@ -151,6 +159,12 @@ func (bot *TipBot) payHandler(ctx intercept.Context) (intercept.Context, error)
 		SuccessAction:   sa,
 		TelegramMessage: payMessage,
 	}
+	if zapRequest, ok := ctx.Value("ZapRequest").(string); ok {
+		payData.ZapRequest = zapRequest
+	}
+	if contact, ok := ctx.Value("Contact").(string); ok {
+		payData.Contact = contact
+	}
 	// add result to persistent struct
 	runtime.IgnoreError(payData.Set(payData, bot.Bunt))
 
@@ -192,6 +206,11 @@ func (bot *TipBot) confirmPayHandler(ctx intercept.Context) (intercept.Context,
 		return ctx, errors.Create(errors.UserNoWalletError)
 	}
 
+	err = bot.precheckSpendingLimits(user, payData.Amount, "pay")
+	if err != nil {
+		return ctx, err
+	}
+
 	// reset state immediately
 	ResetUserState(user, bot)
 
@@ -210,7 +229,7 @@ func (bot *TipBot) confirmPayHandler(ctx intercept.Context) (intercept.Context,
 
 	log.Infof("[/pay] Attempting %s's invoice %s (%d sat)", userStr, payData.ID, payData.Amount)
 	// pay invoice
-	invoice, err := user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: payData.Invoice}, bot.Client)
+	invoice, err := bot.PayInvoice(user, payData.Invoice, "pay", "")
 	if err != nil {
 		errmsg := fmt.Sprintf("[/pay] Could not pay invoice of %s: %s", userStr, err)
 		err = fmt.Errorf(i18n.Translate(payData.LanguageCode, "invoiceUndefinedErrorMessage"))
@@ -255,6 +274,12 @@ func (bot *TipBot) confirmPayHandler(ctx intercept.Context) (intercept.Context,
 		}
 	}
 
+	// wait for the receipt of a NIP-57 zap
+	if len(payData.ZapRequest) > 0 {
+		bot.startZapReceiptWatcher(ctx.Sender(), payData.ZapRequest)
+	}
+	bot.touchContact(user, payData.Contact)
+
 	log.Infof("[⚡️ pay] User %s paid invoice %s (%d sat)", userStr, payData.ID, payData.Amount)
 	return ctx, nil
 }
@@ -0,0 +1,364 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	paymentRequestHelpMessage        = "💸 *Payment requests*\n\n`/request <amount> <@user> [<memo>]` Request a payment from a user. Open requests expire after %d days.\n`/request list` 📖 List your open requests.\n`/request cancel <id>` 🚫 Cancel a request."
+	paymentRequestMessage            = "💸 *Payment request* `%s`\n\n%s requests *%d sat* from %s.%s\n\nState: *%s*\nExpires: `%s`"
+	paymentRequestMemoMessage        = "\nMemo: %s"
+	paymentRequestSentMessage        = "💸 Request `%s` for %d sat sent to %s."
+	paymentRequestPaidMessage        = "✅ %s paid your request `%s` of %d sat."
+	paymentRequestDeclinedMessage    = "🚫 %s declined your request `%s` of %d sat."
+	paymentRequestCancelledMessage   = "🚫 Request `%s` was cancelled."
+	paymentRequestExpiredMessage     = "⌛️ Your request `%s` of %d sat to %s has expired."
+	paymentRequestNotFoundMessage    = "🚫 Request not found."
+	paymentRequestUnreachableMessage = "🚫 Could not deliver the request to %s. They need to start a chat with me first."
+	paymentRequestListMessage        = "💸 *Your open requests:*\n"
+	paymentRequestListEntryMessage   = "\n`%s`: %d sat from %s to %s"
+	paymentRequestListEmptyMessage   = "💸 You have no open requests."
+	paymentRequestTooManyMessage     = "🚫 You can have at most %d open requests."
+)
+
+const (
+	PaymentRequestStateOpen      = "open"
+	PaymentRequestStatePaid      = "paid"
+	PaymentRequestStateDeclined  = "declined"
+	PaymentRequestStateCancelled = "cancelled"
+	PaymentRequestStateExpired   = "expired"
+)
+
+const (
+	paymentRequestExpiryDays     = 7
+	paymentRequestExpiry         = paymentRequestExpiryDays * 24 * time.Hour
+	paymentRequestTickerDuration = time.Minute
+	paymentRequestMaxMemoLength  = 200
+	paymentRequestMaxOpenPerUser = 20
+)
+
+var (
+	paymentRequestMenu       = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnPayPaymentRequest     = paymentRequestMenu.Data("✅ Pay", "confirm_payment_request")
+	btnDeclinePaymentRequest = paymentRequestMenu.Data("🚫 Decline", "decline_payment_request")
+)
+
+// PaymentRequest is a request of From to be paid by To
+type PaymentRequest struct {
+	*storage.Base
+	From         *lnbits.User `json:"from"`
+	To           *lnbits.User `json:"to"`
+	Amount       int64        `json:"amount"`
+	Memo         string       `json:"memo"`
+	State        string       `json:"state"`
+	Expires      time.Time    `json:"expires"`
+	Message      *tb.Message  `json:"message"`
+	LanguageCode string       `json:"languagecode"`
+}
+
+// ShortID is the ID shown to the user
+func (r *PaymentRequest) ShortID() string {
+	return strings.TrimPrefix(r.ID, "request:")
+}
+
+func (r *PaymentRequest) messageText() string {
+	memo := ""
+	if len(r.Memo) > 0 {
+		memo = fmt.Sprintf(paymentRequestMemoMessage, str.MarkdownEscape(r.Memo))
+	}
+	return fmt.Sprintf(paymentRequestMessage, r.ShortID(), GetUserStrMd(r.From.Telegram), r.Amount, GetUserStrMd(r.To.Telegram),
+		memo, r.State, r.Expires.UTC().Format(escrowTimeLayout))
+}
+
+func (r *PaymentRequest) keyboard() *tb.ReplyMarkup {
+	keyboard := &tb.ReplyMarkup{}
+	if r.State == PaymentRequestStateOpen {
+		keyboard.Inline(keyboard.Row(
+			keyboard.Data(btnPayPaymentRequest.Text, btnPayPaymentRequest.Unique, r.ID),
+			keyboard.Data(btnDeclinePaymentRequest.Text, btnDeclinePaymentRequest.Unique, r.ID)))
+	}
+	return keyboard
+}
+
+// closePaymentRequest sets the final state of the request and updates the message of the payer
+func (bot *TipBot) closePaymentRequest(r *PaymentRequest, state string) {
+	r.State = state
+	log.Infof("[request] %s is %s", r.ID, state)
+	runtime.IgnoreError(r.Inactivate(r, bot.Bunt))
+	if r.Message != nil {
+		bot.tryEditMessage(r.Message, r.messageText(), r.keyboard())
+	}
+}
+
+// paymentRequestHandler handles the /request command
+func (bot *TipBot) paymentRequestHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	command, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(paymentRequestHelpMessage, paymentRequestExpiryDays))
+		return ctx, nil
+	}
+	switch strings.ToLower(command) {
+	case "list":
+		return bot.paymentRequestListHandler(ctx)
+	case "cancel":
+		return bot.cancelPaymentRequestHandler(ctx)
+	case "help":
+		bot.trySendMessage(m.Sender, fmt.Sprintf(paymentRequestHelpMessage, paymentRequestExpiryDays))
+		return ctx, nil
+	}
+	return bot.createPaymentRequestHandler(ctx)
+}
+
+func (bot *TipBot) createPaymentRequestHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	from := LoadUser(ctx)
+	if from.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	splits := strings.Fields(m.Text)
+	if len(splits) < 3 || !strings.HasPrefix(splits[2], "@") {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(paymentRequestHelpMessage, paymentRequestExpiryDays))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(splits[1])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "lnurlInvalidAmountMessage"))
+		return ctx, errors.New(errors.InvalidAmountError, err)
+	}
+	to, err := GetUserByTelegramUsername(strings.TrimPrefix(splits[2], "@"), *bot)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape(splits[2])))
+		return ctx, err
+	}
+	if to.Telegram.ID == from.Telegram.ID {
+		bot.trySendMessage(m.Sender, Translate(ctx, "sendYourselfMessage"))
+		return ctx, errors.Create(errors.SelfPaymentError)
+	}
+	open := 0
+	for _, r := range bot.getPaymentRequests(from) {
+		if r.From.Telegram.ID == from.Telegram.ID {
+			open++
+		}
+	}
+	if open >= paymentRequestMaxOpenPerUser {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(paymentRequestTooManyMessage, paymentRequestMaxOpenPerUser))
+		return ctx, fmt.Errorf("too many open requests")
+	}
+	memo := GetMemoFromCommand(m.Text, 3)
+	if len(memo) > paymentRequestMaxMemoLength {
+		memo = memo[:paymentRequestMaxMemoLength]
+	}
+
+	request := &PaymentRequest{
+		Base:         storage.New(storage.ID(fmt.Sprintf("request:%s", RandStringRunes(8)))),
+		From:         from,
+		To:           to,
+		Amount:       amount,
+		Memo:         memo,
+		State:        PaymentRequestStateOpen,
+		Expires:      time.Now().Add(paymentRequestExpiry),
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	request.Message = bot.trySendMessageEditable(to.Telegram, request.messageText(), request.keyboard())
+	if request.Message == nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(paymentRequestUnreachableMessage, GetUserStrMd(to.Telegram)))
+		return ctx, fmt.Errorf("could not send request")
+	}
+	runtime.IgnoreError(request.Set(request, bot.Bunt))
+	log.Infof("[request] %s requests %d sat from %s (%s)", GetUserStr(from.Telegram), amount, GetUserStr(to.Telegram), request.ID)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(paymentRequestSentMessage, request.ShortID(), amount, GetUserStrMd(to.Telegram)))
+	return ctx, nil
+}
+
+func (bot *TipBot) paymentRequestListHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	requests := bot.getPaymentRequests(LoadUser(ctx))
+	if len(requests) == 0 {
+		bot.trySendMessage(m.Sender, paymentRequestListEmptyMessage)
+		return ctx, nil
+	}
+	message := paymentRequestListMessage
+	for _, r := range requests {
+		message += fmt.Sprintf(paymentRequestListEntryMessage, r.ShortID(), r.Amount, GetUserStrMd(r.To.Telegram), GetUserStrMd(r.From.Telegram))
+	}
+	bot.trySendMessage(m.Sender, message)
+	return ctx, nil
+}
+
+// cancelPaymentRequestHandler lets the requester withdraw an open request
+func (bot *TipBot) cancelPaymentRequestHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	splits := strings.Fields(m.Text)
+	if len(splits) != 3 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(paymentRequestHelpMessage, paymentRequestExpiryDays))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	tx := &PaymentRequest{Base: storage.New(storage.ID(fmt.Sprintf("request:%s", splits[2])))}
+	mutex.LockWithContext(ctx, tx.ID)
+	defer mutex.UnlockWithContext(ctx, tx.ID)
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		bot.trySendMessage(m.Sender, paymentRequestNotFoundMessage)
+		return ctx, err
+	}
+	request := sn.(*PaymentRequest)
+	if !request.Active || request.From.Telegram.ID != m.Sender.ID {
+		bot.trySendMessage(m.Sender, paymentRequestNotFoundMessage)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	bot.closePaymentRequest(request, PaymentRequestStateCancelled)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(paymentRequestCancelledMessage, request.ShortID()))
+	return ctx, nil
+}
+
+// loadOpenPaymentRequest loads the request of a button press and makes sure the payer pressed it
+func (bot *TipBot) loadOpenPaymentRequest(ctx intercept.Context) (*PaymentRequest, error) {
+	c := ctx.Callback()
+	tx := &PaymentRequest{Base: storage.New(storage.ID(c.Data))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		log.Errorf("[paymentRequest] %s", err.Error())
+		return nil, err
+	}
+	request := sn.(*PaymentRequest)
+	if request.To.Telegram.ID != c.Sender.ID {
+		return nil, errors.Create(errors.UnknownError)
+	}
+	if !request.Active || request.State != PaymentRequestStateOpen {
+		bot.tryEditMessage(c, request.messageText(), &tb.ReplyMarkup{})
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	// the watcher only expires requests on its next tick
+	if time.Now().After(request.Expires) {
+		bot.closeExpiredPaymentRequest(request)
+		bot.tryEditMessage(c, request.messageText(), &tb.ReplyMarkup{})
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return request, nil
+}
+
+// confirmPaymentRequestHandler is invoked when the payer pays the request
+func (bot *TipBot) confirmPaymentRequestHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	request, err := bot.loadOpenPaymentRequest(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	payer := LoadUser(ctx)
+	err = bot.precheckSpendingLimits(payer, request.Amount, "request")
+	if err != nil {
+		return ctx, err
+	}
+
+	t := NewTransaction(bot, payer, request.From, request.Amount, TransactionType("request"))
+	t.Memo = fmt.Sprintf("💸 Payment request %s from %s to %s.", request.ShortID(), GetUserStr(request.From.Telegram), GetUserStr(payer.Telegram))
+	success, err := t.Send()
+	if !success {
+		if err == nil {
+			err = fmt.Errorf("transaction failed")
+		}
+		log.Warnf("[request] could not pay %s: %v", request.ID, err)
+		bot.trySendMessage(c.Sender, fmt.Sprintf("%s %s", Translate(ctx, "sendErrorMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	bot.closePaymentRequest(request, PaymentRequestStatePaid)
+
+	payerStrMd := GetUserStrMd(payer.Telegram)
+	sendReceivedMessage := fmt.Sprintf(i18n.Translate(request.From.Telegram.LanguageCode, "sendReceivedMessage"), payerStrMd, request.Amount) + bot.fiatValueText(request.From, request.Amount, time.Now())
+	bot.trySendMessage(request.From.Telegram, sendReceivedMessage)
+	bot.sendNostrDirectMessage(request.From.Telegram, sendReceivedMessage)
+	bot.trySendMessage(request.From.Telegram, fmt.Sprintf(paymentRequestPaidMessage, payerStrMd, request.ShortID(), request.Amount))
+	return ctx, nil
+}
+
+// declinePaymentRequestHandler is invoked when the payer declines the request
+func (bot *TipBot) declinePaymentRequestHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	request, err := bot.loadOpenPaymentRequest(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	bot.closePaymentRequest(request, PaymentRequestStateDeclined)
+	bot.trySendMessage(request.From.Telegram, fmt.Sprintf(paymentRequestDeclinedMessage, GetUserStrMd(c.Sender), request.ShortID(), request.Amount))
+	return ctx, nil
+}
+
+// getPaymentRequests returns all open requests from or to a user
+func (bot *TipBot) getPaymentRequests(user *lnbits.User) []*PaymentRequest {
+	var requests []*PaymentRequest
+	for _, r := range bot.loadPaymentRequests() {
+		if r.From.Telegram.ID == user.Telegram.ID || r.To.Telegram.ID == user.Telegram.ID {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// loadPaymentRequests returns all open requests
+func (bot *TipBot) loadPaymentRequests() []*PaymentRequest {
+	var requests []*PaymentRequest
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend(PaymentRequestIndex, func(key, value string) bool {
+			r := &PaymentRequest{}
+			err := json.Unmarshal([]byte(value), r)
+			if err != nil || r.Base == nil || !r.Active || r.From == nil || r.To == nil {
+				return true
+			}
+			requests = append(requests, r)
+			return true // continue iteration
+		})
+	})
+	return requests
+}
+
+// startPaymentRequestWatcher expires open requests after their deadline
+func (bot *TipBot) startPaymentRequestWatcher() {
+	runtime.Watch(paymentRequestTickerDuration, bot.loadPaymentRequests, func(r *PaymentRequest) {
+		if !r.Expires.After(time.Now()) {
+			bot.expirePaymentRequest(r.ID)
+		}
+	})
+}
+
+func (bot *TipBot) expirePaymentRequest(id string) {
+	tx := &PaymentRequest{Base: storage.New(storage.ID(id))}
+	mutex.Lock(tx.ID)
+	defer mutex.Unlock(tx.ID)
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		log.Errorf("[request] could not load %s: %v", id, err)
+		return
+	}
+	request := sn.(*PaymentRequest)
+	// check again, the request could have been paid in the meantime
+	if !request.Active || request.State != PaymentRequestStateOpen {
+		return
+	}
+	bot.closeExpiredPaymentRequest(request)
+}
+
+// closeExpiredPaymentRequest closes the request and tells the requester. The request must be locked.
+func (bot *TipBot) closeExpiredPaymentRequest(request *PaymentRequest) {
+	bot.closePaymentRequest(request, PaymentRequestStateExpired)
+	bot.trySendMessage(request.From.Telegram, fmt.Sprintf(paymentRequestExpiredMessage, request.ShortID(), request.Amount, GetUserStrMd(request.To.Telegram)))
+}
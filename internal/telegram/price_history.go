@@ -0,0 +1,182 @@
+package telegram
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/price"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	priceHelpMessage   = "📈 *Bitcoin price*\n\n`/price [currency] [range]`\n\nRange is `day`, `week`, `month`, `year` or a duration like `3d` (default: `day`).\nCurrencies: %s"
+	priceMessage       = "📈 *BTC/%s* %s\n\n`%s`\n\n*Now:* %s (%+.2f%%)\n*High:* %s *Low:* %s"
+	priceNoDataMessage = "📈 No %s prices stored for this range yet."
+	fiatValueMessage   = "\n💱 ≈ %s"
+)
+
+const (
+	priceSparklineLength = 24
+	priceMaxRange        = 365 * 24 * time.Hour
+)
+
+var sparklineBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// priceRanges are the named ranges of /price
+var priceRanges = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+}
+
+// userFiatCurrency returns the display currency of the user or an empty string if none is set
+func (bot *TipBot) userFiatCurrency(user *lnbits.User) string {
+	if user == nil || user.Telegram == nil {
+		return ""
+	}
+	if user.Settings == nil {
+		u, err := GetLnbitsUserWithSettings(user.Telegram, *bot)
+		if err != nil {
+			return ""
+		}
+		user.Settings = u.Settings
+	}
+	currency := strings.ToUpper(user.Settings.Display.DisplayCurrency)
+	if _, ok := price.P.Currencies[currency]; !ok {
+		return ""
+	}
+	return currency
+}
+
+// fiatValue returns the formatted value of an amount at time t in the display currency of the user.
+// It is empty if the user has no display currency or there is no price.
+func (bot *TipBot) fiatValue(user *lnbits.User, amount int64, t time.Time) string {
+	currency := bot.userFiatCurrency(user)
+	if len(currency) == 0 {
+		return ""
+	}
+	btcPrice, err := price.PriceAt(currency, t)
+	if err != nil {
+		btcPrice, err = price.Get(currency)
+		if err != nil {
+			return ""
+		}
+	}
+	return FormatFiat(float64(amount)/100_000_000*btcPrice, currency, user.Telegram.LanguageCode)
+}
+
+// fiatValueText returns the fiat value of an amount as a line to append to a message
+func (bot *TipBot) fiatValueText(user *lnbits.User, amount int64, t time.Time) string {
+	value := bot.fiatValue(user, amount, t)
+	if len(value) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(fiatValueMessage, value)
+}
+
+// sparkline spreads the buckets over length characters of equal time and renders them as block characters
+func sparkline(buckets []price.Bucket, since, until time.Time, length int) (string, float64, float64) {
+	sums := make([]float64, length)
+	counts := make([]int, length)
+	low, high := math.MaxFloat64, 0.0
+	span := until.Sub(since)
+	for _, bucket := range buckets {
+		i := int(float64(bucket.Time.Sub(since)) / float64(span) * float64(length))
+		if i < 0 {
+			i = 0
+		}
+		if i >= length {
+			i = length - 1
+		}
+		sums[i] += bucket.Price
+		counts[i]++
+		low = math.Min(low, bucket.Low)
+		high = math.Max(high, bucket.High)
+	}
+	var values []float64
+	for i := range sums {
+		if counts[i] > 0 {
+			values = append(values, sums[i]/float64(counts[i]))
+		} else if len(values) > 0 {
+			// gaps keep the previous value
+			values = append(values, values[len(values)-1])
+		}
+	}
+	minimum, maximum := math.MaxFloat64, 0.0
+	for _, v := range values {
+		minimum = math.Min(minimum, v)
+		maximum = math.Max(maximum, v)
+	}
+	var line strings.Builder
+	for _, v := range values {
+		level := len(sparklineBlocks) - 1
+		if maximum > minimum {
+			level = int((v - minimum) / (maximum - minimum) * float64(len(sparklineBlocks)-1))
+		}
+		line.WriteRune(sparklineBlocks[level])
+	}
+	return line.String(), low, high
+}
+
+// priceHandler handles /price [currency] [range]
+func (bot *TipBot) priceHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	currency := bot.userFiatCurrency(LoadUser(ctx))
+	if len(currency) == 0 {
+		currency = "USD"
+	}
+	rangeName, duration := "day", priceRanges["day"]
+	for _, argument := range strings.Fields(m.Text)[1:] {
+		a := strings.ToLower(argument)
+		if _, ok := price.P.Currencies[strings.ToUpper(a)]; ok {
+			currency = strings.ToUpper(a)
+			continue
+		}
+		if d, ok := priceRanges[a]; ok {
+			rangeName, duration = a, d
+			continue
+		}
+		d, err := parseFaucetDuration(a)
+		if err != nil || d <= 0 || d > priceMaxRange {
+			currencies := make([]string, 0, len(price.P.Currencies))
+			for c := range price.P.Currencies {
+				currencies = append(currencies, c)
+			}
+			sort.Strings(currencies)
+			bot.trySendMessage(m.Chat, fmt.Sprintf(priceHelpMessage, strings.Join(currencies, ", ")))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+		rangeName, duration = a, d
+	}
+
+	now := time.Now()
+	since := now.Add(-duration)
+	// the database averages the samples, a year has too many to load them all
+	buckets, err := price.PriceRange(currency, since, duration/priceSparklineLength)
+	if err != nil {
+		log.Errorf("[price] could not load %s prices: %v", currency, err)
+		bot.trySendMessage(m.Chat, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	if len(buckets) == 0 {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(priceNoDataMessage, currency))
+		return ctx, nil
+	}
+	line, low, high := sparkline(buckets, since, now, priceSparklineLength)
+	current, err := price.Get(currency)
+	if err != nil {
+		current = buckets[len(buckets)-1].Price
+	}
+	change := (current - buckets[0].Price) / buckets[0].Price * 100
+	languageCode := m.Sender.LanguageCode
+	bot.trySendMessage(m.Chat, fmt.Sprintf(priceMessage, currency, rangeName, line,
+		FormatFiat(current, currency, languageCode), change, FormatFiat(high, currency, languageCode), FormatFiat(low, currency, languageCode)))
+	return ctx, nil
+}
@@ -0,0 +1,526 @@
+package telegram
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	raffleHelpMessage         = "🎟 *Raffle*\n\n`/raffle <ticket price> <duration> [<max tickets>] [cut=<percent>]` Start a raffle in a group, e.g. `/raffle 100 1d 50`. The pot is held by the bot until the draw.\n`/raffle <id>` Show a raffle and verify its draw."
+	raffleMessage             = "🎟 *Raffle* `%s` by %s\n\nTicket: %d sat\nTickets sold: %d%s\nPot: %d sat%s\nDraw: `%s` (%s left)\n\nCommitment: `%s`"
+	raffleMaxTicketsMessage   = " / %d"
+	raffleCutMessage          = " (%d%% to the creator)"
+	raffleDrawnMessage        = "🎟 *Raffle* `%s` by %s\n\n🏆 Winner: %s with ticket #%d of %d\nPrize: %d sat\n\nSeed: `%s`\nCommitment: `%s`\n\nVerify with `/raffle %s`."
+	raffleNoTicketsMessage    = "🎟 Raffle `%s` ended without tickets."
+	raffleCancelledMessage    = "🚫 Raffle `%s` cancelled. All tickets were refunded."
+	raffleTicketMessage       = "🎟 You bought ticket #%d of raffle `%s` for %d sat."
+	raffleWinnerMessage       = "🏆 You won %d sat in raffle `%s`!"
+	raffleCreatorCutMessage   = "🎟 Your raffle `%s` is drawn. You received your cut of %d sat."
+	raffleRefundMessage       = "↩️ Your ticket #%d of raffle `%s` was refunded (%d sat)."
+	raffleSoldOutMessage      = "🚫 All tickets are sold."
+	raffleEndedMessage        = "🚫 This raffle has ended."
+	raffleNotFoundMessage     = "🚫 Raffle not found."
+	raffleInvalidMessage      = "🚫 Invalid raffle: %s"
+	raffleDetailsMessage      = "🎟 *Raffle* `%s`\n\nCreator: %s\nState: *%s*\nTicket: %d sat\nCreator cut: %d%%\nCreated: `%s`\nDraw: `%s`\nCommitment: `%s`%s"
+	raffleDetailsSeedMessage  = "\nSeed: `%s`\nTickets hash: `%s`\nWinner: %s (ticket #%d)\n\nsha256(seed) = commitment\ntickets hash = sha256 of the lines `number:user id:unix time\\n` of the ticket list\nwinning ticket = sha256(seed ‖ raffle id ‖ tickets hash) mod %d + 1"
+	raffleTicketEntryMessage  = "%s %s %s\n"
+	raffleTicketsMessage      = "🎟 *Tickets:*\n```\n%s```"
+	raffleTicketsFileMessage  = "🎟 %d tickets"
+	raffleUnavailableMessage  = "🚫 Raffles are not available right now."
+	raffleBuyButtonMessage    = "🎟 Buy ticket"
+	raffleCancelButtonMessage = "🚫 Cancel"
+)
+
+const (
+	RaffleStateOpen      = "open"
+	RaffleStateDrawn     = "drawn"
+	RaffleStateCancelled = "cancelled"
+	RaffleStateEmpty     = "empty"
+)
+
+const (
+	raffleMaxDurationDays = 30
+	raffleMaxDuration     = raffleMaxDurationDays * 24 * time.Hour
+	raffleMinDuration     = time.Minute
+	raffleMaxTickets      = 10000
+	raffleMaxCutPercent   = 50
+	raffleTickerDuration  = time.Minute
+	raffleCutArgument     = "cut="
+	// raffleTicketsMessageLength keeps the ticket list below the message limit of Telegram
+	raffleTicketsMessageLength = 3500
+)
+
+var (
+	raffleMenu         = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnBuyRaffleTicket = raffleMenu.Data(raffleBuyButtonMessage, "raffle_buy")
+	btnCancelRaffle    = raffleMenu.Data(raffleCancelButtonMessage, "raffle_cancel")
+)
+
+// Raffle sells tickets into a pot held by the raffle wallet. The winner is drawn from
+// a seed that is committed to at creation and revealed at the draw, mixed with the raffle
+// ID and the final ticket list so that the seed alone doesn't decide the winner.
+type Raffle struct {
+	*storage.Base
+	Creator       *lnbits.User   `json:"creator"`
+	WalletUserID  int64          `json:"wallet_user_id"`
+	TicketPrice   int64          `json:"ticket_price"`
+	MaxTickets    int            `json:"max_tickets"`
+	CutPercent    int64          `json:"cut_percent"`
+	Deadline      time.Time      `json:"deadline"`
+	Tickets       []RaffleTicket `json:"tickets"`
+	Seed          string         `json:"seed"`
+	Commitment    string         `json:"commitment"`
+	TicketsHash   string         `json:"tickets_hash"`
+	State         string         `json:"state"`
+	WinningTicket int            `json:"winning_ticket"`
+	WinnerPaid    bool           `json:"winner_paid"`
+	CutPaid       bool           `json:"cut_paid"`
+	DrawnAt       time.Time      `json:"drawn_at"`
+	Message       *tb.Message    `json:"message"`
+	Text          string         `json:"text"`
+	LanguageCode  string         `json:"languagecode"`
+}
+
+// RaffleTicket is a bought ticket. Tickets are numbered by their position starting at 1.
+type RaffleTicket struct {
+	User     *lnbits.User `json:"user"`
+	Time     time.Time    `json:"time"`
+	Refunded bool         `json:"refunded"`
+}
+
+// ShortID is the ID shown to the user
+func (r *Raffle) ShortID() string {
+	return strings.TrimPrefix(r.ID, "raffle:")
+}
+
+// Pot is the amount of all sold tickets
+func (r *Raffle) Pot() int64 {
+	return int64(len(r.Tickets)) * r.TicketPrice
+}
+
+// Cut is the creator's share of the pot
+func (r *Raffle) Cut() int64 {
+	return r.Pot() * r.CutPercent / 100
+}
+
+// Winner returns the user of the winning ticket
+func (r *Raffle) Winner() *lnbits.User {
+	if r.WinningTicket < 1 || r.WinningTicket > len(r.Tickets) {
+		return nil
+	}
+	return r.Tickets[r.WinningTicket-1].User
+}
+
+// newRaffleSeed returns a random seed and its commitment sha256(seed)
+func newRaffleSeed() (seed string, commitment string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	seed = hex.EncodeToString(b)
+	sum := sha256.Sum256([]byte(seed))
+	return seed, hex.EncodeToString(sum[:]), nil
+}
+
+// line identifies a ticket in the tickets hash
+func (ticket RaffleTicket) line(number int) string {
+	return fmt.Sprintf("%d:%d:%d", number, ticket.User.Telegram.ID, ticket.Time.Unix())
+}
+
+// ticketsHash returns the sha256 of all ticket lines. It is only known after the last ticket is sold.
+func (r *Raffle) ticketsHash() string {
+	h := sha256.New()
+	for i, ticket := range r.Tickets {
+		h.Write([]byte(ticket.line(i+1) + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// drawRaffleTicket returns the winning ticket number sha256(seed || id || ticketsHash) mod tickets + 1
+func drawRaffleTicket(seed string, id string, ticketsHash string, tickets int) int {
+	sum := sha256.Sum256([]byte(seed + id + ticketsHash))
+	n := new(big.Int).SetBytes(sum[:])
+	n.Mod(n, big.NewInt(int64(tickets)))
+	return int(n.Int64()) + 1
+}
+
+func (r *Raffle) messageText() string {
+	maxTickets := ""
+	if r.MaxTickets > 0 {
+		maxTickets = fmt.Sprintf(raffleMaxTicketsMessage, r.MaxTickets)
+	}
+	cut := ""
+	if r.CutPercent > 0 {
+		cut = fmt.Sprintf(raffleCutMessage, r.CutPercent)
+	}
+	return fmt.Sprintf(raffleMessage, r.ShortID(), GetUserStrMd(r.Creator.Telegram), r.TicketPrice, len(r.Tickets), maxTickets,
+		r.Pot(), cut, r.Deadline.UTC().Format(escrowTimeLayout), formatTimeLeft(time.Until(r.Deadline)), r.Commitment)
+}
+
+func (r *Raffle) keyboard() *tb.ReplyMarkup {
+	keyboard := &tb.ReplyMarkup{}
+	keyboard.Inline(keyboard.Row(
+		keyboard.Data(btnBuyRaffleTicket.Text, btnBuyRaffleTicket.Unique, r.ID),
+		keyboard.Data(btnCancelRaffle.Text, btnCancelRaffle.Unique, r.ID),
+	))
+	return keyboard
+}
+
+// raffleHandler handles the /raffle command
+func (bot *TipBot) raffleHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	arguments := strings.Fields(m.Text)
+	switch {
+	case len(arguments) < 2 || strings.ToLower(arguments[1]) == "help":
+		bot.trySendMessage(m.Sender, raffleHelpMessage)
+		return ctx, nil
+	case len(arguments) == 2:
+		return bot.raffleShowHandler(ctx, arguments[1])
+	}
+	return bot.raffleCreateHandler(ctx)
+}
+
+func (bot *TipBot) raffleCreateHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Sender, raffleHelpMessage)
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	creator := LoadUser(ctx)
+	if creator.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	arguments := strings.Fields(m.Text)
+	price, err := GetAmount(arguments[1])
+	if err != nil || price < 1 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "lnurlInvalidAmountMessage"))
+		return ctx, errors.New(errors.InvalidAmountError, err)
+	}
+	duration, err := parseFaucetDuration(arguments[2])
+	if err != nil || duration < raffleMinDuration || duration > raffleMaxDuration {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(raffleInvalidMessage, fmt.Sprintf("the duration must be between 1m and %d days.", raffleMaxDurationDays)))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	raffle := &Raffle{
+		Base:         storage.New(storage.ID(fmt.Sprintf("raffle:%s", RandStringRunes(8)))),
+		Creator:      creator,
+		WalletUserID: raffleWalletUserID,
+		TicketPrice:  price,
+		Deadline:     time.Now().Add(duration),
+		State:        RaffleStateOpen,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	for _, argument := range arguments[3:] {
+		if strings.HasPrefix(strings.ToLower(argument), raffleCutArgument) {
+			cut, err := strconv.ParseInt(strings.TrimSuffix(argument[len(raffleCutArgument):], "%"), 10, 64)
+			if err != nil || cut < 0 || cut > raffleMaxCutPercent {
+				bot.trySendMessage(m.Sender, fmt.Sprintf(raffleInvalidMessage, fmt.Sprintf("the creator cut must be between 0 and %d%%.", raffleMaxCutPercent)))
+				return ctx, errors.Create(errors.InvalidSyntaxError)
+			}
+			raffle.CutPercent = cut
+			continue
+		}
+		maxTickets, err := strconv.Atoi(argument)
+		if err != nil || maxTickets < 1 || maxTickets > raffleMaxTickets {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(raffleInvalidMessage, fmt.Sprintf("the maximum number of tickets must be between 1 and %d.", raffleMaxTickets)))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+		raffle.MaxTickets = maxTickets
+	}
+	raffle.Seed, raffle.Commitment, err = newRaffleSeed()
+	if err != nil {
+		log.Errorf("[raffle] could not create seed: %v", err)
+		bot.trySendMessage(m.Sender, raffleUnavailableMessage)
+		return ctx, err
+	}
+	raffle.Text = raffle.messageText()
+	raffle.Message = bot.trySendMessageEditable(m.Chat, raffle.Text, raffle.keyboard())
+	log.Infof("[raffle] %s created raffle %s: %d sat per ticket, commitment %s", GetUserStr(m.Sender), raffle.ID, raffle.TicketPrice, raffle.Commitment)
+	return ctx, raffle.Set(raffle, bot.Bunt)
+}
+
+func (bot *TipBot) raffleShowHandler(ctx intercept.Context, id string) (intercept.Context, error) {
+	m := ctx.Message()
+	tx := &Raffle{Base: storage.New(storage.ID("raffle:" + strings.TrimPrefix(id, "raffle:")))}
+	rn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		bot.trySendMessage(m.Sender, raffleNotFoundMessage)
+		return ctx, err
+	}
+	raffle := rn.(*Raffle)
+	tickets := ""
+	for i, ticket := range raffle.Tickets {
+		tickets += fmt.Sprintf(raffleTicketEntryMessage, ticket.line(i+1), ticket.Time.UTC().Format(escrowTimeLayout), GetUserStr(ticket.User.Telegram))
+	}
+	reveal := ""
+	if raffle.State == RaffleStateDrawn {
+		reveal = fmt.Sprintf(raffleDetailsSeedMessage, raffle.Seed, raffle.TicketsHash, GetUserStrMd(raffle.Winner().Telegram), raffle.WinningTicket, len(raffle.Tickets))
+	}
+	bot.trySendMessage(m.Chat, fmt.Sprintf(raffleDetailsMessage, raffle.ShortID(), GetUserStrMd(raffle.Creator.Telegram), raffle.State,
+		raffle.TicketPrice, raffle.CutPercent, raffle.CreatedAt.UTC().Format(escrowTimeLayout), raffle.Deadline.UTC().Format(escrowTimeLayout),
+		raffle.Commitment, reveal))
+	if len(tickets) == 0 {
+		return ctx, nil
+	}
+	// Telegram limits the length of a message, the tickets of large raffles are sent as a file
+	if len(tickets) <= raffleTicketsMessageLength {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(raffleTicketsMessage, tickets))
+		return ctx, nil
+	}
+	bot.trySendMessage(m.Chat, &tb.Document{
+		File:     tb.FromReader(strings.NewReader(tickets)),
+		FileName: fmt.Sprintf("raffle_%s_tickets.txt", raffle.ShortID()),
+		MIME:     "text/plain",
+		Caption:  fmt.Sprintf(raffleTicketsFileMessage, len(raffle.Tickets)),
+	})
+	return ctx, nil
+}
+
+// buyRaffleTicketHandler is invoked when a user presses the buy button
+func (bot *TipBot) buyRaffleTicketHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	tx := &Raffle{Base: storage.New(storage.ID(c.Data))}
+	mutex.LockWithContext(ctx, tx.ID)
+	defer mutex.UnlockWithContext(ctx, tx.ID)
+	rn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return ctx, err
+	}
+	raffle := rn.(*Raffle)
+	if !raffle.Active || raffle.State != RaffleStateOpen || time.Now().After(raffle.Deadline) {
+		bot.trySendMessage(user.Telegram, raffleEndedMessage)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if raffle.MaxTickets > 0 && len(raffle.Tickets) >= raffle.MaxTickets {
+		bot.trySendMessage(user.Telegram, raffleSoldOutMessage)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	raffleWallet, err := bot.loadHoldingWallet(holdingWalletUserID(raffle.WalletUserID))
+	if err != nil {
+		log.Errorf("[raffle] could not load raffle wallet: %v", err)
+		bot.trySendMessage(user.Telegram, raffleUnavailableMessage)
+		return ctx, err
+	}
+	t := NewTransaction(bot, user, raffleWallet, raffle.TicketPrice, TransactionType("raffle"))
+	t.Memo = fmt.Sprintf("🎟 Raffle %s ticket.", raffle.ShortID())
+	success, err := t.Send()
+	if !success {
+		if err == nil {
+			err = fmt.Errorf("transaction failed")
+		}
+		bot.trySendMessage(user.Telegram, fmt.Sprintf("%s %s", Translate(ctx, "sendErrorMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	raffle.Tickets = append(raffle.Tickets, RaffleTicket{User: user, Time: time.Now()})
+	log.Infof("[raffle] %s bought ticket #%d of raffle %s", GetUserStr(user.Telegram), len(raffle.Tickets), raffle.ID)
+	bot.trySendMessage(user.Telegram, fmt.Sprintf(raffleTicketMessage, len(raffle.Tickets), raffle.ShortID(), raffle.TicketPrice))
+	raffle.Text = raffle.messageText()
+	bot.tryEditStack(raffle.Message, raffle.ID, raffle.Text, raffle.keyboard())
+	return ctx, raffle.Set(raffle, bot.Bunt)
+}
+
+// cancelRaffleHandler refunds all tickets. Only the creator can cancel a raffle.
+func (bot *TipBot) cancelRaffleHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	tx := &Raffle{Base: storage.New(storage.ID(c.Data))}
+	mutex.LockWithContext(ctx, tx.ID)
+	defer mutex.UnlockWithContext(ctx, tx.ID)
+	rn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return ctx, err
+	}
+	raffle := rn.(*Raffle)
+	if c.Sender.ID != raffle.Creator.Telegram.ID || !raffle.Active || raffle.State != RaffleStateOpen {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	raffle.State = RaffleStateCancelled
+	err = bot.refundRaffle(raffle)
+	runtime.IgnoreError(raffle.Set(raffle, bot.Bunt))
+	return ctx, err
+}
+
+// refundRaffle pays back all tickets that have not been refunded yet. The raffle must be locked.
+func (bot *TipBot) refundRaffle(raffle *Raffle) error {
+	raffleWallet, err := bot.loadHoldingWallet(holdingWalletUserID(raffle.WalletUserID))
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for i := range raffle.Tickets {
+		ticket := &raffle.Tickets[i]
+		if ticket.Refunded {
+			continue
+		}
+		t := NewTransaction(bot, raffleWallet, ticket.User, raffle.TicketPrice, TransactionType("raffle"))
+		t.Memo = fmt.Sprintf("🎟 Raffle %s refund.", raffle.ShortID())
+		// save before paying so that a crash can't refund twice
+		ticket.Refunded = true
+		if err := raffle.Set(raffle, bot.Bunt); err != nil {
+			ticket.Refunded = false
+			return err
+		}
+		success, err := t.Send()
+		if !success {
+			if err == nil {
+				err = fmt.Errorf("transaction failed")
+			}
+			log.Errorf("[raffle] could not refund ticket #%d of %s: %v", i+1, raffle.ID, err)
+			ticket.Refunded = false
+			lastErr = err
+			continue
+		}
+		bot.trySendMessage(ticket.User.Telegram, fmt.Sprintf(raffleRefundMessage, i+1, raffle.ShortID(), raffle.TicketPrice))
+	}
+	if lastErr != nil {
+		// the watcher tries again
+		return lastErr
+	}
+	log.Infof("[raffle] Raffle %s cancelled, %d tickets refunded", raffle.ID, len(raffle.Tickets))
+	raffle.Active = false
+	bot.tryEditStack(raffle.Message, raffle.ID, fmt.Sprintf(raffleCancelledMessage, raffle.ShortID()), &tb.ReplyMarkup{})
+	return nil
+}
+
+// drawRaffle draws the winner and pays out the pot. The raffle must be locked.
+func (bot *TipBot) drawRaffle(raffle *Raffle) error {
+	if len(raffle.Tickets) == 0 {
+		raffle.State = RaffleStateEmpty
+		raffle.Active = false
+		bot.tryEditStack(raffle.Message, raffle.ID, fmt.Sprintf(raffleNoTicketsMessage, raffle.ShortID()), &tb.ReplyMarkup{})
+		return nil
+	}
+	if raffle.State == RaffleStateOpen {
+		raffle.TicketsHash = raffle.ticketsHash()
+		raffle.WinningTicket = drawRaffleTicket(raffle.Seed, raffle.ShortID(), raffle.TicketsHash, len(raffle.Tickets))
+		raffle.State = RaffleStateDrawn
+		raffle.DrawnAt = time.Now()
+		log.Infof("[raffle] Raffle %s drawn: ticket #%d of %d wins", raffle.ID, raffle.WinningTicket, len(raffle.Tickets))
+	}
+	raffleWallet, err := bot.loadHoldingWallet(holdingWalletUserID(raffle.WalletUserID))
+	if err != nil {
+		return err
+	}
+	winner := raffle.Winner()
+	prize := raffle.Pot() - raffle.Cut()
+	if !raffle.WinnerPaid {
+		// save the draw before paying so that a crash can't pay twice
+		raffle.WinnerPaid = true
+		if err := raffle.Set(raffle, bot.Bunt); err != nil {
+			raffle.WinnerPaid = false
+			return err
+		}
+		t := NewTransaction(bot, raffleWallet, winner, prize, TransactionType("raffle"))
+		t.Memo = fmt.Sprintf("🎟 Raffle %s prize.", raffle.ShortID())
+		success, err := t.Send()
+		if !success {
+			if err == nil {
+				err = fmt.Errorf("transaction failed")
+			}
+			log.Errorf("[raffle] could not pay the winner of %s: %v", raffle.ID, err)
+			raffle.WinnerPaid = false
+			return err
+		}
+		bot.trySendMessage(winner.Telegram, fmt.Sprintf(raffleWinnerMessage, prize, raffle.ShortID()))
+	}
+	if !raffle.CutPaid && raffle.Cut() > 0 {
+		raffle.CutPaid = true
+		if err := raffle.Set(raffle, bot.Bunt); err != nil {
+			raffle.CutPaid = false
+			return err
+		}
+		t := NewTransaction(bot, raffleWallet, raffle.Creator, raffle.Cut(), TransactionType("raffle"))
+		t.Memo = fmt.Sprintf("🎟 Raffle %s creator cut.", raffle.ShortID())
+		success, err := t.Send()
+		if !success {
+			if err == nil {
+				err = fmt.Errorf("transaction failed")
+			}
+			log.Errorf("[raffle] could not pay the creator cut of %s: %v", raffle.ID, err)
+			raffle.CutPaid = false
+			return err
+		}
+		bot.trySendMessage(raffle.Creator.Telegram, fmt.Sprintf(raffleCreatorCutMessage, raffle.ShortID(), raffle.Cut()))
+	}
+	raffle.CutPaid = true
+	raffle.Active = false
+	bot.tryEditStack(raffle.Message, raffle.ID, fmt.Sprintf(raffleDrawnMessage, raffle.ShortID(), GetUserStrMd(raffle.Creator.Telegram),
+		GetUserStrMd(winner.Telegram), raffle.WinningTicket, len(raffle.Tickets), prize, raffle.Seed, raffle.Commitment, raffle.ShortID()), &tb.ReplyMarkup{})
+	return nil
+}
+
+// loadRaffles returns all active raffles
+func (bot *TipBot) loadRaffles() []*Raffle {
+	var raffles []*Raffle
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend(RaffleIndex, func(key, value string) bool {
+			r := &Raffle{}
+			err := json.Unmarshal([]byte(value), r)
+			if err != nil || r.Base == nil || !r.Active || r.Creator == nil {
+				return true
+			}
+			raffles = append(raffles, r)
+			return true // continue iteration
+		})
+	})
+	return raffles
+}
+
+// startRaffleWatcher draws raffles at their deadline, retries failed payouts
+// and keeps the time left of open raffles up to date.
+func (bot *TipBot) startRaffleWatcher() {
+	runtime.Watch(raffleTickerDuration, bot.loadRaffles, func(r *Raffle) {
+		bot.updateRaffle(r.ID)
+	})
+}
+
+func (bot *TipBot) updateRaffle(id string) {
+	tx := &Raffle{Base: storage.New(storage.ID(id))}
+	mutex.Lock(tx.ID)
+	defer mutex.Unlock(tx.ID)
+	rn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		log.Errorf("[raffle] could not load %s: %v", id, err)
+		return
+	}
+	raffle := rn.(*Raffle)
+	if !raffle.Active {
+		return
+	}
+	switch {
+	case raffle.State == RaffleStateCancelled:
+		runtime.IgnoreError(bot.refundRaffle(raffle))
+	case raffle.State == RaffleStateDrawn || time.Now().After(raffle.Deadline):
+		runtime.IgnoreError(bot.drawRaffle(raffle))
+	default:
+		// refresh the time left
+		text := raffle.messageText()
+		if text == raffle.Text {
+			return
+		}
+		raffle.Text = text
+		bot.tryEditStack(raffle.Message, raffle.ID, raffle.Text, raffle.keyboard())
+	}
+	runtime.IgnoreError(raffle.Set(raffle, bot.Bunt))
+}
@@ -0,0 +1,106 @@
+package telegram
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// reactionAllowedUpdates are the updates the bot receives. message_reaction
+// updates are only sent if they are requested explicitly.
+var reactionAllowedUpdates = []string{
+	"message",
+	"edited_message",
+	"channel_post",
+	"edited_channel_post",
+	"inline_query",
+	"chosen_inline_result",
+	"callback_query",
+	"shipping_query",
+	"pre_checkout_query",
+	"poll",
+	"poll_answer",
+	"my_chat_member",
+	"chat_join_request",
+	"message_reaction",
+}
+
+// ReactionType is an emoji reaction of a message_reaction update
+type ReactionType struct {
+	Type          string `json:"type"`
+	Emoji         string `json:"emoji"`
+	CustomEmojiID string `json:"custom_emoji_id"`
+}
+
+// MessageReaction is a change of the reactions of a user to a message
+type MessageReaction struct {
+	Chat        *tb.Chat       `json:"chat"`
+	MessageID   int            `json:"message_id"`
+	User        *tb.User       `json:"user"`
+	Date        int64          `json:"date"`
+	OldReaction []ReactionType `json:"old_reaction"`
+	NewReaction []ReactionType `json:"new_reaction"`
+}
+
+type reactionUpdate struct {
+	tb.Update
+	MessageReaction *MessageReaction `json:"message_reaction,omitempty"`
+}
+
+// ReactionPoller is a long poller that also receives message_reaction updates,
+// which telebot doesn't know about.
+type ReactionPoller struct {
+	Timeout      time.Duration
+	LastUpdateID int
+	// OnMessage is called for every new message
+	OnMessage func(m *tb.Message)
+	// OnReaction is called for every message_reaction update
+	OnReaction func(r *MessageReaction)
+}
+
+// Poll does long polling.
+func (p *ReactionPoller) Poll(b *tb.Bot, dest chan tb.Update, stop chan struct{}) {
+	allowed, _ := json.Marshal(reactionAllowedUpdates)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		params := map[string]string{
+			"offset":          strconv.Itoa(p.LastUpdateID + 1),
+			"timeout":         strconv.Itoa(int(p.Timeout / time.Second)),
+			"allowed_updates": string(allowed),
+		}
+		data, err := b.Raw("getUpdates", params)
+		if err != nil {
+			log.Debugf("[ReactionPoller] %v", err)
+			continue
+		}
+		var resp struct {
+			Result []reactionUpdate
+		}
+		if err := json.Unmarshal(data, &resp); err != nil {
+			log.Errorf("[ReactionPoller] %v", err)
+			continue
+		}
+
+		for _, update := range resp.Result {
+			p.LastUpdateID = update.ID
+			if update.MessageReaction != nil {
+				if p.OnReaction != nil {
+					go p.OnReaction(update.MessageReaction)
+				}
+				continue
+			}
+			if update.Message != nil && p.OnMessage != nil {
+				p.OnMessage(update.Message)
+			}
+			dest <- update.Update
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package telegram
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eko/gocache/store"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	reactionTipsHelpMessage    = "😍 *Reaction tips*\n\nReact to a message in a group to tip its author.\n\n`/set reaction <emoji> <amount>` Tip with this reaction, e.g. `/set reaction ⚡ 21`.\n`/set reaction <emoji> off` Stop tipping with this reaction.\n`/set reactioncap <amount>` Change your daily maximum (default %d sat).\n\n%s"
+	reactionTipsListMessage    = "*Your reactions:*\n%s\nDaily maximum: %d sat"
+	reactionTipsEmptyMessage   = "You have no reaction tips yet."
+	reactionTipsSavedMessage   = "✅ Reacting with %s tips %d sat."
+	reactionTipsRemovedMessage = "✅ Reacting with %s doesn't tip anymore."
+	reactionTipsCapMessage     = "✅ You tip at most %d sat per day with reactions."
+	reactionTipsTooManyMessage = "🚫 You can set up to %d reactions."
+	reactionTipsCapReached     = "🚫 Your reaction tip of %d sat was not sent, you reached your daily maximum of %d sat. Change it with `/set reactioncap <amount>`."
+	reactionTipsLimitMessage   = "🚫 Your reaction tip of %d sat was not sent because of your spending limits."
+)
+
+const (
+	reactionTipsDefaultDailyCap = 1000
+	reactionTipsMaxEmojis       = 20
+	reactionTipsAuthorCacheTime = 48 * time.Hour
+	reactionTipsWindow          = 24 * time.Hour
+	reactionTransactionType     = "reaction"
+)
+
+// normalizeEmoji removes the variation selector, Telegram sends reactions without it
+func normalizeEmoji(emoji string) string {
+	return strings.ReplaceAll(emoji, "\ufe0f", "")
+}
+
+// parseReactionTips parses the stored reaction settings of the form "⚡:21,🔥:100"
+func parseReactionTips(tips string) map[string]int64 {
+	amounts := make(map[string]int64)
+	for _, tip := range strings.Split(tips, ",") {
+		emoji, amountStr, ok := strings.Cut(tip, ":")
+		if !ok {
+			continue
+		}
+		amount, err := strconv.ParseInt(amountStr, 10, 64)
+		if err != nil || amount < 1 {
+			continue
+		}
+		amounts[emoji] = amount
+	}
+	return amounts
+}
+
+func formatReactionTips(amounts map[string]int64) string {
+	var tips []string
+	for emoji, amount := range amounts {
+		tips = append(tips, fmt.Sprintf("%s:%d", emoji, amount))
+	}
+	sort.Strings(tips)
+	return strings.Join(tips, ",")
+}
+
+func reactionTipsDailyCap(settings lnbits.ReactionSettings) int64 {
+	if settings.DailyCap > 0 {
+		return settings.DailyCap
+	}
+	return reactionTipsDefaultDailyCap
+}
+
+func reactionAuthorCacheKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("reaction-author:%d:%d", chatID, messageID)
+}
+
+// reactionTippedCacheKey remembers that a reaction of a user already tipped a message
+func reactionTippedCacheKey(chatID int64, messageID int, userID int64, emoji string) string {
+	return fmt.Sprintf("reaction-tipped:%d:%d:%d:%s", chatID, messageID, userID, emoji)
+}
+
+// rememberMessageAuthor caches the sender of group messages. message_reaction
+// updates don't contain the author of the message.
+func (bot *TipBot) rememberMessageAuthor(m *tb.Message) {
+	if m.Private() || m.Sender == nil || m.Chat == nil {
+		return
+	}
+	bot.Cache.Set(reactionAuthorCacheKey(m.Chat.ID, m.ID), m.Sender, &store.Options{Expiration: reactionTipsAuthorCacheTime})
+}
+
+// reactionTipsSpentToday sums the reaction tips of user in the last 24 hours
+func (bot *TipBot) reactionTipsSpentToday(user *lnbits.User) (int64, error) {
+	var spent int64
+	tx := bot.DB.Transactions.Model(&Transaction{}).
+		Select("coalesce(sum(amount), 0)").
+		Where("from_id = ? AND type = ? AND success = ? AND time >= ?", user.Telegram.ID, reactionTransactionType, true, time.Now().Add(-reactionTipsWindow)).
+		Scan(&spent)
+	return spent, tx.Error
+}
+
+// messageReactionHandler tips the author of a message for every newly added reaction that the user mapped to an amount
+func (bot *TipBot) messageReactionHandler(r *MessageReaction) {
+	if r.User == nil || r.User.IsBot || r.Chat == nil || r.Chat.Type == tb.ChatPrivate {
+		return
+	}
+	var added []string
+	for _, reaction := range r.NewReaction {
+		if reaction.Type != "emoji" {
+			continue
+		}
+		isNew := true
+		for _, old := range r.OldReaction {
+			if old.Type == reaction.Type && old.Emoji == reaction.Emoji {
+				isNew = false
+			}
+		}
+		if isNew {
+			added = append(added, normalizeEmoji(reaction.Emoji))
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+	from, err := GetLnbitsUserWithSettings(r.User, *bot)
+	if err != nil || from.Wallet == nil {
+		return
+	}
+	amounts := parseReactionTips(from.Settings.Reactions.Tips)
+	if len(amounts) == 0 {
+		return
+	}
+	author, err := bot.Cache.Get(reactionAuthorCacheKey(r.Chat.ID, r.MessageID))
+	if err != nil {
+		log.Debugf("[reaction] unknown author of message %d in %d", r.MessageID, r.Chat.ID)
+		return
+	}
+	// tip one reaction after the other to keep the daily cap
+	mutex.Lock(fmt.Sprintf("reaction-tip:%d", r.User.ID))
+	defer mutex.Unlock(fmt.Sprintf("reaction-tip:%d", r.User.ID))
+	for _, emoji := range added {
+		amount, ok := amounts[emoji]
+		if !ok {
+			continue
+		}
+		// removing and adding a reaction again doesn't tip again
+		tippedKey := reactionTippedCacheKey(r.Chat.ID, r.MessageID, r.User.ID, emoji)
+		if _, err := bot.Cache.Get(tippedKey); err == nil {
+			continue
+		}
+		if bot.reactionTip(r, from, author.(*tb.User), amount) {
+			bot.Cache.Set(tippedKey, true, &store.Options{Expiration: reactionTipsAuthorCacheTime})
+		}
+	}
+}
+
+// reactionTip tips the author of the message and returns true if the tip was sent
+func (bot *TipBot) reactionTip(r *MessageReaction, from *lnbits.User, author *tb.User, amount int64) bool {
+	dailyCap := reactionTipsDailyCap(from.Settings.Reactions)
+	spent, err := bot.reactionTipsSpentToday(from)
+	if err != nil {
+		log.Errorf("[reaction] could not sum reaction tips of %s: %v", GetUserStr(from.Telegram), err)
+		return false
+	}
+	if spent+amount > dailyCap {
+		log.Infof("[reaction] %s reached the daily maximum of %d sat", GetUserStr(from.Telegram), dailyCap)
+		bot.trySendMessage(from.Telegram, fmt.Sprintf(reactionTipsCapReached, amount, dailyCap))
+		return false
+	}
+	// reaction tips can't be confirmed, Transaction.Send refuses them above the confirmation threshold
+	if err := bot.checkSpendingLimits(from, amount, "reaction tip", "", false); err != nil {
+		bot.trySendMessage(from.Telegram, fmt.Sprintf(reactionTipsLimitMessage, amount))
+		return false
+	}
+
+	to := &lnbits.User{Telegram: author}
+	if author.ID == bot.Telegram.Me.ID {
+		// reactions to messages of the bot go to the group treasury
+		treasuryUser, err := bot.loadTreasuryUser(r.Chat.ID)
+		if err != nil {
+			return false
+		}
+		to = treasuryUser
+	} else if author.IsBot {
+		return false
+	}
+	if from.Telegram.ID == to.Telegram.ID {
+		return false
+	}
+	toUserStr := GetUserStr(to.Telegram)
+	fromUserStr := GetUserStr(from.Telegram)
+	if user, exists := bot.UserExists(to.Telegram); exists {
+		to = user
+	} else {
+		log.Infof("[reaction] User %s has no wallet.", toUserStr)
+		to, err = bot.CreateWalletForTelegramUser(to.Telegram)
+		if err != nil {
+			log.Errorf("[reaction] Could not create wallet for %s", toUserStr)
+			return false
+		}
+	}
+
+	t := NewTransaction(bot, from, to, amount, TransactionType(reactionTransactionType), TransactionChat(r.Chat))
+	t.Memo = fmt.Sprintf("🏅 Reaction tip from %s to %s.", fromUserStr, toUserStr)
+	success, err := t.Send()
+	if !success {
+		log.Warnf("[reaction] Transaction failed: %v", err)
+		bot.trySendMessage(from.Telegram, i18n.Translate(from.Telegram.LanguageCode, "tipErrorMessage"))
+		return false
+	}
+	log.Infof("[💸 reaction] Tip from %s to %s (%d sat).", fromUserStr, toUserStr, amount)
+
+	// the tooltip is a reply to the tipped message
+	m := &tb.Message{Chat: r.Chat, Sender: from.Telegram, ReplyTo: &tb.Message{ID: r.MessageID, Chat: r.Chat}}
+	tipTooltipHandler(m, bot, amount, to.Initialized)
+
+	bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "tipSentMessage"), amount, GetUserStrMd(to.Telegram))+bot.fiatValueText(from, amount, time.Now()))
+	tipReceivedMessage := fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "tipReceivedMessage"), GetUserStrMd(from.Telegram), amount) + bot.fiatValueText(to, amount, time.Now())
+	bot.trySendMessage(to.Telegram, tipReceivedMessage)
+	bot.sendNostrDirectMessage(to.Telegram, tipReceivedMessage)
+	return true
+}
+
+// reactionTipsSettingHandler handles /set reaction <emoji> <amount|off>, /set reactions and /set reactioncap <amount>
+func (bot *TipBot) reactionTipsSettingHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	settings := &user.Settings.Reactions
+	amounts := parseReactionTips(settings.Tips)
+	arguments := strings.Fields(m.Text)
+	command := strings.ToLower(arguments[1])
+	switch {
+	case command == "reactioncap" && len(arguments) == 3:
+		dailyCap, err := GetAmount(arguments[2])
+		if err != nil || dailyCap < 1 {
+			bot.trySendMessage(m.Sender, Translate(ctx, "lnurlInvalidAmountMessage"))
+			return ctx, err
+		}
+		settings.DailyCap = dailyCap
+		bot.trySendMessage(m.Sender, fmt.Sprintf(reactionTipsCapMessage, dailyCap))
+	case command == "reaction" && len(arguments) == 4:
+		emoji := normalizeEmoji(arguments[2])
+		if strings.ContainsAny(emoji, ":,") {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(reactionTipsHelpMessage, reactionTipsDefaultDailyCap, ""))
+			return ctx, fmt.Errorf("invalid emoji")
+		}
+		if strings.ToLower(arguments[3]) == "off" {
+			delete(amounts, emoji)
+			bot.trySendMessage(m.Sender, fmt.Sprintf(reactionTipsRemovedMessage, emoji))
+			break
+		}
+		amount, err := GetAmount(arguments[3])
+		if err != nil || amount < 1 {
+			bot.trySendMessage(m.Sender, Translate(ctx, "lnurlInvalidAmountMessage"))
+			return ctx, err
+		}
+		if _, exists := amounts[emoji]; !exists && len(amounts) >= reactionTipsMaxEmojis {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(reactionTipsTooManyMessage, reactionTipsMaxEmojis))
+			return ctx, fmt.Errorf("too many reactions")
+		}
+		amounts[emoji] = amount
+		bot.trySendMessage(m.Sender, fmt.Sprintf(reactionTipsSavedMessage, emoji, amount))
+	default:
+		list := reactionTipsEmptyMessage
+		if len(amounts) > 0 {
+			entries := ""
+			for _, tip := range strings.Split(formatReactionTips(amounts), ",") {
+				emoji, amount, _ := strings.Cut(tip, ":")
+				entries += fmt.Sprintf("%s %s sat\n", emoji, amount)
+			}
+			list = fmt.Sprintf(reactionTipsListMessage, entries, reactionTipsDailyCap(*settings))
+		}
+		bot.trySendMessage(m.Sender, fmt.Sprintf(reactionTipsHelpMessage, reactionTipsDefaultDailyCap, list))
+		return ctx, nil
+	}
+	settings.Tips = formatReactionTips(amounts)
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[reactionTipsSettingHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	return ctx, nil
+}
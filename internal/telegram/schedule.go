@@ -0,0 +1,458 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	"github.com/massmux/SatsMobiBot/pkg/lightning"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+)
+
+var (
+	scheduleHelpMessage         = "📅 *Scheduled payments*\n\n`/schedule <daily|weekly|monthly> [<YYYY-MM-DD[THH:MM]>] <amount> <@user|address|lnurl> [<memo>]` 🔁 Recurring payment, starting one interval from now or at the given time (UTC).\n`/schedule <YYYY-MM-DD[THH:MM]> <amount> <@user|address|lnurl> [<memo>]` 📆 One-off payment (UTC).\n`/schedule cron <min> <hour> <day> <month> <weekday> <amount> <@user|address|lnurl> [<memo>]` ⏰ Cron-style payment (UTC).\n`/schedule list` 📖 List your scheduled payments.\n`/schedule cancel <id>` 🚫 Cancel a scheduled payment."
+	scheduleCreatedMessage      = "✅ *Payment scheduled.*\n\nID: `%s`\n%d sat to %s (%s)\nNext payment: `%s`"
+	scheduleListMessage         = "📅 *Your scheduled payments:*\n"
+	scheduleListEntryMessage    = "\n`%s`: %d sat to %s (%s), next: `%s`"
+	scheduleListEmptyMessage    = "📅 You have no scheduled payments."
+	scheduleCanceledMessage     = "🚫 Scheduled payment `%s` canceled."
+	scheduleNotFoundMessage     = "🚫 Scheduled payment not found."
+	scheduleInvalidTimeMessage  = "🚫 Could not parse the schedule. Use daily, weekly, monthly, a date like `2024-12-24T18:00` or `cron <min> <hour> <day> <month> <weekday>`."
+	scheduleInvalidToMessage    = "🚫 Recipient must be a @user, a lightning address or a LNURL."
+	scheduleExecutedMessage     = "📅 Scheduled payment `%s`: sent %d sat to %s."
+	scheduleBalanceLowMessage   = "📅 Scheduled payment `%s` of %d sat to %s failed: your balance is too low. I will try again in %s."
+	scheduleRetryFailedMessage  = "📅 Scheduled payment `%s` of %d sat to %s failed: %s. I will try again in %s."
+	scheduleGivingUpMessage     = "🚫 Scheduled payment `%s` of %d sat to %s failed %d times and was skipped."
+	scheduleConfirmationMessage = "🚫 Scheduled payment `%s` of %d sat to %s was skipped because it needs your PIN or TOTP code. Change `/set confirm` or cancel it with `/schedule cancel %s`."
+	scheduleTooManyMessage      = "🚫 You can have at most %d scheduled payments."
+)
+
+const (
+	scheduleTimeLayout         = "2006-01-02 15:04 UTC"
+	scheduleTickerDuration     = time.Minute
+	scheduleRetryDuration      = 15 * time.Minute
+	scheduleMaxRetries         = 3
+	scheduleMaxPaymentsPerUser = 20
+)
+
+const (
+	ScheduleIntervalOnce    = "once"
+	ScheduleIntervalDaily   = "daily"
+	ScheduleIntervalWeekly  = "weekly"
+	ScheduleIntervalMonthly = "monthly"
+	ScheduleIntervalCron    = "cron"
+)
+
+// ScheduledPayment is a one-off or recurring payment that is executed by the scheduler
+type ScheduledPayment struct {
+	*storage.Base
+	From         *lnbits.User `json:"from"`
+	To           *lnbits.User `json:"to,omitempty"`
+	Address      string       `json:"address,omitempty"`
+	Amount       int64        `json:"amount"`
+	Memo         string       `json:"memo"`
+	Interval     string       `json:"interval"`
+	Cron         string       `json:"cron,omitempty"`
+	Anchor       time.Time    `json:"anchor"`
+	NextRun      time.Time    `json:"next_run"`
+	RetryAt      time.Time    `json:"retry_at,omitempty"`
+	Retries      int          `json:"retries"`
+	Executions   int          `json:"executions"`
+	LanguageCode string       `json:"languagecode"`
+}
+
+// ShortID is the ID shown to the user
+func (s *ScheduledPayment) ShortID() string {
+	return strings.TrimPrefix(s.ID, "schedule:")
+}
+
+// Recipient returns a printable recipient of the payment
+func (s *ScheduledPayment) Recipient() string {
+	if s.To != nil {
+		return GetUserStrMd(s.To.Telegram)
+	}
+	return fmt.Sprintf("`%s`", s.Address)
+}
+
+// Description returns a printable description of the interval
+func (s *ScheduledPayment) Description() string {
+	if s.Interval == ScheduleIntervalCron {
+		return fmt.Sprintf("cron `%s`", s.Cron)
+	}
+	return s.Interval
+}
+
+// nextAttempt returns the time of the next payment attempt, which is a retry after a failed run
+func (s *ScheduledPayment) nextAttempt() time.Time {
+	if !s.RetryAt.IsZero() {
+		return s.RetryAt
+	}
+	return s.NextRun
+}
+
+// nextRunAfter returns the next execution time after the run at t.
+// A zero time means there is no further execution.
+func (s *ScheduledPayment) nextRunAfter(t time.Time) time.Time {
+	switch s.Interval {
+	case ScheduleIntervalDaily:
+		return t.AddDate(0, 0, 1)
+	case ScheduleIntervalWeekly:
+		return t.AddDate(0, 0, 7)
+	case ScheduleIntervalMonthly:
+		// keep the day of the first run, clamped to the last day of shorter months
+		anchor := s.Anchor
+		if anchor.IsZero() {
+			anchor = t
+		}
+		year, month, _ := t.Date()
+		month++
+		lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, t.Location()).Day()
+		day := anchor.Day()
+		if day > lastDay {
+			day = lastDay
+		}
+		return time.Date(year, month, day, anchor.Hour(), anchor.Minute(), 0, 0, t.Location())
+	case ScheduleIntervalCron:
+		c, err := runtime.ParseCron(s.Cron)
+		if err != nil {
+			return time.Time{}
+		}
+		return c.Next(t)
+	}
+	return time.Time{}
+}
+
+// parseScheduleTime parses the schedule arguments of a /schedule command.
+// It returns the interval, the cron expression, the first execution
+// and the index of the amount argument.
+func parseScheduleTime(splits []string) (interval string, cron string, nextRun time.Time, amountIdx int, err error) {
+	if len(splits) < 2 {
+		return "", "", time.Time{}, 0, fmt.Errorf("no schedule given")
+	}
+	now := time.Now().UTC()
+	switch strings.ToLower(splits[1]) {
+	case ScheduleIntervalDaily, ScheduleIntervalWeekly, ScheduleIntervalMonthly:
+		interval = strings.ToLower(splits[1])
+		// an optional start time, otherwise the first payment is one interval from now
+		if len(splits) > 2 {
+			if start, ok := parseScheduleDate(splits[2]); ok {
+				if start.Before(now) {
+					return "", "", time.Time{}, 0, fmt.Errorf("date is in the past")
+				}
+				return interval, "", start, 3, nil
+			}
+		}
+		s := &ScheduledPayment{Interval: interval, Anchor: now}
+		return interval, "", s.nextRunAfter(now), 2, nil
+	case ScheduleIntervalCron:
+		if len(splits) < 7 {
+			return "", "", time.Time{}, 0, fmt.Errorf("cron expression incomplete")
+		}
+		cron = strings.Join(splits[2:7], " ")
+		c, err := runtime.ParseCron(cron)
+		if err != nil {
+			return "", "", time.Time{}, 0, err
+		}
+		nextRun = c.Next(now)
+		if nextRun.IsZero() {
+			return "", "", time.Time{}, 0, fmt.Errorf("cron expression never matches")
+		}
+		return ScheduleIntervalCron, cron, nextRun, 7, nil
+	}
+	nextRun, ok := parseScheduleDate(splits[1])
+	if !ok {
+		return "", "", time.Time{}, 0, fmt.Errorf("invalid schedule %s", splits[1])
+	}
+	if nextRun.Before(now) {
+		return "", "", time.Time{}, 0, fmt.Errorf("date is in the past")
+	}
+	return ScheduleIntervalOnce, "", nextRun, 2, nil
+}
+
+// parseScheduleDate parses a UTC date like 2024-12-24 or 2024-12-24T18:00
+func parseScheduleDate(input string) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02"} {
+		t, err := time.ParseInLocation(layout, input, time.UTC)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// scheduleHandler handles the /schedule command
+func (bot *TipBot) scheduleHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	splits := strings.Split(m.Text, " ")
+	if len(splits) < 2 {
+		bot.trySendMessage(m.Sender, scheduleHelpMessage)
+		return ctx, nil
+	}
+	switch strings.ToLower(splits[1]) {
+	case "list":
+		return bot.scheduleListHandler(ctx)
+	case "cancel":
+		return bot.scheduleCancelHandler(ctx)
+	case "help":
+		bot.trySendMessage(m.Sender, scheduleHelpMessage)
+		return ctx, nil
+	}
+	return bot.scheduleCreateHandler(ctx)
+}
+
+func (bot *TipBot) scheduleCreateHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	splits := strings.Split(m.Text, " ")
+	interval, cron, nextRun, amountIdx, err := parseScheduleTime(splits)
+	if err != nil {
+		bot.trySendMessage(m.Sender, scheduleInvalidTimeMessage)
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	if len(splits) < amountIdx+2 {
+		bot.trySendMessage(m.Sender, scheduleHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(splits[amountIdx])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "lnurlInvalidAmountMessage"))
+		return ctx, errors.New(errors.InvalidAmountError, err)
+	}
+	if err = bot.refuseAutomaticPayment(user, m.Sender, amount); err != nil {
+		return ctx, err
+	}
+	if len(bot.getScheduledPayments(user)) >= scheduleMaxPaymentsPerUser {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(scheduleTooManyMessage, scheduleMaxPaymentsPerUser))
+		return ctx, fmt.Errorf("too many scheduled payments")
+	}
+
+	id := fmt.Sprintf("schedule:%s", RandStringRunes(8))
+	scheduledPayment := &ScheduledPayment{
+		Base:         storage.New(storage.ID(id)),
+		From:         user,
+		Amount:       amount,
+		Memo:         GetMemoFromCommand(m.Text, amountIdx+2),
+		Interval:     interval,
+		Cron:         cron,
+		Anchor:       nextRun,
+		NextRun:      nextRun,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	recipient := splits[amountIdx+1]
+	switch {
+	case strings.HasPrefix(recipient, "@"):
+		to, err := GetUserByTelegramUsername(strings.TrimPrefix(recipient, "@"), *bot)
+		if err != nil {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape(recipient)))
+			return ctx, err
+		}
+		if to.Telegram.ID == user.Telegram.ID {
+			bot.trySendMessage(m.Sender, Translate(ctx, "sendYourselfMessage"))
+			return ctx, errors.Create(errors.SelfPaymentError)
+		}
+		scheduledPayment.To = to
+	case lightning.IsLightningAddress(recipient), lightning.IsLnurl(recipient):
+		scheduledPayment.Address = strings.TrimPrefix(recipient, "lightning:")
+	default:
+		bot.trySendMessage(m.Sender, scheduleInvalidToMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+
+	runtime.IgnoreError(scheduledPayment.Set(scheduledPayment, bot.Bunt))
+	log.Infof("[schedule] %s scheduled %d sat to %s (%s)", GetUserStr(user.Telegram), amount, recipient, scheduledPayment.Description())
+	bot.trySendMessage(m.Sender, fmt.Sprintf(scheduleCreatedMessage, scheduledPayment.ShortID(), amount, scheduledPayment.Recipient(), scheduledPayment.Description(), nextRun.UTC().Format(scheduleTimeLayout)))
+	return ctx, nil
+}
+
+func (bot *TipBot) scheduleListHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	scheduledPayments := bot.getScheduledPayments(user)
+	if len(scheduledPayments) == 0 {
+		bot.trySendMessage(m.Sender, scheduleListEmptyMessage)
+		return ctx, nil
+	}
+	message := scheduleListMessage
+	for _, s := range scheduledPayments {
+		message += fmt.Sprintf(scheduleListEntryMessage, s.ShortID(), s.Amount, s.Recipient(), s.Description(), s.nextAttempt().UTC().Format(scheduleTimeLayout))
+	}
+	bot.trySendMessage(m.Sender, message)
+	return ctx, nil
+}
+
+func (bot *TipBot) scheduleCancelHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	shortID, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		bot.trySendMessage(m.Sender, scheduleHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	tx := &ScheduledPayment{Base: storage.New(storage.ID(fmt.Sprintf("schedule:%s", shortID)))}
+	mutex.LockWithContext(ctx, tx.ID)
+	defer mutex.UnlockWithContext(ctx, tx.ID)
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		bot.trySendMessage(m.Sender, scheduleNotFoundMessage)
+		return ctx, err
+	}
+	scheduledPayment := sn.(*ScheduledPayment)
+	if !scheduledPayment.Active || scheduledPayment.From.Telegram.ID != user.Telegram.ID {
+		bot.trySendMessage(m.Sender, scheduleNotFoundMessage)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	scheduledPayment.Canceled = true
+	runtime.IgnoreError(scheduledPayment.Inactivate(scheduledPayment, bot.Bunt))
+	log.Infof("[schedule] %s canceled scheduled payment %s", GetUserStr(user.Telegram), scheduledPayment.ID)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(scheduleCanceledMessage, scheduledPayment.ShortID()))
+	return ctx, nil
+}
+
+// getScheduledPayments returns all active scheduled payments of a user
+func (bot *TipBot) getScheduledPayments(user *lnbits.User) []*ScheduledPayment {
+	var scheduledPayments []*ScheduledPayment
+	for _, s := range bot.loadScheduledPayments() {
+		if s.From.Telegram.ID == user.Telegram.ID {
+			scheduledPayments = append(scheduledPayments, s)
+		}
+	}
+	return scheduledPayments
+}
+
+// loadScheduledPayments returns all active scheduled payments
+func (bot *TipBot) loadScheduledPayments() []*ScheduledPayment {
+	var scheduledPayments []*ScheduledPayment
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend(ScheduledPaymentIndex, func(key, value string) bool {
+			s := &ScheduledPayment{}
+			err := json.Unmarshal([]byte(value), s)
+			if err != nil || s.Base == nil || !s.Active || s.From == nil || s.From.Telegram == nil {
+				return true
+			}
+			scheduledPayments = append(scheduledPayments, s)
+			return true // continue iteration
+		})
+	})
+	return scheduledPayments
+}
+
+// startScheduler periodically executes all scheduled payments that are due
+func (bot *TipBot) startScheduler() {
+	runtime.Watch(scheduleTickerDuration, bot.loadScheduledPayments, func(s *ScheduledPayment) {
+		if !s.nextAttempt().After(time.Now()) {
+			bot.executeScheduledPayment(s.ID)
+		}
+	})
+}
+
+// executeScheduledPayment pays a due scheduled payment and computes its next execution
+func (bot *TipBot) executeScheduledPayment(id string) {
+	tx := &ScheduledPayment{Base: storage.New(storage.ID(id))}
+	mutex.Lock(tx.ID)
+	defer mutex.Unlock(tx.ID)
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		log.Errorf("[schedule] could not load %s: %v", id, err)
+		return
+	}
+	s := sn.(*ScheduledPayment)
+	// check again, the payment could have been canceled in the meantime
+	if !s.Active || s.nextAttempt().After(time.Now()) {
+		return
+	}
+	from, err := GetLnbitsUser(s.From.Telegram, *bot)
+	if err != nil || from.Wallet == nil {
+		log.Errorf("[schedule] could not load user %s: %v", GetUserStr(s.From.Telegram), err)
+		return
+	}
+	recipient := s.Recipient()
+
+	balanceTooLow := false
+	balance, err := bot.GetUserBalance(from)
+	if err == nil && balance < s.Amount {
+		balanceTooLow = true
+		err = errors.Create(errors.BalanceToLowError)
+	}
+	if err == nil {
+		err = bot.sendScheduledPayment(s, from)
+	}
+	if err == ErrSpendingConfirmationRequired {
+		// nobody can enter the code for a scheduled payment, a retry fails as well
+		log.Warnf("[schedule] %s needs a spending confirmation, skipping", s.ID)
+		bot.trySendMessage(from.Telegram, fmt.Sprintf(scheduleConfirmationMessage, s.ShortID(), s.Amount, recipient, s.ShortID()))
+	} else if err != nil {
+		s.Retries++
+		log.Warnf("[schedule] %s failed (%d/%d): %v", s.ID, s.Retries, scheduleMaxRetries, err)
+		if s.Retries <= scheduleMaxRetries {
+			// the retry does not move the schedule itself
+			s.RetryAt = time.Now().Add(scheduleRetryDuration * time.Duration(s.Retries))
+			retryIn := formatTimeLeft(time.Until(s.RetryAt))
+			if balanceTooLow {
+				bot.trySendMessage(from.Telegram, fmt.Sprintf(scheduleBalanceLowMessage, s.ShortID(), s.Amount, recipient, retryIn))
+			} else {
+				bot.trySendMessage(from.Telegram, fmt.Sprintf(scheduleRetryFailedMessage, s.ShortID(), s.Amount, recipient, str.MarkdownEscape(err.Error()), retryIn))
+			}
+			runtime.IgnoreError(s.Set(s, bot.Bunt))
+			return
+		}
+		bot.trySendMessage(from.Telegram, fmt.Sprintf(scheduleGivingUpMessage, s.ShortID(), s.Amount, recipient, s.Retries))
+	} else {
+		s.Executions++
+		bot.trySendMessage(from.Telegram, fmt.Sprintf(scheduleExecutedMessage, s.ShortID(), s.Amount, recipient))
+	}
+
+	// plan the next execution
+	s.Retries = 0
+	s.RetryAt = time.Time{}
+	s.NextRun = s.nextRunAfter(s.NextRun)
+	// skip executions that were missed while the bot was offline
+	for !s.NextRun.IsZero() && s.NextRun.Before(time.Now()) {
+		s.NextRun = s.nextRunAfter(s.NextRun)
+	}
+	if s.NextRun.IsZero() {
+		runtime.IgnoreError(s.Inactivate(s, bot.Bunt))
+		return
+	}
+	runtime.IgnoreError(s.Set(s, bot.Bunt))
+}
+
+// sendScheduledPayment pays the scheduled amount to a Telegram user or to a LNURL
+func (bot *TipBot) sendScheduledPayment(s *ScheduledPayment, from *lnbits.User) error {
+	if s.To != nil {
+		to, err := GetLnbitsUser(s.To.Telegram, *bot)
+		if err != nil || to.Wallet == nil {
+			return fmt.Errorf("recipient has no wallet")
+		}
+		t := NewTransaction(bot, from, to, s.Amount, TransactionType("scheduled"))
+		t.Memo = s.Memo
+		success, err := t.Send()
+		if !success {
+			if err == nil {
+				err = fmt.Errorf("transaction failed")
+			}
+			return err
+		}
+		sendReceivedMessage := fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), GetUserStrMd(from.Telegram), s.Amount) + bot.fiatValueText(to, s.Amount, time.Now())
+		bot.trySendMessage(to.Telegram, sendReceivedMessage)
+		bot.sendNostrDirectMessage(to.Telegram, sendReceivedMessage)
+		if len(s.Memo) > 0 {
+			bot.trySendMessage(to.Telegram, fmt.Sprintf("✉️ %s", str.MarkdownEscape(s.Memo)))
+		}
+		return nil
+	}
+
+	return bot.payLnurlAndLog(from, s.Address, s.Amount, s.Memo, TransactionType("scheduled"))
+}
@@ -0,0 +1,47 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduledPayment_nextRunAfter(t *testing.T) {
+	date := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	for _, v := range []struct {
+		name    string
+		payment ScheduledPayment
+		from    time.Time
+		want    time.Time
+	}{
+		{"once", ScheduledPayment{Interval: ScheduleIntervalOnce}, date(2024, 1, 1, 9), time.Time{}},
+		{"daily", ScheduledPayment{Interval: ScheduleIntervalDaily}, date(2024, 2, 28, 9), date(2024, 2, 29, 9)},
+		{"weekly", ScheduledPayment{Interval: ScheduleIntervalWeekly}, date(2024, 12, 30, 9), date(2025, 1, 6, 9)},
+		{"monthly", ScheduledPayment{Interval: ScheduleIntervalMonthly, Anchor: date(2024, 1, 15, 9)}, date(2024, 1, 15, 9), date(2024, 2, 15, 9)},
+		{"monthly clamped", ScheduledPayment{Interval: ScheduleIntervalMonthly, Anchor: date(2024, 1, 31, 9)}, date(2024, 1, 31, 9), date(2024, 2, 29, 9)},
+		{"monthly clamped non leap year", ScheduledPayment{Interval: ScheduleIntervalMonthly, Anchor: date(2025, 1, 31, 9)}, date(2025, 1, 31, 9), date(2025, 2, 28, 9)},
+		{"monthly keeps anchor day", ScheduledPayment{Interval: ScheduleIntervalMonthly, Anchor: date(2024, 1, 31, 9)}, date(2024, 2, 29, 9), date(2024, 3, 31, 9)},
+		{"monthly over year end", ScheduledPayment{Interval: ScheduleIntervalMonthly, Anchor: date(2024, 12, 31, 9)}, date(2024, 12, 31, 9), date(2025, 1, 31, 9)},
+		{"monthly without anchor", ScheduledPayment{Interval: ScheduleIntervalMonthly}, date(2024, 3, 31, 9), date(2024, 4, 30, 9)},
+		{"cron", ScheduledPayment{Interval: ScheduleIntervalCron, Cron: "0 9 * * 1"}, date(2024, 1, 31, 9), date(2024, 2, 5, 9)},
+		{"invalid cron", ScheduledPayment{Interval: ScheduleIntervalCron, Cron: "0 9 * *"}, date(2024, 1, 31, 9), time.Time{}},
+	} {
+		if got := v.payment.nextRunAfter(v.from); !got.Equal(v.want) {
+			t.Errorf("%s: nextRunAfter(%v) = %v, want %v", v.name, v.from, got, v.want)
+		}
+	}
+}
+
+func TestScheduledPayment_retryKeepsSchedule(t *testing.T) {
+	anchor := time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC)
+	s := ScheduledPayment{Interval: ScheduleIntervalMonthly, Anchor: anchor, NextRun: anchor}
+	s.RetryAt = anchor.Add(3 * time.Hour)
+	if got := s.nextAttempt(); !got.Equal(s.RetryAt) {
+		t.Errorf("nextAttempt() = %v, want retry at %v", got, s.RetryAt)
+	}
+	s.RetryAt = time.Time{}
+	if got, want := s.nextRunAfter(s.NextRun), time.Date(2024, 2, 29, 9, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("nextRunAfter() after retry = %v, want %v", got, want)
+	}
+}
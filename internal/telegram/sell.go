@@ -0,0 +1,235 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/almerlucke/go-iban/iban"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	sellConfirmationMenu = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnCancelSell        = sellConfirmationMenu.Data("🚫 Cancel", "cancel_sell")
+	btnSell              = sellConfirmationMenu.Data("✅ Sell", "confirm_sell")
+)
+
+var (
+	sellHelpMessage        = "🏦 *Sell sats*\n\n`/sell <amount> <IBAN>` Sell sats and receive the amount in %s by bank transfer to your IBAN."
+	sellConfirmMessage     = "🏦 *Sell sats*\n\nOrder: `%s`\nAmount: %s\nFee: %s%%\nPayout to `%s`: %s\nYou pay: %d sat\n\nPlease confirm within %d minutes."
+	sellNotAcceptedMessage = "🚫 The order was not accepted by the provider. Please try again later."
+	sellPaidMessage        = "✅ You paid %d sat for order `%s`. You will be notified when the bank payout was sent. Check the status with `/order %s`."
+	sellCanceledMessage    = "❌ Order `%s` was canceled."
+	sellExpiredMessage     = "⌛️ Order `%s` expired because it was not confirmed in time."
+)
+
+// sellConfirmTimeout is the time a user has to confirm a sell order before it is canceled at the provider
+const sellConfirmTimeout = 10 * time.Minute
+
+// sellHandler handles /sell <amount> <IBAN>
+func (bot *TipBot) sellHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	currency := internal.Configuration.Voucherbot.Currency
+	arguments := strings.Fields(m.Text)
+	if len(arguments) < 3 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(sellHelpMessage, currency))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	// accept the amount before or after the IBAN
+	amount, ok := parseVoucherAmount(arguments[1])
+	givenIban := arguments[2]
+	if !ok {
+		amount, ok = parseVoucherAmount(arguments[2])
+		givenIban = arguments[1]
+	}
+	if !ok {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(sellHelpMessage, currency))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	payoutIban, err := iban.NewIBAN(givenIban)
+	if err != nil {
+		log.Errorf("[/sell] Error: invalid IBAN provided: %s", err.Error())
+		bot.trySendMessage(m.Sender, Translate(ctx, "invalidIBANHelpText"))
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+
+	voucherbotManager := NewVoucherBot(voucherHTTPClient)
+	fee, err := voucherbotManager.sellFee()
+	if err != nil {
+		log.Errorf("[/sell] Could not get fee: %v", err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	orderResult, err := voucherbotManager.createSellOrder(amount, payoutIban.Code)
+	if err != nil {
+		log.Errorf("[/sell] Could not create order: %v", err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	status, _ := orderResult["status"].(string)
+	orderID, _ := orderResult["orderid"].(string)
+	paymentRequest, _ := orderResult["invoice"].(string)
+	if status != VoucherOrderStatusAccepted || len(orderID) == 0 {
+		log.Errorf("[/sell] Order of %s not accepted: %s", GetUserStr(m.Sender), status)
+		bot.trySendMessage(m.Sender, sellNotAcceptedMessage)
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	bolt11, err := decodepay.Decodepay(strings.ToLower(paymentRequest))
+	if err != nil {
+		log.Errorf("[/sell] Could not decode invoice of order %s: %v", orderID, err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	sats := bolt11.MSatoshi / 1000
+
+	balance, err := bot.GetUserBalance(user)
+	if err != nil {
+		log.Errorf("[/sell] Could not get balance of %s: %v", GetUserStr(m.Sender), err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, errors.New(errors.GetBalanceError, err)
+	}
+	if sats > balance {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "insufficientFundsMessage"), balance, sats))
+		if err := voucherbotManager.cancelOrder(orderID); err != nil {
+			log.Errorf("[/sell] Could not cancel order %s: %v", orderID, err)
+		}
+		return ctx, errors.Create(errors.BalanceToLowError)
+	}
+
+	famount, _ := strconv.ParseFloat(amount, 64)
+	order := &VoucherOrder{
+		Base:            storage.New(storage.ID(voucherOrderKey(orderID))),
+		OrderID:         orderID,
+		Type:            VoucherOrderTypeSell,
+		User:            user,
+		LanguageCode:    m.Sender.LanguageCode,
+		Iban:            payoutIban.Code,
+		Amount:          amount,
+		Currency:        currency,
+		Status:          VoucherOrderStatusAccepted,
+		StatusUpdatedAt: time.Now(),
+		Invoice:         paymentRequest,
+		Sats:            sats,
+		Fee:             fee,
+		PayoutAmount:    famount * (1 - fee/100),
+	}
+	runtime.IgnoreError(order.Set(order, bot.Bunt))
+	log.Infof("[/sell] %s created sell order %s: %s %s for %d sat", GetUserStr(m.Sender), orderID, amount, currency, sats)
+
+	confirmText := fmt.Sprintf(sellConfirmMessage, orderID, FormatFiat(famount, currency, order.LanguageCode),
+		strconv.FormatFloat(fee, 'f', -1, 64), payoutIban.Code, FormatFiat(order.PayoutAmount, currency, order.LanguageCode), sats, int(sellConfirmTimeout/time.Minute))
+	sellButton := sellConfirmationMenu.Data(btnSell.Text, btnSell.Unique, order.ID)
+	cancelButton := sellConfirmationMenu.Data(btnCancelSell.Text, btnCancelSell.Unique, order.ID)
+	sellConfirmationMenu.Inline(sellConfirmationMenu.Row(sellButton, cancelButton))
+	bot.trySendMessage(m.Sender, confirmText, sellConfirmationMenu)
+	return ctx, nil
+}
+
+// loadSellOrder returns the open sell order of the pressed button
+func (bot *TipBot) loadSellOrder(ctx intercept.Context) (*VoucherOrder, error) {
+	order := &VoucherOrder{Base: storage.New(storage.ID(ctx.Data()))}
+	err := bot.Bunt.Get(order)
+	if err != nil {
+		return nil, err
+	}
+	// only the seller can press
+	if order.User == nil || order.User.Telegram.ID != ctx.Callback().Sender.ID {
+		return nil, errors.Create(errors.UnknownError)
+	}
+	if order.Active && order.Status == VoucherOrderStatusAccepted && order.confirmExpired() {
+		bot.expireSellOrder(order)
+	}
+	if !order.Active || order.Status != VoucherOrderStatusAccepted {
+		bot.tryEditMessage(ctx.Message(), fmt.Sprintf(voucherOrderStatusMessage, order.OrderID, voucherOrderStatusName(order.Status)), &tb.ReplyMarkup{})
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return order, nil
+}
+
+// confirmExpired is true if the sell order was not confirmed in time
+func (o *VoucherOrder) confirmExpired() bool {
+	return o.orderType() == VoucherOrderTypeSell && o.Status == VoucherOrderStatusAccepted && time.Since(o.CreatedAt) > sellConfirmTimeout
+}
+
+// expireSellOrder cancels an unconfirmed sell order at the provider. The order must be locked.
+func (bot *TipBot) expireSellOrder(order *VoucherOrder) {
+	err := NewVoucherBot(voucherHTTPClient).cancelOrder(order.OrderID)
+	if err != nil {
+		// the watcher tries again
+		log.Errorf("[/sell] Could not cancel expired order %s: %v", order.OrderID, err)
+		return
+	}
+	log.Infof("[/sell] Order %s of %s expired", order.OrderID, GetUserStr(order.User.Telegram))
+	order.Status = VoucherOrderStatusCanceled
+	order.StatusUpdatedAt = time.Now()
+	runtime.IgnoreError(order.Inactivate(order, bot.Bunt))
+	bot.trySendMessage(order.User.Telegram, fmt.Sprintf(sellExpiredMessage, order.OrderID))
+}
+
+// confirmSellHandler pays the invoice of the provider
+func (bot *TipBot) confirmSellHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	order, err := bot.loadSellOrder(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	err = bot.precheckSpendingLimits(user, order.Sats, "sell")
+	if err != nil {
+		return ctx, err
+	}
+	bot.tryEditMessage(ctx.Message(), ctx.Message().Text, &tb.ReplyMarkup{})
+	_, err = bot.PayInvoice(user, order.Invoice, "sell", "")
+	if err != nil {
+		log.Errorf("[/sell] Could not pay invoice of order %s of %s: %v", order.OrderID, GetUserStr(user.Telegram), err)
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "invoicePaymentFailedMessage"), Translate(ctx, "invoiceUndefinedErrorMessage")))
+		return ctx, err
+	}
+	order.Status = VoucherOrderStatusPaymentSent
+	order.StatusUpdatedAt = time.Now()
+	runtime.IgnoreError(order.Set(order, bot.Bunt))
+	log.Infof("[/sell] %s paid %d sat for order %s", GetUserStr(user.Telegram), order.Sats, order.OrderID)
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(sellPaidMessage, order.Sats, order.OrderID, order.OrderID))
+	return ctx, nil
+}
+
+// cancelSellHandler cancels the order at the provider
+func (bot *TipBot) cancelSellHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	order, err := bot.loadSellOrder(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	err = NewVoucherBot(voucherHTTPClient).cancelOrder(order.OrderID)
+	if err != nil {
+		// the order stays open until the provider canceled it
+		log.Errorf("[/sell] Could not cancel order %s: %v", order.OrderID, err)
+		bot.sendVoucherError(ctx, err)
+		return ctx, err
+	}
+	order.Status = VoucherOrderStatusCanceled
+	order.StatusUpdatedAt = time.Now()
+	runtime.IgnoreError(order.Inactivate(order, bot.Bunt))
+	bot.tryDeleteMessage(ctx.Message())
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(sellCanceledMessage, order.OrderID))
+	return ctx, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
 
@@ -55,6 +56,7 @@ type SendData struct {
 	Message        string       `json:"message"`
 	Amount         int64        `json:"amount"`
 	LanguageCode   string       `json:"languagecode"`
+	Contact        string       `json:"contact,omitempty"`
 }
 
 // sendHandler invoked on "/send 123 @user" command
@@ -76,6 +78,19 @@ func (bot *TipBot) sendHandler(ctx intercept.Context) (intercept.Context, error)
 
 	}
 
+	// replace a saved contact name with its address
+	contactArgument := 1
+	if _, err := decodeAmountFromCommand(ctx.Message().Text); err == nil {
+		contactArgument = 2
+	}
+	if contact, ok := bot.resolveContactArgument(ctx, contactArgument); ok {
+		ctx.Context = context.WithValue(ctx, "Contact", contact.Name)
+		if lightning.IsLnurl(contact.Address) {
+			ctx.Message().Text = "/lnurl " + strings.Join(strings.Fields(ctx.Message().Text)[1:], " ")
+			return bot.lnurlHandler(ctx)
+		}
+	}
+
 	if ok, errstr := bot.SendCheckSyntax(ctx, ctx.Message()); !ok {
 		bot.trySendMessage(ctx.Message().Sender, helpSendUsage(ctx, errstr))
 		NewMessage(ctx.Message(), WithDuration(0, bot))
@@ -183,7 +198,7 @@ func (bot *TipBot) sendHandler(ctx intercept.Context) (intercept.Context, error)
 	}
 
 	// entire text of the inline object
-	confirmText := fmt.Sprintf(Translate(ctx, "confirmSendMessage"), str.MarkdownEscape(toUserStrMention), amount)
+	confirmText := fmt.Sprintf(Translate(ctx, "confirmSendMessage"), str.MarkdownEscape(toUserStrMention), amount) + bot.fiatValueText(user, amount, time.Now())
 	if len(sendMemo) > 0 {
 		confirmText = confirmText + fmt.Sprintf(Translate(ctx, "confirmSendAppendMemo"), str.MarkdownEscape(sendMemo))
 	}
@@ -199,6 +214,9 @@ func (bot *TipBot) sendHandler(ctx intercept.Context) (intercept.Context, error)
 		Message:        confirmText,
 		LanguageCode:   ctx.Value("publicLanguageCode").(string),
 	}
+	if contact, ok := ctx.Value("Contact").(string); ok {
+		sendData.Contact = contact
+	}
 	// save persistent struct
 	runtime.IgnoreError(sendData.Set(sendData, bot.Bunt))
 
@@ -298,6 +316,10 @@ func (bot *TipBot) confirmSendHandler(ctx intercept.Context) (intercept.Context,
 	// decode callback data
 	// log.Debug("[send] Callback: %s", c.Data)
 	from := LoadUser(ctx)
+	err = bot.precheckSpendingLimits(from, sendData.Amount, "send")
+	if err != nil {
+		return ctx, err
+	}
 	ResetUserState(from, bot) // we don't need to check the statekey anymore like we did earlier
 
 	// information about the send
@@ -331,21 +353,24 @@ func (bot *TipBot) confirmSendHandler(ctx intercept.Context) (intercept.Context,
 		return ctx, errors.Create(errors.UnknownError)
 	}
 	sendData.Inactivate(sendData, bot.Bunt)
+	bot.touchContact(from, sendData.Contact)
 
 	log.Infof("[💸 send] Send from %s to %s (%d sat).", fromUserStr, toUserStr, amount)
 
 	// notify to user
-	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), fromUserStrMd, amount))
+	sendReceivedMessage := fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), fromUserStrMd, amount) + bot.fiatValueText(to, amount, time.Now())
+	bot.trySendMessage(to.Telegram, sendReceivedMessage)
+	bot.sendNostrDirectMessage(to.Telegram, sendReceivedMessage)
 	// bot.trySendMessage(from.Telegram, fmt.Sprintf(Translate(ctx, "sendSentMessage"), amount, toUserStrMd))
 	if ctx.Callback().Message.Private() {
 		// if the command was invoked in private chat
 		// the edit below was cool, but we need to get rid of the replymarkup inline keyboard thingy for the main menu to pop up
 		// bot.tryEditMessage(c.Message, fmt.Sprintf(i18n.Translate(sendData.LanguageCode, "sendSentMessage"), amount, toUserStrMd), &tb.ReplyMarkup{})
 		bot.tryDeleteMessage(ctx.Callback().Message)
-		bot.trySendMessage(ctx.Callback().Sender, fmt.Sprintf(i18n.Translate(sendData.LanguageCode, "sendSentMessage"), amount, toUserStrMd))
+		bot.trySendMessage(ctx.Callback().Sender, fmt.Sprintf(i18n.Translate(sendData.LanguageCode, "sendSentMessage"), amount, toUserStrMd)+bot.fiatValueText(from, amount, time.Now()))
 	} else {
 		// if the command was invoked in group chat
-		bot.trySendMessage(ctx.Callback().Sender, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "sendSentMessage"), amount, toUserStrMd))
+		bot.trySendMessage(ctx.Callback().Sender, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "sendSentMessage"), amount, toUserStrMd)+bot.fiatValueText(from, amount, time.Now()))
 		bot.tryEditMessage(ctx.Callback().Message, fmt.Sprintf(i18n.Translate(sendData.LanguageCode, "sendPublicSentMessage"), amount, fromUserStrMd, toUserStrMd), &tb.ReplyMarkup{})
 	}
 	// send memo if it was present
@@ -4,12 +4,13 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/massmux/SatsMobiBot/internal/price"
 	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	settingsHelpMessage = "📖 Change user settings\n\n`/set unit <BTC|USD|EUR|GBP>` 💶 Change your default currency."
+	settingsHelpMessage = "📖 Change user settings\n\n`/set unit <BTC|USD|EUR|...>` 💶 Change your default currency.\n`/set limits` 🔐 Spending limits and payment confirmation.\n`/set rankings <on|off>` 🏆 Show or hide yourself in the group rankings of `/top`.\n`/set reactions` 😍 Tip with message reactions."
 )
 
 func (bot *TipBot) settingHandler(ctx intercept.Context) (intercept.Context, error) {
@@ -21,6 +22,12 @@ func (bot *TipBot) settingHandler(ctx intercept.Context) (intercept.Context, err
 		switch strings.ToLower(splits[1]) {
 		case "unit":
 			return bot.addFiatCurrency(ctx)
+		case "limits", "limit", "confirm", "pin", "totp":
+			return bot.spendingLimitSettingHandler(ctx)
+		case "rankings", "ranking":
+			return bot.rankingSettingHandler(ctx)
+		case "reaction", "reactions", "reactioncap":
+			return bot.reactionTipsSettingHandler(ctx)
 		case "help":
 			return bot.nostrHelpHandler(ctx)
 		}
@@ -46,15 +53,20 @@ func (bot *TipBot) addFiatCurrency(ctx intercept.Context) (intercept.Context, er
 		bot.trySendMessage(ctx.Message().Sender, fmt.Sprintf("🌍 Your current default currency is `%s`", currentCurrency))
 		return ctx, nil
 	}
-	currencyInput := splits[2]
-	// convert to lowercase and check if in [usd, eur, gbp, btc, sat]
-	currencyInput = strings.ToLower(currencyInput)
-	if currencyInput != "usd" && currencyInput != "eur" && currencyInput != "gbp" && currencyInput != "btc" && currencyInput != "sat" {
-		bot.trySendMessage(ctx.Message().Sender, "🚫 Invalid currency. Please use one of the following: `BTC`, `USD`, `EUR`")
+	currencyInput := strings.ToUpper(splits[2])
+	if currencyInput == "SAT" {
+		currencyInput = "BTC"
+	}
+	// every currency with a live price can be selected
+	if _, ok := price.P.Currencies[currencyInput]; currencyInput != "BTC" && !ok {
+		bot.trySendMessage(ctx.Message().Sender, fmt.Sprintf("🚫 Invalid currency. Please use one of the following: %s", displayCurrencies()))
 		return ctx, fmt.Errorf("invalid currency")
 	}
-	if currencyInput == "sat" {
-		currencyInput = "BTC"
+	if currencyInput != "BTC" {
+		if _, err := price.Get(currencyInput); err != nil {
+			bot.trySendMessage(ctx.Message().Sender, fmt.Sprintf("🚫 There is no current `%s` price. Please try again later.", currencyInput))
+			return ctx, err
+		}
 	}
 	// save node in db
 	user.Settings.Display.DisplayCurrency = currencyInput
@@ -66,3 +78,12 @@ func (bot *TipBot) addFiatCurrency(ctx intercept.Context) (intercept.Context, er
 	bot.trySendMessage(ctx.Message().Sender, "✅ Your default currency has been updated.")
 	return ctx, nil
 }
+
+// displayCurrencies lists the currencies that can be selected with /set unit
+func displayCurrencies() string {
+	currencies := []string{"`BTC`"}
+	for _, currency := range currenciesBySymbol() {
+		currencies = append(currencies, fmt.Sprintf("`%s`", currency))
+	}
+	return strings.Join(currencies, ", ")
+}
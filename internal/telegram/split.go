@@ -0,0 +1,324 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	splitHelpMessage              = "🧾 *Split a bill*\n\n`/split <amount> <@user> [<@user> ...] [<memo>]` 🍕 Split an amount equally between you and the users. Everyone pays their share to you.\n`/split <invoice> <@user> [<@user> ...] [<memo>]` ⚡️ Split an invoice. Once everyone has paid their share, the invoice is paid from your wallet.\n\nThis command only works in groups."
+	splitMessage                  = "🧾 *Bill split* by %s: %d sat%s\n%d people pay %d sat each to %s.\n"
+	splitInvoiceMessage           = "🧾 *Invoice split* by %s: %d sat%s\n%d people pay %d sat each. The invoice is paid once everyone has paid.\n"
+	splitShareOpenMessage         = "\n⏳ %s"
+	splitSharePaidMessage         = "\n✅ %s"
+	splitProgressMessage          = "\n\n%d of %d shares paid."
+	splitCompletedMessage         = "\n\n✅ Everyone has paid."
+	splitInvoicePaidMessage       = "\n\n✅ Everyone has paid. The invoice was paid."
+	splitInvoiceFailedMessage     = "\n\n🚫 Everyone has paid but the invoice could not be paid: %s"
+	splitCanceledMessage          = "\n\n🚫 The split was canceled."
+	splitTooManyMessage           = "🚫 You can split a bill with at most %d users."
+	splitDuplicateMessage         = "🚫 %s is listed twice."
+	splitInvoiceNoAmountMessage   = "🚫 The invoice has no amount."
+	splitAmountTooSmallMessage    = "🚫 The amount is too small to split."
+	splitInvoiceExpiryMessage     = "🚫 The invoice expires too soon. Everyone needs at least %s to pay their share."
+	splitShareButtonMessage       = "💸 %s: %d sat"
+	splitInvoicePaidNotifyMessage = "🧾 Everyone has paid their share of the split bill. The invoice of %d sat was paid."
+)
+
+const (
+	splitMaxParticipants = 20
+	// splitMinInvoiceExpiry is the time left on an invoice to collect the shares
+	splitMinInvoiceExpiry = time.Hour
+)
+
+var (
+	splitMenu      = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnCancelSplit = splitMenu.Data("🚫 Cancel", "cancel_split")
+)
+
+// Split is a bill that is split between the participants. Every share is an InlineReceive
+// from the participant to the payee.
+type Split struct {
+	*storage.Base
+	Payee          *lnbits.User  `json:"payee"`
+	Amount         int64         `json:"amount"`
+	Share          int64         `json:"share"`
+	PaymentRequest string        `json:"payment_request,omitempty"`
+	Memo           string        `json:"memo"`
+	Shares         []*SplitShare `json:"shares"`
+	Message        *tb.Message   `json:"message"`
+	LanguageCode   string        `json:"languagecode"`
+}
+
+// SplitShare is the share of a participant
+type SplitShare struct {
+	InlineReceiveID string       `json:"inline_receive_id"`
+	User            *lnbits.User `json:"user"`
+	Paid            bool         `json:"paid"`
+}
+
+func (split *Split) paidShares() int {
+	paid := 0
+	for _, share := range split.Shares {
+		if share.Paid {
+			paid++
+		}
+	}
+	return paid
+}
+
+// messageText returns the current state of the split
+func (split *Split) messageText() string {
+	memo := ""
+	if len(split.Memo) > 0 {
+		memo = fmt.Sprintf(" (%s)", str.MarkdownEscape(split.Memo))
+	}
+	payeeStr := GetUserStrMd(split.Payee.Telegram)
+	var text string
+	if len(split.PaymentRequest) > 0 {
+		text = fmt.Sprintf(splitInvoiceMessage, payeeStr, split.Amount, memo, len(split.Shares), split.Share)
+	} else {
+		text = fmt.Sprintf(splitMessage, payeeStr, split.Amount, memo, len(split.Shares), split.Share, payeeStr)
+	}
+	for _, share := range split.Shares {
+		if share.Paid {
+			text += fmt.Sprintf(splitSharePaidMessage, GetUserStrMd(share.User.Telegram))
+		} else {
+			text += fmt.Sprintf(splitShareOpenMessage, GetUserStrMd(share.User.Telegram))
+		}
+	}
+	return text
+}
+
+// makeSplitKeyboard creates one pay button for every open share and a cancel button for the payee
+func (bot *TipBot) makeSplitKeyboard(split *Split) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	var rows []tb.Row
+	for _, share := range split.Shares {
+		if share.Paid {
+			continue
+		}
+		button := menu.Data(fmt.Sprintf(splitShareButtonMessage, GetUserStr(share.User.Telegram), split.Share), btnAcceptInlineReceive.Unique, share.InlineReceiveID)
+		rows = append(rows, menu.Row(button))
+	}
+	rows = append(rows, menu.Row(menu.Data(btnCancelSplit.Text, btnCancelSplit.Unique, split.ID)))
+	menu.Inline(rows...)
+	return menu
+}
+
+// splitHandler handles /split <amount|invoice> @user ... [memo]
+func (bot *TipBot) splitHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	payee := LoadUser(ctx)
+	if payee.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	splits := strings.Fields(m.Text)
+	if m.Chat.Type == tb.ChatPrivate || len(splits) < 3 {
+		bot.trySendMessage(m.Sender, splitHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+
+	split := &Split{
+		Base:         storage.New(storage.ID(fmt.Sprintf("split-%s", RandStringRunes(8)))),
+		Payee:        payee,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	amountStr := strings.TrimPrefix(strings.ToLower(splits[1]), "lightning:")
+	if strings.HasPrefix(amountStr, "lnbc") || strings.HasPrefix(amountStr, "lntb") {
+		bolt11, err := decodepay.Decodepay(amountStr)
+		if err != nil {
+			bot.trySendMessage(m.Sender, helpPayInvoiceUsage(ctx, Translate(ctx, "invalidInvoiceHelpMessage")))
+			return ctx, errors.New(errors.InvalidSyntaxError, err)
+		}
+		split.Amount = int64(bolt11.MSatoshi / 1000)
+		if split.Amount <= 0 {
+			bot.trySendMessage(m.Sender, splitInvoiceNoAmountMessage)
+			return ctx, errors.Create(errors.InvalidAmountError)
+		}
+		expiresAt := time.Unix(int64(bolt11.CreatedAt), 0).Add(time.Duration(bolt11.Expiry) * time.Second)
+		if time.Until(expiresAt) < splitMinInvoiceExpiry {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(splitInvoiceExpiryMessage, splitMinInvoiceExpiry))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+		// the invoice is paid by the bot once all shares are collected
+		if err = bot.refuseAutomaticPayment(payee, m.Sender, split.Amount); err != nil {
+			return ctx, err
+		}
+		split.PaymentRequest = amountStr
+		split.Memo = bolt11.Description
+	} else {
+		amount, err := GetAmount(splits[1])
+		if err != nil || amount < 1 {
+			bot.trySendMessage(m.Sender, Translate(ctx, "lnurlInvalidAmountMessage"))
+			return ctx, errors.New(errors.InvalidAmountError, err)
+		}
+		split.Amount = amount
+	}
+
+	// collect the participants until the memo starts
+	memoIdx := 2
+	seen := map[int64]bool{payee.Telegram.ID: true}
+	for _, arg := range splits[2:] {
+		if !strings.HasPrefix(arg, "@") {
+			break
+		}
+		memoIdx++
+		user, err := GetUserByTelegramUsername(strings.TrimPrefix(arg, "@"), *bot)
+		if err != nil {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape(arg)))
+			return ctx, err
+		}
+		if user.Telegram.ID == payee.Telegram.ID {
+			bot.trySendMessage(m.Sender, Translate(ctx, "sendYourselfMessage"))
+			return ctx, errors.Create(errors.SelfPaymentError)
+		}
+		if seen[user.Telegram.ID] {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(splitDuplicateMessage, str.MarkdownEscape(arg)))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+		seen[user.Telegram.ID] = true
+		split.Shares = append(split.Shares, &SplitShare{User: user})
+	}
+	if len(split.Shares) == 0 {
+		bot.trySendMessage(m.Sender, splitHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if len(split.Shares) > splitMaxParticipants {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(splitTooManyMessage, splitMaxParticipants))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if memo := GetMemoFromCommand(m.Text, memoIdx); len(memo) > 0 {
+		split.Memo = memo
+	}
+	// the payee carries one share and the remainder of the division
+	people := int64(len(split.Shares) + 1)
+	split.Share = split.Amount / people
+	if split.Share < 1 {
+		bot.trySendMessage(m.Sender, splitAmountTooSmallMessage)
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+
+	for i, share := range split.Shares {
+		share.InlineReceiveID = fmt.Sprintf("%s-%d", split.ID, i)
+		inlineReceive := &InlineReceive{
+			Base:              storage.New(storage.ID(share.InlineReceiveID)),
+			MessageText:       split.messageText(),
+			To:                payee,
+			From:              share.User,
+			From_SpecificUser: true,
+			Amount:            split.Share,
+			Memo:              split.Memo,
+			LanguageCode:      split.LanguageCode,
+			SplitID:           split.ID,
+		}
+		runtime.IgnoreError(inlineReceive.Set(inlineReceive, bot.Bunt))
+	}
+	split.Message = bot.trySendMessageEditable(m.Chat, split.messageText(), bot.makeSplitKeyboard(split))
+	runtime.IgnoreError(split.Set(split, bot.Bunt))
+	log.Infof("[split] %s split %d sat between %d users", GetUserStr(payee.Telegram), split.Amount, len(split.Shares))
+	return ctx, nil
+}
+
+// splitSharePaid is called by the inline receive when a participant has paid the share.
+// It updates the split message and pays the invoice once all shares are paid.
+func (bot *TipBot) splitSharePaid(inlineReceive *InlineReceive) {
+	tx := &Split{Base: storage.New(storage.ID(inlineReceive.SplitID))}
+	mutex.Lock(tx.ID)
+	defer mutex.Unlock(tx.ID)
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		log.Errorf("[splitSharePaid] %s", err.Error())
+		return
+	}
+	split := sn.(*Split)
+	// the inline receive must not be paid twice
+	runtime.IgnoreError(inlineReceive.Inactivate(inlineReceive, bot.Bunt))
+	if !split.Active {
+		log.Warnf("[splitSharePaid] share %s paid to inactive split %s", inlineReceive.ID, split.ID)
+		return
+	}
+	var share *SplitShare
+	for _, s := range split.Shares {
+		if s.InlineReceiveID == inlineReceive.ID {
+			share = s
+		}
+	}
+	if share == nil || share.Paid {
+		log.Warnf("[splitSharePaid] share %s of split %s is unknown or already paid", inlineReceive.ID, split.ID)
+		return
+	}
+	share.Paid = true
+
+	text := split.messageText()
+	if split.paidShares() < len(split.Shares) {
+		runtime.IgnoreError(split.Set(split, bot.Bunt))
+		text += fmt.Sprintf(splitProgressMessage, split.paidShares(), len(split.Shares))
+		bot.tryEditMessage(split.Message, text, bot.makeSplitKeyboard(split))
+		return
+	}
+
+	// all shares are paid
+	if len(split.PaymentRequest) == 0 {
+		text += splitCompletedMessage
+	} else {
+		_, err = bot.PayInvoice(split.Payee, split.PaymentRequest, "split", "")
+		if err != nil {
+			log.Errorf("[splitSharePaid] could not pay invoice of split %s: %v", split.ID, err)
+			text += fmt.Sprintf(splitInvoiceFailedMessage, str.MarkdownEscape(err.Error()))
+		} else {
+			text += splitInvoicePaidMessage
+			bot.trySendMessage(split.Payee.Telegram, fmt.Sprintf(splitInvoicePaidNotifyMessage, split.Amount))
+		}
+	}
+	log.Infof("[split] %s completed: %d sat", split.ID, split.Amount)
+	runtime.IgnoreError(split.Inactivate(split, bot.Bunt))
+	bot.tryEditMessage(split.Message, text, &tb.ReplyMarkup{})
+}
+
+// cancelSplitHandler is invoked when the payee cancels the split
+func (bot *TipBot) cancelSplitHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	tx := &Split{Base: storage.New(storage.ID(c.Data))}
+	mutex.LockWithContext(ctx, tx.ID)
+	defer mutex.UnlockWithContext(ctx, tx.ID)
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		log.Errorf("[cancelSplitHandler] %s", err.Error())
+		return ctx, err
+	}
+	split := sn.(*Split)
+	if c.Sender.ID != split.Payee.Telegram.ID {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if !split.Active {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	for _, share := range split.Shares {
+		if share.Paid {
+			continue
+		}
+		inlineReceive := &InlineReceive{Base: storage.New(storage.ID(share.InlineReceiveID))}
+		if rn, err := inlineReceive.Get(inlineReceive, bot.Bunt); err == nil {
+			inlineReceive = rn.(*InlineReceive)
+			runtime.IgnoreError(inlineReceive.Inactivate(inlineReceive, bot.Bunt))
+		}
+	}
+	split.Canceled = true
+	runtime.IgnoreError(split.Inactivate(split, bot.Bunt))
+	log.Infof("[split] %s canceled by %s", split.ID, GetUserStr(c.Sender))
+	bot.tryEditMessage(c, split.messageText()+splitCanceledMessage, &tb.ReplyMarkup{})
+	return ctx, nil
+}
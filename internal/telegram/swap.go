@@ -0,0 +1,376 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	"github.com/tidwall/gjson"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	swapOutConfirmationMenu = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnCancelSwapOut        = swapOutConfirmationMenu.Data("🚫 Cancel", "cancel_swapout")
+	btnSwapOut              = swapOutConfirmationMenu.Data("✅ Withdraw", "confirm_swapout")
+)
+
+var (
+	swapOutHelpMessage      = "⛓ *On-chain withdrawal*\n\n`/swapout <amount> <bitcoin address>` Send sats to a bitcoin address with a Boltz swap."
+	swapOutConfirmMessage   = "⛓ *On-chain withdrawal*\n\nAmount: %d sat\nBoltz fee: %d sat (%s%%)\nLockup fee: %d sat\nClaim fee: ~%d sat (%s sat/vB)\nYou receive: ~%d sat\nAddress: `%s`"
+	swapOutLimitMessage     = "🚫 The amount must be between %d and %d sat."
+	swapOutAddressMessage   = "🚫 Invalid bitcoin address."
+	swapOutCreatedMessage   = "⛓ Swap `%s` created. Your bitcoin is sent as soon as Boltz locked the funds."
+	swapOutClaimedMessage   = "⛓ Swap `%s`: %d sat are on the way to `%s`.\nTransaction: %s"
+	swapOutCompletedMessage = "✅ Swap `%s` completed."
+	swapOutFailedMessage    = "🚫 Swap `%s` failed: %s"
+	swapOutCanceledMessage  = "❌ On-chain withdrawal canceled."
+	swapOutExpiredMessage   = "🚫 The fees have changed. Please start the withdrawal again."
+)
+
+const (
+	SwapStatusQuote     = "quote"
+	SwapStatusCreated   = "created"
+	SwapStatusClaimed   = "claimed"
+	SwapStatusCompleted = "completed"
+	SwapStatusFailed    = "failed"
+)
+
+const (
+	// swapClaimVSize is the size of a cooperative claim transaction with one input and one output
+	swapClaimVSize = 111
+	// quotes are only valid for a short time because of the fees
+	swapQuoteMaxAge = 10 * time.Minute
+)
+
+// SwapOutQuote are the fees of a reverse swap from Lightning to the chain
+type SwapOutQuote struct {
+	Amount     int64   `json:"amount"`
+	Percentage float64 `json:"percentage"`
+	BoltzFee   int64   `json:"boltz_fee"`
+	LockupFee  int64   `json:"lockup_fee"`
+	ClaimFee   int64   `json:"claim_fee"`
+	FeeRate    float64 `json:"fee_rate"`
+	PairHash   string  `json:"pair_hash"`
+	Minimal    int64   `json:"minimal"`
+	Maximal    int64   `json:"maximal"`
+}
+
+// Receive returns the estimated amount that arrives at the address
+func (q *SwapOutQuote) Receive() int64 {
+	return q.Amount - q.BoltzFee - q.LockupFee - q.ClaimFee
+}
+
+// SwapOut is a reverse swap that pays out the wallet of a user to a bitcoin address.
+// The keys and the preimage are persisted to claim the funds after a restart.
+type SwapOut struct {
+	*storage.Base
+	User               *lnbits.User    `json:"user"`
+	LanguageCode       string          `json:"languagecode"`
+	Address            string          `json:"address"`
+	Amount             int64           `json:"amount"`
+	Quote              *SwapOutQuote   `json:"quote"`
+	Status             string          `json:"status"`
+	SwapID             string          `json:"swap_id"`
+	Invoice            string          `json:"invoice"`
+	LockupAddress      string          `json:"lockup_address"`
+	OnchainAmount      int64           `json:"onchain_amount"`
+	TimeoutBlockHeight uint32          `json:"timeout_block_height"`
+	Preimage           string          `json:"preimage"`
+	PrivateKey         string          `json:"private_key"`
+	RefundPublicKey    string          `json:"refund_public_key"`
+	SwapTree           json.RawMessage `json:"swap_tree"`
+	ClaimTxID          string          `json:"claim_txid"`
+	Error              string          `json:"error"`
+}
+
+// swapChainParams returns the parameters of the configured bitcoin network
+func swapChainParams() *chaincfg.Params {
+	switch internal.Configuration.Boltz.Network {
+	case "testnet":
+		return &chaincfg.TestNet3Params
+	case "regtest":
+		return &chaincfg.RegressionNetParams
+	}
+	return &chaincfg.MainNetParams
+}
+
+func validateBitcoinAddress(address string) error {
+	decoded, err := btcutil.DecodeAddress(address, swapChainParams())
+	if err != nil {
+		return err
+	}
+	if !decoded.IsForNet(swapChainParams()) {
+		return fmt.Errorf("address is not for %s", swapChainParams().Name)
+	}
+	return nil
+}
+
+func mempoolEndpoint() string {
+	if len(internal.Configuration.Boltz.MempoolEndpoint) > 0 {
+		return strings.TrimSuffix(internal.Configuration.Boltz.MempoolEndpoint, "/")
+	}
+	return "https://mempool.space/api"
+}
+
+// mempoolTransactionLink returns the link to a transaction in the block explorer
+func mempoolTransactionLink(txid string) string {
+	return fmt.Sprintf("%s/tx/%s", strings.TrimSuffix(mempoolEndpoint(), "/api"), txid)
+}
+
+// mempoolFeeRate returns the fee rate in sat/vB for a confirmation within about half an hour
+func mempoolFeeRate() (float64, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(mempoolEndpoint() + "/v1/fees/recommended")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	feeRate := gjson.GetBytes(body, "halfHourFee").Float()
+	if !(feeRate > 0) {
+		return 0, fmt.Errorf("no fee rate")
+	}
+	return feeRate, nil
+}
+
+// swapClaimFee estimates the fee of the claim transaction
+func swapClaimFee(feeRate float64) int64 {
+	return int64(math.Ceil(feeRate * swapClaimVSize))
+}
+
+// swapOutHandler handles /swapout <amount> <bitcoin address>
+func (bot *TipBot) swapOutHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	arguments := strings.Fields(m.Text)
+	if len(arguments) < 3 {
+		bot.trySendMessage(m.Sender, swapOutHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(arguments[1])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, swapOutHelpMessage)
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	address := arguments[2]
+	err = validateBitcoinAddress(address)
+	if err != nil {
+		log.Debugf("[/swapout] invalid address %s: %v", address, err)
+		bot.trySendMessage(m.Sender, swapOutAddressMessage)
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	quote, err := getSwapOutQuote(amount)
+	if err != nil {
+		log.Errorf("[/swapout] Could not get quote: %v", err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	if amount < quote.Minimal || amount > quote.Maximal {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(swapOutLimitMessage, quote.Minimal, quote.Maximal))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	balance, err := bot.GetUserBalance(user)
+	if err != nil {
+		log.Errorf("[/swapout] Could not get balance of %s: %v", GetUserStr(m.Sender), err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, errors.New(errors.GetBalanceError, err)
+	}
+	if amount > balance {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "insufficientFundsMessage"), balance, amount))
+		return ctx, errors.Create(errors.BalanceToLowError)
+	}
+
+	id := fmt.Sprintf("swap-out:%d-%s", m.Sender.ID, RandStringRunes(8))
+	swap := &SwapOut{
+		Base:         storage.New(storage.ID(id)),
+		User:         user,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+		Address:      address,
+		Amount:       amount,
+		Quote:        quote,
+		Status:       SwapStatusQuote,
+	}
+	runtime.IgnoreError(swap.Set(swap, bot.Bunt))
+
+	confirmText := fmt.Sprintf(swapOutConfirmMessage, amount, quote.BoltzFee, strconv.FormatFloat(quote.Percentage, 'f', -1, 64),
+		quote.LockupFee, quote.ClaimFee, strconv.FormatFloat(quote.FeeRate, 'f', -1, 64), quote.Receive(), address)
+	swapButton := swapOutConfirmationMenu.Data(btnSwapOut.Text, btnSwapOut.Unique, id)
+	cancelButton := swapOutConfirmationMenu.Data(btnCancelSwapOut.Text, btnCancelSwapOut.Unique, id)
+	swapOutConfirmationMenu.Inline(swapOutConfirmationMenu.Row(swapButton, cancelButton))
+	bot.trySendMessage(m.Sender, confirmText, swapOutConfirmationMenu)
+	return ctx, nil
+}
+
+// loadSwapOutQuote returns the swap of the pressed button if it was not confirmed yet
+func (bot *TipBot) loadSwapOutQuote(ctx intercept.Context) (*SwapOut, error) {
+	swap := &SwapOut{Base: storage.New(storage.ID(ctx.Data()))}
+	err := bot.Bunt.Get(swap)
+	if err != nil {
+		return nil, err
+	}
+	// only the owner can press
+	if swap.User == nil || swap.User.Telegram.ID != ctx.Callback().Sender.ID {
+		return nil, errors.Create(errors.UnknownError)
+	}
+	if !swap.Active || swap.Status != SwapStatusQuote {
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return swap, nil
+}
+
+// confirmSwapOutHandler creates the swap at Boltz and pays its invoice
+func (bot *TipBot) confirmSwapOutHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	swap, err := bot.loadSwapOutQuote(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	bot.tryEditMessage(ctx.Message(), ctx.Message().Text, &tb.ReplyMarkup{})
+	if time.Since(swap.CreatedAt) > swapQuoteMaxAge {
+		bot.trySendMessage(ctx.Sender(), swapOutExpiredMessage)
+		return ctx, swap.Inactivate(swap, bot.Bunt)
+	}
+	user := LoadUser(ctx)
+	err = bot.precheckSpendingLimits(user, swap.Amount, "swapout")
+	if err != nil {
+		return ctx, err
+	}
+	err = createReverseSwap(swap)
+	if err != nil {
+		log.Errorf("[/swapout] Could not create swap for %s: %v", GetUserStr(user.Telegram), err)
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "errorTryLaterMessage"))
+		return ctx, swap.Inactivate(swap, bot.Bunt)
+	}
+	swap.Status = SwapStatusCreated
+	runtime.IgnoreError(swap.Set(swap, bot.Bunt))
+	log.Infof("[/swapout] %s created swap %s: %d sat to %s", GetUserStr(user.Telegram), swap.SwapID, swap.Amount, swap.Address)
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(swapOutCreatedMessage, swap.SwapID))
+
+	// the invoice is a hold invoice that is settled after the funds were claimed
+	go bot.watchSwapOut(swap.ID)
+	go bot.paySwapOut(swap)
+	return ctx, nil
+}
+
+// cancelSwapOutHandler removes a swap that was not confirmed
+func (bot *TipBot) cancelSwapOutHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	swap, err := bot.loadSwapOutQuote(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	bot.tryDeleteMessage(ctx.Message())
+	bot.trySendMessage(ctx.Sender(), swapOutCanceledMessage)
+	return ctx, swap.Inactivate(swap, bot.Bunt)
+}
+
+// paySwapOut pays the invoice of the swap. It returns when Boltz settled the invoice.
+func (bot *TipBot) paySwapOut(swap *SwapOut) {
+	_, err := bot.PayInvoice(swap.User, swap.Invoice, "swapout", "")
+	if err != nil {
+		// the payment can still be pending, so Boltz may lock the funds anyway.
+		// The swap stays active until the watcher sees a final state.
+		log.Errorf("[/swapout] Could not pay invoice of swap %s, waiting for Boltz: %v", swap.SwapID, err)
+		_, err = bot.updateSwapOut(swap.ID, func(swap *SwapOut) {
+			swap.Error = i18n.Translate(swap.LanguageCode, "invoiceUndefinedErrorMessage")
+		})
+		if err != nil {
+			log.Errorf("[/swapout] Could not update swap %s: %v", swap.SwapID, err)
+		}
+	}
+}
+
+// updateSwapOut loads the swap, applies f and stores it
+func (bot *TipBot) updateSwapOut(id string, f func(swap *SwapOut)) (*SwapOut, error) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	swap := &SwapOut{Base: storage.New(storage.ID(id))}
+	err := bot.Bunt.Get(swap)
+	if err != nil {
+		return nil, err
+	}
+	f(swap)
+	return swap, swap.Set(swap, bot.Bunt)
+}
+
+func (bot *TipBot) failSwapOut(id string, reason string) {
+	failed := false
+	swap, err := bot.updateSwapOut(id, func(swap *SwapOut) {
+		if swap.Status == SwapStatusCompleted || swap.Status == SwapStatusFailed {
+			return
+		}
+		swap.Status = SwapStatusFailed
+		// keep the error of the payment, it explains the failure better than the status of Boltz
+		if len(swap.Error) == 0 {
+			swap.Error = reason
+		}
+		swap.Active = false
+		failed = true
+	})
+	if err != nil || !failed {
+		return
+	}
+	log.Warnf("[/swapout] Swap %s failed: %s", swap.SwapID, reason)
+	bot.trySendMessage(swap.User.Telegram, fmt.Sprintf(swapOutFailedMessage, swap.SwapID, swap.Error))
+}
+
+// restartSwapOuts watches all swaps that were not finished before a restart
+func (bot *TipBot) restartSwapOuts() {
+	var ids []string
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend(SwapOutIndex, func(key, value string) bool {
+			swap := &SwapOut{}
+			err := json.Unmarshal([]byte(value), swap)
+			if err != nil || swap.Base == nil || !swap.Active {
+				return true
+			}
+			switch swap.Status {
+			case SwapStatusCreated, SwapStatusClaimed:
+				ids = append(ids, swap.ID)
+			case SwapStatusQuote:
+				if time.Since(swap.CreatedAt) > swapQuoteMaxAge {
+					ids = append(ids, swap.ID)
+				}
+			}
+			return true // continue iteration
+		})
+	})
+	for _, id := range ids {
+		swap := &SwapOut{Base: storage.New(storage.ID(id))}
+		if err := bot.Bunt.Get(swap); err != nil {
+			continue
+		}
+		if swap.Status == SwapStatusQuote {
+			runtime.IgnoreError(swap.Inactivate(swap, bot.Bunt))
+			continue
+		}
+		log.Infof("[/swapout] Resuming swap %s", swap.SwapID)
+		go bot.watchSwapOut(id)
+	}
+}
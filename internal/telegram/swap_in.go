@@ -0,0 +1,374 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	swapInHelpMessage           = "⛓ *On-chain deposit*\n\n`/swapin <amount> [refund address]` Fund your wallet with on-chain bitcoin through a Boltz swap.\n`/swapin refund <swap> <address>` Set the address that receives your bitcoin back if the swap fails."
+	swapInCreatedMessage        = "⛓ *On-chain deposit*\n\nSend exactly *%d sat* to\n`%s`\n\nYou receive: %d sat\nBoltz fee: %d sat (%s%%) + %d sat miner fee\nSwap: `%s`\nThe swap expires at block %d."
+	swapInNoRefundMessage       = "\n\n⚠️ Set a refund address with `/swapin refund %s <address>` to get your bitcoin back if the swap fails."
+	swapInRefundAddressMessage  = "\n\nRefund address: `%s`"
+	swapInLimitMessage          = "🚫 The amount must be between %d and %d sat."
+	swapInMempoolMessage        = "⛓ Swap `%s`: your deposit was seen. Your wallet is funded once it confirms."
+	swapInCompletedMessage      = "✅ Swap `%s` completed."
+	swapInExpiredMessage        = "⛓ Swap `%s` expired without a deposit.\nIf you sent bitcoin anyway, get it back with `/swapin refund %s <address>`."
+	swapInNoDepositMessage      = "⛓ Swap `%s` has no deposit to refund. The refund address is kept in case it arrives."
+	swapInRefundRequiredMessage = "🚫 Swap `%s` failed: %s\nSet an address to get your bitcoin back with `/swapin refund %s <address>`."
+	swapInRefundPendingMessage  = "🚫 Swap `%s` failed: %s\nYour bitcoin is sent back to `%s`."
+	swapInRefundedMessage       = "⛓ Swap `%s` refunded to `%s`.\nTransaction: %s"
+	swapInRefundSetMessage      = "✅ Refund address of swap `%s` set to `%s`."
+	swapInNotFoundMessage       = "🚫 Swap `%s` not found."
+)
+
+const (
+	SwapInStatusCreated        = "created"
+	SwapInStatusLocked         = "locked"
+	SwapInStatusCompleted      = "completed"
+	SwapInStatusExpired        = "expired"
+	SwapInStatusRefundRequired = "refund_required"
+	SwapInStatusRefundPending  = "refund_pending"
+	SwapInStatusRefunded       = "refunded"
+)
+
+const (
+	// the invoice has to be valid until Boltz pays it after the deposit confirmed
+	swapInInvoiceExpiry = 24 * 60 * 60
+	// refunds without the cooperation of Boltz are only possible after the timeout
+	swapInRefundRetryDuration = 10 * time.Minute
+)
+
+// SwapInQuote are the fees of a submarine swap from the chain to Lightning
+type SwapInQuote struct {
+	Amount     int64   `json:"amount"`
+	Percentage float64 `json:"percentage"`
+	BoltzFee   int64   `json:"boltz_fee"`
+	MinerFee   int64   `json:"miner_fee"`
+	PairHash   string  `json:"pair_hash"`
+	Minimal    int64   `json:"minimal"`
+	Maximal    int64   `json:"maximal"`
+}
+
+// Send returns the amount the user has to send to the lockup address
+func (q *SwapInQuote) Send() int64 {
+	return q.Amount + q.BoltzFee + q.MinerFee
+}
+
+// SwapIn is a submarine swap that funds the wallet of a user with on-chain bitcoin.
+// The keys are persisted so that the deposit can always be refunded.
+type SwapIn struct {
+	*storage.Base
+	User               *lnbits.User    `json:"user"`
+	LanguageCode       string          `json:"languagecode"`
+	Amount             int64           `json:"amount"`
+	Quote              *SwapInQuote    `json:"quote"`
+	Status             string          `json:"status"`
+	SwapID             string          `json:"swap_id"`
+	Invoice            string          `json:"invoice"`
+	PaymentHash        string          `json:"payment_hash"`
+	LockupAddress      string          `json:"lockup_address"`
+	Bip21              string          `json:"bip21"`
+	ExpectedAmount     int64           `json:"expected_amount"`
+	TimeoutBlockHeight uint32          `json:"timeout_block_height"`
+	PrivateKey         string          `json:"private_key"`
+	ClaimPublicKey     string          `json:"claim_public_key"`
+	SwapTree           json.RawMessage `json:"swap_tree"`
+	LockupTransaction  string          `json:"lockup_transaction"`
+	RefundAddress      string          `json:"refund_address"`
+	RefundTxID         string          `json:"refund_txid"`
+	Error              string          `json:"error"`
+}
+
+func swapInKey(swapID string) string {
+	return fmt.Sprintf("swap-in:%s", swapID)
+}
+
+// swapInHandler handles /swapin <amount> [refund address] and /swapin refund <swap> <address>
+func (bot *TipBot) swapInHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	arguments := strings.Fields(m.Text)
+	if len(arguments) < 2 {
+		bot.trySendMessage(m.Sender, swapInHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if strings.ToLower(arguments[1]) == "refund" {
+		if len(arguments) < 4 {
+			bot.trySendMessage(m.Sender, swapInHelpMessage)
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+		return bot.setSwapInRefundAddress(ctx, arguments[2], arguments[3])
+	}
+	amount, err := GetAmount(arguments[1])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, swapInHelpMessage)
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	refundAddress := ""
+	if len(arguments) > 2 {
+		refundAddress = arguments[2]
+		err = validateBitcoinAddress(refundAddress)
+		if err != nil {
+			bot.trySendMessage(m.Sender, swapOutAddressMessage)
+			return ctx, errors.New(errors.InvalidSyntaxError, err)
+		}
+	}
+	quote, err := getSwapInQuote(amount)
+	if err != nil {
+		log.Errorf("[/swapin] Could not get quote: %v", err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	if amount < quote.Minimal || amount > quote.Maximal {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(swapInLimitMessage, quote.Minimal, quote.Maximal))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+
+	// Boltz pays this invoice after the deposit confirmed, the user is notified like for any other invoice
+	invoice, err := bot.createInvoiceEvent(user, lnbits.InvoiceParams{Amount: amount, Memo: "⛓ On-chain deposit", Expiry: swapInInvoiceExpiry},
+		ctx.Value("publicLanguageCode").(string), "", InvoiceCallbackGeneric, "", nil)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	swap := &SwapIn{
+		User:          user,
+		LanguageCode:  ctx.Value("publicLanguageCode").(string),
+		Amount:        amount,
+		Quote:         quote,
+		Status:        SwapInStatusCreated,
+		Invoice:       invoice.PaymentRequest,
+		PaymentHash:   invoice.PaymentHash,
+		RefundAddress: refundAddress,
+	}
+	err = createSubmarineSwap(swap)
+	if err != nil {
+		log.Errorf("[/swapin] Could not create swap for %s: %v", GetUserStr(m.Sender), err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	swap.Base = storage.New(storage.ID(swapInKey(swap.SwapID)))
+	runtime.IgnoreError(swap.Set(swap, bot.Bunt))
+	log.Infof("[/swapin] %s created swap %s: %d sat, lockup %d sat to %s", GetUserStr(m.Sender), swap.SwapID, amount, swap.ExpectedAmount, swap.LockupAddress)
+	go bot.watchSwapIn(swap.ID)
+
+	caption := fmt.Sprintf(swapInCreatedMessage, swap.ExpectedAmount, swap.LockupAddress, amount, quote.BoltzFee,
+		strconv.FormatFloat(quote.Percentage, 'f', -1, 64), quote.MinerFee, swap.SwapID, swap.TimeoutBlockHeight)
+	if len(refundAddress) > 0 {
+		caption += fmt.Sprintf(swapInRefundAddressMessage, refundAddress)
+	} else {
+		caption += fmt.Sprintf(swapInNoRefundMessage, swap.SwapID)
+	}
+	qr, err := qrcode.Encode(swap.Bip21, qrcode.Medium, 256)
+	if err != nil {
+		log.Errorf("[/swapin] Failed to create QR code: %v", err)
+		bot.trySendMessage(m.Sender, caption)
+		return ctx, nil
+	}
+	bot.trySendMessage(m.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: caption})
+	return ctx, nil
+}
+
+// setSwapInRefundAddress sets the refund address of a swap and refunds it if it failed already
+func (bot *TipBot) setSwapInRefundAddress(ctx intercept.Context, swapID string, address string) (intercept.Context, error) {
+	m := ctx.Message()
+	err := validateBitcoinAddress(address)
+	if err != nil {
+		bot.trySendMessage(m.Sender, swapOutAddressMessage)
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	// a deposit can arrive after the swap expired, so expired swaps are checked at Boltz again
+	lockupTransactionHex := ""
+	swap := &SwapIn{Base: storage.New(storage.ID(swapInKey(swapID)))}
+	if bot.Bunt.Get(swap) == nil && swap.Status == SwapInStatusExpired && len(swap.LockupTransaction) == 0 {
+		lockupTransactionHex, err = swapInLockupTransaction(swap.SwapID)
+		if err != nil {
+			log.Warnf("[/swapin] Could not find lockup transaction of expired swap %s: %v", swap.SwapID, err)
+		}
+	}
+	refund := false
+	owner := false
+	swap, err = bot.updateSwapIn(swapInKey(swapID), func(swap *SwapIn) {
+		if swap.User == nil || swap.User.Telegram.ID != m.Sender.ID || !(swap.Active || swap.Status == SwapInStatusExpired) {
+			return
+		}
+		owner = true
+		swap.RefundAddress = address
+		if len(lockupTransactionHex) > 0 {
+			swap.LockupTransaction = lockupTransactionHex
+		}
+		if swap.Status == SwapInStatusRefundRequired || (swap.Status == SwapInStatusExpired && len(swap.LockupTransaction) > 0) {
+			swap.Status = SwapInStatusRefundPending
+			swap.Active = true
+			refund = true
+		}
+	})
+	if err != nil || !owner {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(swapInNotFoundMessage, swapID))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(swapInRefundSetMessage, swap.SwapID, address))
+	if refund {
+		go bot.refundSwapInUntilDone(swap.ID)
+	} else if swap.Status == SwapInStatusExpired {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(swapInNoDepositMessage, swap.SwapID))
+	}
+	return ctx, nil
+}
+
+// updateSwapIn loads the swap, applies f and stores it
+func (bot *TipBot) updateSwapIn(id string, f func(swap *SwapIn)) (*SwapIn, error) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	swap := &SwapIn{Base: storage.New(storage.ID(id))}
+	err := bot.Bunt.Get(swap)
+	if err != nil {
+		return nil, err
+	}
+	f(swap)
+	return swap, swap.Set(swap, bot.Bunt)
+}
+
+// swapInLocked is called when the deposit of the user is in the mempool
+func (bot *TipBot) swapInLocked(id string, lockupTransactionHex string) {
+	notify := false
+	swap, err := bot.updateSwapIn(id, func(swap *SwapIn) {
+		if len(lockupTransactionHex) > 0 {
+			swap.LockupTransaction = lockupTransactionHex
+		}
+		if swap.Status == SwapInStatusCreated {
+			swap.Status = SwapInStatusLocked
+			notify = true
+		}
+	})
+	if err == nil && notify {
+		bot.trySendMessage(swap.User.Telegram, fmt.Sprintf(swapInMempoolMessage, swap.SwapID))
+	}
+}
+
+// swapInCompleted is called when Boltz paid the invoice and claimed the deposit
+func (bot *TipBot) swapInCompleted(id string) {
+	swap, err := bot.updateSwapIn(id, func(swap *SwapIn) {
+		swap.Status = SwapInStatusCompleted
+		swap.Active = false
+	})
+	if err == nil {
+		log.Infof("[/swapin] Swap %s completed", swap.SwapID)
+		bot.trySendMessage(swap.User.Telegram, fmt.Sprintf(swapInCompletedMessage, swap.SwapID))
+	}
+}
+
+// swapInFailed is called when the swap failed. A deposit is refunded if there is one.
+func (bot *TipBot) swapInFailed(id string, reason string, locked bool) {
+	// the websocket can miss the deposit, so Boltz is asked before the swap counts as expired
+	lockupTransactionHex := ""
+	if !locked {
+		swap := &SwapIn{Base: storage.New(storage.ID(id))}
+		if bot.Bunt.Get(swap) == nil && len(swap.LockupTransaction) == 0 {
+			var err error
+			lockupTransactionHex, err = swapInLockupTransaction(swap.SwapID)
+			if err != nil {
+				log.Warnf("[/swapin] Could not find lockup transaction of swap %s: %v", swap.SwapID, err)
+			}
+		}
+	}
+	swap, err := bot.updateSwapIn(id, func(swap *SwapIn) {
+		swap.Error = reason
+		if len(lockupTransactionHex) > 0 {
+			swap.LockupTransaction = lockupTransactionHex
+		}
+		switch {
+		case !locked && len(swap.LockupTransaction) == 0:
+			swap.Status = SwapInStatusExpired
+			swap.Active = false
+		case len(swap.RefundAddress) > 0:
+			swap.Status = SwapInStatusRefundPending
+		default:
+			swap.Status = SwapInStatusRefundRequired
+		}
+	})
+	if err != nil {
+		return
+	}
+	log.Warnf("[/swapin] Swap %s failed: %s", swap.SwapID, reason)
+	switch swap.Status {
+	case SwapInStatusExpired:
+		bot.trySendMessage(swap.User.Telegram, fmt.Sprintf(swapInExpiredMessage, swap.SwapID, swap.SwapID))
+	case SwapInStatusRefundPending:
+		bot.trySendMessage(swap.User.Telegram, fmt.Sprintf(swapInRefundPendingMessage, swap.SwapID, reason, swap.RefundAddress))
+		go bot.refundSwapInUntilDone(id)
+	case SwapInStatusRefundRequired:
+		bot.trySendMessage(swap.User.Telegram, fmt.Sprintf(swapInRefundRequiredMessage, swap.SwapID, reason, swap.SwapID))
+	}
+}
+
+// refundSwapInUntilDone retries the refund until it was broadcast. A refund without the
+// cooperation of Boltz is only valid after the timeout block height.
+func (bot *TipBot) refundSwapInUntilDone(id string) {
+	for {
+		swap := &SwapIn{Base: storage.New(storage.ID(id))}
+		err := bot.Bunt.Get(swap)
+		if err != nil || !swap.Active {
+			return
+		}
+		txid, err := refundSubmarineSwap(swap)
+		if err == nil {
+			swap, err := bot.updateSwapIn(id, func(swap *SwapIn) {
+				swap.RefundTxID = txid
+				swap.Status = SwapInStatusRefunded
+				swap.Active = false
+			})
+			if err == nil {
+				log.Infof("[/swapin] Swap %s refunded: %s", swap.SwapID, txid)
+				bot.trySendMessage(swap.User.Telegram, fmt.Sprintf(swapInRefundedMessage, swap.SwapID, swap.RefundAddress, mempoolTransactionLink(txid)))
+			}
+			return
+		}
+		log.Warnf("[/swapin] Could not refund swap %s: %v", id, err)
+		time.Sleep(swapInRefundRetryDuration)
+	}
+}
+
+// restartSwapIns watches all swaps that were not finished before a restart
+func (bot *TipBot) restartSwapIns() {
+	swaps := make(map[string]string)
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend(SwapInIndex, func(key, value string) bool {
+			swap := &SwapIn{}
+			err := json.Unmarshal([]byte(value), swap)
+			if err != nil || swap.Base == nil || !swap.Active {
+				return true
+			}
+			swaps[swap.ID] = swap.Status
+			return true // continue iteration
+		})
+	})
+	for id, status := range swaps {
+		log.Infof("[/swapin] Resuming swap %s", id)
+		switch status {
+		case SwapInStatusCreated, SwapInStatusLocked:
+			go bot.watchSwapIn(id)
+		case SwapInStatusRefundPending:
+			go bot.refundSwapInUntilDone(id)
+		}
+	}
+}
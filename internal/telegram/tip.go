@@ -44,6 +44,10 @@ func (bot *TipBot) tipHandler(ctx intercept.Context) (intercept.Context, error)
 
 	// only if message is a reply
 	if !m.IsReply() {
+		// tip a saved contact
+		if bot.isContactName(ctx, 1) || bot.isContactName(ctx, 2) {
+			return bot.sendToContactHandler(ctx)
+		}
 		bot.tryDeleteMessage(m)
 		bot.trySendMessage(m.Sender, helpTipUsage(ctx, Translate(ctx, "tipDidYouReplyMessage")))
 		bot.trySendMessage(m.Sender, Translate(ctx, "tipInviteGroupMessage"))
@@ -74,6 +78,12 @@ func (bot *TipBot) tipHandler(ctx intercept.Context) (intercept.Context, error)
 	// TIP COMMAND IS VALID
 	from := LoadUser(ctx)
 	to := LoadReplyToUser(ctx)
+	// tips on messages of the bot go to the group treasury
+	if to.Telegram.ID == bot.Telegram.Me.ID {
+		if treasuryUser, err := bot.loadTreasuryUser(m.Chat.ID); err == nil {
+			to = treasuryUser
+		}
+	}
 
 	if from.Telegram.ID == to.Telegram.ID {
 		NewMessage(m, WithDuration(0, bot))
@@ -125,17 +135,20 @@ func (bot *TipBot) tipHandler(ctx intercept.Context) (intercept.Context, error)
 	log.Infof("[💸 tip] Tip from %s to %s (%d sat).", fromUserStr, toUserStr, amount)
 
 	// notify users
-	bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "tipSentMessage"), amount, toUserStrMd))
+	bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "tipSentMessage"), amount, toUserStrMd)+bot.fiatValueText(from, amount, time.Now()))
 
 	// forward tipped message to user once
 	if !messageHasTip {
 		bot.tryForwardMessage(to.Telegram, m.ReplyTo, tb.Silent)
 	}
-	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "tipReceivedMessage"), fromUserStrMd, amount))
+	tipReceivedMessage := fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "tipReceivedMessage"), fromUserStrMd, amount) + bot.fiatValueText(to, amount, time.Now())
+	bot.trySendMessage(to.Telegram, tipReceivedMessage)
 
 	if len(tipMemo) > 0 {
 		bot.trySendMessage(to.Telegram, fmt.Sprintf("✉️ %s", str.MarkdownEscape(tipMemo)))
+		tipReceivedMessage += fmt.Sprintf("\n✉️ %s", tipMemo)
 	}
+	bot.sendNostrDirectMessage(to.Telegram, tipReceivedMessage)
 	// delete the tip message after a few seconds, this is default behaviour
 	NewMessage(m, WithDuration(time.Second*time.Duration(internal.Configuration.Telegram.MessageDisposeDuration), bot))
 	return ctx, nil
@@ -3,11 +3,13 @@ package telegram
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
 
+	"github.com/massmux/SatsMobiBot/internal/runtime"
 	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
 	"github.com/massmux/SatsMobiBot/internal/storage"
 
@@ -39,6 +41,12 @@ type InlineTipjar struct {
 	NTotal        int            `json:"inline_tipjar_ntotal"`
 	NGiven        int            `json:"inline_tipjar_ngiven"`
 	LanguageCode  string         `json:"languagecode"`
+	// crowdfunding tipjars hold the pledges in the crowdfunding wallet until the deadline
+	Deadline     time.Time        `json:"inline_tipjar_deadline"`
+	State        string           `json:"inline_tipjar_state"`
+	Refunded     []int64          `json:"inline_tipjar_refunded"`
+	Editable     tb.StoredMessage `json:"inline_tipjar_editable"`
+	WalletUserID int64            `json:"inline_tipjar_wallet_user_id"`
 }
 
 func (bot TipBot) mapTipjarLanguage(ctx context.Context, command string) context.Context {
@@ -68,6 +76,10 @@ func (bot TipBot) createTipjar(ctx context.Context, text string, sender *tb.User
 	nTotal := int(amount / perUserAmount)
 	toUser := LoadUser(ctx)
 	// toUserStr := GetUserStr(sender)
+	text, deadline, err := parseTipjarDeadline(text)
+	if err != nil {
+		return nil, errors.New(errors.InvalidSyntaxError, err)
+	}
 	// // check for memo in command
 	memo := GetMemoFromCommand(text, 3)
 
@@ -85,7 +97,7 @@ func (bot TipBot) createTipjar(ctx context.Context, text string, sender *tb.User
 	}
 	id := fmt.Sprintf("tipjar:%s:%d", RandStringRunes(10), amount)
 
-	return &InlineTipjar{
+	inlineTipjar := &InlineTipjar{
 		Base:          storage.New(storage.ID(id)),
 		Message:       inlineMessage,
 		Amount:        amount,
@@ -96,7 +108,14 @@ func (bot TipBot) createTipjar(ctx context.Context, text string, sender *tb.User
 		NGiven:        0,
 		GivenAmount:   0,
 		LanguageCode:  ctx.Value("publicLanguageCode").(string),
-	}, nil
+		Deadline:      deadline,
+		State:         TipjarStateOpen,
+	}
+	if inlineTipjar.IsCrowdfund() {
+		inlineTipjar.WalletUserID = crowdfundWalletUserID
+	}
+	inlineTipjar.Message += inlineTipjar.crowdfundMessage()
+	return inlineTipjar, nil
 
 }
 func (bot TipBot) makeTipjar(ctx context.Context, m *tb.Message, query bool) (*InlineTipjar, error) {
@@ -128,6 +147,10 @@ func (bot TipBot) makeTipjar(ctx context.Context, m *tb.Message, query bool) (*I
 			bot.trySendMessage(m.Sender, Translate(ctx, "inlineSendBalanceLowMessage"))
 			bot.tryDeleteMessage(m)
 			return nil, err
+		case errors.InvalidSyntaxError:
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "inlineTipjarHelpText"), err.(errors.TipBotError).Message))
+			bot.tryDeleteMessage(m)
+			return nil, err
 		}
 	}
 	return tipjar, err
@@ -156,6 +179,9 @@ func (bot TipBot) makeQueryTipjar(ctx intercept.Context) (*InlineTipjar, error)
 			log.Errorf(err.Error())
 			bot.inlineQueryReplyWithError(ctx, TranslateUser(ctx, "inlineSendBalanceLowMessage"), fmt.Sprintf(TranslateUser(ctx, "inlineQueryTipjarDescription"), bot.Telegram.Me.Username))
 			return nil, err
+		case errors.InvalidSyntaxError:
+			bot.inlineQueryReplyWithError(ctx, err.(errors.TipBotError).Message, fmt.Sprintf(TranslateUser(ctx, "inlineQueryTipjarDescription"), bot.Telegram.Me.Username))
+			return nil, err
 		}
 	}
 	return tipjar, err
@@ -189,7 +215,10 @@ func (bot TipBot) tipjarHandler(ctx intercept.Context) (intercept.Context, error
 		return ctx, err
 	}
 	toUserStr := GetUserStr(m.Sender)
-	bot.trySendMessage(m.Chat, inlineTipjar.Message, bot.makeTipjarKeyboard(ctx, inlineTipjar))
+	mTipjar := bot.trySendMessage(m.Chat, inlineTipjar.Message, bot.makeTipjarKeyboard(ctx, inlineTipjar))
+	if mTipjar != nil {
+		inlineTipjar.Editable = tb.StoredMessage{MessageID: strconv.Itoa(mTipjar.ID), ChatID: mTipjar.Chat.ID}
+	}
 	log.Infof("[tipjar] %s created tipjar %s: %d sat (%d per user)", toUserStr, inlineTipjar.ID, inlineTipjar.Amount, inlineTipjar.PerUserAmount)
 	return ctx, inlineTipjar.Set(inlineTipjar, bot.Bunt)
 }
@@ -256,6 +285,10 @@ func (bot *TipBot) acceptInlineTipjarHandler(ctx intercept.Context) (intercept.C
 		bot.tryEditMessage(c, i18n.Translate(inlineTipjar.LanguageCode, "inlineTipjarCancelledMessage"), &tb.ReplyMarkup{})
 		return ctx, errors.Create(errors.NotActiveError)
 	}
+	if inlineTipjar.IsCrowdfund() && time.Now().After(inlineTipjar.Deadline) {
+		// the watcher refunds the pledges
+		return ctx, errors.Create(errors.NotActiveError)
+	}
 
 	if from.Telegram.ID == to.Telegram.ID {
 		bot.trySendMessage(from.Telegram, Translate(ctx, "sendYourselfMessage"))
@@ -281,6 +314,16 @@ func (bot *TipBot) acceptInlineTipjarHandler(ctx intercept.Context) (intercept.C
 		// todo: user new get username function to get userStrings
 		transactionMemo := fmt.Sprintf("🍯 Tipjar from %s to %s.", fromUserStr, toUserStr)
 		t := NewTransaction(bot, from, to, inlineTipjar.PerUserAmount, TransactionType("tipjar"))
+		if inlineTipjar.IsCrowdfund() {
+			// pledges are held in the crowdfunding wallet
+			crowdfundWallet, err := bot.loadHoldingWallet(holdingWalletUserID(inlineTipjar.WalletUserID))
+			if err != nil {
+				bot.trySendMessage(from.Telegram, Translate(ctx, "sendErrorMessage"))
+				return ctx, errors.New(errors.UnknownError, err)
+			}
+			transactionMemo = fmt.Sprintf("🍯 Crowdfunding pledge from %s to %s.", fromUserStr, toUserStr)
+			t = NewTransaction(bot, from, crowdfundWallet, inlineTipjar.PerUserAmount, TransactionType("tipjar"))
+		}
 		t.Memo = transactionMemo
 
 		success, err := t.Send()
@@ -296,32 +339,34 @@ func (bot *TipBot) acceptInlineTipjarHandler(ctx intercept.Context) (intercept.C
 		inlineTipjar.From = append(inlineTipjar.From, from)
 		inlineTipjar.GivenAmount = inlineTipjar.GivenAmount + inlineTipjar.PerUserAmount
 
-		bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "inlineTipjarReceivedMessage"), fromUserStrMd, inlineTipjar.PerUserAmount))
-		bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "inlineTipjarSentMessage"), inlineTipjar.PerUserAmount, toUserStrMd))
+		if inlineTipjar.IsCrowdfund() {
+			bot.trySendMessage(to.Telegram, fmt.Sprintf(crowdfundPledgedMessage, fromUserStrMd, inlineTipjar.PerUserAmount))
+			bot.trySendMessage(from.Telegram, fmt.Sprintf(crowdfundPledgeSentMessage, inlineTipjar.PerUserAmount, toUserStrMd))
+		} else {
+			tipjarReceivedMessage := fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "inlineTipjarReceivedMessage"), fromUserStrMd, inlineTipjar.PerUserAmount)
+			bot.trySendMessage(to.Telegram, tipjarReceivedMessage)
+			bot.sendNostrDirectMessage(to.Telegram, tipjarReceivedMessage)
+			bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "inlineTipjarSentMessage"), inlineTipjar.PerUserAmount, toUserStrMd))
+		}
 		if err != nil {
 			errmsg := fmt.Errorf("[tipjar] Error: Send message to %s: %s", toUserStr, err)
 			log.Warnln(errmsg)
 		}
 
-		// build tipjar message
-		inlineTipjar.Message = fmt.Sprintf(
-			i18n.Translate(inlineTipjar.LanguageCode, "inlineTipjarMessage"),
-			inlineTipjar.PerUserAmount,
-			GetUserStrMd(inlineTipjar.To.Telegram),
-			inlineTipjar.GivenAmount,
-			inlineTipjar.Amount,
-			inlineTipjar.NGiven,
-			MakeTipjarbar(inlineTipjar.GivenAmount, inlineTipjar.Amount),
-		)
-		memo := inlineTipjar.Memo
-		if len(memo) > 0 {
-			inlineTipjar.Message = inlineTipjar.Message + fmt.Sprintf(i18n.Translate(inlineTipjar.LanguageCode, "inlineTipjarAppendMemo"), memo)
+		// remember the message for edits from the crowdfunding watcher
+		if len(inlineTipjar.Editable.MessageID) == 0 {
+			inlineTipjar.Editable.MessageID, inlineTipjar.Editable.ChatID = c.MessageSig()
 		}
+		// build tipjar message
+		inlineTipjar.Message = bot.buildTipjarMessage(inlineTipjar)
 		// update message
 		log.Infoln(inlineTipjar.Message)
 		bot.tryEditMessage(c, inlineTipjar.Message, bot.makeTipjarKeyboard(ctx, inlineTipjar))
 	}
-	if inlineTipjar.GivenAmount >= inlineTipjar.Amount {
+	if inlineTipjar.GivenAmount >= inlineTipjar.Amount && inlineTipjar.IsCrowdfund() {
+		// the goal is reached, if the release fails the watcher tries again
+		runtime.IgnoreError(bot.releaseCrowdfund(inlineTipjar))
+	} else if inlineTipjar.GivenAmount >= inlineTipjar.Amount {
 		// tipjar is full
 		inlineTipjar.Message = fmt.Sprintf(
 			i18n.Translate(inlineTipjar.LanguageCode, "inlineTipjarEndedMessage"),
@@ -354,6 +399,14 @@ func (bot *TipBot) cancelInlineTipjarHandler(ctx intercept.Context) (intercept.C
 	if c.Sender.ID != inlineTipjar.To.Telegram.ID {
 		return ctx, errors.Create(errors.UnknownError)
 	}
+	if inlineTipjar.IsCrowdfund() && inlineTipjar.Active {
+		// pledges go back to the givers, if a refund fails the watcher tries again after the deadline
+		err = bot.refundCrowdfund(inlineTipjar)
+		if err != nil {
+			runtime.IgnoreError(inlineTipjar.Set(inlineTipjar, bot.Bunt))
+			return ctx, err
+		}
+	}
 	bot.tryEditMessage(c, i18n.Translate(inlineTipjar.LanguageCode, "inlineTipjarCancelledMessage"), &tb.ReplyMarkup{})
 
 	// send update to tipjar creator
@@ -366,6 +419,24 @@ func (bot *TipBot) cancelInlineTipjarHandler(ctx intercept.Context) (intercept.C
 	return ctx, inlineTipjar.Set(inlineTipjar, bot.Bunt)
 }
 
+// buildTipjarMessage returns the tipjar message with the current progress
+func (bot *TipBot) buildTipjarMessage(inlineTipjar *InlineTipjar) string {
+	message := fmt.Sprintf(
+		i18n.Translate(inlineTipjar.LanguageCode, "inlineTipjarMessage"),
+		inlineTipjar.PerUserAmount,
+		GetUserStrMd(inlineTipjar.To.Telegram),
+		inlineTipjar.GivenAmount,
+		inlineTipjar.Amount,
+		inlineTipjar.NGiven,
+		MakeTipjarbar(inlineTipjar.GivenAmount, inlineTipjar.Amount),
+	)
+	memo := inlineTipjar.Memo
+	if len(memo) > 0 {
+		message = message + fmt.Sprintf(i18n.Translate(inlineTipjar.LanguageCode, "inlineTipjarAppendMemo"), memo)
+	}
+	return message + inlineTipjar.crowdfundMessage()
+}
+
 func listTipjarGivers(inlineTipjar *InlineTipjar) string {
 	var from_str string
 	from_str = fmt.Sprintf("🍯 *Tipjar summary*\n\nMemo: %s\nCapacity: %d sat\nGivers: %d\nCollected: %d sat\n\n*Givers:*\n\n", inlineTipjar.Memo, inlineTipjar.Amount, inlineTipjar.NGiven, inlineTipjar.GivenAmount)
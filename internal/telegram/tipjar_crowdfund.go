@@ -0,0 +1,226 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	i18n2 "github.com/nicksnyder/go-i18n/v2/i18n"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	crowdfundMessage           = "\n\n🎯 *All or nothing:* pledges are held until the goal is reached and refunded otherwise.\n⏳ Time left: %s"
+	crowdfundPledgedMessage    = "🍯 %s pledged %d sat to your crowdfunding."
+	crowdfundPledgeSentMessage = "🍯 You pledged %d sat to %s. You get it back if the goal is not reached."
+	crowdfundReleasedMessage   = "🎉 %s's crowdfunding reached its goal.\n\n🏅 %d sat given by %d users."
+	crowdfundRefundedMessage   = "↩️ %s's crowdfunding did not reach its goal. All %d pledges were refunded."
+	crowdfundRefundMessage     = "↩️ Your pledge of %d sat to %s was refunded."
+	crowdfundInvalidDeadline   = "🚫 Invalid deadline `%s`. Use e.g. `deadline=3d` (at most %d days)."
+)
+
+const (
+	TipjarStateOpen     = "open"
+	TipjarStateReleased = "released"
+	TipjarStateRefunded = "refunded"
+)
+
+const (
+	crowdfundMaxDeadlineDays  = 30
+	crowdfundMaxDeadline      = crowdfundMaxDeadlineDays * 24 * time.Hour
+	crowdfundTickerDuration   = time.Minute
+	crowdfundDeadlineArgument = "deadline="
+)
+
+// parseTipjarDeadline removes the deadline option from the tipjar command
+func parseTipjarDeadline(text string) (string, time.Time, error) {
+	arguments := strings.Split(text, " ")
+	for i, argument := range arguments {
+		// the first three arguments are the command, capacity and per user amount
+		if i < 3 || !strings.HasPrefix(strings.ToLower(argument), crowdfundDeadlineArgument) {
+			continue
+		}
+		d, err := parseFaucetDuration(argument[len(crowdfundDeadlineArgument):])
+		if err != nil || d <= 0 || d > crowdfundMaxDeadline {
+			return text, time.Time{}, fmt.Errorf(crowdfundInvalidDeadline, argument, crowdfundMaxDeadlineDays)
+		}
+		arguments = append(arguments[:i], arguments[i+1:]...)
+		return strings.Join(arguments, " "), time.Now().Add(d), nil
+	}
+	return text, time.Time{}, nil
+}
+
+// formatTimeLeft rounds the time left to hours, or to minutes in the last hour
+func formatTimeLeft(d time.Duration) string {
+	if d <= 0 {
+		return "0m"
+	}
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d.Minutes())+1)
+	}
+	hours := int(d.Hours())
+	if hours < 24 {
+		return fmt.Sprintf("%dh", hours)
+	}
+	return fmt.Sprintf("%dd %dh", hours/24, hours%24)
+}
+
+// IsCrowdfund returns true if the pledges are held until the deadline
+func (t *InlineTipjar) IsCrowdfund() bool {
+	return !t.Deadline.IsZero()
+}
+
+func (t *InlineTipjar) crowdfundMessage() string {
+	if !t.IsCrowdfund() {
+		return ""
+	}
+	return fmt.Sprintf(crowdfundMessage, formatTimeLeft(time.Until(t.Deadline)))
+}
+
+// releaseCrowdfund pays all pledges to the recipient. The tipjar must be locked.
+func (bot *TipBot) releaseCrowdfund(inlineTipjar *InlineTipjar) error {
+	crowdfundWallet, err := bot.loadHoldingWallet(holdingWalletUserID(inlineTipjar.WalletUserID))
+	if err != nil {
+		return err
+	}
+	t := NewTransaction(bot, crowdfundWallet, inlineTipjar.To, inlineTipjar.GivenAmount, TransactionType("tipjar"))
+	t.Memo = fmt.Sprintf("🍯 Crowdfunding %s released.", inlineTipjar.ID)
+	// save before paying so that a crash can't release twice
+	inlineTipjar.State = TipjarStateReleased
+	inlineTipjar.Active = false
+	if err := inlineTipjar.Set(inlineTipjar, bot.Bunt); err != nil {
+		inlineTipjar.State = TipjarStateOpen
+		inlineTipjar.Active = true
+		return err
+	}
+	success, err := t.Send()
+	if !success {
+		if err == nil {
+			err = fmt.Errorf("transaction failed")
+		}
+		log.Errorf("[tipjar] could not release crowdfunding %s: %v", inlineTipjar.ID, err)
+		inlineTipjar.State = TipjarStateOpen
+		inlineTipjar.Active = true
+		return err
+	}
+	log.Infof("[tipjar] Crowdfunding %s released: %d sat to %s", inlineTipjar.ID, inlineTipjar.GivenAmount, GetUserStr(inlineTipjar.To.Telegram))
+	inlineTipjar.Message = fmt.Sprintf(crowdfundReleasedMessage, GetUserStrMd(inlineTipjar.To.Telegram), inlineTipjar.GivenAmount, inlineTipjar.NGiven)
+	bot.editCrowdfundMessage(inlineTipjar, &tb.ReplyMarkup{})
+	bot.trySendMessage(inlineTipjar.To.Telegram, listTipjarGivers(inlineTipjar))
+	return nil
+}
+
+// refundCrowdfund pays back every giver that has not been refunded yet. The tipjar must be locked.
+func (bot *TipBot) refundCrowdfund(inlineTipjar *InlineTipjar) error {
+	crowdfundWallet, err := bot.loadHoldingWallet(holdingWalletUserID(inlineTipjar.WalletUserID))
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, from := range inlineTipjar.From {
+		if containsUserID(inlineTipjar.Refunded, from.Telegram.ID) {
+			continue
+		}
+		t := NewTransaction(bot, crowdfundWallet, from, inlineTipjar.PerUserAmount, TransactionType("tipjar"))
+		t.Memo = fmt.Sprintf("🍯 Crowdfunding %s refunded.", inlineTipjar.ID)
+		// save before paying so that a crash can't refund twice
+		inlineTipjar.Refunded = append(inlineTipjar.Refunded, from.Telegram.ID)
+		if err := inlineTipjar.Set(inlineTipjar, bot.Bunt); err != nil {
+			inlineTipjar.Refunded = inlineTipjar.Refunded[:len(inlineTipjar.Refunded)-1]
+			return err
+		}
+		success, err := t.Send()
+		if !success {
+			if err == nil {
+				err = fmt.Errorf("transaction failed")
+			}
+			log.Errorf("[tipjar] could not refund %s from crowdfunding %s: %v", GetUserStr(from.Telegram), inlineTipjar.ID, err)
+			inlineTipjar.Refunded = inlineTipjar.Refunded[:len(inlineTipjar.Refunded)-1]
+			lastErr = err
+			continue
+		}
+		bot.trySendMessage(from.Telegram, fmt.Sprintf(crowdfundRefundMessage, inlineTipjar.PerUserAmount, GetUserStrMd(inlineTipjar.To.Telegram)))
+	}
+	if lastErr != nil {
+		// the watcher tries again
+		return lastErr
+	}
+	log.Infof("[tipjar] Crowdfunding %s refunded to %d users", inlineTipjar.ID, len(inlineTipjar.Refunded))
+	inlineTipjar.State = TipjarStateRefunded
+	inlineTipjar.Active = false
+	return nil
+}
+
+func (bot *TipBot) editCrowdfundMessage(inlineTipjar *InlineTipjar, options ...interface{}) {
+	if len(inlineTipjar.Editable.MessageID) == 0 {
+		return
+	}
+	bot.tryEditStack(inlineTipjar.Editable, inlineTipjar.ID, inlineTipjar.Message, options...)
+}
+
+// loadCrowdfunds returns all open crowdfunding tipjars
+func (bot *TipBot) loadCrowdfunds() []*InlineTipjar {
+	var tipjars []*InlineTipjar
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend(TipjarIndex, func(key, value string) bool {
+			t := &InlineTipjar{}
+			err := json.Unmarshal([]byte(value), t)
+			if err != nil || t.Base == nil || !t.Active || !t.IsCrowdfund() || t.To == nil {
+				return true
+			}
+			tipjars = append(tipjars, t)
+			return true // continue iteration
+		})
+	})
+	return tipjars
+}
+
+// startCrowdfundWatcher settles crowdfunding tipjars after their deadline
+// and keeps the time left of the others up to date.
+func (bot *TipBot) startCrowdfundWatcher() {
+	runtime.Watch(crowdfundTickerDuration, bot.loadCrowdfunds, func(t *InlineTipjar) {
+		bot.updateCrowdfund(t.ID)
+	})
+}
+
+func (bot *TipBot) updateCrowdfund(id string) {
+	tx := &InlineTipjar{Base: storage.New(storage.ID(id))}
+	mutex.Lock(tx.ID)
+	defer mutex.Unlock(tx.ID)
+	tn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		log.Errorf("[tipjar] could not load %s: %v", id, err)
+		return
+	}
+	inlineTipjar := tn.(*InlineTipjar)
+	if !inlineTipjar.Active {
+		return
+	}
+	switch {
+	case inlineTipjar.GivenAmount >= inlineTipjar.Amount:
+		// the release failed before
+		runtime.IgnoreError(bot.releaseCrowdfund(inlineTipjar))
+	case time.Now().After(inlineTipjar.Deadline):
+		if bot.refundCrowdfund(inlineTipjar) == nil {
+			inlineTipjar.Message = fmt.Sprintf(crowdfundRefundedMessage, GetUserStrMd(inlineTipjar.To.Telegram), inlineTipjar.NGiven)
+			bot.editCrowdfundMessage(inlineTipjar, &tb.ReplyMarkup{})
+			bot.trySendMessage(inlineTipjar.To.Telegram, listTipjarGivers(inlineTipjar))
+		}
+	default:
+		message := bot.buildTipjarMessage(inlineTipjar)
+		if message == inlineTipjar.Message {
+			return
+		}
+		inlineTipjar.Message = message
+		bot.editCrowdfundMessage(inlineTipjar, bot.makeTipjarKeyboard(context.WithValue(context.Background(), "publicLocalizer", i18n2.NewLocalizer(i18n.Bundle, inlineTipjar.LanguageCode)), inlineTipjar))
+	}
+	runtime.IgnoreError(inlineTipjar.Set(inlineTipjar, bot.Bunt))
+}
@@ -0,0 +1,296 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	topHelpMessage          = "🏆 *Group rankings*\n\n`/top [day|week|month|all]` Show the top tippers and receivers of this group.\n`/top digest <weekday> <hour>` Post the weekly ranking every week at this time (UTC, group owner only).\n`/top digest off` Stop the weekly digest.\n\nHide yourself from the rankings with `/set rankings off` in a private chat."
+	topMessage              = "🏆 *%s in %s*\n\n*Top tippers:*\n%s\n*Top receivers:*\n%s\n*Total:* %d sat in %d transactions by %d users"
+	topEntryMessage         = "%d. %s %d sat\n"
+	topEmptyMessage         = "No transactions yet.\n"
+	topDigestSetMessage     = "🗓 The weekly digest is posted every %s at %02d:00 UTC."
+	topDigestOffMessage     = "🗓 The weekly digest is turned off."
+	topDigestInvalidMessage = "🚫 Please use `/top digest <weekday> <hour>`, e.g. `/top digest monday 18`."
+	topNotOwnerMessage      = "🚫 Only the group owner can schedule the digest."
+	rankingsShownMessage    = "🏆 You are shown in the group rankings."
+	rankingsHiddenMessage   = "🏆 You are hidden from the group rankings."
+	rankingsStatusMessage   = "🏆 You are currently %s in the group rankings. Change it with `/set rankings <on|off>`."
+)
+
+const (
+	topRankingSize           = 10
+	topDigestTickerDuration  = time.Minute
+	topDigestMinimumInterval = 24 * time.Hour
+)
+
+// topPeriods are the periods of /top. A zero duration means all time.
+var topPeriods = map[string]struct {
+	title    string
+	duration time.Duration
+}{
+	"day":   {"Last 24 hours", 24 * time.Hour},
+	"week":  {"Last 7 days", 7 * 24 * time.Hour},
+	"month": {"Last 30 days", 30 * 24 * time.Hour},
+	"all":   {"All time", 0},
+}
+
+// TopDigest is the schedule of the weekly ranking of a group
+type TopDigest struct {
+	ChatID    int64        `gorm:"primaryKey;autoIncrement:false"`
+	ChatName  string       `json:"chat_name"`
+	Weekday   time.Weekday `json:"weekday"`
+	Hour      int          `json:"hour"`
+	LastSent  time.Time    `json:"last_sent"`
+	CreatedBy string       `json:"created_by"`
+}
+
+// RankingEntry is the summed amount of one user in a ranking
+type RankingEntry struct {
+	UserID int64
+	Name   string
+	Amount int64
+}
+
+// RankingTotals are the totals of a group in a period
+type RankingTotals struct {
+	Amount int64
+	Count  int64
+	Users  int64
+}
+
+// hiddenFromRankings returns the Telegram IDs of users that opted out of the rankings
+func (bot *TipBot) hiddenFromRankings() []int64 {
+	var ids []int64
+	tx := bot.DB.Users.Model(&lnbits.User{}).
+		Joins("JOIN settings ON settings.id = users.id").
+		Where("settings.privacy_hide_from_rankings = ?", true).
+		Pluck("users.telegram_id", &ids)
+	if tx.Error != nil {
+		log.Errorf("[top] could not load hidden users: %v", tx.Error)
+	}
+	return ids
+}
+
+// groupRanking sums the successful transactions in a chat since the given time by sender or receiver
+func (bot *TipBot) groupRanking(chatID int64, since time.Time, byReceiver bool, hidden []int64) ([]RankingEntry, error) {
+	idColumn, nameColumn := "from_id", "from_user"
+	if byReceiver {
+		idColumn, nameColumn = "to_id", "to_user"
+	}
+	var entries []RankingEntry
+	tx := bot.DB.Transactions.Model(&Transaction{}).
+		Select(fmt.Sprintf("%s as user_id, max(%s) as name, sum(amount) as amount", idColumn, nameColumn)).
+		Where("chat_id = ? AND success = ? AND time >= ? AND from_id > 0 AND to_id > 0 AND from_id <> to_id", chatID, true, since)
+	if len(hidden) > 0 {
+		tx = tx.Where(fmt.Sprintf("%s NOT IN ?", idColumn), hidden)
+	}
+	tx = tx.Group(idColumn).Order("amount desc").Limit(topRankingSize).Scan(&entries)
+	return entries, tx.Error
+}
+
+func (bot *TipBot) groupTotals(chatID int64, since time.Time) (RankingTotals, error) {
+	var totals RankingTotals
+	tx := bot.DB.Transactions.Model(&Transaction{}).
+		Select("coalesce(sum(amount), 0) as amount, count(*) as count, count(distinct from_id) as users").
+		Where("chat_id = ? AND success = ? AND time >= ? AND from_id > 0 AND to_id > 0 AND from_id <> to_id", chatID, true, since).
+		Scan(&totals)
+	return totals, tx.Error
+}
+
+func formatRanking(entries []RankingEntry) string {
+	if len(entries) == 0 {
+		return topEmptyMessage
+	}
+	ranking := ""
+	for i, entry := range entries {
+		ranking += fmt.Sprintf(topEntryMessage, i+1, str.MarkdownEscape(entry.Name), entry.Amount)
+	}
+	return ranking
+}
+
+// topMessageText returns the rankings of a chat for the period
+func (bot *TipBot) topMessageText(chat *tb.Chat, period string) (string, error) {
+	p := topPeriods[period]
+	since := time.Time{}
+	if p.duration > 0 {
+		since = time.Now().Add(-p.duration)
+	}
+	hidden := bot.hiddenFromRankings()
+	tippers, err := bot.groupRanking(chat.ID, since, false, hidden)
+	if err != nil {
+		return "", err
+	}
+	receivers, err := bot.groupRanking(chat.ID, since, true, hidden)
+	if err != nil {
+		return "", err
+	}
+	totals, err := bot.groupTotals(chat.ID, since)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(topMessage, p.title, str.MarkdownEscape(chat.Title), formatRanking(tippers), formatRanking(receivers),
+		totals.Amount, totals.Count, totals.Users), nil
+}
+
+// topHandler handles the /top command in groups
+func (bot *TipBot) topHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Sender, topHelpMessage)
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	arguments := strings.Fields(m.Text)
+	period := "week"
+	if len(arguments) > 1 {
+		switch strings.ToLower(arguments[1]) {
+		case "digest":
+			return bot.topDigestHandler(ctx, arguments[2:])
+		case "help":
+			bot.trySendMessage(m.Chat, topHelpMessage)
+			return ctx, nil
+		default:
+			if _, ok := topPeriods[strings.ToLower(arguments[1])]; !ok {
+				bot.trySendMessage(m.Chat, topHelpMessage)
+				return ctx, errors.Create(errors.InvalidSyntaxError)
+			}
+			period = strings.ToLower(arguments[1])
+		}
+	}
+	message, err := bot.topMessageText(m.Chat, period)
+	if err != nil {
+		log.Errorf("[top] could not load rankings of %d: %v", m.Chat.ID, err)
+		bot.trySendMessage(m.Chat, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	bot.trySendMessage(m.Chat, message)
+	return ctx, nil
+}
+
+func parseWeekday(input string) (time.Weekday, bool) {
+	input = strings.ToLower(input)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if input == name || input == name[:3] {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// topDigestHandler schedules or stops the weekly digest of a group
+func (bot *TipBot) topDigestHandler(ctx intercept.Context, arguments []string) (intercept.Context, error) {
+	m := ctx.Message()
+	if !bot.isOwner(m.Chat, m.Sender) {
+		bot.trySendMessage(m.Chat, topNotOwnerMessage)
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if len(arguments) == 1 && strings.ToLower(arguments[0]) == "off" {
+		tx := bot.DB.Groups.Delete(&TopDigest{ChatID: m.Chat.ID})
+		if tx.Error != nil {
+			return ctx, tx.Error
+		}
+		bot.trySendMessage(m.Chat, topDigestOffMessage)
+		return ctx, nil
+	}
+	if len(arguments) != 2 {
+		bot.trySendMessage(m.Chat, topDigestInvalidMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	weekday, ok := parseWeekday(arguments[0])
+	hour, err := strconv.Atoi(arguments[1])
+	if !ok || err != nil || hour < 0 || hour > 23 {
+		bot.trySendMessage(m.Chat, topDigestInvalidMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	digest := &TopDigest{ChatID: m.Chat.ID, ChatName: m.Chat.Title, Weekday: weekday, Hour: hour, CreatedBy: GetUserStr(m.Sender)}
+	tx := bot.DB.Groups.Save(digest)
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	log.Infof("[top] %s scheduled the digest of %s on %s at %02d:00 UTC", GetUserStr(m.Sender), m.Chat.Title, weekday, hour)
+	bot.trySendMessage(m.Chat, fmt.Sprintf(topDigestSetMessage, weekday, hour))
+	return ctx, nil
+}
+
+// startTopDigestScheduler posts the weekly rankings of groups with a digest
+func (bot *TipBot) startTopDigestScheduler() {
+	runtime.Watch(topDigestTickerDuration, bot.loadDueTopDigests, bot.sendTopDigest)
+}
+
+// loadDueTopDigests returns the digests scheduled for the current hour
+func (bot *TipBot) loadDueTopDigests() []TopDigest {
+	now := time.Now().UTC()
+	var digests []TopDigest
+	tx := bot.DB.Groups.Where("weekday = ? AND hour = ?", now.Weekday(), now.Hour()).Find(&digests)
+	if tx.Error != nil {
+		log.Errorf("[top] could not load digests: %v", tx.Error)
+		return nil
+	}
+	return digests
+}
+
+func (bot *TipBot) sendTopDigest(digest TopDigest) {
+	now := time.Now().UTC()
+	if now.Sub(digest.LastSent) < topDigestMinimumInterval {
+		return
+	}
+	chat := &tb.Chat{ID: digest.ChatID, Title: digest.ChatName}
+	message, err := bot.topMessageText(chat, "week")
+	if err != nil {
+		log.Errorf("[top] could not create digest of %d: %v", digest.ChatID, err)
+		return
+	}
+	bot.trySendMessage(chat, message)
+	digest.LastSent = now
+	bot.DB.Groups.Save(&digest)
+}
+
+// rankingSettingHandler handles /set rankings <on|off>
+func (bot *TipBot) rankingSettingHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	arguments := strings.Fields(m.Text)
+	if len(arguments) < 3 {
+		status := "shown"
+		if user.Settings.Privacy.HideFromRankings {
+			status = "hidden"
+		}
+		bot.trySendMessage(m.Sender, fmt.Sprintf(rankingsStatusMessage, status))
+		return ctx, nil
+	}
+	switch strings.ToLower(arguments[2]) {
+	case "on":
+		user.Settings.Privacy.HideFromRankings = false
+	case "off":
+		user.Settings.Privacy.HideFromRankings = true
+	default:
+		bot.trySendMessage(m.Sender, settingsHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[rankingSettingHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	if user.Settings.Privacy.HideFromRankings {
+		bot.trySendMessage(m.Sender, rankingsHiddenMessage)
+	} else {
+		bot.trySendMessage(m.Sender, rankingsShownMessage)
+	}
+	return ctx, nil
+}
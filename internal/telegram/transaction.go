@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	decodepay "github.com/fiatjaf/ln-decodepay"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/massmux/SatsMobiBot/internal/lnbits"
@@ -91,6 +92,12 @@ func (t *Transaction) SendTransaction(bot *TipBot, from *lnbits.User, to *lnbits
 	t.FromWallet = from.Wallet.ID
 	t.FromLNbitsID = from.ID
 
+	err := bot.CheckSpendingLimits(from, amount, t.Type, "")
+	if err != nil {
+		log.Warnf("[Send] Spending limits of %s refused %d sat: %s", fromUserStr, amount, err.Error())
+		return false, err
+	}
+
 	// check if fromUser has balance
 	balance, err := bot.GetUserBalance(from)
 	if err != nil {
@@ -145,3 +152,17 @@ func (t *Transaction) SendTransaction(bot *TipBot, from *lnbits.User, to *lnbits
 
 	return true, err
 }
+
+// PayInvoice pays paymentRequest from the wallet of user. It is the only way to pay
+// an invoice on behalf of a user, the spending limits of the user are checked first.
+func (bot *TipBot) PayInvoice(user *lnbits.User, paymentRequest string, source string, confirmation string) (lnbits.Invoice, error) {
+	bolt11, err := decodepay.Decodepay(paymentRequest)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	err = bot.CheckSpendingLimits(user, bolt11.MSatoshi/1000, source, confirmation)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	return user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: paymentRequest}, bot.Client)
+}
@@ -22,7 +22,7 @@ type TransactionsList struct {
 	TxPerPage    int             `json:"txperpage"`
 }
 
-func (txlist *TransactionsList) printTransactions(ctx intercept.Context) string {
+func (txlist *TransactionsList) printTransactions(ctx intercept.Context, bot *TipBot) string {
 	txstr := ""
 	// for _, p := range payments {
 	payments := txlist.Payments
@@ -53,6 +53,9 @@ func (txlist *TransactionsList) printTransactions(ctx intercept.Context) string
 		timestr := time.Unix(int64(p.Time), 0).UTC().Format("2 Jan 06 15:04")
 		txstr += fmt.Sprintf("` %s`", timestr)
 		txstr += fmt.Sprintf("` %+d sat`", p.Amount/1000)
+		if fiat := bot.fiatValue(txlist.User, p.Amount/1000, time.Unix(int64(p.Time), 0)); len(fiat) > 0 {
+			txstr += fmt.Sprintf(" _(%s)_", fiat)
+		}
 		if p.Fee > 0 {
 			fee := p.Fee
 			if fee < 1000 {
@@ -123,7 +126,7 @@ func (bot *TipBot) transactionsHandler(ctx intercept.Context) (intercept.Context
 		MaxPages:     (len(payments)+1)/tx_per_page + 1,
 	}
 	bot.Cache.Set(fmt.Sprintf("%s_transactions", user.Name), transactionsList, &store.Options{Expiration: 1 * time.Minute})
-	txstr := transactionsList.printTransactions(ctx)
+	txstr := transactionsList.printTransactions(ctx, bot)
 	bot.trySendMessage(m.Sender, txstr, bot.makeTransactionsKeyboard(ctx, transactionsList))
 	return ctx, nil
 }
@@ -145,7 +148,7 @@ func (bot *TipBot) transactionsScrollLeftHandler(ctx intercept.Context) (interce
 			return ctx, err
 		}
 		bot.Cache.Set(fmt.Sprintf("%s_transactions", user.Name), transactionsList, &store.Options{Expiration: 1 * time.Minute})
-		bot.tryEditMessage(c.Message, transactionsList.printTransactions(ctx), bot.makeTransactionsKeyboard(ctx, transactionsList))
+		bot.tryEditMessage(c.Message, transactionsList.printTransactions(ctx, bot), bot.makeTransactionsKeyboard(ctx, transactionsList))
 	}
 	return ctx, nil
 }
@@ -167,7 +170,7 @@ func (bot *TipBot) transactionsScrollRightHandler(ctx intercept.Context) (interc
 			return ctx, nil
 		}
 		bot.Cache.Set(fmt.Sprintf("%s_transactions", user.Name), transactionsList, &store.Options{Expiration: 1 * time.Minute})
-		bot.tryEditMessage(c.Message, transactionsList.printTransactions(ctx), bot.makeTransactionsKeyboard(ctx, transactionsList))
+		bot.tryEditMessage(c.Message, transactionsList.printTransactions(ctx, bot), bot.makeTransactionsKeyboard(ctx, transactionsList))
 	}
 	return ctx, nil
 }
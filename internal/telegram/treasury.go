@@ -0,0 +1,547 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	"github.com/massmux/SatsMobiBot/pkg/lightning"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	treasuryHelpMessage             = "🏦 *Group treasury*\n\n`/group treasury` 💰 Show the treasury of this group.\n`/group treasury create <approvals> [<@admin> ...]` 🏗 Create the treasury. Spending needs <approvals> of the listed admins (default: all admins).\n`/group treasury spend <amount> <@user|address|lnurl> [<memo>]` 💸 Request a payment from the treasury.\n`/group treasury donate <amount>` 🎁 Donate to the treasury.\n`/group treasury log` 📜 Show the latest spend requests.\n\nYou can also donate by tipping a message of the bot in this group."
+	treasuryInfoMessage             = "🏦 *Treasury of %s*\n\nBalance: %d sat\nApprovals needed: %d of %d\nApprovers: %s\nDonations: `/group treasury donate <amount>`"
+	treasuryCreatedMessage          = "🏦 *Treasury created.*\n\nSpending needs %d of %d approvals by %s.\nDonate with `/group treasury donate <amount>` or by tipping a message of the bot."
+	treasuryNotFoundMessage         = "🚫 This group has no treasury. The group owner can create one with `/group treasury create <approvals>`."
+	treasuryGroupNotAddedMessage    = "🚫 Add this group first with `/group add <group_name>`."
+	treasuryNotOwnerMessage         = "🚫 Only the group owner can create the treasury."
+	treasuryDonatedMessage          = "🎁 %s donated %d sat to the treasury."
+	treasuryDonateFailedMessage     = "🚫 Donation failed: %s"
+	treasuryInvalidApproverMessage  = "🚫 %s is not an admin of this group."
+	treasuryInvalidThresholdMessage = "🚫 The number of approvals must be between 1 and %d."
+	treasuryNotApproverMessage      = "🚫 Only treasury approvers can do this."
+	treasuryInvalidToMessage        = "🚫 Recipient must be a @user, a lightning address or a LNURL."
+	treasurySpendMessage            = "💸 *Treasury spend request* `%s`\n\n%s requests %d sat to %s.%s\n\n✅ %d of %d approvals · 🚫 %d rejections"
+	treasurySpendMemoMessage        = "\nMemo: %s"
+	treasurySpendPaidMessage        = "✅ *Treasury spend* `%s` *approved.*\n\nPaid %d sat to %s.\nApproved by %s."
+	treasurySpendFailedMessage      = "🚫 *Treasury spend* `%s` *approved but the payment failed:* %s"
+	treasurySpendRejectedMessage    = "🚫 *Treasury spend* `%s` *rejected.*\n\n%d sat to %s was rejected by %s."
+	treasuryAlreadyVotedMessage     = "You have already voted."
+	treasuryVotedMessage            = "Your vote was recorded."
+	treasurySpendClosedMessage      = "This request is already %s."
+	treasuryLogMessage              = "📜 *Latest treasury spend requests:*\n"
+	treasuryLogEntryMessage         = "\n`%s` %s: %d sat to %s by %s (%s)%s"
+	treasuryLogVoteMessage          = "\n    %s %s `%s`"
+	treasuryLogEmptyMessage         = "📜 No spend requests yet."
+)
+
+const (
+	TreasurySpendStatusPending  = "pending"
+	TreasurySpendStatusRejected = "rejected"
+	TreasurySpendStatusPaid     = "paid"
+	TreasurySpendStatusFailed   = "failed"
+)
+
+const (
+	treasuryTimeLayout = "2006-01-02 15:04"
+	treasuryLogLimit   = 10
+)
+
+var (
+	treasurySpendMenu  = &tb.ReplyMarkup{}
+	btnTreasuryApprove = treasurySpendMenu.Data("✅ Approve", "treasury_approve")
+	btnTreasuryReject  = treasurySpendMenu.Data("🚫 Reject", "treasury_reject")
+)
+
+// Treasury holds the spending rules of a group wallet
+type Treasury struct {
+	GroupID   int64              `json:"group_id" gorm:"primaryKey"`
+	Threshold int                `json:"threshold"`
+	Approvers []TreasuryApprover `json:"approvers" gorm:"foreignKey:GroupID;references:GroupID"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+// TreasuryApprover is an admin who can approve spend requests of a treasury
+type TreasuryApprover struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	GroupID  int64  `json:"group_id" gorm:"index"`
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// TreasurySpend is a request to pay from a treasury
+type TreasurySpend struct {
+	ID              string    `json:"id" gorm:"primaryKey"`
+	GroupID         int64     `json:"group_id" gorm:"index"`
+	Amount          int64     `json:"amount"`
+	Recipient       string    `json:"recipient"`
+	Memo            string    `json:"memo"`
+	RequestedBy     int64     `json:"requested_by"`
+	RequestedByName string    `json:"requested_by_name"`
+	Status          string    `json:"status"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// TreasuryApproval is the audit log entry of a vote on a spend request
+type TreasuryApproval struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	SpendID   string    `json:"spend_id" gorm:"index"`
+	GroupID   int64     `json:"group_id"`
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	Approved  bool      `json:"approved"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (t *Treasury) isApprover(user *tb.User) bool {
+	for _, approver := range t.Approvers {
+		if approver.UserID == user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Treasury) approverNames() string {
+	var names []string
+	for _, approver := range t.Approvers {
+		names = append(names, str.MarkdownEscape(approver.Username))
+	}
+	return strings.Join(names, ", ")
+}
+
+// treasuryTelegramUser is the user that owns the treasury wallet of a group.
+// It has no username so that it can't be addressed like (or collide with) a real user,
+// the wallet is always resolved by the ID of the group.
+func treasuryTelegramUser(group *Group) *tb.User {
+	return &tb.User{
+		ID:        group.ID,
+		FirstName: fmt.Sprintf("Treasury %s", group.Title),
+	}
+}
+
+func (bot *TipBot) loadTreasury(groupID int64) (*Treasury, error) {
+	treasury := &Treasury{}
+	tx := bot.DB.Groups.Preload("Approvers").Where("group_id = ?", groupID).First(treasury)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return treasury, nil
+}
+
+// loadTreasuryUser returns the wallet owner of the treasury of a group
+func (bot *TipBot) loadTreasuryUser(groupID int64) (*lnbits.User, error) {
+	if _, err := bot.loadTreasury(groupID); err != nil {
+		return nil, err
+	}
+	user, err := GetLnbitsUser(&tb.User{ID: groupID}, *bot)
+	if err != nil {
+		return nil, err
+	}
+	if user.Wallet == nil {
+		return nil, errors.Create(errors.UserNoWalletError)
+	}
+	return user, nil
+}
+
+// treasuryHandler is invoked on /group treasury <cmd>
+func (bot *TipBot) treasuryHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Chat.Type == tb.ChatPrivate {
+		bot.trySendMessage(m.Chat, treasuryHelpMessage)
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	group := &Group{}
+	tx := bot.DB.Groups.Where("id = ?", m.Chat.ID).First(group)
+	if tx.Error != nil {
+		bot.trySendMessage(m.Chat, treasuryGroupNotAddedMessage)
+		return ctx, tx.Error
+	}
+	command, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		return bot.treasuryInfoHandler(ctx, group)
+	}
+	switch strings.ToLower(command) {
+	case "create":
+		return bot.treasuryCreateHandler(ctx, group)
+	case "spend":
+		return bot.treasurySpendHandler(ctx, group)
+	case "donate":
+		return bot.treasuryDonateHandler(ctx, group)
+	case "log":
+		return bot.treasuryLogHandler(ctx, group)
+	}
+	bot.trySendMessage(m.Chat, treasuryHelpMessage)
+	return ctx, nil
+}
+
+func (bot *TipBot) treasuryInfoHandler(ctx intercept.Context, group *Group) (intercept.Context, error) {
+	m := ctx.Message()
+	treasury, err := bot.loadTreasury(group.ID)
+	if err != nil {
+		bot.trySendMessage(m.Chat, treasuryNotFoundMessage)
+		return ctx, err
+	}
+	treasuryUser, err := bot.loadTreasuryUser(group.ID)
+	if err != nil {
+		return ctx, err
+	}
+	balance, err := bot.GetUserBalance(treasuryUser)
+	if err != nil {
+		return ctx, err
+	}
+	bot.trySendMessage(m.Chat, fmt.Sprintf(treasuryInfoMessage,
+		str.MarkdownEscape(group.Title), balance, treasury.Threshold, len(treasury.Approvers),
+		treasury.approverNames()))
+	return ctx, nil
+}
+
+// treasuryCreateHandler creates the treasury wallet of a group or updates its approvers.
+// Only the group owner can call it.
+func (bot *TipBot) treasuryCreateHandler(ctx intercept.Context, group *Group) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if !bot.isOwner(m.Chat, user.Telegram) {
+		bot.trySendMessage(m.Chat, treasuryNotOwnerMessage)
+		return ctx, fmt.Errorf("not owner")
+	}
+	splits := strings.Fields(m.Text)
+	if len(splits) < 4 {
+		bot.trySendMessage(m.Chat, treasuryHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	admins, err := bot.Telegram.AdminsOf(m.Chat)
+	if err != nil {
+		return ctx, err
+	}
+	var approvers []TreasuryApprover
+	if len(splits) > 4 {
+		// approvers are given as @usernames and must be admins of the group
+		for _, name := range splits[4:] {
+			found := false
+			for _, admin := range admins {
+				if admin.User.IsBot || !strings.EqualFold(admin.User.Username, strings.TrimPrefix(name, "@")) {
+					continue
+				}
+				approvers = append(approvers, TreasuryApprover{GroupID: group.ID, UserID: admin.User.ID, Username: GetUserStr(admin.User)})
+				found = true
+				break
+			}
+			if !found {
+				bot.trySendMessage(m.Chat, fmt.Sprintf(treasuryInvalidApproverMessage, str.MarkdownEscape(name)))
+				return ctx, fmt.Errorf("%s is not an admin", name)
+			}
+		}
+	} else {
+		for _, admin := range admins {
+			if admin.User.IsBot {
+				continue
+			}
+			approvers = append(approvers, TreasuryApprover{GroupID: group.ID, UserID: admin.User.ID, Username: GetUserStr(admin.User)})
+		}
+	}
+	threshold, err := strconv.Atoi(splits[3])
+	if err != nil || threshold < 1 || threshold > len(approvers) {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(treasuryInvalidThresholdMessage, len(approvers)))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+
+	tbUser := treasuryTelegramUser(group)
+	if treasuryUser, exists := bot.UserExists(tbUser); !exists {
+		_, err = bot.CreateWalletForTelegramUser(tbUser)
+		if err != nil {
+			return ctx, err
+		}
+	} else if treasuryUser.Telegram != nil && len(treasuryUser.Telegram.Username) > 0 {
+		// treasuries used to have a username, release it
+		treasuryUser.Telegram = tbUser
+		if err = UpdateUserRecord(treasuryUser, *bot); err != nil {
+			return ctx, err
+		}
+	}
+
+	treasury := &Treasury{
+		GroupID:   group.ID,
+		Threshold: threshold,
+		Approvers: approvers,
+		CreatedAt: time.Now(),
+	}
+	// replace the approvers of an existing treasury
+	bot.DB.Groups.Where("group_id = ?", group.ID).Delete(&TreasuryApprover{})
+	if tx := bot.DB.Groups.Save(treasury); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	log.Infof("[treasury] %s created treasury of group %s with %d of %d approvals", GetUserStr(user.Telegram), group.Name, treasury.Threshold, len(approvers))
+	bot.trySendMessage(m.Chat, fmt.Sprintf(treasuryCreatedMessage, treasury.Threshold, len(approvers), treasury.approverNames()))
+	return ctx, nil
+}
+
+// treasurySpendHandler creates a spend request and posts the approval buttons.
+// The vote of the requester counts as approval.
+func (bot *TipBot) treasurySpendHandler(ctx intercept.Context, group *Group) (intercept.Context, error) {
+	m := ctx.Message()
+	treasury, err := bot.loadTreasury(group.ID)
+	if err != nil {
+		bot.trySendMessage(m.Chat, treasuryNotFoundMessage)
+		return ctx, err
+	}
+	if !treasury.isApprover(m.Sender) {
+		bot.trySendMessage(m.Chat, treasuryNotApproverMessage)
+		return ctx, fmt.Errorf("not an approver")
+	}
+	splits := strings.Fields(m.Text)
+	if len(splits) < 5 {
+		bot.trySendMessage(m.Chat, treasuryHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(splits[3])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "lnurlInvalidAmountMessage"))
+		return ctx, errors.New(errors.InvalidAmountError, err)
+	}
+	recipient := strings.TrimPrefix(splits[4], "lightning:")
+	switch {
+	case strings.HasPrefix(recipient, "@"):
+		if _, err := GetUserByTelegramUsername(strings.TrimPrefix(recipient, "@"), *bot); err != nil {
+			bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape(recipient)))
+			return ctx, err
+		}
+	case lightning.IsLightningAddress(recipient), lightning.IsLnurl(recipient):
+	default:
+		bot.trySendMessage(m.Chat, treasuryInvalidToMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+
+	spend := &TreasurySpend{
+		ID:              fmt.Sprintf("treasury-%s", RandStringRunes(8)),
+		GroupID:         group.ID,
+		Amount:          amount,
+		Recipient:       recipient,
+		Memo:            GetMemoFromCommand(m.Text, 5),
+		RequestedBy:     m.Sender.ID,
+		RequestedByName: GetUserStr(m.Sender),
+		Status:          TreasurySpendStatusPending,
+	}
+	if tx := bot.DB.Groups.Create(spend); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	log.Infof("[treasury] %s requested %d sat to %s from treasury of group %s", GetUserStr(m.Sender), amount, recipient, group.Name)
+
+	mutex.LockWithContext(ctx, spend.ID)
+	defer mutex.UnlockWithContext(ctx, spend.ID)
+	message, markup := bot.voteTreasurySpend(ctx, treasury, spend, m.Sender, true)
+	bot.trySendMessage(m.Chat, message, markup)
+	return ctx, nil
+}
+
+// treasuryDonateHandler sends sats of the user to the treasury of the group
+func (bot *TipBot) treasuryDonateHandler(ctx intercept.Context, group *Group) (intercept.Context, error) {
+	m := ctx.Message()
+	from := LoadUser(ctx)
+	if from.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	to, err := bot.loadTreasuryUser(group.ID)
+	if err != nil {
+		bot.trySendMessage(m.Chat, treasuryNotFoundMessage)
+		return ctx, err
+	}
+	amountStr, err := getArgumentFromCommand(m.Text, 3)
+	if err != nil {
+		bot.trySendMessage(m.Chat, treasuryHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(amountStr)
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "lnurlInvalidAmountMessage"))
+		return ctx, errors.New(errors.InvalidAmountError, err)
+	}
+	t := NewTransaction(bot, from, to, amount, TransactionType("treasury"), TransactionChat(m.Chat))
+	t.Memo = fmt.Sprintf("🎁 Donation to the treasury of %s", group.Title)
+	success, err := t.Send()
+	if !success {
+		if err == nil {
+			err = fmt.Errorf("transaction failed")
+		}
+		bot.trySendMessage(m.Chat, fmt.Sprintf(treasuryDonateFailedMessage, str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	log.Infof("[treasury] %s donated %d sat to treasury of group %s", GetUserStr(from.Telegram), amount, group.Name)
+	bot.trySendMessage(m.Chat, fmt.Sprintf(treasuryDonatedMessage, GetUserStrMd(from.Telegram), amount))
+	return ctx, nil
+}
+
+func (bot *TipBot) treasuryLogHandler(ctx intercept.Context, group *Group) (intercept.Context, error) {
+	m := ctx.Message()
+	var spends []TreasurySpend
+	bot.DB.Groups.Where("group_id = ?", group.ID).Order("created_at desc").Limit(treasuryLogLimit).Find(&spends)
+	if len(spends) == 0 {
+		bot.trySendMessage(m.Chat, treasuryLogEmptyMessage)
+		return ctx, nil
+	}
+	message := treasuryLogMessage
+	for _, spend := range spends {
+		memo := ""
+		if len(spend.Memo) > 0 {
+			memo = fmt.Sprintf(": %s", str.MarkdownEscape(spend.Memo))
+		}
+		message += fmt.Sprintf(treasuryLogEntryMessage, spend.ID, spend.Status, spend.Amount,
+			str.MarkdownEscape(spend.Recipient), str.MarkdownEscape(spend.RequestedByName), spend.CreatedAt.Format(treasuryTimeLayout), memo)
+		for _, approval := range bot.getTreasuryApprovals(spend.ID) {
+			vote := "✅"
+			if !approval.Approved {
+				vote = "🚫"
+			}
+			message += fmt.Sprintf(treasuryLogVoteMessage, vote, str.MarkdownEscape(approval.Username), approval.CreatedAt.Format(treasuryTimeLayout))
+		}
+	}
+	bot.trySendMessage(m.Chat, message)
+	return ctx, nil
+}
+
+func (bot *TipBot) getTreasuryApprovals(spendID string) []TreasuryApproval {
+	var approvals []TreasuryApproval
+	bot.DB.Groups.Where("spend_id = ?", spendID).Order("created_at asc").Find(&approvals)
+	return approvals
+}
+
+// approveTreasurySpendHandler is invoked when an approver presses the approve button
+func (bot *TipBot) approveTreasurySpendHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.treasuryVoteHandler(ctx, true)
+}
+
+// rejectTreasurySpendHandler is invoked when an approver presses the reject button
+func (bot *TipBot) rejectTreasurySpendHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.treasuryVoteHandler(ctx, false)
+}
+
+func (bot *TipBot) treasuryVoteHandler(ctx intercept.Context, approved bool) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	spend := &TreasurySpend{}
+	if tx := bot.DB.Groups.Where("id = ?", c.Data).First(spend); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	if spend.Status != TreasurySpendStatusPending {
+		ctx.Context = context.WithValue(ctx, "callback_response", fmt.Sprintf(treasurySpendClosedMessage, spend.Status))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	treasury, err := bot.loadTreasury(spend.GroupID)
+	if err != nil {
+		return ctx, err
+	}
+	if !treasury.isApprover(c.Sender) {
+		ctx.Context = context.WithValue(ctx, "callback_response", treasuryNotApproverMessage)
+		return ctx, fmt.Errorf("not an approver")
+	}
+	for _, approval := range bot.getTreasuryApprovals(spend.ID) {
+		if approval.UserID == c.Sender.ID {
+			ctx.Context = context.WithValue(ctx, "callback_response", treasuryAlreadyVotedMessage)
+			return ctx, fmt.Errorf("already voted")
+		}
+	}
+	message, markup := bot.voteTreasurySpend(ctx, treasury, spend, c.Sender, approved)
+	ctx.Context = context.WithValue(ctx, "callback_response", treasuryVotedMessage)
+	bot.tryEditMessage(c, message, markup)
+	return ctx, nil
+}
+
+// voteTreasurySpend records a vote and pays the spend request once enough approvals are collected.
+// It returns the updated spend message and its buttons.
+func (bot *TipBot) voteTreasurySpend(ctx context.Context, treasury *Treasury, spend *TreasurySpend, voter *tb.User, approved bool) (string, *tb.ReplyMarkup) {
+	bot.DB.Groups.Create(&TreasuryApproval{
+		SpendID:  spend.ID,
+		GroupID:  spend.GroupID,
+		UserID:   voter.ID,
+		Username: GetUserStr(voter),
+		Approved: approved,
+	})
+	log.Infof("[treasury] %s voted %t on %s", GetUserStr(voter), approved, spend.ID)
+
+	var approvers, rejecters []string
+	for _, approval := range bot.getTreasuryApprovals(spend.ID) {
+		if approval.Approved {
+			approvers = append(approvers, str.MarkdownEscape(approval.Username))
+		} else {
+			rejecters = append(rejecters, str.MarkdownEscape(approval.Username))
+		}
+	}
+	recipient := str.MarkdownEscape(spend.Recipient)
+
+	if len(rejecters) > len(treasury.Approvers)-treasury.Threshold {
+		spend.Status = TreasurySpendStatusRejected
+		bot.DB.Groups.Save(spend)
+		log.Infof("[treasury] %s rejected", spend.ID)
+		return fmt.Sprintf(treasurySpendRejectedMessage, spend.ID, spend.Amount, recipient, strings.Join(rejecters, ", ")), &tb.ReplyMarkup{}
+	}
+	if len(approvers) >= treasury.Threshold {
+		err := bot.payTreasurySpend(spend)
+		if err != nil {
+			spend.Status = TreasurySpendStatusFailed
+			bot.DB.Groups.Save(spend)
+			log.Errorf("[treasury] could not pay %s: %v", spend.ID, err)
+			return fmt.Sprintf(treasurySpendFailedMessage, spend.ID, str.MarkdownEscape(err.Error())), &tb.ReplyMarkup{}
+		}
+		spend.Status = TreasurySpendStatusPaid
+		bot.DB.Groups.Save(spend)
+		log.Infof("[treasury] paid %s: %d sat to %s", spend.ID, spend.Amount, spend.Recipient)
+		return fmt.Sprintf(treasurySpendPaidMessage, spend.ID, spend.Amount, recipient, strings.Join(approvers, ", ")), &tb.ReplyMarkup{}
+	}
+
+	memo := ""
+	if len(spend.Memo) > 0 {
+		memo = fmt.Sprintf(treasurySpendMemoMessage, str.MarkdownEscape(spend.Memo))
+	}
+	menu := &tb.ReplyMarkup{}
+	menu.Inline(menu.Row(
+		menu.Data(btnTreasuryApprove.Text, btnTreasuryApprove.Unique, spend.ID),
+		menu.Data(btnTreasuryReject.Text, btnTreasuryReject.Unique, spend.ID)),
+	)
+	return fmt.Sprintf(treasurySpendMessage, spend.ID, str.MarkdownEscape(spend.RequestedByName), spend.Amount, recipient, memo,
+		len(approvers), treasury.Threshold, len(rejecters)), menu
+}
+
+// payTreasurySpend pays an approved spend request from the treasury wallet
+func (bot *TipBot) payTreasurySpend(spend *TreasurySpend) error {
+	from, err := bot.loadTreasuryUser(spend.GroupID)
+	if err != nil {
+		return err
+	}
+	chat := &tb.Chat{ID: spend.GroupID}
+	memo := fmt.Sprintf("🏦 Treasury spend %s", spend.ID)
+	if len(spend.Memo) > 0 {
+		memo = fmt.Sprintf("%s: %s", memo, spend.Memo)
+	}
+	if !strings.HasPrefix(spend.Recipient, "@") {
+		return bot.payLnurlAndLog(from, spend.Recipient, spend.Amount, memo, TransactionType("treasury"), TransactionChat(chat))
+	}
+	to, err := GetUserByTelegramUsername(strings.TrimPrefix(spend.Recipient, "@"), *bot)
+	if err != nil {
+		return err
+	}
+	t := NewTransaction(bot, from, to, spend.Amount, TransactionType("treasury"), TransactionChat(chat))
+	t.Memo = memo
+	success, err := t.Send()
+	if !success {
+		if err == nil {
+			err = fmt.Errorf("transaction failed")
+		}
+		return err
+	}
+	sendReceivedMessage := fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), GetUserStrMd(from.Telegram), spend.Amount) + bot.fiatValueText(to, spend.Amount, time.Now())
+	bot.trySendMessage(to.Telegram, sendReceivedMessage)
+	bot.sendNostrDirectMessage(to.Telegram, sendReceivedMessage)
+	return nil
+}
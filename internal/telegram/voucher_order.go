@@ -0,0 +1,323 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+)
+
+var (
+	voucherOrdersMessage         = "🧾 *Your orders*\n\n%s\nShow an order with `/order <id>`."
+	voucherOrdersEntryMessage    = "`%s` %s %s %s %s — %s\n"
+	voucherOrdersEmptyMessage    = "🧾 You have no orders yet. Buy sats with `/buy [amount] <IBAN>` or sell with `/sell <amount> <IBAN>`."
+	voucherOrderMessage          = "🧾 *Order* `%s`\n\nDate: %s\nFiat amount: %s %s\nFrom IBAN: %s\nRecipient: %s\nStatus: %s\n\nSEPA Bank transfer coordinates\nBeneficiary: `%s`\nAddress: `%s`\nBank: `%s`\nIBAN: `%s`\nBIC: `%s`\nPayment reason: `%s`"
+	voucherOrderNotFoundMessage  = "🚫 Order `%s` not found."
+	voucherOrderAcceptedMessage  = "✅ Order `%s` was accepted by the provider."
+	voucherOrderReceivedMessage  = "🏦 The payment for order `%s` was received. Your sats are on the way."
+	voucherOrderDeliveredMessage = "⚡️ Order `%s` completed. Your sats were delivered."
+	voucherOrderCanceledMessage  = "❌ Order `%s` was canceled."
+	voucherOrderStatusMessage    = "🧾 Order `%s` is now: %s"
+	voucherSellOrderMessage      = "🧾 *Sell order* `%s`\n\nDate: %s\nFiat amount: %s %s\nFee: %s%%\nPayout: %s\nTo IBAN: %s\nPaid: %d sat\nStatus: %s"
+	voucherSellReceivedMessage   = "⚡️ The provider received the sats of order `%s`. Your bank payout is on the way."
+	voucherSellDeliveredMessage  = "🏦 Order `%s` completed. The bank payout was sent."
+	voucherProviderErrorMessage  = "🚫 The provider refused the request: %s"
+)
+
+const (
+	VoucherOrderStatusAccepted        = "order.accepted"
+	VoucherOrderStatusPaymentNotified = "order.payment_notified"
+	VoucherOrderStatusPaymentReceived = "order.payment_received"
+	VoucherOrderStatusCompleted       = "order.completed"
+	VoucherOrderStatusCanceled        = "order.canceled"
+	VoucherOrderStatusPaymentSent     = "order.payment_sent"
+)
+
+const (
+	VoucherOrderTypeBuy  = "buy"
+	VoucherOrderTypeSell = "sell"
+)
+
+const (
+	voucherOrderTickerDuration = time.Minute
+	// orders are not polled anymore after this time
+	voucherOrderMaxAge     = 30 * 24 * time.Hour
+	voucherOrdersListLimit = 10
+)
+
+// voucherOrderStatusNames are the readable names of the statuses of the provider
+var voucherOrderStatusNames = map[string]string{
+	VoucherOrderStatusAccepted:        "waiting for your bank transfer",
+	VoucherOrderStatusPaymentNotified: "payment confirmed by you",
+	VoucherOrderStatusPaymentReceived: "payment received",
+	VoucherOrderStatusCompleted:       "completed",
+	VoucherOrderStatusCanceled:        "canceled",
+	VoucherOrderStatusPaymentSent:     "sats sent, waiting for the bank payout",
+}
+
+// VoucherOrder is a purchase of sats with a bank transfer through the voucher provider
+type VoucherOrder struct {
+	*storage.Base
+	OrderID            string       `json:"order_id"`
+	Type               string       `json:"type"`
+	User               *lnbits.User `json:"user"`
+	LanguageCode       string       `json:"languagecode"`
+	Iban               string       `json:"iban"`
+	Amount             string       `json:"amount"`
+	Currency           string       `json:"currency"`
+	Recipient          string       `json:"recipient"`
+	PaymentDescription string       `json:"payment_description"`
+	CreditorName       string       `json:"creditor_name"`
+	CreditorAddress    string       `json:"creditor_address"`
+	CreditorBankName   string       `json:"creditor_bank_name"`
+	CreditorBankIban   string       `json:"creditor_bank_iban"`
+	CreditorBankBic    string       `json:"creditor_bank_bic"`
+	Status             string       `json:"status"`
+	StatusUpdatedAt    time.Time    `json:"status_updated_at"`
+	// sell orders
+	Invoice      string  `json:"invoice"`
+	Sats         int64   `json:"sats"`
+	Fee          float64 `json:"fee"`
+	PayoutAmount float64 `json:"payout_amount"`
+}
+
+// orderType returns the type of the order. Orders stored before selling was possible are purchases.
+func (o *VoucherOrder) orderType() string {
+	if len(o.Type) == 0 {
+		return VoucherOrderTypeBuy
+	}
+	return o.Type
+}
+
+func voucherOrderKey(orderID string) string {
+	return fmt.Sprintf("voucher-order:%s", orderID)
+}
+
+// isFinal returns true if the status of the order will not change anymore
+func isFinalVoucherOrderStatus(status string) bool {
+	switch status {
+	case VoucherOrderStatusCompleted, VoucherOrderStatusCanceled, "order.cancelled", "order.rejected", "order.expired":
+		return true
+	}
+	return false
+}
+
+func voucherOrderStatusName(status string) string {
+	if name, ok := voucherOrderStatusNames[status]; ok {
+		return name
+	}
+	return strings.TrimPrefix(status, "order.")
+}
+
+// newVoucherOrder stores an order that was accepted by the provider
+func (bot *TipBot) newVoucherOrder(user *lnbits.User, languageCode string, orderResult map[string]interface{}, iban, amount, recipient string) *VoucherOrder {
+	paymentMethod, _ := orderResult["payment_method"].(map[string]interface{})
+	orderID, _ := orderResult["orderid"].(string)
+	order := &VoucherOrder{
+		Base:            storage.New(storage.ID(voucherOrderKey(orderID))),
+		OrderID:         orderID,
+		Type:            VoucherOrderTypeBuy,
+		User:            user,
+		LanguageCode:    languageCode,
+		Iban:            iban,
+		Amount:          amount,
+		Currency:        internal.Configuration.Voucherbot.Currency,
+		Recipient:       recipient,
+		Status:          VoucherOrderStatusAccepted,
+		StatusUpdatedAt: time.Now(),
+	}
+	order.PaymentDescription, _ = orderResult["payment_description"].(string)
+	order.CreditorName, _ = paymentMethod["creditor_name"].(string)
+	order.CreditorAddress, _ = paymentMethod["creditor_address"].(string)
+	order.CreditorBankName, _ = paymentMethod["creditor_bank_name"].(string)
+	order.CreditorBankIban, _ = paymentMethod["creditor_bank_iban"].(string)
+	order.CreditorBankBic, _ = paymentMethod["creditor_bank_bic"].(string)
+	runtime.IgnoreError(order.Set(order, bot.Bunt))
+	return order
+}
+
+// getVoucherOrder loads an order of the user
+func (bot *TipBot) getVoucherOrder(user *lnbits.User, orderID string) (*VoucherOrder, error) {
+	order := &VoucherOrder{Base: storage.New(storage.ID(voucherOrderKey(orderID)))}
+	err := bot.Bunt.Get(order)
+	if err != nil {
+		return nil, err
+	}
+	if order.User == nil || order.User.Telegram == nil || order.User.Telegram.ID != user.Telegram.ID {
+		return nil, fmt.Errorf("order %s does not belong to user", orderID)
+	}
+	return order, nil
+}
+
+// isForeignVoucherOrder returns true if the order is stored and belongs to another user
+func (bot *TipBot) isForeignVoucherOrder(user *lnbits.User, orderID string) bool {
+	order := &VoucherOrder{Base: storage.New(storage.ID(voucherOrderKey(orderID)))}
+	if err := bot.Bunt.Get(order); err != nil {
+		return false
+	}
+	return order.User == nil || order.User.Telegram == nil || order.User.Telegram.ID != user.Telegram.ID
+}
+
+// setVoucherOrderStatus updates the status of a stored order after /confirm or /cancel
+func (bot *TipBot) setVoucherOrderStatus(user *lnbits.User, orderID string, status string) {
+	mutex.Lock(voucherOrderKey(orderID))
+	defer mutex.Unlock(voucherOrderKey(orderID))
+	order, err := bot.getVoucherOrder(user, orderID)
+	if err != nil {
+		// orders from before orders were stored
+		return
+	}
+	order.Status = status
+	order.StatusUpdatedAt = time.Now()
+	order.Active = !isFinalVoucherOrderStatus(status)
+	runtime.IgnoreError(order.Set(order, bot.Bunt))
+}
+
+// loadVoucherOrders returns the orders of all users, or of one user if telegramID is not 0
+func (bot *TipBot) loadVoucherOrders(telegramID int64, activeOnly bool) []*VoucherOrder {
+	var orders []*VoucherOrder
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend(VoucherOrderIndex, func(key, value string) bool {
+			o := &VoucherOrder{}
+			err := json.Unmarshal([]byte(value), o)
+			if err != nil || o.Base == nil || o.User == nil || o.User.Telegram == nil {
+				return true
+			}
+			if (telegramID != 0 && o.User.Telegram.ID != telegramID) || (activeOnly && !o.Active) {
+				return true
+			}
+			orders = append(orders, o)
+			return true // continue iteration
+		})
+	})
+	return orders
+}
+
+// ordersHandler handles /orders
+func (bot *TipBot) ordersHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	orders := bot.loadVoucherOrders(m.Sender.ID, false)
+	if len(orders) == 0 {
+		bot.trySendMessage(m.Sender, voucherOrdersEmptyMessage)
+		return ctx, nil
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.After(orders[j].CreatedAt)
+	})
+	if len(orders) > voucherOrdersListLimit {
+		orders = orders[:voucherOrdersListLimit]
+	}
+	list := ""
+	for _, o := range orders {
+		list += fmt.Sprintf(voucherOrdersEntryMessage, o.OrderID, o.orderType(), o.CreatedAt.Format("2006-01-02"), o.Amount, o.Currency, voucherOrderStatusName(o.Status))
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(voucherOrdersMessage, list))
+	return ctx, nil
+}
+
+// orderHandler handles /order <id>
+func (bot *TipBot) orderHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	orderID, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		return bot.ordersHandler(ctx)
+	}
+	order, err := bot.getVoucherOrder(LoadUser(ctx), orderID)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(voucherOrderNotFoundMessage, orderID))
+		return ctx, errors.New(errors.UnknownError, err)
+	}
+	if order.orderType() == VoucherOrderTypeSell {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(voucherSellOrderMessage, order.OrderID, order.CreatedAt.Format("2006-01-02 15:04"),
+			order.Amount, order.Currency, strconv.FormatFloat(order.Fee, 'f', -1, 64), FormatFiat(order.PayoutAmount, order.Currency, order.LanguageCode),
+			order.Iban, order.Sats, voucherOrderStatusName(order.Status)))
+		return ctx, nil
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(voucherOrderMessage, order.OrderID, order.CreatedAt.Format("2006-01-02 15:04"),
+		order.Amount, order.Currency, order.Iban, order.Recipient, voucherOrderStatusName(order.Status),
+		order.CreditorName, order.CreditorAddress, order.CreditorBankName, order.CreditorBankIban, order.CreditorBankBic, order.PaymentDescription))
+	return ctx, nil
+}
+
+// startVoucherOrderWatcher expires unconfirmed sell orders and polls the provider for
+// status changes of open orders. Polling is off if no voucherbot.status_path is configured.
+func (bot *TipBot) startVoucherOrderWatcher() {
+	runtime.Watch(voucherOrderTickerDuration, func() []*VoucherOrder {
+		return bot.loadVoucherOrders(0, true)
+	}, func(o *VoucherOrder) {
+		bot.updateVoucherOrder(o.OrderID)
+	})
+}
+
+func (bot *TipBot) updateVoucherOrder(orderID string) {
+	mutex.Lock(voucherOrderKey(orderID))
+	defer mutex.Unlock(voucherOrderKey(orderID))
+	order := &VoucherOrder{Base: storage.New(storage.ID(voucherOrderKey(orderID)))}
+	err := bot.Bunt.Get(order)
+	if err != nil || !order.Active {
+		return
+	}
+	if order.confirmExpired() {
+		bot.expireSellOrder(order)
+		return
+	}
+	if time.Since(order.CreatedAt) > voucherOrderMaxAge {
+		log.Infof("[voucher] Order %s is too old, stop polling", orderID)
+		runtime.IgnoreError(order.Inactivate(order, bot.Bunt))
+		return
+	}
+	if len(internal.Configuration.Voucherbot.StatusPath) == 0 {
+		return
+	}
+	voucherbotManager := NewVoucherBot(voucherHTTPClient)
+	result, err := voucherbotManager.orderStatus(orderID)
+	if err != nil {
+		log.Debugf("[voucher] Could not get status of order %s: %v", orderID, err)
+		return
+	}
+	status, _ := result["status"].(string)
+	// the provider does not know that the user confirmed or sent the payment
+	if len(status) == 0 || status == order.Status || (status == VoucherOrderStatusAccepted && order.Status != VoucherOrderStatusAccepted) {
+		return
+	}
+	log.Infof("[voucher] Order %s of %s: %s -> %s", orderID, GetUserStr(order.User.Telegram), order.Status, status)
+	order.Status = status
+	order.StatusUpdatedAt = time.Now()
+	order.Active = !isFinalVoucherOrderStatus(status)
+	runtime.IgnoreError(order.Set(order, bot.Bunt))
+	bot.trySendMessage(order.User.Telegram, voucherOrderStatusChangedMessage(order))
+}
+
+func voucherOrderStatusChangedMessage(order *VoucherOrder) string {
+	if order.orderType() == VoucherOrderTypeSell {
+		switch order.Status {
+		case VoucherOrderStatusPaymentReceived:
+			return fmt.Sprintf(voucherSellReceivedMessage, order.OrderID)
+		case VoucherOrderStatusCompleted:
+			return fmt.Sprintf(voucherSellDeliveredMessage, order.OrderID)
+		}
+	}
+	switch order.Status {
+	case VoucherOrderStatusAccepted:
+		return fmt.Sprintf(voucherOrderAcceptedMessage, order.OrderID)
+	case VoucherOrderStatusPaymentReceived:
+		return fmt.Sprintf(voucherOrderReceivedMessage, order.OrderID)
+	case VoucherOrderStatusCompleted:
+		return fmt.Sprintf(voucherOrderDeliveredMessage, order.OrderID)
+	case VoucherOrderStatusCanceled, "order.cancelled":
+		return fmt.Sprintf(voucherOrderCanceledMessage, order.OrderID)
+	}
+	return fmt.Sprintf(voucherOrderStatusMessage, order.OrderID, voucherOrderStatusName(order.Status))
+}
@@ -3,37 +3,133 @@ package telegram
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"github.com/massmux/SatsMobiBot/internal"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 )
 
+const (
+	defaultVoucherbotEndpoint = "api.gwoq.com"
+	defaultVoucherbotSellType = "LN-B"
+	defaultVoucherbotSellPath = "/v1/order/create_sell"
+)
+
+// voucherHTTPClient is used for all requests to the voucher provider. It can be replaced to talk to a stub of the API.
+var voucherHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
 type VoucherBot struct {
-	Amount           string `json:"amount"`
-	LightningAddress string `json:"lightning_address"`
-	Iban             string `json:"iban"`
-	APIKey           string `json:"api_key"`
-	BitcoinAddress   string `json:"bitcoin_address"`
-	Message          string `json:"message"`
-	Signature        string `json:"signature"`
+	Amount           string       `json:"amount"`
+	LightningAddress string       `json:"lightning_address"`
+	Iban             string       `json:"iban"`
+	APIKey           string       `json:"api_key"`
+	BitcoinAddress   string       `json:"bitcoin_address"`
+	Message          string       `json:"message"`
+	Signature        string       `json:"signature"`
+	Endpoint         string       `json:"-"`
+	Client           *http.Client `json:"-"`
 }
 
-func (vb *VoucherBot) getChallenge() (*http.Response, error) {
-	url := "https://api.gwoq.com/v1/order/challenge"
-	req, _ := http.NewRequest("GET", url, nil)
+// NewVoucherBot returns a client of the configured voucher provider
+func NewVoucherBot(client *http.Client) *VoucherBot {
+	return &VoucherBot{
+		APIKey:   internal.Configuration.Voucherbot.ApiKey,
+		Endpoint: internal.Configuration.Voucherbot.Endpoint,
+		Client:   client,
+	}
+}
+
+// url returns the url of an API path. The endpoint can be a host or a full url like http://localhost:8080
+func (vb *VoucherBot) url(path string) string {
+	endpoint := vb.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = defaultVoucherbotEndpoint
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "https://" + endpoint
+	}
+	return strings.TrimSuffix(endpoint, "/") + path
+}
+
+func (vb *VoucherBot) httpClient() *http.Client {
+	if vb.Client != nil {
+		return vb.Client
+	}
+	return voucherHTTPClient
+}
+
+func (vb *VoucherBot) newRequest(method string, path string, payload interface{}) *http.Request {
+	var req *http.Request
+	if payload != nil {
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ = http.NewRequest(method, vb.url(path), bytes.NewBuffer(jsonPayload))
+	} else {
+		req, _ = http.NewRequest(method, vb.url(path), nil)
+	}
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Authorization", vb.APIKey)
-	client := &http.Client{}
-	return client.Do(req)
+	return req
+}
+
+// VoucherError is returned if the provider does not accept a request
+type VoucherError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e VoucherError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Message)
+}
+
+// newVoucherError reads the message of the provider from the response body
+func newVoucherError(statusCode int, body []byte) VoucherError {
+	var result map[string]interface{}
+	if json.Unmarshal(body, &result) == nil {
+		for _, key := range []string{"message", "error", "status"} {
+			if message, ok := result[key].(string); ok && len(message) > 0 {
+				return VoucherError{StatusCode: statusCode, Message: message}
+			}
+		}
+	}
+	return VoucherError{StatusCode: statusCode, Message: strings.TrimSpace(string(body))}
+}
+
+// do sends the request and returns the response body if the provider accepted it
+func (vb *VoucherBot) do(req *http.Request) ([]byte, error) {
+	resp, err := vb.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, newVoucherError(resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// doJSON sends the request and decodes the json response
+func (vb *VoucherBot) doJSON(req *http.Request) (map[string]interface{}, error) {
+	body, err := vb.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	err = json.Unmarshal(body, &result)
+	return result, err
+}
+
+func (vb *VoucherBot) getChallenge() (*http.Response, error) {
+	return vb.httpClient().Do(vb.newRequest("GET", "/v1/order/challenge", nil))
 }
 
 func (vb *VoucherBot) getFee() (*http.Response, error) {
-	url := "https://api.gwoq.com/v1/order/getfee"
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", vb.APIKey)
-	client := &http.Client{}
-	return client.Do(req)
+	return vb.httpClient().Do(vb.newRequest("GET", "/v1/order/getfee", nil))
 }
 
 func (vb *VoucherBot) setLightningRecipient(lightningAddress string, amount string, iban string) {
@@ -43,8 +139,6 @@ func (vb *VoucherBot) setLightningRecipient(lightningAddress string, amount stri
 }
 
 func (vb *VoucherBot) createLightningOrder() map[string]interface{} {
-
-	url := "https://api.gwoq.com/v1/order/create_lightning"
 	payload := map[string]interface{}{
 		"event": "order.create",
 		"payload": map[string]string{
@@ -58,13 +152,7 @@ func (vb *VoucherBot) createLightningOrder() map[string]interface{} {
 			"op_type":        internal.Configuration.Voucherbot.PurchaseType,
 		},
 	}
-	jsonPayload, _ := json.Marshal(payload)
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", vb.APIKey)
-	client := &http.Client{}
-
-	resp, _ := client.Do(req)
+	resp, _ := vb.httpClient().Do(vb.newRequest("POST", "/v1/order/create_lightning", payload))
 	defer resp.Body.Close()
 	body, _ := ioutil.ReadAll(resp.Body)
 	var result map[string]interface{}
@@ -81,7 +169,6 @@ func (vb *VoucherBot) setOnchainRecipient(bitcoinAddress string, message string,
 }
 
 func (vb *VoucherBot) createOnchainOrder() (*http.Response, error) {
-	url := "https://api.gwoq.com/v1/order/create"
 	payload := map[string]interface{}{
 		"event": "order.create",
 		"payload": map[string]string{
@@ -94,42 +181,88 @@ func (vb *VoucherBot) createOnchainOrder() (*http.Response, error) {
 			"public_key":      "npubxx",
 		},
 	}
-	jsonPayload, _ := json.Marshal(payload)
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", vb.APIKey)
-	client := &http.Client{}
-	return client.Do(req)
+	return vb.httpClient().Do(vb.newRequest("POST", "/v1/order/create", payload))
 }
 
-func (vb *VoucherBot) cancelOrder(orderid string) (*http.Response, error) {
-	url := "https://api.gwoq.com/v1/order/cancel"
+func (vb *VoucherBot) cancelOrder(orderid string) error {
 	payload := map[string]interface{}{
 		"event": "order.cancel",
 		"payload": map[string]string{
 			"orderid": orderid,
 		},
 	}
-	jsonPayload, _ := json.Marshal(payload)
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", vb.APIKey)
-	client := &http.Client{}
-	return client.Do(req)
+	_, err := vb.do(vb.newRequest("POST", "/v1/order/cancel", payload))
+	return err
 }
 
-func (vb *VoucherBot) notifyPayment(orderid string) (*http.Response, error) {
-	url := "https://api.gwoq.com/v1/order/notify_payment"
+func (vb *VoucherBot) notifyPayment(orderid string) error {
 	payload := map[string]interface{}{
 		"event": "order.notify_payment",
 		"payload": map[string]string{
 			"orderid": orderid,
 		},
 	}
-	jsonPayload, _ := json.Marshal(payload)
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", vb.APIKey)
-	client := &http.Client{}
-	return client.Do(req)
+	_, err := vb.do(vb.newRequest("POST", "/v1/order/notify_payment", payload))
+	return err
+}
+
+// orderStatus queries the configured status call of the provider
+func (vb *VoucherBot) orderStatus(orderid string) (map[string]interface{}, error) {
+	path := internal.Configuration.Voucherbot.StatusPath
+	if len(path) == 0 {
+		return nil, fmt.Errorf("no order status path configured")
+	}
+	payload := map[string]interface{}{
+		"event": "order.status",
+		"payload": map[string]string{
+			"orderid": orderid,
+		},
+	}
+	return vb.doJSON(vb.newRequest("POST", path, payload))
+}
+
+// sellFee returns the fee of the provider in percent
+func (vb *VoucherBot) sellFee() (float64, error) {
+	result, err := vb.doJSON(vb.newRequest("GET", "/v1/order/getfee", nil))
+	if err != nil {
+		return 0, err
+	}
+	return voucherFloat(result["fee"])
+}
+
+// createSellOrder creates an order to pay out amount to iban. The provider returns a
+// Lightning invoice that the user has to pay.
+func (vb *VoucherBot) createSellOrder(amount string, iban string) (map[string]interface{}, error) {
+	opType, path := internal.Configuration.Voucherbot.SellType, internal.Configuration.Voucherbot.SellPath
+	if len(opType) == 0 {
+		opType = defaultVoucherbotSellType
+	}
+	if len(path) == 0 {
+		path = defaultVoucherbotSellPath
+	}
+	payload := map[string]interface{}{
+		"event": "order.create",
+		"payload": map[string]string{
+			"currency":   internal.Configuration.Voucherbot.Currency,
+			"email":      "nomail@nomail.com",
+			"iban":       iban,
+			"amount":     amount,
+			"public_key": "npubxx",
+			"op_type":    opType,
+		},
+	}
+	return vb.doJSON(vb.newRequest("POST", path, payload))
+}
+
+// voucherFloat reads numbers that the provider sends as number or string
+func voucherFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		var f float64
+		_, err := fmt.Sscanf(strings.TrimSpace(v), "%g", &f)
+		return f, err
+	}
+	return 0, fmt.Errorf("invalid number %v", value)
 }
@@ -0,0 +1,81 @@
+package telegram
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/massmux/SatsMobiBot/internal"
+)
+
+// newVoucherStub returns a client of a stub provider that answers every request with handler
+func newVoucherStub(t *testing.T, handler http.HandlerFunc) *VoucherBot {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	vb := NewVoucherBot(server.Client())
+	vb.Endpoint = server.URL
+	vb.APIKey = "key"
+	return vb
+}
+
+func TestVoucherBot_createSellOrder(t *testing.T) {
+	internal.Configuration.Voucherbot.Currency = "EUR"
+	internal.Configuration.Voucherbot.SellType = "LN-B"
+	internal.Configuration.Voucherbot.SellPath = "/v1/order/create_sell"
+	vb := newVoucherStub(t, func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Event   string            `json:"event"`
+			Payload map[string]string `json:"payload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+			return
+		}
+		if r.URL.Path != "/v1/order/create_sell" || r.Header.Get("Authorization") != "key" || request.Event != "order.create" {
+			t.Errorf("unexpected request %s %s %s", r.URL.Path, r.Header.Get("Authorization"), request.Event)
+		}
+		for key, want := range map[string]string{"amount": "50", "iban": "DE89370400440532013000", "currency": "EUR", "op_type": "LN-B"} {
+			if request.Payload[key] != want {
+				t.Errorf("payload %s = %q, want %q", key, request.Payload[key], want)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": VoucherOrderStatusAccepted, "orderid": "42", "invoice": "lnbc1"})
+	})
+	result, err := vb.createSellOrder("50", "DE89370400440532013000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["status"] != VoucherOrderStatusAccepted || result["orderid"] != "42" || result["invoice"] != "lnbc1" {
+		t.Errorf("createSellOrder() = %v", result)
+	}
+}
+
+func TestVoucherBot_createSellOrderRefused(t *testing.T) {
+	vb := newVoucherStub(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "amount too low"}`))
+	})
+	_, err := vb.createSellOrder("1", "DE89370400440532013000")
+	voucherErr, ok := err.(VoucherError)
+	if !ok || voucherErr.StatusCode != http.StatusBadRequest || voucherErr.Message != "amount too low" {
+		t.Errorf("createSellOrder() error = %v, want the message of the provider", err)
+	}
+}
+
+func TestVoucherBot_cancelOrder(t *testing.T) {
+	status := http.StatusOK
+	vb := newVoucherStub(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/order/cancel" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.WriteHeader(status)
+	})
+	if err := vb.cancelOrder("42"); err != nil {
+		t.Errorf("cancelOrder() = %v", err)
+	}
+	status = http.StatusNotFound
+	if err := vb.cancelOrder("42"); err == nil {
+		t.Errorf("cancelOrder() with status %d succeeded", status)
+	}
+}
@@ -0,0 +1,385 @@
+package telegram
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lnurl "github.com/fiatjaf/go-lnurl"
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/network"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	nostrKindZapRequest = 9734
+	nostrKindZapReceipt = 9735
+)
+
+var (
+	zapHelpMessage            = "⚙️ *Zap on nostr:*\n`/zap <npub|nprofile|note|nevent> <amount> [<comment>]` ⚡️ Zap a nostr profile or note."
+	zapResolvingMessage       = "🟣 Looking up the nostr profile..."
+	zapProfileNotFoundMessage = "🚫 Could not find the nostr profile on relays."
+	zapNoLightningMessage     = "🚫 This nostr profile has no lightning address."
+	zapNotConfiguredMessage   = "🚫 Zaps are not available on this bot."
+	zapNotSupportedMessage    = "🚫 The lightning address of this profile does not support zaps."
+	zapReceiptReceivedMessage = "⚡️ *Zap receipt published:* `%s`"
+)
+
+var zapTargetPrefixes = []string{"npub", "nprofile", "note", "nevent"}
+
+const (
+	zapReceiptWaitDuration = 5 * time.Minute
+	zapReceiptPollInterval = 30 * time.Second
+	zapRelayQueryTimeout   = 5 * time.Second
+	zapRequestMaxRelays    = 10
+)
+
+// nostrProfileMetadata holds the lightning fields of a kind-0 event
+type nostrProfileMetadata struct {
+	Name  string `json:"name,omitempty"`
+	Lud06 string `json:"lud06,omitempty"`
+	Lud16 string `json:"lud16,omitempty"`
+}
+
+// zapTarget is the decoded recipient of a zap
+type zapTarget struct {
+	PubKey  string
+	EventID string
+	Relays  []string
+}
+
+func isZapTarget(input string) bool {
+	for _, prefix := range zapTargetPrefixes {
+		if strings.HasPrefix(strings.ToLower(input), prefix+"1") {
+			return true
+		}
+	}
+	return false
+}
+
+// zapHandler handles /zap. If no nostr entity is given, /zap acts as an alias of /tip.
+func (bot *TipBot) zapHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	targetInput, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil || !isZapTarget(targetInput) {
+		if m.ReplyTo != nil {
+			return bot.tipHandler(ctx)
+		}
+		bot.trySendMessage(m.Sender, zapHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if m.Chat.Type != tb.ChatPrivate {
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	if len(internal.Configuration.Nostr.PrivateKey) == 0 {
+		bot.trySendMessage(m.Sender, zapNotConfiguredMessage)
+		return ctx, fmt.Errorf("no nostr private key configured")
+	}
+	amountInput, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		bot.trySendMessage(m.Sender, zapHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(amountInput)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "lnurlInvalidAmountMessage"))
+		return ctx, errors.New(errors.InvalidAmountError, err)
+	}
+	if amount < 1 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "lnurlInvalidAmountMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	comment := GetMemoFromCommand(m.Text, 3)
+
+	statusMsg := bot.trySendMessageEditable(m.Sender, zapResolvingMessage)
+	target, err := bot.resolveZapTarget(ctx, targetInput)
+	if err != nil {
+		log.Warnf("[zapHandler] %s: could not resolve %s: %v", GetUserStr(m.Sender), targetInput, err)
+		bot.tryEditMessage(statusMsg, zapProfileNotFoundMessage)
+		return ctx, err
+	}
+	metadata, err := bot.getNostrProfileMetadata(ctx, target.PubKey, target.Relays)
+	if err != nil {
+		log.Warnf("[zapHandler] %s: could not fetch profile %s: %v", GetUserStr(m.Sender), target.PubKey, err)
+		bot.tryEditMessage(statusMsg, zapProfileNotFoundMessage)
+		return ctx, err
+	}
+	if len(metadata.Lud16) == 0 && len(metadata.Lud06) == 0 {
+		bot.tryEditMessage(statusMsg, zapNoLightningMessage)
+		return ctx, fmt.Errorf("profile %s has no lud06 or lud16", target.PubKey)
+	}
+	endpoint, err := bot.getZapEndpoint(metadata)
+	if err != nil {
+		log.Warnf("[zapHandler] %s: no zap endpoint for %s: %v", GetUserStr(m.Sender), target.PubKey, err)
+		bot.tryEditMessage(statusMsg, zapNotSupportedMessage)
+		return ctx, err
+	}
+	bot.tryDeleteMessage(statusMsg)
+
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	zapRequest, err := bot.makeZapRequest(user, target, endpoint, amount, comment)
+	if err != nil {
+		log.Errorf("[zapHandler] could not create zap request: %v", err)
+		return ctx, err
+	}
+	zapRequestSerialized, err := json.Marshal(zapRequest)
+	if err != nil {
+		return ctx, err
+	}
+	log.Infof("[zapHandler] %s zaps %d sat to %s", GetUserStr(m.Sender), amount, target.PubKey)
+	// the receipt is awaited after the payment in confirmPayHandler
+	ctx.Context = context.WithValue(ctx, "ZapRequest", string(zapRequestSerialized))
+
+	if len(metadata.Lud16) > 0 {
+		return bot.sendToLightningAddress(ctx, metadata.Lud16, amount)
+	}
+	m.Text = fmt.Sprintf("/lnurl %d %s", amount, metadata.Lud06)
+	if len(comment) > 0 {
+		m.Text = m.Text + " " + comment
+	}
+	return bot.lnurlHandler(ctx)
+}
+
+// resolveZapTarget decodes a NIP-19 entity. Notes are looked up on relays to find their author.
+func (bot *TipBot) resolveZapTarget(ctx context.Context, input string) (*zapTarget, error) {
+	prefix, value, err := nip19.Decode(input)
+	if err != nil {
+		return nil, err
+	}
+	target := &zapTarget{}
+	switch prefix {
+	case "npub":
+		target.PubKey = value.(string)
+		return target, nil
+	case "nprofile":
+		pointer := value.(nostr.ProfilePointer)
+		target.PubKey = pointer.PublicKey
+		target.Relays = pointer.Relays
+		return target, nil
+	case "note":
+		target.EventID = value.(string)
+	case "nevent":
+		pointer := value.(nostr.EventPointer)
+		target.EventID = pointer.ID
+		target.Relays = pointer.Relays
+	default:
+		return nil, fmt.Errorf("unsupported nostr entity %s", prefix)
+	}
+	events := bot.queryNostrRelays(ctx, target.Relays, nostr.Filter{IDs: []string{target.EventID}, Limit: 1})
+	if len(events) == 0 {
+		return nil, fmt.Errorf("event %s not found", target.EventID)
+	}
+	target.PubKey = events[0].PubKey
+	return target, nil
+}
+
+// getNostrProfileMetadata fetches the latest kind-0 event of a pubkey
+func (bot *TipBot) getNostrProfileMetadata(ctx context.Context, pubkey string, relays []string) (*nostrProfileMetadata, error) {
+	events := bot.queryNostrRelays(ctx, relays, nostr.Filter{Kinds: []int{nostr.KindSetMetadata}, Authors: []string{pubkey}, Limit: 1})
+	if len(events) == 0 {
+		return nil, fmt.Errorf("no metadata found")
+	}
+	latest := events[0]
+	for _, ev := range events {
+		if ev.CreatedAt.After(latest.CreatedAt) {
+			latest = ev
+		}
+	}
+	var metadata nostrProfileMetadata
+	err := json.Unmarshal([]byte(latest.Content), &metadata)
+	if err != nil {
+		return nil, err
+	}
+	metadata.Lud16 = strings.TrimSpace(metadata.Lud16)
+	metadata.Lud06 = strings.TrimSpace(metadata.Lud06)
+	return &metadata, nil
+}
+
+// zapEndpoint is the LNURL-p endpoint of a zap recipient
+type zapEndpoint struct {
+	LNURL       string `json:"-"`
+	AllowsNostr bool   `json:"allowsNostr"`
+	NostrPubkey string `json:"nostrPubkey"`
+}
+
+// getZapEndpoint fetches the LNURL-p endpoint of a profile and checks that it accepts zaps
+func (bot *TipBot) getZapEndpoint(metadata *nostrProfileMetadata) (*zapEndpoint, error) {
+	var rawurl string
+	if len(metadata.Lud16) > 0 {
+		name, domain, ok := lnurl.ParseInternetIdentifier(metadata.Lud16)
+		if !ok {
+			return nil, fmt.Errorf("invalid lightning address %s", metadata.Lud16)
+		}
+		scheme := "https"
+		if strings.HasSuffix(domain, ".onion") {
+			scheme = "http"
+		}
+		rawurl = fmt.Sprintf("%s://%s/.well-known/lnurlp/%s", scheme, domain, name)
+	} else {
+		decoded, err := lnurl.LNURLDecode(metadata.Lud06)
+		if err != nil {
+			return nil, err
+		}
+		rawurl = decoded
+	}
+	endpointUrl, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := lnurl.LNURLEncode(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	client, err := network.GetClientForScheme(endpointUrl)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Get(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	endpoint := &zapEndpoint{LNURL: strings.ToLower(encoded)}
+	err = json.NewDecoder(res.Body).Decode(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if pubkey, err := hex.DecodeString(endpoint.NostrPubkey); !endpoint.AllowsNostr || err != nil || len(pubkey) != 32 {
+		return nil, fmt.Errorf("%s does not support zaps", rawurl)
+	}
+	return endpoint, nil
+}
+
+// makeZapRequest creates a NIP-57 zap request signed with the bot key. If the user
+// has delegated their pubkey to the bot (NIP-26), the zap is sent in their name.
+func (bot *TipBot) makeZapRequest(user *lnbits.User, target *zapTarget, endpoint *zapEndpoint, amount int64, comment string) (nostr.Event, error) {
+	pk := internal.Configuration.Nostr.PrivateKey
+	pub, err := nostr.GetPublicKey(pk)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	relays := uniqueSlice(cleanUrls(append(append([]string{}, target.Relays...), nostrRelays...)))
+	if len(relays) > zapRequestMaxRelays {
+		relays = relays[:zapRequestMaxRelays]
+	}
+	tags := nostr.Tags{
+		nostr.Tag{"p", target.PubKey},
+		nostr.Tag{"amount", strconv.FormatInt(amount*1000, 10)},
+		append(nostr.Tag{"relays"}, relays...),
+		nostr.Tag{"lnurl", endpoint.LNURL},
+	}
+	if len(target.EventID) > 0 {
+		tags = append(tags, nostr.Tag{"e", target.EventID})
+	}
+	delegation := user.Settings.Nostr
+	if len(delegation.Delegation) > 0 && nostrDelegationAllows(delegation.DelegationConditions, nostrKindZapRequest, time.Now()) {
+		tags = append(tags, nostr.Tag{"delegation", delegation.PubKey, delegation.DelegationConditions, delegation.Delegation})
+	}
+	ev := nostr.Event{
+		PubKey:    pub,
+		CreatedAt: time.Now(),
+		Kind:      nostrKindZapRequest,
+		Tags:      tags,
+		Content:   comment,
+	}
+	err = ev.Sign(pk)
+	return ev, err
+}
+
+// startZapReceiptWatcher waits for the receipt of a paid zap request in the background
+func (bot *TipBot) startZapReceiptWatcher(to *tb.User, zapRequestSerialized string) {
+	var zapRequest nostr.Event
+	if err := json.Unmarshal([]byte(zapRequestSerialized), &zapRequest); err != nil {
+		log.Errorf("[startZapReceiptWatcher] could not parse zap request: %v", err)
+		return
+	}
+	var relays []string
+	if tag := zapRequest.Tags.GetFirst([]string{"relays"}); tag != nil {
+		relays = (*tag)[1:]
+	}
+	go bot.waitForZapReceipt(to, zapRequest, relays)
+}
+
+// waitForZapReceipt polls relays for the receipt of a zap request and notifies the user
+func (bot *TipBot) waitForZapReceipt(to *tb.User, zapRequest nostr.Event, relays []string) {
+	since := zapRequest.CreatedAt.Add(-time.Minute)
+	filter := nostr.Filter{
+		Kinds: []int{nostrKindZapReceipt},
+		Tags:  nostr.TagMap{"p": []string{zapRequest.Tags.GetFirst([]string{"p"}).Value()}},
+		Since: &since,
+	}
+	deadline := time.Now().Add(zapReceiptWaitDuration)
+	for time.Now().Before(deadline) {
+		time.Sleep(zapReceiptPollInterval)
+		for _, receipt := range bot.queryNostrRelays(context.Background(), relays, filter) {
+			description := receipt.Tags.GetFirst([]string{"description"})
+			if description == nil {
+				continue
+			}
+			var request nostr.Event
+			if err := json.Unmarshal([]byte(description.Value()), &request); err != nil || request.ID != zapRequest.ID {
+				continue
+			}
+			note, err := nip19.EncodeNote(receipt.ID)
+			if err != nil {
+				note = receipt.ID
+			}
+			log.Infof("[waitForZapReceipt] %s: zap receipt %s", GetUserStr(to), receipt.ID)
+			bot.trySendMessage(to, fmt.Sprintf(zapReceiptReceivedMessage, note))
+			return
+		}
+	}
+	log.Debugf("[waitForZapReceipt] %s: no zap receipt for %s", GetUserStr(to), zapRequest.ID)
+}
+
+// queryNostrRelays queries the given relays and the default relays concurrently
+// and returns the matching events without duplicates.
+func (bot *TipBot) queryNostrRelays(ctx context.Context, relays []string, filter nostr.Filter) []*nostr.Event {
+	relays = uniqueSlice(cleanUrls(append(append([]string{}, relays...), nostrRelays...)))
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		seen   = make(map[string]bool)
+		events []*nostr.Event
+	)
+	for _, url := range relays {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			queryCtx, cancel := context.WithTimeout(ctx, zapRelayQueryTimeout)
+			defer cancel()
+			relay, err := nostr.RelayConnect(queryCtx, url)
+			if err != nil {
+				log.Debugf("[NOSTR] could not connect to %s: %v", url, err)
+				return
+			}
+			defer relay.Close()
+			for _, ev := range relay.QuerySync(queryCtx, filter) {
+				lock.Lock()
+				if !seen[ev.ID] {
+					seen[ev.ID] = true
+					events = append(events, ev)
+				}
+				lock.Unlock()
+			}
+		}(url)
+	}
+	wg.Wait()
+	return events
+}
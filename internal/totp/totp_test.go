@@ -0,0 +1,65 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the base32 encoded SHA1 secret "12345678901234567890" of RFC 6238
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// test vectors from RFC 6238, truncated to six digits
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		got, err := Code(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Code(%d) = %s, want %s", unix, got, want)
+		}
+	}
+	if _, err := Code("not base32!", time.Now()); err == nil {
+		t.Error("Code() with invalid secret expected error")
+	}
+}
+
+func TestValidateCounter(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, now)
+	counter, ok := ValidateCounter(code, rfcSecret, now)
+	if !ok || counter != now.Unix()/period {
+		t.Errorf("ValidateCounter() = %d, %t, want %d, true", counter, ok, now.Unix()/period)
+	}
+	// the code of the previous step is accepted with its own counter
+	counter, ok = ValidateCounter(code, rfcSecret, now.Add(period*time.Second))
+	if !ok || counter != now.Unix()/period {
+		t.Errorf("ValidateCounter() after one step = %d, %t, want %d, true", counter, ok, now.Unix()/period)
+	}
+	if _, ok = ValidateCounter(code, rfcSecret, now.Add(3*period*time.Second)); ok {
+		t.Error("ValidateCounter() accepted an expired code")
+	}
+	for _, invalid := range []string{"", "12345", "1234567", "000000"} {
+		if _, ok = ValidateCounter(invalid, rfcSecret, now); ok {
+			t.Errorf("ValidateCounter(%q) accepted an invalid code", invalid)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := Code(secret, time.Now())
+	if !Validate(" "+code+" ", secret) {
+		t.Errorf("Validate(%s) = false", code)
+	}
+}
@@ -37,8 +37,9 @@ func main() {
 	setLogger()
 
 	defer withRecovery()
-	price.NewPriceWatcher().Start()
 	bot := telegram.NewBot()
+	// the price history is opened with the databases of the bot
+	price.NewPriceWatcher().Start()
 	startApiServer(&bot)
 	bot.Start()
 }
@@ -55,6 +56,10 @@ func startApiServer(bot *telegram.TipBot) {
 	// append lnurl ctx functions
 	lnUrl := lnurl.New(bot)
 	s.AppendRoute("/.well-known/lnurlp/{username}", lnUrl.Handle, http.MethodGet)
+	// bolt cards
+	s.AppendRoute("/boltcard/new/{token}", lnUrl.HandleBoltCardProgram, http.MethodGet, http.MethodPost)
+	s.AppendRoute("/boltcard/{id}/callback", lnUrl.HandleBoltCardCallback, http.MethodGet)
+	s.AppendRoute("/boltcard/{id}", lnUrl.HandleBoltCard, http.MethodGet)
 	// userpage server
 	userpage := userpage.New(bot)
 	s.AppendRoute("/@{username}", userpage.UserPageHandler, http.MethodGet)
@@ -78,6 +83,7 @@ func startApiServer(bot *telegram.TipBot) {
 	s.AppendAuthorizedRoute(`/api/v1/invoicestream`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.InvoiceStream, http.MethodGet)
 	s.AppendAuthorizedRoute(`/api/v1/createinvoice`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.CreateInvoice, http.MethodPost)
 	s.AppendAuthorizedRoute(`/api/v1/balance`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.Balance, http.MethodGet)
+	s.AppendAuthorizedRoute(`/api/v1/transactions/export`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.ExportTransactions, http.MethodGet)
 
 	// start internal admin server
 	adminService := admin.New(bot)